@@ -4,14 +4,21 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"time"
 
 	"github.com/MasonD-007/proxicloud/backend/internal/analytics"
+	"github.com/MasonD-007/proxicloud/backend/internal/audit"
+	"github.com/MasonD-007/proxicloud/backend/internal/auth"
 	"github.com/MasonD-007/proxicloud/backend/internal/cache"
 	"github.com/MasonD-007/proxicloud/backend/internal/config"
 	"github.com/MasonD-007/proxicloud/backend/internal/handlers"
 	"github.com/MasonD-007/proxicloud/backend/internal/middleware"
+	"github.com/MasonD-007/proxicloud/backend/internal/oidc"
 	"github.com/MasonD-007/proxicloud/backend/internal/proxmox"
+	"github.com/MasonD-007/proxicloud/backend/internal/scheduler"
+	"github.com/MasonD-007/proxicloud/backend/internal/volumes"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 )
@@ -40,6 +47,43 @@ func main() {
 		cfg.Proxmox.TokenSecret,
 		cfg.Proxmox.Insecure,
 	)
+	if cfg.Proxmox.RequestTimeout > 0 {
+		client.SetRequestTimeout(time.Duration(cfg.Proxmox.RequestTimeout) * time.Second)
+	}
+
+	retry := proxmox.DefaultRetryPolicy
+	if cfg.Proxmox.MaxRetries != nil {
+		retry.MaxRetries = *cfg.Proxmox.MaxRetries
+	}
+	if cfg.Proxmox.RetryBackoff > 0 {
+		retry.BaseDelay = time.Duration(cfg.Proxmox.RetryBackoff) * time.Millisecond
+	}
+	client.SetRetryPolicy(retry)
+
+	breakerThreshold := proxmox.DefaultBreakerThreshold
+	if cfg.Proxmox.BreakerThreshold != nil {
+		breakerThreshold = *cfg.Proxmox.BreakerThreshold
+	}
+	breakerCooldown := proxmox.DefaultBreakerCooldown
+	if cfg.Proxmox.BreakerCooldown > 0 {
+		breakerCooldown = time.Duration(cfg.Proxmox.BreakerCooldown) * time.Second
+	}
+	client.SetCircuitBreaker(breakerThreshold, breakerCooldown)
+
+	if cfg.Proxmox.ConfigConcurrency > 0 {
+		client.SetConfigConcurrency(cfg.Proxmox.ConfigConcurrency)
+	}
+
+	switch cfg.Proxmox.UsageExec {
+	case "pct":
+		client.SetContainerExecutor(&proxmox.PctExecutor{})
+	case "ssh":
+		sshUser := cfg.Proxmox.UsageSSHUser
+		if sshUser == "" {
+			sshUser = "root"
+		}
+		client.SetContainerExecutor(&proxmox.PctExecutor{SSHUser: sshUser, SSHKey: cfg.Proxmox.UsageSSHKey})
+	}
 
 	// Initialize cache
 	cacheDB := os.Getenv("CACHE_PATH")
@@ -89,6 +133,25 @@ func main() {
 		log.Println("Metrics collector started (30-second intervals, 30-day retention)")
 	}
 
+	// Initialize volume registry
+	volumesDB := os.Getenv("VOLUMES_PATH")
+	if volumesDB == "" {
+		volumesDB = "/var/lib/proxicloud/volumes.db"
+	}
+
+	registry, err := volumes.NewRegistry(volumesDB)
+	if err != nil {
+		log.Printf("Warning: Failed to initialize volume registry: %v (continuing without volume metadata)", err)
+		registry = nil
+	} else {
+		defer func() {
+			if err := registry.Close(); err != nil {
+				log.Printf("Error closing volume registry: %v", err)
+			}
+		}()
+		log.Printf("Volume registry initialized at %s", volumesDB)
+	}
+
 	// Initialize project store
 	projectsDB := os.Getenv("PROJECTS_PATH")
 	if projectsDB == "" {
@@ -103,8 +166,115 @@ func main() {
 		log.Printf("Project store initialized at %s", projectsDB)
 	}
 
+	// Initialize policy store
+	policiesDB := os.Getenv("POLICIES_PATH")
+	if policiesDB == "" {
+		policiesDB = "/var/lib/proxicloud/policies.json"
+	}
+
+	var policyScheduler *scheduler.Scheduler
+	policyStore, err := scheduler.NewPolicyStore(policiesDB)
+	if err != nil {
+		log.Printf("Warning: Failed to initialize policy store: %v (continuing without scheduled policies)", err)
+	} else {
+		log.Printf("Policy store initialized at %s", policiesDB)
+
+		// Start policy scheduler
+		policyScheduler = scheduler.NewScheduler(client, policyStore, projectStore)
+		policyScheduler.Start()
+		defer policyScheduler.Stop()
+
+		log.Println("Policy scheduler started")
+	}
+
+	// Initialize auth store. Unlike the stores above the API must not run
+	// without it, as every route but the health check requires a login.
+	authDB := os.Getenv("AUTH_PATH")
+	if authDB == "" {
+		authDB = "/var/lib/proxicloud/auth.db"
+	}
+
+	authStore, err := auth.NewStore(authDB)
+	if err != nil {
+		log.Fatalf("Failed to initialize auth store: %v", err)
+	}
+	defer func() {
+		if err := authStore.Close(); err != nil {
+			log.Printf("Error closing auth store: %v", err)
+		}
+	}()
+	log.Printf("Auth store initialized at %s", authDB)
+
+	// Initialize audit log. Like the auth store it is required, so no change
+	// goes unrecorded.
+	auditDB := os.Getenv("AUDIT_PATH")
+	if auditDB == "" {
+		auditDB = "/var/lib/proxicloud/audit.db"
+	}
+
+	auditStore, err := audit.NewStore(auditDB)
+	if err != nil {
+		log.Fatalf("Failed to initialize audit log: %v", err)
+	}
+	defer func() {
+		if err := auditStore.Close(); err != nil {
+			log.Printf("Error closing audit log: %v", err)
+		}
+	}()
+	log.Printf("Audit log initialized at %s", auditDB)
+
+	if err := bootstrapAdmin(authStore); err != nil {
+		log.Fatalf("Failed to create admin user: %v", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := authStore.CleanExpiredSessions(); err != nil {
+				log.Printf("Error cleaning expired sessions: %v", err)
+			}
+		}
+	}()
+
+	sessionTTL := handlers.DefaultSessionTTL
+	if cfg.Auth.SessionTTL > 0 {
+		sessionTTL = time.Duration(cfg.Auth.SessionTTL) * time.Hour
+	}
+
 	// Create handlers
-	h := handlers.NewHandler(client, cacheInstance, analyticsInstance, projectStore)
+	h := handlers.NewHandler(client, cacheInstance, analyticsInstance, projectStore, policyScheduler, registry)
+
+	// Storage classes
+	storageClasses := make([]proxmox.StorageClass, 0, len(cfg.StorageClasses))
+	for name, class := range cfg.StorageClasses {
+		storageClasses = append(storageClasses, proxmox.StorageClass{
+			Name:        name,
+			Storage:     class.Storage,
+			Description: class.Description,
+			Default:     class.Default,
+			DefaultSize: class.DefaultSize,
+			MaxSize:     class.MaxSize,
+			Snapshots:   class.Snapshots,
+			Projects:    class.Projects,
+		})
+	}
+	h.SetStorageClasses(storageClasses)
+	log.Printf("Loaded %d storage classes", len(storageClasses))
+
+	h.SetAuth(authStore, sessionTTL)
+	h.SetAudit(auditStore)
+	if cfg.Auth.PasswordLogin != nil {
+		h.SetPasswordLogin(*cfg.Auth.PasswordLogin)
+	}
+	if cfg.Auth.OIDC.Issuer != "" {
+		settings, err := oidcSettings(cfg.Auth.OIDC)
+		if err != nil {
+			log.Fatalf("Invalid single sign-on configuration: %v", err)
+		}
+		h.SetOIDC(settings)
+		log.Printf("Single sign-on enabled with %s", cfg.Auth.OIDC.Issuer)
+	}
 
 	// Set up router
 	router := mux.NewRouter()
@@ -112,38 +282,106 @@ func main() {
 
 	// Routes
 	api.HandleFunc("/health", h.Health).Methods("GET")
+
+	// Auth routes
+	api.HandleFunc("/auth/login", h.Login).Methods("POST")
+	api.HandleFunc("/auth/providers", h.GetAuthProviders).Methods("GET")
+	api.HandleFunc("/auth/oidc/login", h.OIDCLogin).Methods("GET")
+	api.HandleFunc("/auth/oidc/callback", h.OIDCCallback).Methods("GET")
+	api.HandleFunc("/auth/oidc/exchange", h.OIDCExchange).Methods("POST")
+	api.HandleFunc("/auth/logout", h.Logout).Methods("POST")
+	api.HandleFunc("/auth/me", h.GetCurrentUser).Methods("GET")
+	api.HandleFunc("/auth/password", h.ChangePassword).Methods("PUT")
+	api.HandleFunc("/auth/keys", h.ListAPIKeys).Methods("GET")
+	api.HandleFunc("/auth/keys", h.CreateAPIKey).Methods("POST")
+	api.HandleFunc("/auth/keys/{id}", h.DeleteAPIKey).Methods("DELETE")
+	api.HandleFunc("/auth/users", h.ListUsers).Methods("GET")
+	api.HandleFunc("/auth/users", h.CreateUser).Methods("POST")
+	api.HandleFunc("/auth/users/{username}", h.DeleteUser).Methods("DELETE")
+
+	api.HandleFunc("/audit", h.ListAudit).Methods("GET")
 	api.HandleFunc("/dashboard", h.Dashboard).Methods("GET")
+	api.HandleFunc("/nodes", h.ListNodes).Methods("GET")
+	api.HandleFunc("/nodes/{node}/drain", h.DrainNode).Methods("POST")
+	api.HandleFunc("/nodes/{node}/drain", h.GetNodeDrain).Methods("GET")
 	api.HandleFunc("/containers", h.ListContainers).Methods("GET")
 	api.HandleFunc("/containers", h.CreateContainer).Methods("POST")
 	api.HandleFunc("/containers/{vmid}", h.GetContainer).Methods("GET")
+	api.HandleFunc("/containers/{vmid}", h.UpdateContainer).Methods("PATCH")
 	api.HandleFunc("/containers/{vmid}", h.DeleteContainer).Methods("DELETE")
+	api.HandleFunc("/containers/{vmid}/pending", h.GetContainerPending).Methods("GET")
+	api.HandleFunc("/containers/{vmid}/clone", h.CloneContainer).Methods("POST")
+	api.HandleFunc("/containers/{vmid}/template", h.ConvertContainerToTemplate).Methods("POST")
+	api.HandleFunc("/containers/{vmid}/migrate", h.MigrateContainer).Methods("POST")
+	api.HandleFunc("/containers/{vmid}/snapshots", h.ListContainerSnapshots).Methods("GET")
+	api.HandleFunc("/containers/{vmid}/snapshots", h.CreateContainerSnapshot).Methods("POST")
+	api.HandleFunc("/containers/{vmid}/snapshots/{name}", h.DeleteContainerSnapshot).Methods("DELETE")
+	api.HandleFunc("/containers/{vmid}/snapshots/{name}/rollback", h.RollbackContainerSnapshot).Methods("POST")
 	api.HandleFunc("/containers/{vmid}/start", h.StartContainer).Methods("POST")
 	api.HandleFunc("/containers/{vmid}/stop", h.StopContainer).Methods("POST")
 	api.HandleFunc("/containers/{vmid}/reboot", h.RebootContainer).Methods("POST")
 	api.HandleFunc("/containers/{vmid}/termproxy", h.GetContainerTermProxy).Methods("POST")
+	api.HandleFunc("/tasks/{id}", h.GetTask).Methods("GET")
+
+	// Virtual machine routes
+	api.HandleFunc("/vms", h.ListVMs).Methods("GET")
+	api.HandleFunc("/vms", h.CreateVM).Methods("POST")
+	api.HandleFunc("/vms/{vmid}", h.GetVM).Methods("GET")
+	api.HandleFunc("/vms/{vmid}", h.DeleteVM).Methods("DELETE")
+	api.HandleFunc("/vms/{vmid}/config", h.UpdateVMConfig).Methods("PUT")
+	api.HandleFunc("/vms/{vmid}/clone", h.CloneVM).Methods("POST")
+	api.HandleFunc("/vms/{vmid}/start", h.StartVM).Methods("POST")
+	api.HandleFunc("/vms/{vmid}/stop", h.StopVM).Methods("POST")
+	api.HandleFunc("/vms/{vmid}/reboot", h.RebootVM).Methods("POST")
+	api.HandleFunc("/vms/{vmid}/project", h.AssignContainerProject).Methods("POST")
 	api.HandleFunc("/templates", h.GetTemplates).Methods("GET")
 	api.HandleFunc("/templates/upload", h.UploadTemplate).Methods("POST")
 
 	// Storage routes
 	api.HandleFunc("/storage", h.GetStorage).Methods("GET")
+	api.HandleFunc("/storage-classes", h.ListStorageClasses).Methods("GET")
 
 	// Analytics routes
 	api.HandleFunc("/analytics/stats", h.GetAnalyticsStats).Methods("GET")
 	api.HandleFunc("/containers/{vmid}/metrics", h.GetContainerMetrics).Methods("GET")
 	api.HandleFunc("/containers/{vmid}/metrics/summary", h.GetContainerMetricsSummary).Methods("GET")
+	api.HandleFunc("/vms/{vmid}/metrics", h.GetContainerMetrics).Methods("GET")
+	api.HandleFunc("/vms/{vmid}/metrics/summary", h.GetContainerMetricsSummary).Methods("GET")
+	api.HandleFunc("/volumes/{volid}/metrics", h.GetVolumeMetrics).Methods("GET")
 
 	// Volume routes
 	api.HandleFunc("/volumes", h.ListVolumes).Methods("GET")
 	api.HandleFunc("/volumes", h.CreateVolume).Methods("POST")
+	api.HandleFunc("/volumes/orphans", h.ListOrphanedVolumes).Methods("GET")
+	api.HandleFunc("/volumes/orphans/cleanup", h.CleanupOrphanedVolumes).Methods("POST")
 	api.HandleFunc("/volumes/{volid}", h.GetVolume).Methods("GET")
+	api.HandleFunc("/volumes/{volid}", h.UpdateVolume).Methods("PATCH")
 	api.HandleFunc("/volumes/{volid}", h.DeleteVolume).Methods("DELETE")
+	api.HandleFunc("/volumes/{volid}/history", h.GetVolumeHistory).Methods("GET")
 	api.HandleFunc("/volumes/{volid}/attach/{vmid}", h.AttachVolume).Methods("POST")
 	api.HandleFunc("/volumes/{volid}/detach/{vmid}", h.DetachVolume).Methods("POST")
+	api.HandleFunc("/volumes/{volid}/resize", h.ResizeVolume).Methods("POST")
+	api.HandleFunc("/volumes/{volid}/move", h.MoveVolume).Methods("POST")
 	api.HandleFunc("/volumes/{volid}/snapshots", h.ListSnapshots).Methods("GET")
 	api.HandleFunc("/volumes/{volid}/snapshots", h.CreateSnapshot).Methods("POST")
 	api.HandleFunc("/volumes/{volid}/snapshots/restore", h.RestoreSnapshot).Methods("POST")
 	api.HandleFunc("/volumes/{volid}/snapshots/clone", h.CloneSnapshot).Methods("POST")
 
+	// Backup routes
+	api.HandleFunc("/backups", h.ListBackups).Methods("GET")
+	api.HandleFunc("/backups", h.CreateBackup).Methods("POST")
+	api.HandleFunc("/backups/restore", h.RestoreBackup).Methods("POST")
+	api.HandleFunc("/backups/{volid:.+}", h.DeleteBackup).Methods("DELETE")
+
+	// Policy routes
+	api.HandleFunc("/policies", h.ListPolicies).Methods("GET")
+	api.HandleFunc("/policies", h.CreatePolicy).Methods("POST")
+	api.HandleFunc("/policies/{id}", h.GetPolicy).Methods("GET")
+	api.HandleFunc("/policies/{id}", h.UpdatePolicy).Methods("PUT")
+	api.HandleFunc("/policies/{id}", h.DeletePolicy).Methods("DELETE")
+	api.HandleFunc("/policies/{id}/runs", h.ListPolicyRuns).Methods("GET")
+	api.HandleFunc("/policies/{id}/run", h.RunPolicy).Methods("POST")
+
 	// Project routes
 	api.HandleFunc("/projects", h.ListProjects).Methods("GET")
 	api.HandleFunc("/projects", h.CreateProject).Methods("POST")
@@ -151,17 +389,32 @@ func main() {
 	api.HandleFunc("/projects/{id}", h.UpdateProject).Methods("PUT")
 	api.HandleFunc("/projects/{id}", h.DeleteProject).Methods("DELETE")
 	api.HandleFunc("/projects/{id}/containers", h.GetProjectContainers).Methods("GET")
+	api.HandleFunc("/projects/{id}/members", h.ListProjectMembers).Methods("GET")
+	api.HandleFunc("/projects/{id}/members/{username}", h.SetProjectMember).Methods("PUT")
+	api.HandleFunc("/projects/{id}/members/{username}", h.RemoveProjectMember).Methods("DELETE")
 	api.HandleFunc("/containers/{vmid}/project", h.AssignContainerProject).Methods("POST")
 
-	// Set up CORS
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"*"},
-		AllowCredentials: true,
-	})
+	// Record every mutating request, including denied ones, then evaluate the
+	// access policy of each route for the logged in user
+	router.Use(h.Audit, h.Authorize)
+
+	// Set up CORS. Credentials (the session cookie) are only allowed for the
+	// configured origins; any other origin must send a token header.
+	corsOptions := cors.Options{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Authorization", "Content-Type", "X-API-Key"},
+	}
+	if len(cfg.Server.CORSOrigins) > 0 {
+		corsOptions.AllowedOrigins = cfg.Server.CORSOrigins
+		corsOptions.AllowCredentials = true
+	}
+	c := cors.New(corsOptions)
 
-	handler := c.Handler(router)
+	// Require a session or API key for everything but the health check and
+	// login. Single sign-on sessions are refreshed as they are looked up.
+	handler := middleware.Auth(h.Authenticator(), handlers.PublicPaths...)(router)
+	handler = c.Handler(handler)
 
 	// Apply middleware
 	handler = middleware.Logger(handler)
@@ -174,3 +427,78 @@ func main() {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
+
+// oidcSettings converts the single sign-on configuration for the handler. The
+// frontend is assumed to be served from the callback's origin unless set.
+func oidcSettings(cfg config.OIDCConfig) (handlers.OIDCSettings, error) {
+	frontendURL := cfg.FrontendURL
+	if frontendURL == "" {
+		redirect, err := url.Parse(cfg.RedirectURL)
+		if err != nil || redirect.Scheme == "" || redirect.Host == "" {
+			return handlers.OIDCSettings{}, fmt.Errorf("invalid redirect_url %q", cfg.RedirectURL)
+		}
+		frontendURL = redirect.Scheme + "://" + redirect.Host
+	}
+
+	settings := handlers.OIDCSettings{
+		Provider: oidc.NewProvider(oidc.Config{
+			Issuer:        cfg.Issuer,
+			ClientID:      cfg.ClientID,
+			ClientSecret:  cfg.ClientSecret,
+			RedirectURL:   cfg.RedirectURL,
+			Scopes:        cfg.Scopes,
+			UsernameClaim: cfg.UsernameClaim,
+			GroupsClaim:   cfg.GroupsClaim,
+		}),
+		DisplayName: cfg.DisplayName,
+		FrontendURL: frontendURL,
+		AdminGroups: cfg.AdminGroups,
+	}
+	for _, mapping := range cfg.ProjectRoles {
+		role, err := auth.ParseRole(mapping.Role)
+		if err != nil {
+			return handlers.OIDCSettings{}, err
+		}
+		settings.ProjectRoles = append(settings.ProjectRoles, handlers.OIDCProjectRole{
+			Group:   mapping.Group,
+			Project: mapping.Project,
+			Role:    role,
+		})
+	}
+	return settings, nil
+}
+
+// bootstrapAdmin creates the first admin user when there are no users yet.
+// The password is taken from PROXICLOUD_ADMIN_PASSWORD or generated and logged
+// once, to be changed after the first login.
+func bootstrapAdmin(store *auth.Store) error {
+	hasUsers, err := store.HasUsers()
+	if err != nil {
+		return err
+	}
+	if hasUsers {
+		return nil
+	}
+
+	username := os.Getenv("PROXICLOUD_ADMIN_USER")
+	if username == "" {
+		username = "admin"
+	}
+	password := os.Getenv("PROXICLOUD_ADMIN_PASSWORD")
+	generated := password == ""
+	if generated {
+		if password, err = auth.GeneratePassword(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := store.CreateUser(username, password, true); err != nil {
+		return err
+	}
+	if generated {
+		log.Printf("Created admin user %q with password %s (change it after logging in)", username, password)
+	} else {
+		log.Printf("Created admin user %q", username)
+	}
+	return nil
+}
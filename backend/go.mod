@@ -9,3 +9,5 @@ require (
 )
 
 require github.com/mattn/go-sqlite3 v1.14.32
+
+require golang.org/x/crypto v0.21.0
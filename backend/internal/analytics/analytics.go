@@ -30,6 +30,14 @@ type Metric struct {
 	Status    string    `json:"status"`
 }
 
+// VolumeMetric represents a single volume usage data point
+type VolumeMetric struct {
+	VolID     string    `json:"volid"`
+	Timestamp time.Time `json:"timestamp"`
+	Size      int64     `json:"size"` // Bytes
+	Used      int64     `json:"used"` // Bytes
+}
+
 // MetricSummary represents aggregated metrics
 type MetricSummary struct {
 	VMID         int       `json:"vmid"`
@@ -85,6 +93,17 @@ func (a *Analytics) initialize() error {
 	CREATE INDEX IF NOT EXISTS idx_metrics_vmid ON metrics(vmid);
 	CREATE INDEX IF NOT EXISTS idx_metrics_timestamp ON metrics(timestamp);
 	CREATE INDEX IF NOT EXISTS idx_metrics_vmid_timestamp ON metrics(vmid, timestamp);
+
+	CREATE TABLE IF NOT EXISTS volume_metrics (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		volid TEXT NOT NULL,
+		timestamp DATETIME NOT NULL,
+		size INTEGER,
+		used INTEGER,
+		UNIQUE(volid, timestamp)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_volume_metrics_volid_timestamp ON volume_metrics(volid, timestamp);
 	`
 
 	if _, err := a.db.Exec(schema); err != nil {
@@ -423,8 +442,14 @@ func (a *Analytics) CleanOldMetrics(retentionDays int) error {
 	if err != nil {
 		return fmt.Errorf("failed to clean old metrics: %w", err)
 	}
+	volumeResult, err := a.db.Exec("DELETE FROM volume_metrics WHERE timestamp < ?", cutoffTime)
+	if err != nil {
+		return fmt.Errorf("failed to clean old volume metrics: %w", err)
+	}
 
 	rowsAffected, _ := result.RowsAffected()
+	volumeRowsAffected, _ := volumeResult.RowsAffected()
+	rowsAffected += volumeRowsAffected
 	if rowsAffected > 0 {
 		log.Printf("Cleaned %d old metric records (older than %d days)", rowsAffected, retentionDays)
 	}
@@ -455,3 +480,70 @@ func (a *Analytics) ExportMetrics(vmid int, start, end time.Time) ([]byte, error
 
 	return json.MarshalIndent(metrics, "", "  ")
 }
+
+// RecordVolumeMetrics stores the usage of several volumes at once
+func (a *Analytics) RecordVolumeMetrics(metrics []VolumeMetric) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rbErr := tx.Rollback(); rbErr != nil && rbErr != sql.ErrTxDone {
+			log.Printf("Failed to rollback transaction: %v", rbErr)
+		}
+	}()
+
+	stmt, err := tx.Prepare(`
+		INSERT OR REPLACE INTO volume_metrics (volid, timestamp, size, used)
+		VALUES (?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := stmt.Close(); closeErr != nil {
+			log.Printf("Failed to close statement: %v", closeErr)
+		}
+	}()
+
+	for _, metric := range metrics {
+		if _, err := stmt.Exec(metric.VolID, metric.Timestamp, metric.Size, metric.Used); err != nil {
+			return fmt.Errorf("failed to record volume metric: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetVolumeMetrics retrieves the usage of a volume within a time range, newest first
+func (a *Analytics) GetVolumeMetrics(volid string, start, end time.Time, limit int) ([]VolumeMetric, error) {
+	query := `
+		SELECT volid, timestamp, size, used
+		FROM volume_metrics
+		WHERE volid = ? AND timestamp BETWEEN ? AND ?
+		ORDER BY timestamp DESC
+		LIMIT ?
+	`
+
+	rows, err := a.db.Query(query, volid, start, end, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Failed to close rows: %v", closeErr)
+		}
+	}()
+
+	metrics := []VolumeMetric{}
+	for rows.Next() {
+		var m VolumeMetric
+		if err := rows.Scan(&m.VolID, &m.Timestamp, &m.Size, &m.Used); err != nil {
+			log.Printf("Failed to scan volume metric: %v", err)
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+
+	return metrics, rows.Err()
+}
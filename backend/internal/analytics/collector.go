@@ -8,9 +8,14 @@ import (
 	"github.com/MasonD-007/proxicloud/backend/internal/proxmox"
 )
 
+// volumeCollectEvery is how many guest collections happen per volume usage
+// collection. Listing volumes is expensive and may run df in containers, and
+// usage changes slowly.
+const volumeCollectEvery = 10
+
 // Collector handles background metrics collection
 type Collector struct {
-	client    *proxmox.Client
+	client    proxmox.ProxmoxAPI
 	analytics *Analytics
 	interval  time.Duration
 	ctx       context.Context
@@ -18,7 +23,7 @@ type Collector struct {
 }
 
 // NewCollector creates a new metrics collector
-func NewCollector(client *proxmox.Client, analytics *Analytics, intervalSeconds int) *Collector {
+func NewCollector(client proxmox.ProxmoxAPI, analytics *Analytics, intervalSeconds int) *Collector {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &Collector{
@@ -35,15 +40,21 @@ func (c *Collector) Start() {
 	log.Printf("Starting metrics collector (interval: %v)", c.interval)
 
 	// Collect immediately on start
-	go c.collectOnce()
+	go func() {
+		c.collectOnce()
+		c.collectVolumes()
+	}()
 
 	// Then collect on interval
 	ticker := time.NewTicker(c.interval)
 	go func() {
-		for {
+		for runs := 1; ; runs++ {
 			select {
 			case <-ticker.C:
 				c.collectOnce()
+				if runs%volumeCollectEvery == 0 {
+					c.collectVolumes()
+				}
 			case <-c.ctx.Done():
 				ticker.Stop()
 				log.Println("Metrics collector stopped")
@@ -59,27 +70,26 @@ func (c *Collector) Stop() {
 	c.cancel()
 }
 
-// collectOnce collects metrics for all containers once
+// collectOnce collects metrics for all containers and VMs once
 func (c *Collector) collectOnce() {
 	start := time.Now()
 
-	// Get all containers
-	containers, err := c.client.GetContainers()
+	// Get all guests; the cluster resources carry every stat we record,
+	// so the per-guest configs are not needed
+	inventory, err := c.client.GetInventory(c.ctx, false)
 	if err != nil {
 		log.Printf("Failed to list containers for metrics collection: %v", err)
 		return
 	}
 
-	if len(containers) == 0 {
-		// No containers to collect metrics for
-		return
-	}
-
 	var metrics []Metric
 	timestamp := time.Now()
 
 	// Collect metrics for each container
-	for _, container := range containers {
+	for _, container := range inventory.Containers {
+		if container.Template {
+			continue
+		}
 		// Parse metrics from container status
 		metric := Metric{
 			VMID:      container.VMID,
@@ -98,20 +108,68 @@ func (c *Collector) collectOnce() {
 		metrics = append(metrics, metric)
 	}
 
+	// VMs are recorded the same way; VMIDs are unique across guest types
+	for _, vm := range inventory.VMs {
+		if vm.Template {
+			continue
+		}
+		metrics = append(metrics, Metric{
+			VMID:      vm.VMID,
+			Timestamp: timestamp,
+			Status:    vm.Status,
+			Uptime:    vm.Uptime,
+			CPUUsage:  vm.CPU * 100,
+			MemUsage:  vm.Mem,
+			MemTotal:  vm.MaxMem,
+			DiskUsage: vm.Disk,
+			DiskTotal: vm.MaxDisk,
+		})
+	}
+
 	// Store all metrics
 	if len(metrics) > 0 {
 		if err := c.analytics.RecordMetrics(metrics); err != nil {
 			log.Printf("Failed to record metrics: %v", err)
 		} else {
 			duration := time.Since(start)
-			log.Printf("Collected metrics for %d containers in %v", len(metrics), duration)
+			log.Printf("Collected metrics for %d guests in %v", len(metrics), duration)
+		}
+	}
+}
+
+// collectVolumes records the usage of every volume once
+func (c *Collector) collectVolumes() {
+	start := time.Now()
+
+	volumes, err := c.client.GetVolumes(c.ctx)
+	if err != nil {
+		log.Printf("Failed to list volumes for usage collection: %v", err)
+		return
+	}
+
+	timestamp := time.Now()
+	metrics := make([]VolumeMetric, 0, len(volumes))
+	for _, volume := range volumes {
+		metrics = append(metrics, VolumeMetric{
+			VolID:     volume.VolID,
+			Timestamp: timestamp,
+			Size:      volume.SizeBytes,
+			Used:      volume.UsedBytes,
+		})
+	}
+
+	if len(metrics) > 0 {
+		if err := c.analytics.RecordVolumeMetrics(metrics); err != nil {
+			log.Printf("Failed to record volume metrics: %v", err)
+		} else {
+			log.Printf("Collected usage of %d volumes in %v", len(metrics), time.Since(start))
 		}
 	}
 }
 
 // CollectForContainer collects metrics for a specific container
 func (c *Collector) CollectForContainer(vmid int) error {
-	container, err := c.client.GetContainer(vmid)
+	container, err := c.client.GetContainer(c.ctx, vmid)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,55 @@
+package audit
+
+import "strings"
+
+// Redacted replaces the values of secret parameters
+const Redacted = "[REDACTED]"
+
+// secretWords mark parameter names whose values must not be logged
+var secretWords = []string{"password", "passwd", "secret", "token", "ticket", "private", "credential"}
+
+// secretNames are parameter names that hold secrets but are too generic to
+// match as words, like the OAuth authorization code and PKCE verifier
+var secretNames = []string{"key", "api_key", "code", "code_verifier"}
+
+// IsSecret reports whether a parameter name looks like it holds a secret,
+// e.g. password, cipassword, new_password, token_secret or private_key.
+// Public SSH keys are not secret.
+func IsSecret(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range secretWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	for _, secret := range secretNames {
+		if name == secret {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns a copy of decoded JSON with the values of secret fields
+// replaced, at any depth
+func Redact(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(value))
+		for name, v := range value {
+			if IsSecret(name) && v != nil && v != "" {
+				redacted[name] = Redacted
+			} else {
+				redacted[name] = Redact(v)
+			}
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(value))
+		for i, v := range value {
+			redacted[i] = Redact(v)
+		}
+		return redacted
+	}
+	return value
+}
@@ -0,0 +1,298 @@
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Results of an audited request
+const (
+	ResultSuccess = "success" // 2xx and 3xx responses
+	ResultDenied  = "denied"  // 401 and 403 responses
+	ResultFailed  = "failed"  // Any other error response
+)
+
+// DefaultLimit and MaxLimit bound how many entries a query returns
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Entry records one request that changed, or tried to change, something
+type Entry struct {
+	ID         int64                  `json:"id"`
+	Time       int64                  `json:"time"`
+	Actor      string                 `json:"actor,omitempty"` // Empty for requests without a logged in user, such as logins
+	SourceIP   string                 `json:"source_ip"`
+	Method     string                 `json:"method"`
+	Path       string                 `json:"path"`
+	Action     string                 `json:"action"` // Method and route template, e.g. "DELETE /containers/{vmid}"
+	VMID       int                    `json:"vmid,omitempty"`
+	VolID      string                 `json:"volid,omitempty"`
+	Project    string                 `json:"project,omitempty"`
+	Params     map[string]interface{} `json:"params,omitempty"` // Query and body parameters with secrets redacted
+	Status     int                    `json:"status"`
+	Result     string                 `json:"result"`
+	Error      string                 `json:"error,omitempty"`
+	UPIDs      []string               `json:"upids,omitempty"` // Proxmox tasks the request started
+	DurationMS int64                  `json:"duration_ms"`
+}
+
+// Filter selects audit entries. Zero fields match everything.
+type Filter struct {
+	Actor   string
+	Method  string
+	Action  string
+	VMID    int
+	VolID   string
+	Project string
+	Result  string
+	Since   int64 // Unix time, inclusive
+	Until   int64 // Unix time, exclusive
+	Limit   int
+	Offset  int
+}
+
+// ResultFor returns the result of a request with an HTTP status code
+func ResultFor(status int) string {
+	switch {
+	case status == 401 || status == 403:
+		return ResultDenied
+	case status >= 400:
+		return ResultFailed
+	}
+	return ResultSuccess
+}
+
+// Store is the append-only audit log. Entries cannot be changed or deleted
+// through it, and triggers reject updates and deletes made to the database
+// directly.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore opens the audit database, creating it if needed
+func NewStore(dbPath string) (*Store, error) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit database: %w", err)
+	}
+
+	store := &Store{db: db}
+	if err := store.initialize(); err != nil {
+		if closeErr := db.Close(); closeErr != nil {
+			log.Printf("Failed to close database after initialization error: %v", closeErr)
+		}
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// initialize creates the audit table if it doesn't exist
+func (s *Store) initialize() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		time INTEGER NOT NULL,
+		actor TEXT NOT NULL,
+		source_ip TEXT NOT NULL,
+		method TEXT NOT NULL,
+		path TEXT NOT NULL,
+		action TEXT NOT NULL,
+		vmid INTEGER NOT NULL DEFAULT 0,
+		volid TEXT NOT NULL DEFAULT '',
+		project TEXT NOT NULL DEFAULT '',
+		params TEXT NOT NULL DEFAULT '',
+		status INTEGER NOT NULL,
+		result TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		upids TEXT NOT NULL DEFAULT '',
+		duration_ms INTEGER NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_audit_time ON audit_log(time);
+	CREATE INDEX IF NOT EXISTS idx_audit_actor ON audit_log(actor);
+	CREATE INDEX IF NOT EXISTS idx_audit_vmid ON audit_log(vmid);
+	CREATE INDEX IF NOT EXISTS idx_audit_volid ON audit_log(volid);
+	CREATE INDEX IF NOT EXISTS idx_audit_project ON audit_log(project);
+
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log is append-only');
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log is append-only');
+	END;
+	`
+
+	if _, err := s.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to create audit table: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database connection
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Append adds an entry to the log and sets its ID
+func (s *Store) Append(entry *Entry) error {
+	var params []byte
+	if len(entry.Params) > 0 {
+		var err error
+		if params, err = json.Marshal(entry.Params); err != nil {
+			return fmt.Errorf("failed to encode audit params: %w", err)
+		}
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO audit_log (time, actor, source_ip, method, path, action, vmid, volid, project,
+			params, status, result, error, upids, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Time, entry.Actor, entry.SourceIP, entry.Method, entry.Path, entry.Action,
+		entry.VMID, entry.VolID, entry.Project, string(params), entry.Status, entry.Result,
+		entry.Error, strings.Join(entry.UPIDs, ","), entry.DurationMS,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to append audit entry: %w", err)
+	}
+	entry.ID, err = result.LastInsertId()
+	return err
+}
+
+// Query returns the entries matching a filter, newest first
+func (s *Store) Query(filter Filter) ([]Entry, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultLimit
+	}
+	if filter.Limit > MaxLimit {
+		filter.Limit = MaxLimit
+	}
+
+	return s.query(filter, "DESC", 0)
+}
+
+// Export calls fn with every entry matching a filter, oldest first. Unlike
+// Query there is no limit unless the filter sets one. Entries are read in
+// pages so the database is not locked while fn writes them out.
+func (s *Store) Export(filter Filter, fn func(Entry) error) error {
+	remaining := filter.Limit
+	var afterID int64
+	for {
+		page := filter
+		page.Limit = MaxLimit
+		if remaining > 0 && remaining < page.Limit {
+			page.Limit = remaining
+		}
+		entries, err := s.query(page, "ASC", afterID)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+
+		if len(entries) < page.Limit {
+			return nil
+		}
+		if remaining > 0 {
+			if remaining -= len(entries); remaining == 0 {
+				return nil
+			}
+		}
+		// Later pages continue after the last entry rather than skipping
+		filter.Offset = 0
+		afterID = entries[len(entries)-1].ID
+	}
+}
+
+// query runs a filtered query, starting after an entry ID when ascending
+func (s *Store) query(filter Filter, order string, afterID int64) ([]Entry, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		conditions = append(conditions, condition)
+		args = append(args, arg)
+	}
+	if filter.Actor != "" {
+		add("actor = ?", filter.Actor)
+	}
+	if filter.Method != "" {
+		add("method = ?", strings.ToUpper(filter.Method))
+	}
+	if filter.Action != "" {
+		add("action = ?", filter.Action)
+	}
+	if filter.VMID > 0 {
+		add("vmid = ?", filter.VMID)
+	}
+	if filter.VolID != "" {
+		add("volid = ?", filter.VolID)
+	}
+	if filter.Project != "" {
+		add("project = ?", filter.Project)
+	}
+	if filter.Result != "" {
+		add("result = ?", filter.Result)
+	}
+	if filter.Since > 0 {
+		add("time >= ?", filter.Since)
+	}
+	if filter.Until > 0 {
+		add("time < ?", filter.Until)
+	}
+	if afterID > 0 {
+		add("id > ?", afterID)
+	}
+
+	query := `SELECT id, time, actor, source_ip, method, path, action, vmid, volid, project,
+		params, status, result, error, upids, duration_ms FROM audit_log`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id " + order
+	query += " LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Failed to close rows: %v", closeErr)
+		}
+	}()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var entry Entry
+		var params, upids string
+		if err := rows.Scan(&entry.ID, &entry.Time, &entry.Actor, &entry.SourceIP, &entry.Method,
+			&entry.Path, &entry.Action, &entry.VMID, &entry.VolID, &entry.Project, &params,
+			&entry.Status, &entry.Result, &entry.Error, &upids, &entry.DurationMS); err != nil {
+			return nil, fmt.Errorf("failed to read audit entry: %w", err)
+		}
+		if params != "" {
+			if err := json.Unmarshal([]byte(params), &entry.Params); err != nil {
+				log.Printf("[WARNING] Audit entry %d has invalid params: %v", entry.ID, err)
+			}
+		}
+		if upids != "" {
+			entry.UPIDs = strings.Split(upids, ",")
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,81 @@
+package auth
+
+import "fmt"
+
+// Role is what a user may do in a project they are a member of. Global admins
+// (User.Admin) may do everything in every project.
+type Role string
+
+const (
+	// RoleViewer may see the project and its guests and volumes
+	RoleViewer Role = "viewer"
+	// RoleOperator may also start, stop and snapshot them and use consoles
+	RoleOperator Role = "operator"
+	// RoleAdmin may also create, change and delete them and manage members
+	RoleAdmin Role = "admin"
+)
+
+// Action is the kind of access a request needs to a project's resources
+type Action int
+
+const (
+	// ActionView reads a resource
+	ActionView Action = iota
+	// ActionOperate changes a resource's state without changing or deleting it
+	ActionOperate
+	// ActionManage creates, reconfigures or deletes a resource
+	ActionManage
+)
+
+// String returns the name of the action for error messages
+func (a Action) String() string {
+	switch a {
+	case ActionView:
+		return "view"
+	case ActionOperate:
+		return "operate"
+	case ActionManage:
+		return "manage"
+	}
+	return fmt.Sprintf("action(%d)", int(a))
+}
+
+// ParseRole checks that a role name is valid
+func ParseRole(name string) (Role, error) {
+	switch role := Role(name); role {
+	case RoleViewer, RoleOperator, RoleAdmin:
+		return role, nil
+	}
+	return "", fmt.Errorf("invalid role %q (must be admin, operator or viewer)", name)
+}
+
+// Allows reports whether the role permits an action. The empty role, for
+// users who are not members, permits nothing.
+func (r Role) Allows(action Action) bool {
+	switch r {
+	case RoleAdmin:
+		return true
+	case RoleOperator:
+		return action <= ActionOperate
+	case RoleViewer:
+		return action == ActionView
+	}
+	return false
+}
+
+// Outranks reports whether the role permits more than another role
+func (r Role) Outranks(other Role) bool {
+	return r.rank() > other.rank()
+}
+
+func (r Role) rank() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleOperator:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
@@ -0,0 +1,621 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrInvalidCredentials is returned for unknown users, wrong passwords
+	// and unknown, expired or revoked tokens alike, so callers cannot tell
+	// which part was wrong
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrNotFound is returned for users and API keys that do not exist
+	ErrNotFound = errors.New("not found")
+	// ErrExists is returned when creating a user that already exists
+	ErrExists = errors.New("already exists")
+)
+
+// APIKeyPrefix starts every API key, so keys can be told apart from session
+// tokens and found by secret scanners
+const APIKeyPrefix = "pxc_"
+
+// MinPasswordLength is the shortest password accepted for a user
+const MinPasswordLength = 8
+
+// dummyHash is compared against when a login has no password hash to check,
+// so unknown usernames take as long to reject as wrong passwords
+var dummyHash = []byte("$2a$10$gySTkb1r43FWxZGB8gib6.yKJQHLB6GzySafQf3YE3SMNzrlkLnd2")
+
+// usernamePattern is the format of usernames
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._@-]{0,63}$`)
+
+// Store keeps local users, login sessions and API keys. Passwords are stored
+// as bcrypt hashes; session tokens and API keys are random and stored as
+// SHA-256 hashes, so a leaked database cannot be used to log in.
+type Store struct {
+	db *sql.DB
+}
+
+// User is a ProxiCloud user. External users sign in through the identity
+// provider and have no password.
+type User struct {
+	Username  string `json:"username"`
+	Admin     bool   `json:"admin"`
+	External  bool   `json:"external,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// APIKey describes a long-lived key for automation. The key itself is only
+// returned once, when it is created.
+type APIKey struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Username   string `json:"username"`
+	Prefix     string `json:"prefix"` // Start of the key, to recognise it by
+	CreatedAt  int64  `json:"created_at"`
+	ExpiresAt  int64  `json:"expires_at,omitempty"` // 0 for keys that do not expire
+	LastUsedAt int64  `json:"last_used_at,omitempty"`
+}
+
+// NewStore opens the auth database, creating it if needed
+func NewStore(dbPath string) (*Store, error) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open auth database: %w", err)
+	}
+
+	store := &Store{db: db}
+	if err := store.initialize(); err != nil {
+		if closeErr := db.Close(); closeErr != nil {
+			log.Printf("Failed to close database after initialization error: %v", closeErr)
+		}
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// initialize creates the auth tables if they don't exist
+func (s *Store) initialize() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS users (
+		username TEXT PRIMARY KEY,
+		password_hash TEXT NOT NULL,
+		admin INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS sessions (
+		token_hash TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		key_hash TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL,
+		username TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL DEFAULT 0,
+		last_used_at INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_sessions_username ON sessions(username);
+	CREATE INDEX IF NOT EXISTS idx_api_keys_username ON api_keys(username);
+	`
+
+	if _, err := s.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to create auth tables: %w", err)
+	}
+
+	// Columns added after the tables were first created
+	columns := []struct{ table, column, definition string }{
+		{"users", "external", "INTEGER NOT NULL DEFAULT 0"},
+		{"sessions", "refresh_token", "TEXT NOT NULL DEFAULT ''"},
+		{"sessions", "refresh_at", "INTEGER NOT NULL DEFAULT 0"},
+		{"users", "oidc_issuer", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "oidc_subject", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, c := range columns {
+		if err := s.addColumn(c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	// An identity provider account maps to one external user
+	if _, err := s.db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc
+		ON users(oidc_issuer, oidc_subject) WHERE oidc_subject != ''`); err != nil {
+		return fmt.Errorf("failed to create auth index: %w", err)
+	}
+
+	return nil
+}
+
+// addColumn adds a column to a table unless it already has it
+func (s *Store) addColumn(table, column, definition string) error {
+	rows, err := s.db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Failed to close rows: %v", closeErr)
+		}
+	}()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// Close closes the auth database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// randomToken returns n random bytes, URL-safe base64 encoded
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the hash a session token or API key is stored under
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GeneratePassword returns a random password, e.g. for the initial admin
+func GeneratePassword() (string, error) {
+	return randomToken(18)
+}
+
+// HasUsers reports whether any user exists
+func (s *Store) HasUsers() (bool, error) {
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// CreateUser creates a local user with a bcrypt-hashed password
+func (s *Store) CreateUser(username, password string, admin bool) (*User, error) {
+	if !usernamePattern.MatchString(username) {
+		return nil, fmt.Errorf("invalid username %q: use letters, digits and . _ @ -", username)
+	}
+	if len(password) < MinPasswordLength {
+		return nil, fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	user := &User{Username: username, Admin: admin, CreatedAt: time.Now().Unix()}
+	_, err = s.db.Exec(
+		"INSERT INTO users (username, password_hash, admin, created_at) VALUES (?, ?, ?, ?)",
+		user.Username, string(hash), user.Admin, user.CreatedAt,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return nil, fmt.Errorf("user %s %w", username, ErrExists)
+		}
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	return user, nil
+}
+
+// EnsureExternalUser creates or updates the user an identity provider
+// account, identified by its issuer and subject, signs in as. The username is
+// only used to name a new user: an account that is renamed at the provider
+// keeps its user, and a username that belongs to another account is
+// rejected. Local users with a password are never taken over.
+func (s *Store) EnsureExternalUser(issuer, subject, username string, admin bool) (*User, error) {
+	if subject == "" {
+		return nil, fmt.Errorf("identity provider account has no subject")
+	}
+
+	var linked string
+	err := s.db.QueryRow("SELECT username FROM users WHERE oidc_issuer = ? AND oidc_subject = ?", issuer, subject).Scan(&linked)
+	switch {
+	case err == nil:
+		username = linked
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	case !usernamePattern.MatchString(username):
+		return nil, fmt.Errorf("invalid username %q: use letters, digits and . _ @ -", username)
+	}
+
+	user, err := s.GetUser(username)
+	if errors.Is(err, ErrNotFound) {
+		user = &User{Username: username, Admin: admin, External: true, CreatedAt: time.Now().Unix()}
+		_, err = s.db.Exec(
+			"INSERT INTO users (username, password_hash, admin, external, oidc_issuer, oidc_subject, created_at) VALUES (?, '', ?, 1, ?, ?, ?)",
+			user.Username, user.Admin, issuer, subject, user.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create user: %w", err)
+		}
+		return user, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !user.External {
+		return nil, fmt.Errorf("user %s is a local user %w", username, ErrExists)
+	}
+
+	if linked == "" {
+		// Users created before accounts were linked are linked on their next
+		// login; users linked to another account are not taken over
+		result, err := s.db.Exec(
+			"UPDATE users SET oidc_issuer = ?, oidc_subject = ? WHERE username = ? AND oidc_subject = ''",
+			issuer, subject, username,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to link user: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return nil, fmt.Errorf("user %s belongs to another identity provider account %w", username, ErrExists)
+		}
+	}
+
+	if user.Admin != admin {
+		if _, err := s.db.Exec("UPDATE users SET admin = ? WHERE username = ?", admin, username); err != nil {
+			return nil, fmt.Errorf("failed to update user: %w", err)
+		}
+		user.Admin = admin
+	}
+	return user, nil
+}
+
+// ExternalIdentity returns the issuer and subject of the identity provider
+// account an external user is linked to
+func (s *Store) ExternalIdentity(username string) (string, string, error) {
+	var issuer, subject string
+	err := s.db.QueryRow("SELECT oidc_issuer, oidc_subject FROM users WHERE username = ?", username).Scan(&issuer, &subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", fmt.Errorf("user %s %w", username, ErrNotFound)
+	}
+	return issuer, subject, err
+}
+
+// GetUser returns a user by name
+func (s *Store) GetUser(username string) (*User, error) {
+	var user User
+	err := s.db.QueryRow("SELECT username, admin, external, created_at FROM users WHERE username = ?", username).
+		Scan(&user.Username, &user.Admin, &user.External, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("user %s %w", username, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ListUsers returns all users sorted by name
+func (s *Store) ListUsers() ([]User, error) {
+	rows, err := s.db.Query("SELECT username, admin, external, created_at FROM users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Failed to close rows: %v", closeErr)
+		}
+	}()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.Username, &user.Admin, &user.External, &user.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// DeleteUser deletes a user with their sessions and API keys
+func (s *Store) DeleteUser(username string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("Failed to rollback transaction: %v", err)
+		}
+	}()
+
+	result, err := tx.Exec("DELETE FROM users WHERE username = ?", username)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %s %w", username, ErrNotFound)
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE username = ?", username); err != nil {
+		return fmt.Errorf("failed to end sessions: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM api_keys WHERE username = ?", username); err != nil {
+		return fmt.Errorf("failed to revoke API keys: %w", err)
+	}
+	return tx.Commit()
+}
+
+// SetPassword changes a user's password and ends their sessions
+func (s *Store) SetPassword(username, password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	user, err := s.GetUser(username)
+	if err != nil {
+		return err
+	}
+	if user.External {
+		return fmt.Errorf("user %s signs in through the identity provider and has no password", username)
+	}
+
+	if _, err := s.db.Exec("UPDATE users SET password_hash = ? WHERE username = ?", string(hash), username); err != nil {
+		return fmt.Errorf("failed to set password: %w", err)
+	}
+	if _, err := s.db.Exec("DELETE FROM sessions WHERE username = ?", username); err != nil {
+		return fmt.Errorf("failed to end sessions: %w", err)
+	}
+	return nil
+}
+
+// Authenticate checks a username and password. Unknown and external users
+// are rejected only after a bcrypt comparison, like wrong passwords.
+func (s *Store) Authenticate(username, password string) (*User, error) {
+	var user User
+	var hash string
+	err := s.db.QueryRow("SELECT username, password_hash, admin, external, created_at FROM users WHERE username = ?", username).
+		Scan(&user.Username, &hash, &user.Admin, &user.External, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.External {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &user, nil
+}
+
+// CreateSession starts a login session for a user and returns its token
+func (s *Store) CreateSession(username string, ttl time.Duration) (string, time.Time, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	expires := now.Add(ttl)
+	_, err = s.db.Exec(
+		"INSERT INTO sessions (token_hash, username, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		hashToken(token), username, now.Unix(), expires.Unix(),
+	)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create session: %w", err)
+	}
+	return token, expires, nil
+}
+
+// LookupSession returns the user a session token belongs to
+func (s *Store) LookupSession(token string) (*User, error) {
+	var user User
+	err := s.db.QueryRow(`
+		SELECT u.username, u.admin, u.external, u.created_at
+		FROM sessions s JOIN users u ON u.username = s.username
+		WHERE s.token_hash = ? AND s.expires_at > ?`,
+		hashToken(token), time.Now().Unix(),
+	).Scan(&user.Username, &user.Admin, &user.External, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// DeleteSession ends a login session
+func (s *Store) DeleteSession(token string) error {
+	if _, err := s.db.Exec("DELETE FROM sessions WHERE token_hash = ?", hashToken(token)); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// SetSessionRefresh stores the identity provider refresh token of a session
+// and when it is next due to be used. The refresh token is stored as is, as
+// it has to be sent back to the identity provider.
+func (s *Store) SetSessionRefresh(token, refreshToken string, refreshAt time.Time) error {
+	var at int64
+	if !refreshAt.IsZero() {
+		at = refreshAt.Unix()
+	}
+	if _, err := s.db.Exec(
+		"UPDATE sessions SET refresh_token = ?, refresh_at = ? WHERE token_hash = ?",
+		refreshToken, at, hashToken(token),
+	); err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	return nil
+}
+
+// GetSessionRefresh returns the refresh token of a session and when it is
+// due, or "" for sessions that are not refreshed
+func (s *Store) GetSessionRefresh(token string) (string, time.Time, error) {
+	var refreshToken string
+	var refreshAt int64
+	err := s.db.QueryRow("SELECT refresh_token, refresh_at FROM sessions WHERE token_hash = ?", hashToken(token)).
+		Scan(&refreshToken, &refreshAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", time.Time{}, ErrInvalidCredentials
+	}
+	if err != nil || refreshToken == "" {
+		return "", time.Time{}, err
+	}
+	return refreshToken, time.Unix(refreshAt, 0), nil
+}
+
+// ExtendSession moves the expiry of a session ttl from now
+func (s *Store) ExtendSession(token string, ttl time.Duration) (time.Time, error) {
+	expires := time.Now().Add(ttl)
+	if _, err := s.db.Exec("UPDATE sessions SET expires_at = ? WHERE token_hash = ?", expires.Unix(), hashToken(token)); err != nil {
+		return time.Time{}, fmt.Errorf("failed to extend session: %w", err)
+	}
+	return expires, nil
+}
+
+// CleanExpiredSessions removes sessions that have expired
+func (s *Store) CleanExpiredSessions() error {
+	if _, err := s.db.Exec("DELETE FROM sessions WHERE expires_at <= ?", time.Now().Unix()); err != nil {
+		return fmt.Errorf("failed to clean expired sessions: %w", err)
+	}
+	return nil
+}
+
+// CreateAPIKey creates an API key for a user and returns the key, which is
+// not stored and cannot be shown again. A zero expiry never expires.
+func (s *Store) CreateAPIKey(username, name string, expiresAt int64) (string, *APIKey, error) {
+	id, err := randomToken(6)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	key := APIKeyPrefix + secret
+
+	apiKey := &APIKey{
+		ID:        id,
+		Name:      name,
+		Username:  username,
+		Prefix:    key[:len(APIKeyPrefix)+6],
+		CreatedAt: time.Now().Unix(),
+		ExpiresAt: expiresAt,
+	}
+	_, err = s.db.Exec(
+		"INSERT INTO api_keys (id, key_hash, name, username, prefix, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		apiKey.ID, hashToken(key), apiKey.Name, apiKey.Username, apiKey.Prefix, apiKey.CreatedAt, apiKey.ExpiresAt,
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create API key: %w", err)
+	}
+	return key, apiKey, nil
+}
+
+// ListAPIKeys returns the API keys of a user, newest first
+func (s *Store) ListAPIKeys(username string) ([]APIKey, error) {
+	rows, err := s.db.Query(
+		"SELECT id, name, username, prefix, created_at, expires_at, last_used_at FROM api_keys WHERE username = ? ORDER BY created_at DESC, id",
+		username,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Failed to close rows: %v", closeErr)
+		}
+	}()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		if err := rows.Scan(&key.ID, &key.Name, &key.Username, &key.Prefix, &key.CreatedAt, &key.ExpiresAt, &key.LastUsedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteAPIKey revokes one of a user's API keys
+func (s *Store) DeleteAPIKey(username, id string) error {
+	result, err := s.db.Exec("DELETE FROM api_keys WHERE id = ? AND username = ?", id, username)
+	if err != nil {
+		return fmt.Errorf("failed to delete API key: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("API key %s %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// LookupAPIKey returns the user an API key belongs to and records its use.
+// Keys of external users are not accepted, since only the identity provider
+// can vouch for them.
+func (s *Store) LookupAPIKey(key string) (*User, error) {
+	now := time.Now().Unix()
+	hash := hashToken(key)
+
+	var user User
+	err := s.db.QueryRow(`
+		SELECT u.username, u.admin, u.external, u.created_at
+		FROM api_keys k JOIN users u ON u.username = k.username
+		WHERE k.key_hash = ? AND (k.expires_at = 0 OR k.expires_at > ?) AND u.external = 0`,
+		hash, now,
+	).Scan(&user.Username, &user.Admin, &user.External, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.db.Exec("UPDATE api_keys SET last_used_at = ? WHERE key_hash = ?", now, hash); err != nil {
+		log.Printf("Failed to record API key use: %v", err)
+	}
+	return &user, nil
+}
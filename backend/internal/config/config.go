@@ -66,5 +66,61 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("proxmox token_secret is required")
 	}
 
+	switch c.Proxmox.UsageExec {
+	case "", "pct", "ssh":
+	default:
+		return fmt.Errorf("invalid proxmox usage_exec: %s (must be pct or ssh)", c.Proxmox.UsageExec)
+	}
+
+	if c.Auth.SessionTTL < 0 {
+		return fmt.Errorf("invalid auth session_ttl: %d", c.Auth.SessionTTL)
+	}
+
+	if oidc := c.Auth.OIDC; oidc.Issuer != "" {
+		if oidc.ClientID == "" {
+			return fmt.Errorf("auth oidc client_id is required")
+		}
+		if oidc.RedirectURL == "" {
+			return fmt.Errorf("auth oidc redirect_url is required")
+		}
+		for _, mapping := range oidc.ProjectRoles {
+			if mapping.Group == "" || mapping.Project == "" {
+				return fmt.Errorf("auth oidc project_roles: group and project are required")
+			}
+			switch mapping.Role {
+			case "admin", "operator", "viewer":
+			default:
+				return fmt.Errorf("auth oidc project_roles: invalid role %q for group %s (must be admin, operator or viewer)", mapping.Role, mapping.Group)
+			}
+		}
+	} else if c.Auth.PasswordLogin != nil && !*c.Auth.PasswordLogin {
+		return fmt.Errorf("auth password_login can only be disabled when auth oidc is configured")
+	}
+
+	for _, origin := range c.Server.CORSOrigins {
+		if origin == "*" {
+			return fmt.Errorf("server cors_origins must list origins explicitly, not *")
+		}
+	}
+
+	defaultClass := ""
+	for name, class := range c.StorageClasses {
+		if class.Storage == "" {
+			return fmt.Errorf("storage class %s: storage is required", name)
+		}
+		if class.DefaultSize < 0 || class.MaxSize < 0 {
+			return fmt.Errorf("storage class %s: sizes must not be negative", name)
+		}
+		if class.MaxSize > 0 && class.DefaultSize > class.MaxSize {
+			return fmt.Errorf("storage class %s: default_size exceeds max_size", name)
+		}
+		if class.Default {
+			if defaultClass != "" {
+				return fmt.Errorf("storage classes %s and %s are both marked default", defaultClass, name)
+			}
+			defaultClass = name
+		}
+	}
+
 	return nil
 }
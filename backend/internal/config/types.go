@@ -2,21 +2,80 @@ package config
 
 // Config represents the application configuration
 type Config struct {
-	Server  ServerConfig  `yaml:"server"`
-	Proxmox ProxmoxConfig `yaml:"proxmox"`
+	Server         ServerConfig                  `yaml:"server"`
+	Proxmox        ProxmoxConfig                 `yaml:"proxmox"`
+	StorageClasses map[string]StorageClassConfig `yaml:"storage_classes"` // Keyed by class name, e.g. "ssd"
+	Auth           AuthConfig                    `yaml:"auth"`
 }
 
 // ServerConfig holds server-specific configuration
 type ServerConfig struct {
-	Port int    `yaml:"port"`
-	Host string `yaml:"host"`
+	Port        int      `yaml:"port"`
+	Host        string   `yaml:"host"`
+	CORSOrigins []string `yaml:"cors_origins"` // Origins allowed to call the API with credentials (default: any origin, without credentials)
+}
+
+// AuthConfig holds API authentication configuration
+type AuthConfig struct {
+	SessionTTL    int        `yaml:"session_ttl"`    // Login session lifetime in hours (default: 12)
+	PasswordLogin *bool      `yaml:"password_login"` // Whether local users may log in with a password (default: true)
+	OIDC          OIDCConfig `yaml:"oidc"`
+}
+
+// OIDCConfig holds OpenID Connect single sign-on configuration. Single
+// sign-on is enabled when an issuer is set.
+type OIDCConfig struct {
+	Issuer        string   `yaml:"issuer"`         // Identity provider issuer URL
+	ClientID      string   `yaml:"client_id"`      // Client registered with the provider
+	ClientSecret  string   `yaml:"client_secret"`  // Client secret (empty for public clients)
+	RedirectURL   string   `yaml:"redirect_url"`   // Callback URL, ending in /api/auth/oidc/callback
+	FrontendURL   string   `yaml:"frontend_url"`   // Where to send the browser after login (default: the redirect URL's origin)
+	DisplayName   string   `yaml:"display_name"`   // Shown on the login button (default: "Single sign-on")
+	Scopes        []string `yaml:"scopes"`         // Scopes besides openid (default: profile, email, groups, offline_access)
+	UsernameClaim string   `yaml:"username_claim"` // ID token claim with the username (default: preferred_username)
+	GroupsClaim   string   `yaml:"groups_claim"`   // ID token claim with the groups (default: groups)
+
+	AdminGroups  []string                `yaml:"admin_groups"`  // Groups whose members are global admins
+	ProjectRoles []OIDCProjectRoleConfig `yaml:"project_roles"` // Groups whose members get a role in a project
+}
+
+// OIDCProjectRoleConfig gives the members of an identity provider group a
+// role in a project
+type OIDCProjectRoleConfig struct {
+	Group   string `yaml:"group"`
+	Project string `yaml:"project"` // Project ID or name
+	Role    string `yaml:"role"`    // admin, operator or viewer
 }
 
 // ProxmoxConfig holds Proxmox API configuration
 type ProxmoxConfig struct {
-	Host        string `yaml:"host"`
-	Node        string `yaml:"node"`
-	TokenID     string `yaml:"token_id"`
-	TokenSecret string `yaml:"token_secret"`
-	Insecure    bool   `yaml:"insecure"`
+	Host           string `yaml:"host"`
+	Node           string `yaml:"node"`
+	TokenID        string `yaml:"token_id"`
+	TokenSecret    string `yaml:"token_secret"`
+	Insecure       bool   `yaml:"insecure"`
+	RequestTimeout int    `yaml:"request_timeout"` // Per-call deadline in seconds (default: 60)
+
+	MaxRetries       *int `yaml:"max_retries"`       // Retries for transient failures of idempotent calls (default: 3, 0 disables)
+	RetryBackoff     int  `yaml:"retry_backoff_ms"`  // Initial retry backoff in milliseconds (default: 250)
+	BreakerThreshold *int `yaml:"breaker_threshold"` // Consecutive failures before failing fast (default: 5, 0 disables)
+	BreakerCooldown  int  `yaml:"breaker_cooldown"`  // Seconds to fail fast before probing Proxmox again (default: 30)
+
+	ConfigConcurrency int `yaml:"config_concurrency"` // Parallel container config reads when listing (default: 8)
+
+	UsageExec    string `yaml:"usage_exec"`     // How to run df in containers for mount point usage: "pct" or "ssh" (default: disabled)
+	UsageSSHUser string `yaml:"usage_ssh_user"` // SSH user on the nodes for "ssh" (default: root)
+	UsageSSHKey  string `yaml:"usage_ssh_key"`  // SSH identity file for "ssh"
+}
+
+// StorageClassConfig maps a storage class to a Proxmox storage pool and sets
+// the limits of volumes and container disks created in it
+type StorageClassConfig struct {
+	Storage     string   `yaml:"storage"`      // Proxmox storage pool backing the class
+	Description string   `yaml:"description"`  // Shown to users when picking a class
+	Default     bool     `yaml:"default"`      // Used when a request names no class
+	DefaultSize int      `yaml:"default_size"` // Size in GB when a request gives none
+	MaxSize     int      `yaml:"max_size"`     // Largest volume or disk in GB (0 for no limit)
+	Snapshots   bool     `yaml:"snapshots"`    // Whether volumes in the class may be snapshotted
+	Projects    []string `yaml:"projects"`     // Projects allowed to use the class (empty for all)
 }
@@ -0,0 +1,469 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/auth"
+	"github.com/MasonD-007/proxicloud/backend/internal/proxmox"
+	"github.com/MasonD-007/proxicloud/backend/internal/scheduler"
+	"github.com/gorilla/mux"
+)
+
+// maxPolicyBody limits how much of a request body is read to find the
+// project, guest or backup a request is for
+const maxPolicyBody = 1 << 20
+
+// policyBody holds the fields of request bodies that policies look at
+type policyBody struct {
+	ProjectID *string `json:"project_id"`
+	VMID      *int    `json:"vmid"`
+	VolID     string  `json:"volid"`
+	Force     bool    `json:"force"`
+}
+
+// projectResolver returns the project a policy check applies to. Resources in
+// no project resolve to "", which only global admins may access. It returns
+// false if the check does not apply to the request.
+type projectResolver func(h *Handler, r *http.Request, body *policyBody) (string, bool)
+
+// projectCheck requires an action on the project a resolver finds
+type projectCheck struct {
+	resolve projectResolver
+	action  auth.Action
+}
+
+// routePolicy says who may call a route
+type routePolicy struct {
+	public bool           // Anyone, without logging in
+	admin  bool           // Global admins only
+	checks []projectCheck // For other users every check must pass; none means any user
+}
+
+func publicRoute() routePolicy                    { return routePolicy{public: true} }
+func anyUser() routePolicy                        { return routePolicy{} }
+func adminOnly() routePolicy                      { return routePolicy{admin: true} }
+func requires(checks ...projectCheck) routePolicy { return routePolicy{checks: checks} }
+func check(resolve projectResolver, action auth.Action) projectCheck {
+	return projectCheck{resolve: resolve, action: action}
+}
+
+// routePolicies is the access policy of every API route, keyed by method and
+// path template. Routes missing from it are for global admins only.
+var routePolicies = map[string]routePolicy{
+	"GET /health":                              publicRoute(),
+	"POST /auth/login":                         publicRoute(),
+	"GET /auth/providers":                      publicRoute(),
+	"GET /auth/oidc/login":                     publicRoute(),
+	"GET /auth/oidc/callback":                  publicRoute(),
+	"POST /auth/oidc/exchange":                 publicRoute(),
+	"POST /auth/logout":                        anyUser(),
+	"GET /auth/me":                             anyUser(),
+	"PUT /auth/password":                       anyUser(),
+	"GET /auth/keys":                           anyUser(),
+	"POST /auth/keys":                          anyUser(),
+	"DELETE /auth/keys/{id}":                   anyUser(),
+	"GET /auth/users":                          adminOnly(),
+	"POST /auth/users":                         adminOnly(),
+	"DELETE /auth/users/{username}":            adminOnly(),
+	"GET /dashboard":                           anyUser(), // Filtered by Dashboard
+	"GET /nodes":                               anyUser(),
+	"POST /nodes/{node}/drain":                 adminOnly(),
+	"GET /nodes/{node}/drain":                  adminOnly(),
+	"GET /tasks/{id}":                          requires(check(taskVar, auth.ActionView)),
+	"GET /templates":                           anyUser(),
+	"POST /templates/upload":                   adminOnly(),
+	"GET /storage":                             anyUser(),
+	"GET /storage-classes":                     anyUser(),
+	"GET /analytics/stats":                     adminOnly(),
+	"GET /containers":                          anyUser(), // Filtered by ListContainers
+	"GET /vms":                                 anyUser(), // Filtered by ListVMs
+	"GET /volumes":                             anyUser(), // Filtered by ListVolumes
+	"GET /backups":                             anyUser(), // Filtered by ListBackups
+	"GET /projects":                            anyUser(), // Filtered by ListProjects
+	"POST /projects":                           adminOnly(),
+	"DELETE /projects/{id}":                    adminOnly(),
+	"GET /volumes/orphans":                     adminOnly(),
+	"POST /volumes/orphans/cleanup":            adminOnly(),
+	"GET /policies":                            anyUser(), // Filtered by ListPolicies
+	"GET /policies/{id}":                       requires(check(policyVar, auth.ActionView)),
+	"GET /policies/{id}/runs":                  requires(check(policyVar, auth.ActionView)),
+	"POST /policies":                           adminOnly(),
+	"PUT /policies/{id}":                       adminOnly(),
+	"DELETE /policies/{id}":                    adminOnly(),
+	"POST /policies/{id}/run":                  adminOnly(),
+	"GET /projects/{id}":                       requires(check(projectVar, auth.ActionView)),
+	"PUT /projects/{id}":                       requires(check(projectVar, auth.ActionManage)),
+	"GET /projects/{id}/containers":            requires(check(projectVar, auth.ActionView)),
+	"GET /projects/{id}/members":               requires(check(projectVar, auth.ActionView)),
+	"PUT /projects/{id}/members/{username}":    requires(check(projectVar, auth.ActionManage)),
+	"DELETE /projects/{id}/members/{username}": requires(check(projectVar, auth.ActionManage)),
+
+	"POST /containers":                                  requires(check(bodyProject, auth.ActionManage)),
+	"GET /containers/{vmid}":                            requires(check(guestVar, auth.ActionView)),
+	"PATCH /containers/{vmid}":                          requires(check(guestVar, auth.ActionManage)),
+	"DELETE /containers/{vmid}":                         requires(check(guestVar, auth.ActionManage)),
+	"GET /containers/{vmid}/pending":                    requires(check(guestVar, auth.ActionView)),
+	"POST /containers/{vmid}/clone":                     requires(check(guestVar, auth.ActionManage), check(bodyProject, auth.ActionManage)),
+	"POST /containers/{vmid}/template":                  requires(check(guestVar, auth.ActionManage)),
+	"POST /containers/{vmid}/migrate":                   requires(check(guestVar, auth.ActionManage)),
+	"GET /containers/{vmid}/snapshots":                  requires(check(guestVar, auth.ActionView)),
+	"POST /containers/{vmid}/snapshots":                 requires(check(guestVar, auth.ActionOperate)),
+	"DELETE /containers/{vmid}/snapshots/{name}":        requires(check(guestVar, auth.ActionManage)),
+	"POST /containers/{vmid}/snapshots/{name}/rollback": requires(check(guestVar, auth.ActionManage)),
+	"POST /containers/{vmid}/start":                     requires(check(guestVar, auth.ActionOperate)),
+	"POST /containers/{vmid}/stop":                      requires(check(guestVar, auth.ActionOperate)),
+	"POST /containers/{vmid}/reboot":                    requires(check(guestVar, auth.ActionOperate)),
+	"POST /containers/{vmid}/termproxy":                 requires(check(guestVar, auth.ActionOperate)),
+	"POST /containers/{vmid}/project":                   requires(check(guestVar, auth.ActionManage), check(bodyProject, auth.ActionManage)),
+	"GET /containers/{vmid}/metrics":                    requires(check(guestVar, auth.ActionView)),
+	"GET /containers/{vmid}/metrics/summary":            requires(check(guestVar, auth.ActionView)),
+
+	"POST /vms":                       requires(check(bodyProject, auth.ActionManage)),
+	"GET /vms/{vmid}":                 requires(check(guestVar, auth.ActionView)),
+	"DELETE /vms/{vmid}":              requires(check(guestVar, auth.ActionManage)),
+	"PUT /vms/{vmid}/config":          requires(check(guestVar, auth.ActionManage)),
+	"POST /vms/{vmid}/clone":          requires(check(guestVar, auth.ActionManage), check(bodyProject, auth.ActionManage)),
+	"POST /vms/{vmid}/start":          requires(check(guestVar, auth.ActionOperate)),
+	"POST /vms/{vmid}/stop":           requires(check(guestVar, auth.ActionOperate)),
+	"POST /vms/{vmid}/reboot":         requires(check(guestVar, auth.ActionOperate)),
+	"POST /vms/{vmid}/project":        requires(check(guestVar, auth.ActionManage), check(bodyProject, auth.ActionManage)),
+	"GET /vms/{vmid}/metrics":         requires(check(guestVar, auth.ActionView)),
+	"GET /vms/{vmid}/metrics/summary": requires(check(guestVar, auth.ActionView)),
+
+	"POST /volumes":                           requires(check(bodyProject, auth.ActionManage)),
+	"GET /volumes/{volid}":                    requires(check(volumeVar, auth.ActionView)),
+	"PATCH /volumes/{volid}":                  requires(check(volumeVar, auth.ActionManage), check(bodyProjectIfSet, auth.ActionManage)),
+	"DELETE /volumes/{volid}":                 requires(check(volumeVar, auth.ActionManage)),
+	"GET /volumes/{volid}/history":            requires(check(volumeVar, auth.ActionView)),
+	"GET /volumes/{volid}/metrics":            requires(check(volumeVar, auth.ActionView)),
+	"POST /volumes/{volid}/attach/{vmid}":     requires(check(volumeVar, auth.ActionOperate), check(guestVar, auth.ActionOperate)),
+	"POST /volumes/{volid}/detach/{vmid}":     requires(check(volumeVar, auth.ActionOperate), check(guestVar, auth.ActionOperate)),
+	"POST /volumes/{volid}/resize":            requires(check(volumeVar, auth.ActionManage)),
+	"POST /volumes/{volid}/move":              requires(check(volumeVar, auth.ActionManage)),
+	"GET /volumes/{volid}/snapshots":          requires(check(volumeVar, auth.ActionView)),
+	"POST /volumes/{volid}/snapshots":         requires(check(volumeVar, auth.ActionOperate)),
+	"POST /volumes/{volid}/snapshots/restore": requires(check(volumeVar, auth.ActionManage)),
+	"POST /volumes/{volid}/snapshots/clone":   requires(check(volumeVar, auth.ActionManage)),
+
+	"POST /backups":              requires(check(bodyGuest, auth.ActionOperate)),
+	"POST /backups/restore":      requires(check(bodyBackup, auth.ActionManage), check(bodyProject, auth.ActionManage), check(bodyForcedGuest, auth.ActionManage)),
+	"DELETE /backups/{volid:.+}": requires(check(backupVar, auth.ActionManage)),
+
+	"GET /audit": adminOnly(),
+}
+
+// guestProject returns the project a container or VM is assigned to
+func (h *Handler) guestProject(vmid int) string {
+	if h.projectStore == nil || vmid <= 0 {
+		return ""
+	}
+	return h.projectStore.GetContainerProject(vmid)
+}
+
+// volumeProject returns the project a volume was assigned to, or else the
+// project of the guest that owns it
+func (h *Handler) volumeProject(volid string) string {
+	if h.registry != nil {
+		projectID, err := h.registry.Project(volid)
+		if err != nil {
+			log.Printf("[ERROR] Failed to look up project of volume %s: %v", volid, err)
+		}
+		if projectID != "" {
+			return projectID
+		}
+	}
+	return h.guestProject(proxmox.VolumeOwner(volid))
+}
+
+func projectVar(h *Handler, r *http.Request, body *policyBody) (string, bool) {
+	return mux.Vars(r)["id"], true
+}
+
+func guestVar(h *Handler, r *http.Request, body *policyBody) (string, bool) {
+	vmid, err := strconv.Atoi(mux.Vars(r)["vmid"])
+	if err != nil {
+		return "", true
+	}
+	return h.guestProject(vmid), true
+}
+
+func volumeVar(h *Handler, r *http.Request, body *policyBody) (string, bool) {
+	return h.volumeProject(mux.Vars(r)["volid"]), true
+}
+
+func backupVar(h *Handler, r *http.Request, body *policyBody) (string, bool) {
+	return h.guestProject(proxmox.BackupOwner(mux.Vars(r)["volid"])), true
+}
+
+// policyVar is the project of the guest, volume or project a policy targets.
+// Unknown policies are left to the handler to report.
+func policyVar(h *Handler, r *http.Request, body *policyBody) (string, bool) {
+	if h.policies == nil {
+		return "", false
+	}
+	policy, err := h.policies.Store().GetPolicy(mux.Vars(r)["id"])
+	if err != nil {
+		return "", false
+	}
+	return h.targetProject(policy.Target), true
+}
+
+// taskVar is the project of the guest a task is for. Tasks that are not for a
+// guest are for global admins only.
+func taskVar(h *Handler, r *http.Request, body *policyBody) (string, bool) {
+	upid, err := proxmox.ParseUPID(mux.Vars(r)["id"])
+	if err != nil {
+		return "", true
+	}
+	return h.guestProject(upid.VMID()), true
+}
+
+// bodyProject is the project a request creates or assigns something in.
+// Without one the resource ends up in no project.
+func bodyProject(h *Handler, r *http.Request, body *policyBody) (string, bool) {
+	if body.ProjectID == nil {
+		return "", true
+	}
+	return *body.ProjectID, true
+}
+
+// bodyProjectIfSet is bodyProject for requests that only move a resource to
+// another project when project_id is given
+func bodyProjectIfSet(h *Handler, r *http.Request, body *policyBody) (string, bool) {
+	if body.ProjectID == nil {
+		return "", false
+	}
+	return *body.ProjectID, true
+}
+
+func bodyGuest(h *Handler, r *http.Request, body *policyBody) (string, bool) {
+	if body.VMID == nil {
+		return "", true
+	}
+	return h.guestProject(*body.VMID), true
+}
+
+func bodyBackup(h *Handler, r *http.Request, body *policyBody) (string, bool) {
+	return h.guestProject(proxmox.BackupOwner(body.VolID)), true
+}
+
+// bodyForcedGuest is the existing guest a forced restore overwrites
+func bodyForcedGuest(h *Handler, r *http.Request, body *policyBody) (string, bool) {
+	if !body.Force || body.VMID == nil {
+		return "", false
+	}
+	return h.guestProject(*body.VMID), true
+}
+
+// allowed reports whether a user may perform an action on a project's
+// resources. Only global admins may access resources in no project.
+func (h *Handler) allowed(user *auth.User, projectID string, action auth.Action) bool {
+	if user.Admin {
+		return true
+	}
+	if projectID == "" || h.projectStore == nil {
+		return false
+	}
+	return auth.Role(h.projectStore.GetMemberRole(projectID, user.Username)).Allows(action)
+}
+
+// visibleTo returns whether the calling user may see resources in a project,
+// for filtering listings. Requests without a user see nothing.
+func (h *Handler) visibleTo(r *http.Request) func(projectID string) bool {
+	user, ok := auth.UserFrom(r.Context())
+	if !ok {
+		return func(string) bool { return false }
+	}
+	return func(projectID string) bool { return h.allowed(user, projectID, auth.ActionView) }
+}
+
+// targetProject returns the project of the guest, volume or project a policy
+// targets
+func (h *Handler) targetProject(target scheduler.Target) string {
+	switch target.Type {
+	case scheduler.TargetContainer:
+		return h.guestProject(target.VMID)
+	case scheduler.TargetVolume:
+		return h.volumeProject(target.VolID)
+	case scheduler.TargetProject:
+		return target.ProjectID
+	}
+	return ""
+}
+
+// visibleContainers keeps the containers in projects the calling user may see
+func (h *Handler) visibleContainers(r *http.Request, containers []proxmox.Container) []proxmox.Container {
+	visible := h.visibleTo(r)
+	filtered := make([]proxmox.Container, 0, len(containers))
+	for _, container := range containers {
+		if visible(container.ProjectID) {
+			filtered = append(filtered, container)
+		}
+	}
+	return filtered
+}
+
+// visibleVolumes keeps the volumes in projects the calling user may see. A
+// volume without a project of its own is in the project of its owner.
+func (h *Handler) visibleVolumes(r *http.Request, volumes []proxmox.Volume) []proxmox.Volume {
+	visible := h.visibleTo(r)
+	filtered := make([]proxmox.Volume, 0, len(volumes))
+	for _, volume := range volumes {
+		projectID := volume.ProjectID
+		if projectID == "" {
+			projectID = h.guestProject(proxmox.VolumeOwner(volume.VolID))
+		}
+		if visible(projectID) {
+			filtered = append(filtered, volume)
+		}
+	}
+	return filtered
+}
+
+// Authorize is router middleware that evaluates the policy of the matched
+// route in routePolicies for the user the auth middleware authenticated.
+func (h *Handler) Authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := routeKey(r)
+		policy, ok := routePolicies[key]
+		if !ok {
+			log.Printf("[WARNING] No access policy for %s, allowing global admins only", key)
+			policy = adminOnly()
+		}
+
+		if policy.public {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, ok := auth.UserFrom(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		if user.Admin {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if policy.admin {
+			respondError(w, http.StatusForbidden, "admin access required")
+			return
+		}
+
+		var body policyBody
+		if len(policy.checks) > 0 && r.Body != nil {
+			data, err := io.ReadAll(io.LimitReader(r.Body, maxPolicyBody))
+			if err != nil {
+				respondError(w, http.StatusBadRequest, "failed to read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(data))
+			// Malformed bodies are rejected by the handler
+			_ = json.Unmarshal(data, &body)
+		}
+
+		for _, c := range policy.checks {
+			projectID, applies := c.resolve(h, r, &body)
+			if !applies || h.allowed(user, projectID, c.action) {
+				continue
+			}
+			if projectID == "" {
+				respondError(w, http.StatusForbidden, fmt.Sprintf("%s access to resources outside a project requires an admin", c.action))
+			} else {
+				respondError(w, http.StatusForbidden, fmt.Sprintf("%s access to project %s denied", c.action, projectID))
+			}
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// projectMembers returns the members of a project sorted by username
+func projectMembers(project *proxmox.Project) []proxmox.ProjectMember {
+	members := make([]proxmox.ProjectMember, 0, len(project.Members))
+	for username, role := range project.Members {
+		members = append(members, proxmox.ProjectMember{Username: username, Role: role})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Username < members[j].Username })
+	return members
+}
+
+// ListProjectMembers lists the members of a project and their roles
+func (h *Handler) ListProjectMembers(w http.ResponseWriter, r *http.Request) {
+	if h.projectStore == nil {
+		respondError(w, http.StatusServiceUnavailable, "project store not available")
+		return
+	}
+
+	project, err := h.projectStore.GetProject(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, projectMembers(project))
+}
+
+// SetProjectMember grants a user a role in a project
+func (h *Handler) SetProjectMember(w http.ResponseWriter, r *http.Request) {
+	if h.projectStore == nil {
+		respondError(w, http.StatusServiceUnavailable, "project store not available")
+		return
+	}
+
+	vars := mux.Vars(r)
+	if _, err := h.projectStore.GetProject(vars["id"]); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	role, err := auth.ParseRole(req.Role)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if h.auth != nil {
+		if _, err := h.auth.GetUser(vars["username"]); err != nil {
+			respondError(w, authStatus(err), err.Error())
+			return
+		}
+	}
+
+	if err := h.projectStore.SetMember(vars["id"], vars["username"], string(role)); err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("[INFO] Granted %s the %s role in project %s", vars["username"], role, vars["id"])
+	respondJSON(w, http.StatusOK, proxmox.ProjectMember{Username: vars["username"], Role: string(role)})
+}
+
+// RemoveProjectMember revokes a user's role in a project
+func (h *Handler) RemoveProjectMember(w http.ResponseWriter, r *http.Request) {
+	if h.projectStore == nil {
+		respondError(w, http.StatusServiceUnavailable, "project store not available")
+		return
+	}
+
+	vars := mux.Vars(r)
+	if err := h.projectStore.RemoveMember(vars["id"], vars["username"]); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	log.Printf("[INFO] Removed %s from project %s", vars["username"], vars["id"])
+	respondJSON(w, http.StatusOK, map[string]string{"status": "removed"})
+}
@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/audit"
+	"github.com/MasonD-007/proxicloud/backend/internal/auth"
+	"github.com/MasonD-007/proxicloud/backend/internal/proxmox"
+	"github.com/gorilla/mux"
+)
+
+// maxAuditResponse limits how much of a response is kept to find the error
+// message and the IDs of created resources
+const maxAuditResponse = 64 << 10
+
+// auditContextKey is the context key of the audit entry of a request
+type auditContextKey struct{}
+
+// SetAudit sets the store every mutating request is recorded in
+func (h *Handler) SetAudit(store *audit.Store) {
+	h.audit = store
+}
+
+// routeKey returns the method and path template of the route a request
+// matched, without the /api prefix, e.g. "DELETE /containers/{vmid}"
+func routeKey(r *http.Request) string {
+	key := r.Method
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			key += " " + strings.TrimPrefix(template, "/api")
+		}
+	}
+	return key
+}
+
+// noteTask adds a Proxmox task a request started to its audit entry
+func noteTask(r *http.Request, upid string) {
+	entry, ok := r.Context().Value(auditContextKey{}).(*audit.Entry)
+	if !ok || upid == "" {
+		return
+	}
+	for _, known := range entry.UPIDs {
+		if known == upid {
+			return
+		}
+	}
+	entry.UPIDs = append(entry.UPIDs, upid)
+}
+
+// auditRecorder captures the status and the start of the body of a response
+type auditRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *auditRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *auditRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	if room := maxAuditResponse - rec.body.Len(); room > 0 {
+		rec.body.Write(b[:min(room, len(b))])
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Audit is router middleware that records every POST, PUT, PATCH and DELETE
+// request in the audit log: who made it from where, the route, the guest,
+// volume and project it was for, its parameters with secrets redacted, the
+// result and the Proxmox tasks it started. It runs before Authorize so denied
+// requests are recorded too.
+func (h *Handler) Audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if h.audit == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		entry := &audit.Entry{
+			Time:     start.Unix(),
+			SourceIP: r.RemoteAddr,
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			Action:   routeKey(r),
+		}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			entry.SourceIP = host
+		}
+		if user, ok := auth.UserFrom(r.Context()); ok {
+			entry.Actor = user.Username
+		}
+
+		body := auditBody(r)
+		entry.Params = auditParams(r, body)
+		h.auditTargets(entry, mux.Vars(r), body)
+
+		rec := &auditRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), auditContextKey{}, entry)))
+
+		entry.Status = rec.status
+		entry.Result = audit.ResultFor(rec.status)
+		entry.DurationMS = time.Since(start).Milliseconds()
+		h.auditResponse(entry, rec.body.Bytes())
+
+		if err := h.audit.Append(entry); err != nil {
+			log.Printf("[ERROR] Failed to record %s %s by %q in the audit log: %v", r.Method, r.URL.Path, entry.Actor, err)
+		}
+	})
+}
+
+// auditBody reads a JSON request body and puts it back for the handler.
+// Uploads and other bodies that are not JSON are not read.
+func auditBody(r *http.Request) map[string]interface{} {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "application/json" {
+			return nil
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxPolicyBody))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(data), r.Body))
+	if err != nil || len(data) == maxPolicyBody {
+		return nil
+	}
+
+	var body map[string]interface{}
+	if json.Unmarshal(data, &body) != nil {
+		return nil
+	}
+	return body
+}
+
+// auditParams merges the query and body parameters of a request, with
+// secrets redacted
+func auditParams(r *http.Request, body map[string]interface{}) map[string]interface{} {
+	params := make(map[string]interface{}, len(body))
+	for name, value := range body {
+		params[name] = value
+	}
+	for name, values := range r.URL.Query() {
+		if _, ok := params[name]; ok {
+			continue
+		}
+		if len(values) == 1 {
+			params[name] = values[0]
+		} else {
+			params[name] = values
+		}
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return audit.Redact(params).(map[string]interface{})
+}
+
+// auditTargets sets the guest, volume and project a request is for from its
+// path and body. The project is looked up before the handler runs, as
+// deleting a guest also removes it from its project.
+func (h *Handler) auditTargets(entry *audit.Entry, vars map[string]string, body map[string]interface{}) {
+	if vmid, err := strconv.Atoi(vars["vmid"]); err == nil {
+		entry.VMID = vmid
+	} else if vmid, ok := body["vmid"].(float64); ok {
+		entry.VMID = int(vmid)
+	}
+	if volid := vars["volid"]; volid != "" {
+		entry.VolID = volid
+	} else if volid, ok := body["volid"].(string); ok {
+		entry.VolID = volid
+	}
+	if strings.HasPrefix(entry.Action, entry.Method+" /projects/{id}") {
+		entry.Project = vars["id"]
+	} else if projectID, ok := body["project_id"].(string); ok {
+		entry.Project = projectID
+	}
+	h.auditProject(entry)
+}
+
+// auditProject sets the project of the guest or volume a request is for
+func (h *Handler) auditProject(entry *audit.Entry) {
+	if entry.Project != "" {
+		return
+	}
+	switch {
+	case entry.VMID > 0:
+		entry.Project = h.guestProject(entry.VMID)
+	case entry.VolID != "":
+		entry.Project = h.volumeProject(entry.VolID)
+		if entry.Project == "" {
+			entry.Project = h.guestProject(proxmox.BackupOwner(entry.VolID))
+		}
+	}
+}
+
+// auditResponse takes the error message, the IDs of created resources and
+// the task ID from a JSON response
+func (h *Handler) auditResponse(entry *audit.Entry, data []byte) {
+	var response map[string]interface{}
+	if json.Unmarshal(data, &response) != nil {
+		return
+	}
+
+	if message, ok := response["error"].(string); ok && entry.Result != audit.ResultSuccess {
+		entry.Error = message
+	}
+	if entry.Result != audit.ResultSuccess {
+		return
+	}
+	if vmid, ok := response["vmid"].(float64); ok && entry.VMID == 0 {
+		entry.VMID = int(vmid)
+	}
+	if volid, ok := response["volid"].(string); ok && entry.VolID == "" {
+		entry.VolID = volid
+	}
+	if id, ok := response["id"].(string); ok && entry.Action == "POST /projects" {
+		entry.Project = id
+	}
+	if upid, ok := response["task_id"].(string); ok && len(entry.UPIDs) == 0 {
+		entry.UPIDs = []string{upid}
+	}
+	h.auditProject(entry)
+}
+
+// auditFilter reads the filters of an audit query
+func auditFilter(r *http.Request) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Actor:   query.Get("actor"),
+		Method:  query.Get("method"),
+		Action:  query.Get("action"),
+		VolID:   query.Get("volid"),
+		Project: query.Get("project"),
+		Result:  query.Get("result"),
+	}
+
+	switch filter.Result {
+	case "", audit.ResultSuccess, audit.ResultDenied, audit.ResultFailed:
+	default:
+		return filter, fmt.Errorf("invalid result %q (must be success, denied or failed)", filter.Result)
+	}
+
+	numbers := []struct {
+		name string
+		into func(int64)
+	}{
+		{"vmid", func(v int64) { filter.VMID = int(v) }},
+		{"limit", func(v int64) { filter.Limit = int(v) }},
+		{"offset", func(v int64) { filter.Offset = int(v) }},
+	}
+	for _, n := range numbers {
+		if value := query.Get(n.name); value != "" {
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || v < 0 {
+				return filter, fmt.Errorf("invalid %s: %s", n.name, value)
+			}
+			n.into(v)
+		}
+	}
+
+	times := []struct {
+		name string
+		into *int64
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	}
+	for _, t := range times {
+		value := query.Get(t.name)
+		if value == "" {
+			continue
+		}
+		if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+			*t.into = unix
+		} else if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+			*t.into = parsed.Unix()
+		} else {
+			return filter, fmt.Errorf("invalid %s: %s (use Unix time or RFC 3339)", t.name, value)
+		}
+	}
+
+	return filter, nil
+}
+
+// ListAudit returns audit log entries matching the query's filters, newest
+// first, or with format=jsonl exports them as JSON lines, oldest first (admins
+// only)
+func (h *Handler) ListAudit(w http.ResponseWriter, r *http.Request) {
+	if h.audit == nil {
+		respondError(w, http.StatusServiceUnavailable, "audit log not available")
+		return
+	}
+
+	filter, err := auditFilter(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+	case "jsonl":
+		h.exportAudit(w, filter)
+		return
+	default:
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("invalid format %q (must be json or jsonl)", format))
+		return
+	}
+
+	entries, err := h.audit.Query(filter)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, entries)
+}
+
+// exportAudit streams audit entries as JSON lines
+func (h *Handler) exportAudit(w http.ResponseWriter, filter audit.Filter) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="proxicloud-audit-%s.jsonl"`, time.Now().Format("20060102-150405")))
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	if err := h.audit.Export(filter, func(entry audit.Entry) error {
+		return encoder.Encode(entry)
+	}); err != nil {
+		// The status is already sent, so the export just ends early
+		log.Printf("[ERROR] Audit log export failed: %v", err)
+	}
+}
@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/auth"
+	"github.com/MasonD-007/proxicloud/backend/internal/middleware"
+	"github.com/gorilla/mux"
+)
+
+// DefaultSessionTTL is how long a login session lasts unless configured
+const DefaultSessionTTL = 12 * time.Hour
+
+// LoginRequest holds the credentials of a local user
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// ChangePasswordRequest changes the password of the calling user
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// CreateUserRequest creates a local user
+type CreateUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Admin    bool   `json:"admin,omitempty"`
+}
+
+// CreateAPIKeyRequest creates an API key for the calling user
+type CreateAPIKeyRequest struct {
+	Name          string `json:"name"`
+	ExpiresInDays int    `json:"expires_in_days,omitempty"` // 0 for a key that does not expire
+}
+
+// SetAuth sets the store of users, sessions and API keys and how long login
+// sessions last
+func (h *Handler) SetAuth(store *auth.Store, sessionTTL time.Duration) {
+	h.auth = store
+	h.sessionTTL = sessionTTL
+}
+
+// authStatus maps an error from the auth store to an HTTP status code
+func authStatus(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		return http.StatusUnauthorized
+	case errors.Is(err, auth.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, auth.ErrExists):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// currentUser returns the user the auth middleware authenticated the request
+// as. If there is none it writes a 401 response and returns false.
+func (h *Handler) currentUser(w http.ResponseWriter, r *http.Request) (*auth.User, bool) {
+	if h.auth == nil {
+		respondError(w, http.StatusServiceUnavailable, "authentication not available")
+		return nil, false
+	}
+	user, ok := auth.UserFrom(r.Context())
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "authentication required")
+		return nil, false
+	}
+	return user, true
+}
+
+// requireAdmin is currentUser for endpoints only admins may use
+func (h *Handler) requireAdmin(w http.ResponseWriter, r *http.Request) (*auth.User, bool) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !user.Admin {
+		respondError(w, http.StatusForbidden, "admin access required")
+		return nil, false
+	}
+	return user, true
+}
+
+// Login starts a session for a local user. The token is returned for API
+// clients and set as an HttpOnly cookie for browsers.
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	if h.auth == nil {
+		respondError(w, http.StatusServiceUnavailable, "authentication not available")
+		return
+	}
+	if h.passwordLoginDisabled {
+		respondError(w, http.StatusForbidden, "password login is disabled, use single sign-on")
+		return
+	}
+
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	user, err := h.auth.Authenticate(req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			log.Printf("[WARNING] Failed login for user %q from %s", req.Username, r.RemoteAddr)
+			respondError(w, http.StatusUnauthorized, "invalid username or password")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	token, expires, err := h.auth.CreateSession(user.Username, h.sessionTTL)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"token":      token,
+		"expires_at": expires.Unix(),
+		"user":       user,
+	})
+}
+
+// Logout ends the session the request was made with
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.currentUser(w, r); !ok {
+		return
+	}
+	if err := h.auth.DeleteSession(middleware.RequestToken(r)); err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	respondJSON(w, http.StatusOK, map[string]string{"status": "logged out"})
+}
+
+// GetCurrentUser returns the user the request is authenticated as, with their
+// role in each project they are a member of
+func (h *Handler) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	projects := map[string]string{}
+	if h.projectStore != nil {
+		projects = h.projectStore.GetUserRoles(user.Username)
+	}
+	respondJSON(w, http.StatusOK, struct {
+		*auth.User
+		Projects map[string]string `json:"projects"`
+	}{user, projects})
+}
+
+// ChangePassword changes the calling user's password, which ends all of
+// their sessions
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if user.External {
+		respondError(w, http.StatusBadRequest, "users who sign in through single sign-on have no password")
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if _, err := h.auth.Authenticate(user.Username, req.CurrentPassword); err != nil {
+		respondError(w, http.StatusForbidden, "current password is wrong")
+		return
+	}
+	if err := h.auth.SetPassword(user.Username, req.NewPassword); err != nil {
+		respondError(w, authStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "password changed"})
+}
+
+// ListAPIKeys lists the calling user's API keys
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := h.auth.ListAPIKeys(user.Username)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, keys)
+}
+
+// CreateAPIKey creates an API key for the calling user. The key is only
+// returned in this response.
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	// Keys never check back with the identity provider, so they would outlive
+	// the account there
+	if user.External {
+		respondError(w, http.StatusForbidden, "users who sign in through single sign-on cannot create API keys")
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Name == "" {
+		respondError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if req.ExpiresInDays < 0 {
+		respondError(w, http.StatusBadRequest, "expires_in_days must not be negative")
+		return
+	}
+
+	var expiresAt int64
+	if req.ExpiresInDays > 0 {
+		expiresAt = time.Now().AddDate(0, 0, req.ExpiresInDays).Unix()
+	}
+	key, apiKey, err := h.auth.CreateAPIKey(user.Username, req.Name, expiresAt)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("[INFO] User %s created API key %s (%s)", user.Username, apiKey.ID, apiKey.Name)
+	respondJSON(w, http.StatusCreated, map[string]interface{}{
+		"key":     key,
+		"api_key": apiKey,
+	})
+}
+
+// DeleteAPIKey revokes one of the calling user's API keys
+func (h *Handler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.auth.DeleteAPIKey(user.Username, mux.Vars(r)["id"]); err != nil {
+		respondError(w, authStatus(err), err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// ListUsers lists the local users (admins only)
+func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+
+	users, err := h.auth.ListUsers()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, users)
+}
+
+// CreateUser creates a local user (admins only)
+func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	admin, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	user, err := h.auth.CreateUser(req.Username, req.Password, req.Admin)
+	if err != nil {
+		respondError(w, authStatus(err), err.Error())
+		return
+	}
+
+	log.Printf("[INFO] User %s created user %s (admin: %v)", admin.Username, user.Username, user.Admin)
+	respondJSON(w, http.StatusCreated, user)
+}
+
+// DeleteUser deletes a local user with their sessions, API keys and project
+// memberships (admins only). Admins cannot delete themselves, so one admin always remains.
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	admin, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	username := mux.Vars(r)["username"]
+	if username == admin.Username {
+		respondError(w, http.StatusBadRequest, "you cannot delete yourself")
+		return
+	}
+	if err := h.auth.DeleteUser(username); err != nil {
+		respondError(w, authStatus(err), err.Error())
+		return
+	}
+	if h.projectStore != nil {
+		if err := h.projectStore.RemoveUser(username); err != nil {
+			log.Printf("[ERROR] Failed to remove user %s from projects: %v", username, err)
+		}
+	}
+
+	log.Printf("[INFO] User %s deleted user %s", admin.Username, username)
+	respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/proxmox"
+	"github.com/gorilla/mux"
+)
+
+// validBackupModes and validBackupCompress are the vzdump settings accepted by CreateBackup
+var (
+	validBackupModes    = map[string]bool{"snapshot": true, "suspend": true, "stop": true}
+	validBackupCompress = map[string]bool{"zstd": true, "lzo": true, "gzip": true, "0": true}
+)
+
+// ListBackups lists backup archives, newest first. Without a storage query
+// parameter every storage with backup content is listed; vmid limits the list
+// to the archives of one guest.
+func (h *Handler) ListBackups(w http.ResponseWriter, r *http.Request) {
+	vmid := 0
+	if v := r.URL.Query().Get("vmid"); v != "" {
+		var err error
+		if vmid, err = strconv.Atoi(v); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid vmid")
+			return
+		}
+	}
+
+	storages := []string{r.URL.Query().Get("storage")}
+	if storages[0] == "" {
+		list, err := h.client.GetStorage(r.Context(), &proxmox.GetStorageRequest{Content: "backup"})
+		if err != nil {
+			respondError(w, errorStatus(err), err.Error())
+			return
+		}
+		storages = storages[:0]
+		for _, s := range list {
+			storages = append(storages, s.Storage)
+		}
+	}
+
+	backups := make([]proxmox.Backup, 0)
+	for _, storage := range storages {
+		list, err := h.client.GetBackups(r.Context(), storage, vmid)
+		if err != nil {
+			respondError(w, errorStatus(err), err.Error())
+			return
+		}
+		backups = append(backups, list...)
+	}
+	sort.SliceStable(backups, func(i, j int) bool { return backups[i].CreatedAt > backups[j].CreatedAt })
+
+	// Only list backups of guests in the caller's projects
+	visible := h.visibleTo(r)
+	filtered := make([]proxmox.Backup, 0, len(backups))
+	for _, backup := range backups {
+		if visible(h.guestProject(backup.VMID)) {
+			filtered = append(filtered, backup)
+		}
+	}
+
+	respondJSON(w, http.StatusOK, filtered)
+}
+
+// CreateBackup starts a vzdump backup of a container or VM. Without a storage
+// the backup goes to the default backup storage of the guest's project.
+func (h *Handler) CreateBackup(w http.ResponseWriter, r *http.Request) {
+	var req proxmox.CreateBackupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.VMID <= 0 {
+		respondError(w, http.StatusBadRequest, "vmid is required")
+		return
+	}
+	if req.Mode != "" && !validBackupModes[req.Mode] {
+		respondError(w, http.StatusBadRequest, "mode must be snapshot, suspend or stop")
+		return
+	}
+	if req.Compress != "" && !validBackupCompress[req.Compress] {
+		respondError(w, http.StatusBadRequest, "compress must be zstd, lzo, gzip or 0")
+		return
+	}
+	if req.Storage == "" {
+		storage, err := h.backupStorage(r.Context(), req.VMID)
+		if err != nil {
+			respondError(w, errorStatus(err), err.Error())
+			return
+		}
+		req.Storage = storage
+	}
+
+	upid, err := h.client.CreateBackup(r.Context(), req)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("back up guest %d to %s", req.VMID, req.Storage))
+	if !ok {
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, withTask(map[string]interface{}{"vmid": req.VMID, "storage": req.Storage}, task))
+}
+
+// DeleteBackup deletes a backup archive. Archives on storage that is not
+// shared are deleted through the node given in the node query parameter.
+func (h *Handler) DeleteBackup(w http.ResponseWriter, r *http.Request) {
+	volid := mux.Vars(r)["volid"]
+
+	upid, err := h.client.DeleteBackup(r.Context(), r.URL.Query().Get("node"), volid)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("delete backup %s", volid))
+	if !ok {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, withTask(map[string]interface{}{"status": "deleted", "volid": volid}, task))
+}
+
+// RestoreBackup restores a backup archive into a new guest, or over an
+// existing stopped guest when force is set
+func (h *Handler) RestoreBackup(w http.ResponseWriter, r *http.Request) {
+	var req proxmox.RestoreBackupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.VolID == "" {
+		respondError(w, http.StatusBadRequest, "volid is required")
+		return
+	}
+
+	var vmid int
+	if req.Force {
+		if req.VMID == nil || *req.VMID <= 0 {
+			respondError(w, http.StatusBadRequest, "vmid is required to overwrite an existing guest")
+			return
+		}
+		vmid = *req.VMID
+	} else {
+		var status int
+		var err error
+		if vmid, status, err = h.allocateVMID(r, req.VMID, req.ProjectID); err != nil {
+			respondError(w, status, err.Error())
+			return
+		}
+	}
+
+	upid, err := h.client.RestoreBackup(r.Context(), vmid, req)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("restore %s to guest %d", req.VolID, vmid))
+	if !ok {
+		return
+	}
+
+	if req.ProjectID != "" && h.projectStore != nil {
+		if err := h.projectStore.AssignContainer(vmid, req.ProjectID); err != nil {
+			log.Printf("[WARNING] Failed to assign restored guest %d to project %s: %v", vmid, req.ProjectID, err)
+		}
+	}
+
+	respondJSON(w, http.StatusCreated, withTask(map[string]interface{}{"vmid": vmid, "volid": req.VolID}, task))
+}
+
+// backupStorage returns the default backup storage of the project a guest
+// belongs to, or else the first backup storage Proxmox reports
+func (h *Handler) backupStorage(ctx context.Context, vmid int) (string, error) {
+	if h.projectStore != nil {
+		if storage := h.projectStore.GetContainerBackupStorage(vmid); storage != "" {
+			return storage, nil
+		}
+	}
+	return h.client.FindStorage(ctx, "", "backup")
+}
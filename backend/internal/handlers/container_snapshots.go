@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/proxmox"
+	"github.com/gorilla/mux"
+)
+
+// snapshotNamePattern matches the snapshot names Proxmox accepts
+var snapshotNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{1,39}$`)
+
+// ListContainerSnapshots lists the snapshots of a container, both as a flat
+// list and as a tree following the parent links
+func (h *Handler) ListContainerSnapshots(w http.ResponseWriter, r *http.Request) {
+	vmid, err := strconv.Atoi(mux.Vars(r)["vmid"])
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid vmid")
+		return
+	}
+
+	snapshots, err := h.client.GetContainerSnapshots(r.Context(), vmid)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	current := ""
+	for _, s := range snapshots {
+		if s.Current {
+			current = s.Name
+		}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"vmid":      vmid,
+		"current":   current,
+		"snapshots": snapshots,
+		"tree":      proxmox.BuildSnapshotTree(snapshots),
+	})
+}
+
+// CreateContainerSnapshot snapshots a whole container
+func (h *Handler) CreateContainerSnapshot(w http.ResponseWriter, r *http.Request) {
+	vmid, err := strconv.Atoi(mux.Vars(r)["vmid"])
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid vmid")
+		return
+	}
+
+	var req proxmox.CreateSnapshotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if !snapshotNamePattern.MatchString(req.Name) || req.Name == "current" {
+		respondError(w, http.StatusBadRequest, "snapshot name must start with a letter and contain 2-40 letters, digits, '-' or '_'")
+		return
+	}
+
+	upid, err := h.client.CreateContainerSnapshot(r.Context(), vmid, req)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("snapshot container %d as %s", vmid, req.Name))
+	if !ok {
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, withTask(map[string]interface{}{"vmid": vmid, "name": req.Name}, task))
+}
+
+// RollbackContainerSnapshot returns a container to one of its snapshots
+func (h *Handler) RollbackContainerSnapshot(w http.ResponseWriter, r *http.Request) {
+	h.containerSnapshotAction(w, r, "roll back", "rolled back", h.client.RollbackContainerSnapshot)
+}
+
+// DeleteContainerSnapshot deletes a container snapshot
+func (h *Handler) DeleteContainerSnapshot(w http.ResponseWriter, r *http.Request) {
+	h.containerSnapshotAction(w, r, "delete", "deleted", h.client.DeleteContainerSnapshot)
+}
+
+// containerSnapshotAction runs an action on the snapshot in the request path and writes the response
+func (h *Handler) containerSnapshotAction(w http.ResponseWriter, r *http.Request, action, status string, run func(ctx context.Context, vmid int, snapname string) (string, error)) {
+	vars := mux.Vars(r)
+	vmid, err := strconv.Atoi(vars["vmid"])
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid vmid")
+		return
+	}
+	name := vars["name"]
+
+	upid, err := run(r.Context(), vmid, name)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("%s snapshot %s of container %d", action, name, vmid))
+	if !ok {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, withTask(map[string]interface{}{"status": status, "name": name}, task))
+}
@@ -1,38 +1,65 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/MasonD-007/proxicloud/backend/internal/analytics"
+	"github.com/MasonD-007/proxicloud/backend/internal/audit"
+	"github.com/MasonD-007/proxicloud/backend/internal/auth"
 	"github.com/MasonD-007/proxicloud/backend/internal/cache"
 	"github.com/MasonD-007/proxicloud/backend/internal/proxmox"
+	"github.com/MasonD-007/proxicloud/backend/internal/scheduler"
+	"github.com/MasonD-007/proxicloud/backend/internal/volumes"
 	"github.com/gorilla/mux"
 )
 
-// Handler holds the Proxmox client, cache, analytics, and project store
+// Handler holds the Proxmox client, cache, analytics, project store, policy
+// scheduler, volume registry, task tracker and node drains
 type Handler struct {
-	client       *proxmox.Client
+	client       proxmox.ProxmoxAPI
 	cache        *cache.Cache
 	analytics    *analytics.Analytics
 	projectStore *proxmox.ProjectStore
+	policies     *scheduler.Scheduler
+	registry     *volumes.Registry
+	tasks        *proxmox.TaskTracker
+	drains       *proxmox.DrainManager
+
+	storageClasses map[string]proxmox.StorageClass
+
+	auth       *auth.Store
+	sessionTTL time.Duration
+	audit      *audit.Store
+
+	sso                   *singleSignOn
+	passwordLoginDisabled bool
 }
 
 // NewHandler creates a new handler
-func NewHandler(client *proxmox.Client, cache *cache.Cache, analytics *analytics.Analytics, projectStore *proxmox.ProjectStore) *Handler {
+func NewHandler(client proxmox.ProxmoxAPI, cache *cache.Cache, analytics *analytics.Analytics, projectStore *proxmox.ProjectStore, policies *scheduler.Scheduler, registry *volumes.Registry) *Handler {
+	tasks := proxmox.NewTaskTracker(client)
 	return &Handler{
 		client:       client,
 		cache:        cache,
 		analytics:    analytics,
 		projectStore: projectStore,
+		policies:     policies,
+		registry:     registry,
+		tasks:        tasks,
+		drains:       proxmox.NewDrainManager(client, tasks),
 	}
 }
 
@@ -74,17 +101,255 @@ func respondError(w http.ResponseWriter, status int, message string) {
 	respondJSON(w, status, map[string]string{"error": message})
 }
 
+// errorStatus maps an error from the Proxmox client to an HTTP status code
+func errorStatus(err error) int {
+	if errors.Is(err, proxmox.ErrCircuitOpen) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	if errors.Is(err, proxmox.ErrVolumeNotAttached) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, proxmox.ErrMountPointInUse) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, proxmox.ErrSnapshotsUnsupported) {
+		return http.StatusBadRequest
+	}
+
+	var apiErr *proxmox.APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.IsNotFound():
+			return http.StatusNotFound
+		case apiErr.IsConflict():
+			return http.StatusConflict
+		case apiErr.IsValidation(), apiErr.IsUnsupported():
+			return http.StatusBadRequest
+		case apiErr.IsTransient():
+			return http.StatusBadGateway
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// wantsWait reports whether the caller asked to block until the Proxmox task finishes
+func wantsWait(r *http.Request) bool {
+	wait := r.URL.Query().Get("wait")
+	return wait == "true" || wait == "1"
+}
+
+// trackTask registers a Proxmox task with the tracker and, if the request has
+// ?wait=true, blocks until it finishes. When the task fails (or waiting is
+// cut short) an error response is written and false is returned.
+func (h *Handler) trackTask(w http.ResponseWriter, r *http.Request, upid string, description string) (*proxmox.Task, bool) {
+	if upid == "" {
+		return nil, true
+	}
+
+	if !wantsWait(r) {
+		noteTask(r, upid)
+		return h.tasks.Track(upid, description), true
+	}
+	return h.waitTask(w, r, upid, description)
+}
+
+// waitTask tracks a task and blocks until it finishes. If the task fails or
+// the wait is cut short it writes the error response and returns false.
+func (h *Handler) waitTask(w http.ResponseWriter, r *http.Request, upid string, description string) (*proxmox.Task, bool) {
+	noteTask(r, upid)
+	h.tasks.Track(upid, description)
+	finished, err := h.tasks.Wait(r.Context(), upid)
+	if err != nil {
+		respondError(w, http.StatusGatewayTimeout, fmt.Sprintf("stopped waiting for task %s: %v", upid, err))
+		return nil, false
+	}
+	if !finished.Succeeded() {
+		respondJSON(w, http.StatusInternalServerError, map[string]interface{}{
+			"error":   fmt.Sprintf("task %s failed: %s", upid, finished.ExitStatus),
+			"task_id": upid,
+			"log":     finished.Log,
+		})
+		return nil, false
+	}
+
+	return finished, true
+}
+
+// withTask adds the task ID, and its exit status once finished, to a response body
+func withTask(body map[string]interface{}, task *proxmox.Task) map[string]interface{} {
+	if task != nil {
+		body["task_id"] = task.UPID
+		if task.Finished() {
+			body["task_status"] = task.ExitStatus
+		}
+	}
+	return body
+}
+
+// allocateVMID picks the VMID for a new container or VM: the requested one if
+// set (validated against the project's ID range), otherwise the next free ID
+// in the project's range or in the cluster. On failure it also returns the
+// HTTP status to respond with.
+func (h *Handler) allocateVMID(r *http.Request, requested *int, projectID string) (int, int, error) {
+	// Check if user provided a custom VMID
+	if requested != nil && *requested > 0 {
+		vmid := *requested
+		log.Printf("[INFO] Using user-specified VMID: %d", vmid)
+
+		// If the guest is assigned to a project with ID range, validate it's within range
+		if projectID != "" && h.projectStore != nil {
+			project, err := h.projectStore.GetProject(projectID)
+			if err == nil && project.ContainerIDStart != nil && project.ContainerIDEnd != nil {
+				if vmid < *project.ContainerIDStart || vmid > *project.ContainerIDEnd {
+					return 0, http.StatusBadRequest, fmt.Errorf("VMID %d is outside project's container ID range %d-%d", vmid, *project.ContainerIDStart, *project.ContainerIDEnd)
+				}
+			}
+		}
+
+		// Verify the VMID is not already used by a container or VM
+		if _, err := h.client.GetContainer(r.Context(), vmid); err == nil {
+			return 0, http.StatusConflict, fmt.Errorf("VMID %d is already in use", vmid)
+		}
+		if _, err := h.client.GetVM(r.Context(), vmid); err == nil {
+			return 0, http.StatusConflict, fmt.Errorf("VMID %d is already in use", vmid)
+		}
+		// If error is not nil, the VMID is likely available (or there's another issue)
+		// We'll let Proxmox handle the final validation
+		return vmid, 0, nil
+	}
+
+	// If the guest is assigned to a project with ID range, use project's range
+	if projectID != "" && h.projectStore != nil {
+		project, err := h.projectStore.GetProject(projectID)
+		if err == nil && project.ContainerIDStart != nil && project.ContainerIDEnd != nil {
+			vmid, err := h.projectStore.GetNextContainerIDInRange(projectID)
+			if err != nil {
+				return 0, http.StatusInternalServerError, fmt.Errorf("Failed to allocate container ID from project range: %v", err)
+			}
+			log.Printf("[INFO] Using project-allocated VMID: %d (from range %d-%d)", vmid, *project.ContainerIDStart, *project.ContainerIDEnd)
+			return vmid, 0, nil
+		}
+	}
+
+	// No project range, get next available VMID
+	vmid, err := h.client.GetNextVMID(r.Context())
+	if err != nil {
+		return 0, errorStatus(err), err
+	}
+	log.Printf("[INFO] Using auto-generated VMID: %d", vmid)
+	return vmid, 0, nil
+}
+
 // Health handles health check requests
 func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
-	respondJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
+	health := map[string]string{"status": "healthy"}
+	if breaker, ok := h.client.(interface{ CircuitState() string }); ok {
+		health["proxmox_circuit"] = breaker.CircuitState()
+	}
+	respondJSON(w, http.StatusOK, health)
+}
+
+// nodePlacement summarizes the containers placed on a cluster node
+type nodePlacement struct {
+	Node       string `json:"node"`
+	Status     string `json:"status"`
+	Containers int    `json:"containers"`
+	VMs        int    `json:"vms"`
+	Running    int    `json:"running"`
 }
 
-// Dashboard returns dashboard statistics
+// ListNodes lists the nodes of the Proxmox cluster
+func (h *Handler) ListNodes(w http.ResponseWriter, r *http.Request) {
+	nodes, err := h.client.GetNodes(r.Context())
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, nodes)
+}
+
+// DrainNode starts migrating every container off a node, e.g. before
+// maintenance. The drain runs in the background; poll GetNodeDrain for progress.
+func (h *Handler) DrainNode(w http.ResponseWriter, r *http.Request) {
+	node := mux.Vars(r)["node"]
+
+	var req proxmox.DrainNodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Target == "" || req.Target == node {
+		respondError(w, http.StatusBadRequest, "target must be another node of the cluster")
+		return
+	}
+
+	nodes, err := h.client.GetNodes(r.Context())
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+	found, targetFound := false, false
+	for _, n := range nodes {
+		if n.Node == req.Target {
+			if n.Status != "online" {
+				respondError(w, http.StatusBadRequest, fmt.Sprintf("target node %s is %s", req.Target, n.Status))
+				return
+			}
+			targetFound = true
+		}
+		if n.Node == node {
+			found = true
+		}
+	}
+	if !found {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("node %s not found", node))
+		return
+	}
+	if !targetFound {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("target node %s is not in the cluster", req.Target))
+		return
+	}
+
+	drain, err := h.drains.Start(r.Context(), node, req)
+	if err != nil {
+		if errors.Is(err, proxmox.ErrDrainRunning) {
+			respondError(w, http.StatusConflict, err.Error())
+			return
+		}
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	log.Printf("[INFO] Draining %d containers from node %s to %s", len(drain.Containers), node, req.Target)
+	respondJSON(w, http.StatusAccepted, drain)
+}
+
+// GetNodeDrain returns the progress of the latest drain of a node
+func (h *Handler) GetNodeDrain(w http.ResponseWriter, r *http.Request) {
+	node := mux.Vars(r)["node"]
+
+	drain, ok := h.drains.Get(node)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("node %s has not been drained", node))
+		return
+	}
+	respondJSON(w, http.StatusOK, drain)
+}
+
+// Dashboard returns dashboard statistics over the guests the calling user may
+// see. Only global admins see every guest, so only their stats are cached.
 func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
-	containers, err := h.client.GetContainers()
+	user, _ := auth.UserFrom(r.Context())
+	everything := user != nil && user.Admin
+	visible := h.visibleTo(r)
+
+	inventory, err := h.client.GetInventory(r.Context(), false)
 	if err != nil {
 		// Try to get from cache if Proxmox is down
-		if h.cache != nil {
+		if h.cache != nil && everything {
 			cached, cacheErr := h.cache.GetDashboard()
 			if cacheErr == nil {
 				log.Printf("Serving dashboard from cache (Proxmox error: %v)", err)
@@ -92,25 +357,58 @@ func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		}
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
+	containers := inventory.Containers
 
 	stats := struct {
-		TotalContainers   int     `json:"total_containers"`
-		RunningContainers int     `json:"running_containers"`
-		StoppedContainers int     `json:"stopped_containers"`
-		TotalCPU          float64 `json:"total_cpu"`
-		TotalMemory       int64   `json:"total_memory"`
-		UsedMemory        int64   `json:"used_memory"`
-		TotalDisk         int64   `json:"total_disk"`
-		UsedDisk          int64   `json:"used_disk"`
+		TotalContainers   int             `json:"total_containers"`
+		RunningContainers int             `json:"running_containers"`
+		StoppedContainers int             `json:"stopped_containers"`
+		TotalVMs          int             `json:"total_vms"`
+		RunningVMs        int             `json:"running_vms"`
+		TotalCPU          float64         `json:"total_cpu"`
+		TotalMemory       int64           `json:"total_memory"`
+		UsedMemory        int64           `json:"used_memory"`
+		TotalDisk         int64           `json:"total_disk"`
+		UsedDisk          int64           `json:"used_disk"`
+		Nodes             []nodePlacement `json:"nodes"`
 	}{}
 
-	stats.TotalContainers = len(containers)
+	// Start from the cluster's node list so empty nodes are shown too
+	placement := make(map[string]*nodePlacement)
+	var nodeOrder []string
+	nodes, err := h.client.GetNodes(r.Context())
+	if err != nil {
+		log.Printf("[WARNING] Failed to list cluster nodes for dashboard: %v", err)
+	}
+	for _, n := range nodes {
+		placement[n.Node] = &nodePlacement{Node: n.Node, Status: n.Status}
+		nodeOrder = append(nodeOrder, n.Node)
+	}
+
+	nodeEntry := func(name string) *nodePlacement {
+		node, ok := placement[name]
+		if !ok {
+			node = &nodePlacement{Node: name, Status: "unknown"}
+			placement[name] = node
+			nodeOrder = append(nodeOrder, name)
+		}
+		return node
+	}
+
 	for _, c := range containers {
+		if c.Template || !visible(h.guestProject(c.VMID)) {
+			continue
+		}
+		node := nodeEntry(c.Node)
+		node.Containers++
+		stats.TotalContainers++
+
 		if c.Status == "running" {
 			stats.RunningContainers++
+			node.Running++
 		} else {
 			stats.StoppedContainers++
 		}
@@ -120,9 +418,30 @@ func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
 		stats.TotalDisk += c.MaxDisk
 		stats.UsedDisk += c.Disk
 	}
+	for _, vm := range inventory.VMs {
+		if vm.Template || !visible(h.guestProject(vm.VMID)) {
+			continue
+		}
+		node := nodeEntry(vm.Node)
+		node.VMs++
+		stats.TotalVMs++
+
+		if vm.Status == "running" {
+			stats.RunningVMs++
+			node.Running++
+		}
+		stats.TotalCPU += vm.CPU
+		stats.TotalMemory += vm.MaxMem
+		stats.UsedMemory += vm.Mem
+		stats.TotalDisk += vm.MaxDisk
+		stats.UsedDisk += vm.Disk
+	}
+	for _, name := range nodeOrder {
+		stats.Nodes = append(stats.Nodes, *placement[name])
+	}
 
 	// Cache the stats
-	if h.cache != nil {
+	if h.cache != nil && everything {
 		if err := h.cache.SetDashboard(stats); err != nil {
 			log.Printf("Failed to cache dashboard: %v", err)
 		}
@@ -135,7 +454,7 @@ func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) ListContainers(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[DEBUG] ListContainers handler called")
 
-	containers, err := h.client.GetContainers()
+	containers, err := h.client.GetContainers(r.Context())
 	if err != nil {
 		log.Printf("[ERROR] GetContainers failed: %v", err)
 		// Try to get from cache if Proxmox is down
@@ -143,12 +462,12 @@ func (h *Handler) ListContainers(w http.ResponseWriter, r *http.Request) {
 			cached, cacheErr := h.cache.GetContainers()
 			if cacheErr == nil {
 				log.Printf("[INFO] Serving containers from cache (Proxmox error: %v)", err)
-				respondJSONWithCache(w, http.StatusOK, cached, true)
+				respondJSONWithCache(w, http.StatusOK, h.visibleContainers(r, cached), true)
 				return
 			}
 			log.Printf("[ERROR] Cache retrieval also failed: %v", cacheErr)
 		}
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
@@ -174,7 +493,7 @@ func (h *Handler) ListContainers(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	respondJSONWithCache(w, http.StatusOK, containers, false)
+	respondJSONWithCache(w, http.StatusOK, h.visibleContainers(r, containers), false)
 }
 
 // GetContainer gets a specific container
@@ -186,10 +505,10 @@ func (h *Handler) GetContainer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	container, err := h.client.GetContainer(vmid)
+	container, err := h.client.GetContainer(r.Context(), vmid)
 	if err != nil {
 		// Try to get from cache if Proxmox is down
-		if h.cache != nil {
+		if h.cache != nil && !proxmox.IsNotFound(err) {
 			cached, cacheErr := h.cache.GetContainer(vmid)
 			if cacheErr == nil {
 				log.Printf("Serving container %d from cache (Proxmox error: %v)", vmid, err)
@@ -197,7 +516,7 @@ func (h *Handler) GetContainer(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		}
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
@@ -224,65 +543,27 @@ func (h *Handler) CreateContainer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var vmid int
-	var err error
-
-	// Check if user provided a custom VMID
-	if req.VMID != nil && *req.VMID > 0 {
-		vmid = *req.VMID
-		log.Printf("[INFO] Using user-specified VMID: %d", vmid)
-
-		// If container is assigned to a project with ID range, validate it's within range
-		if req.ProjectID != "" && h.projectStore != nil {
-			project, err := h.projectStore.GetProject(req.ProjectID)
-			if err == nil && project.ContainerIDStart != nil && project.ContainerIDEnd != nil {
-				if vmid < *project.ContainerIDStart || vmid > *project.ContainerIDEnd {
-					respondError(w, http.StatusBadRequest, fmt.Sprintf("VMID %d is outside project's container ID range %d-%d", vmid, *project.ContainerIDStart, *project.ContainerIDEnd))
-					return
-				}
-			}
-		}
-
-		// Verify the VMID is not already in use
-		_, err := h.client.GetContainer(vmid)
-		if err == nil {
-			// Container exists with this VMID
-			respondError(w, http.StatusConflict, fmt.Sprintf("VMID %d is already in use", vmid))
+	if req.Storage == "" {
+		class, status, err := h.resolveStorageClass(req.StorageClass, req.ProjectID)
+		if err != nil {
+			respondError(w, status, err.Error())
 			return
 		}
-		// If error is not nil, the VMID is likely available (or there's another issue)
-		// We'll let Proxmox handle the final validation
-	} else {
-		// If container is assigned to a project with ID range, use project's range
-		if req.ProjectID != "" && h.projectStore != nil {
-			project, err := h.projectStore.GetProject(req.ProjectID)
-			if err == nil && project.ContainerIDStart != nil && project.ContainerIDEnd != nil {
-				vmid, err = h.projectStore.GetNextContainerIDInRange(req.ProjectID)
-				if err != nil {
-					respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to allocate container ID from project range: %v", err))
-					return
-				}
-				log.Printf("[INFO] Using project-allocated VMID: %d (from range %d-%d)", vmid, *project.ContainerIDStart, *project.ContainerIDEnd)
-			} else {
-				// Project doesn't have ID range, use global next VMID
-				vmid, err = h.client.GetNextVMID()
-				if err != nil {
-					respondError(w, http.StatusInternalServerError, err.Error())
-					return
-				}
-				log.Printf("[INFO] Using auto-generated VMID: %d", vmid)
-			}
-		} else {
-			// No project or no projectStore, get next available VMID
-			vmid, err = h.client.GetNextVMID()
-			if err != nil {
-				respondError(w, http.StatusInternalServerError, err.Error())
+		if class != nil {
+			if err := applyClassSize(class, &req.Disk); err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
 				return
 			}
-			log.Printf("[INFO] Using auto-generated VMID: %d", vmid)
+			req.Storage = class.Storage
 		}
 	}
 
+	vmid, status, err := h.allocateVMID(r, req.VMID, req.ProjectID)
+	if err != nil {
+		respondError(w, status, err.Error())
+		return
+	}
+
 	// If container is assigned to a project with network configuration, use project's VNet
 	if req.ProjectID != "" && h.projectStore != nil {
 		project, err := h.projectStore.GetProject(req.ProjectID)
@@ -307,8 +588,14 @@ func (h *Handler) CreateContainer(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if err := h.client.CreateContainer(vmid, req); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	upid, err := h.client.CreateContainer(r.Context(), vmid, req)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("create container %d", vmid))
+	if !ok {
 		return
 	}
 
@@ -320,7 +607,11 @@ func (h *Handler) CreateContainer(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	respondJSON(w, http.StatusCreated, map[string]int{"vmid": vmid})
+	node := req.Node
+	if task != nil {
+		node = task.Node
+	}
+	respondJSON(w, http.StatusCreated, withTask(map[string]interface{}{"vmid": vmid, "node": node}, task))
 }
 
 // StartContainer starts a container
@@ -332,12 +623,18 @@ func (h *Handler) StartContainer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.client.StartContainer(vmid); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	upid, err := h.client.StartContainer(r.Context(), vmid)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("start container %d", vmid))
+	if !ok {
 		return
 	}
 
-	respondJSON(w, http.StatusOK, map[string]string{"status": "started"})
+	respondJSON(w, http.StatusOK, withTask(map[string]interface{}{"status": "started"}, task))
 }
 
 // StopContainer stops a container
@@ -349,12 +646,18 @@ func (h *Handler) StopContainer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.client.StopContainer(vmid); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	upid, err := h.client.StopContainer(r.Context(), vmid)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("stop container %d", vmid))
+	if !ok {
 		return
 	}
 
-	respondJSON(w, http.StatusOK, map[string]string{"status": "stopped"})
+	respondJSON(w, http.StatusOK, withTask(map[string]interface{}{"status": "stopped"}, task))
 }
 
 // RebootContainer reboots a container
@@ -366,12 +669,18 @@ func (h *Handler) RebootContainer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.client.RebootContainer(vmid); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	upid, err := h.client.RebootContainer(r.Context(), vmid)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("reboot container %d", vmid))
+	if !ok {
 		return
 	}
 
-	respondJSON(w, http.StatusOK, map[string]string{"status": "rebooting"})
+	respondJSON(w, http.StatusOK, withTask(map[string]interface{}{"status": "rebooting"}, task))
 }
 
 // DeleteContainer deletes a container
@@ -383,8 +692,14 @@ func (h *Handler) DeleteContainer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.client.DeleteContainer(vmid); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	upid, err := h.client.DeleteContainer(r.Context(), vmid)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("delete container %d", vmid))
+	if !ok {
 		return
 	}
 
@@ -396,14 +711,232 @@ func (h *Handler) DeleteContainer(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+	respondJSON(w, http.StatusOK, withTask(map[string]interface{}{"status": "deleted"}, task))
+}
+
+// CloneContainer clones a container or container template. The clone's VMID
+// comes from the project's ID range when a project is given.
+func (h *Handler) CloneContainer(w http.ResponseWriter, r *http.Request) {
+	vmid, err := strconv.Atoi(mux.Vars(r)["vmid"])
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid vmid")
+		return
+	}
+
+	var req proxmox.CloneContainerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Hostname != "" && !hostnamePattern.MatchString(req.Hostname) {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("invalid hostname '%s'", req.Hostname))
+		return
+	}
+
+	newID, status, err := h.allocateVMID(r, &req.NewID, req.ProjectID)
+	if err != nil {
+		respondError(w, status, err.Error())
+		return
+	}
+	req.NewID = newID
+
+	upid, err := h.client.CloneContainer(r.Context(), vmid, req)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("clone container %d to %d", vmid, newID))
+	if !ok {
+		return
+	}
+
+	// Assign to project if specified
+	if req.ProjectID != "" && h.projectStore != nil {
+		if err := h.projectStore.AssignContainer(newID, req.ProjectID); err != nil {
+			log.Printf("[WARNING] Failed to assign container %d to project %s: %v", newID, req.ProjectID, err)
+		}
+	}
+
+	respondJSON(w, http.StatusCreated, withTask(map[string]interface{}{"vmid": newID}, task))
+}
+
+// MigrateContainer moves a container to another cluster node
+func (h *Handler) MigrateContainer(w http.ResponseWriter, r *http.Request) {
+	vmid, err := strconv.Atoi(mux.Vars(r)["vmid"])
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid vmid")
+		return
+	}
+
+	var req proxmox.MigrateContainerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Target == "" {
+		respondError(w, http.StatusBadRequest, "target node is required")
+		return
+	}
+
+	upid, err := h.client.MigrateContainer(r.Context(), vmid, req)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("migrate container %d to %s", vmid, req.Target))
+	if !ok {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, withTask(map[string]interface{}{"status": "migrating", "target": req.Target}, task))
+}
+
+// ConvertContainerToTemplate turns a stopped container into a template that can be cloned
+func (h *Handler) ConvertContainerToTemplate(w http.ResponseWriter, r *http.Request) {
+	vmid, err := strconv.Atoi(mux.Vars(r)["vmid"])
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid vmid")
+		return
+	}
+
+	if err := h.client.ConvertToTemplate(r.Context(), vmid); err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"vmid": vmid, "template": true})
+}
+
+// UpdateContainer changes the resources and settings of a container and grows
+// its rootfs. Changes Proxmox cannot apply to a running container are kept
+// pending; they are returned together with reboot_required.
+func (h *Handler) UpdateContainer(w http.ResponseWriter, r *http.Request) {
+	vmid, err := strconv.Atoi(mux.Vars(r)["vmid"])
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid vmid")
+		return
+	}
+
+	var req proxmox.UpdateContainerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := validateContainerUpdate(req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	container, err := h.client.GetContainer(r.Context(), vmid)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	// Proxmox cannot shrink a rootfs, and resizing to the current size is a no-op
+	resize := false
+	if req.Disk != nil {
+		currentGB := container.MaxDisk / (1024 * 1024 * 1024)
+		if int64(*req.Disk) < currentGB {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("disk can only grow, the rootfs is %dGB", currentGB))
+			return
+		}
+		resize = int64(*req.Disk)*1024*1024*1024 > container.MaxDisk
+	}
+
+	if err := h.client.UpdateContainerConfig(r.Context(), vmid, req); err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	var task *proxmox.Task
+	if resize {
+		upid, err := h.client.ResizeContainerDisk(r.Context(), vmid, "rootfs", *req.Disk)
+		if err != nil {
+			respondError(w, errorStatus(err), fmt.Sprintf("container %d was updated but its disk could not be resized: %v", vmid, err))
+			return
+		}
+		var ok bool
+		task, ok = h.trackTask(w, r, upid, fmt.Sprintf("resize container %d rootfs to %dGB", vmid, *req.Disk))
+		if !ok {
+			return
+		}
+	}
+
+	body := map[string]interface{}{"status": "updated"}
+	pending, err := h.client.GetContainerPending(r.Context(), vmid)
+	if err != nil {
+		log.Printf("[WARNING] Failed to read pending changes of container %d: %v", vmid, err)
+	} else {
+		body["pending"] = pending
+		body["reboot_required"] = len(pending) > 0
+	}
+
+	respondJSON(w, http.StatusOK, withTask(body, task))
+}
+
+// GetContainerPending lists the config changes of a container that take effect on its next restart
+func (h *Handler) GetContainerPending(w http.ResponseWriter, r *http.Request) {
+	vmid, err := strconv.Atoi(mux.Vars(r)["vmid"])
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid vmid")
+		return
+	}
+
+	pending, err := h.client.GetContainerPending(r.Context(), vmid)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"vmid":            vmid,
+		"pending":         pending,
+		"reboot_required": len(pending) > 0,
+	})
+}
+
+// hostnamePattern matches a DNS name made of labels of letters, digits and hyphens
+var hostnamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+
+// validateContainerUpdate checks the requested container changes before any is applied
+func validateContainerUpdate(req proxmox.UpdateContainerRequest) error {
+	if req.Cores == nil && req.Memory == nil && req.Swap == nil && req.Hostname == nil &&
+		req.Nameserver == nil && req.OnBoot == nil && req.Disk == nil {
+		return fmt.Errorf("no changes requested")
+	}
+	if req.Cores != nil && (*req.Cores < 1 || *req.Cores > 8192) {
+		return fmt.Errorf("cores must be between 1 and 8192")
+	}
+	if req.Memory != nil && *req.Memory < 16 {
+		return fmt.Errorf("memory must be at least 16 MB")
+	}
+	if req.Swap != nil && *req.Swap < 0 {
+		return fmt.Errorf("swap cannot be negative")
+	}
+	if req.Hostname != nil && (len(*req.Hostname) > 253 || !hostnamePattern.MatchString(*req.Hostname)) {
+		return fmt.Errorf("invalid hostname '%s'", *req.Hostname)
+	}
+	if req.Nameserver != nil {
+		for _, ns := range strings.Fields(*req.Nameserver) {
+			if net.ParseIP(ns) == nil {
+				return fmt.Errorf("invalid nameserver '%s'", ns)
+			}
+		}
+	}
+	if req.Disk != nil && *req.Disk < 1 {
+		return fmt.Errorf("disk must be at least 1 GB")
+	}
+	return nil
 }
 
 // GetTemplates lists available templates
 func (h *Handler) GetTemplates(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[DEBUG] GetTemplates handler called")
 
-	templates, err := h.client.GetTemplates()
+	templates, err := h.client.GetTemplates(r.Context())
 	if err != nil {
 		log.Printf("[ERROR] GetTemplates failed: %v", err)
 		// Try to get from cache if Proxmox is down
@@ -416,7 +949,7 @@ func (h *Handler) GetTemplates(w http.ResponseWriter, r *http.Request) {
 			}
 			log.Printf("[ERROR] Cache retrieval also failed: %v", cacheErr)
 		}
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
@@ -461,10 +994,14 @@ func (h *Handler) UploadTemplate(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	// Get storage parameter (default to "local")
+	// Get storage parameter (default to the first storage that holds templates)
 	storage := r.FormValue("storage")
 	if storage == "" {
-		storage = "local"
+		storage, err = h.client.FindStorage(r.Context(), "", "vztmpl")
+		if err != nil {
+			respondError(w, errorStatus(err), err.Error())
+			return
+		}
 	}
 
 	log.Printf("[INFO] Uploading template: filename=%s, size=%d bytes, storage=%s", header.Filename, header.Size, storage)
@@ -493,18 +1030,24 @@ func (h *Handler) UploadTemplate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Upload to Proxmox
-	if err := h.client.UploadTemplate(storage, filename, fileData); err != nil {
+	upid, err := h.client.UploadTemplate(r.Context(), storage, filename, fileData)
+	if err != nil {
 		log.Printf("[ERROR] Failed to upload template: %v", err)
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("upload template %s to %s", filename, storage))
+	if !ok {
 		return
 	}
 
 	log.Printf("[INFO] Template uploaded successfully: %s", filename)
-	respondJSON(w, http.StatusOK, map[string]string{
+	respondJSON(w, http.StatusOK, withTask(map[string]interface{}{
 		"status":   "success",
 		"filename": filename,
 		"storage":  storage,
-	})
+	}, task))
 }
 
 // GetContainerMetrics returns time-series metrics for a container
@@ -544,7 +1087,7 @@ func (h *Handler) GetContainerMetrics(w http.ResponseWriter, r *http.Request) {
 
 	metrics, err := h.analytics.GetMetrics(vmid, start, end, limit)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
@@ -580,13 +1123,52 @@ func (h *Handler) GetContainerMetricsSummary(w http.ResponseWriter, r *http.Requ
 
 	summary, err := h.analytics.GetMetricsSummary(vmid, start, end)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
 	respondJSON(w, http.StatusOK, summary)
 }
 
+// GetVolumeMetrics returns the recorded usage of a volume
+func (h *Handler) GetVolumeMetrics(w http.ResponseWriter, r *http.Request) {
+	if h.analytics == nil {
+		respondError(w, http.StatusServiceUnavailable, "analytics not available")
+		return
+	}
+
+	volid := mux.Vars(r)["volid"]
+
+	// Parse query parameters
+	hoursStr := r.URL.Query().Get("hours")
+	hours := 24 * 7 // Default to 7 days; usage changes slowly
+	if hoursStr != "" {
+		if h, err := strconv.Atoi(hoursStr); err == nil && h > 0 {
+			hours = h
+		}
+	}
+
+	limitStr := r.URL.Query().Get("limit")
+	limit := 1000 // Default limit
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+
+	// Calculate time range
+	end := time.Now()
+	start := end.Add(-time.Duration(hours) * time.Hour)
+
+	metrics, err := h.analytics.GetVolumeMetrics(volid, start, end, limit)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, metrics)
+}
+
 // GetAnalyticsStats returns overall analytics statistics
 func (h *Handler) GetAnalyticsStats(w http.ResponseWriter, r *http.Request) {
 	if h.analytics == nil {
@@ -596,7 +1178,7 @@ func (h *Handler) GetAnalyticsStats(w http.ResponseWriter, r *http.Request) {
 
 	count, err := h.analytics.GetMetricsCount()
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
@@ -612,7 +1194,7 @@ func (h *Handler) GetAnalyticsStats(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) ListVolumes(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[DEBUG] ListVolumes handler called")
 
-	volumes, err := h.client.GetVolumes()
+	volumes, err := h.client.GetVolumes(r.Context())
 	if err != nil {
 		log.Printf("[ERROR] GetVolumes failed: %v", err)
 		// Try to get from cache if Proxmox is down
@@ -620,16 +1202,24 @@ func (h *Handler) ListVolumes(w http.ResponseWriter, r *http.Request) {
 			cached, cacheErr := h.cache.GetVolumes()
 			if cacheErr == nil {
 				log.Printf("[INFO] Serving volumes from cache (Proxmox error: %v)", err)
-				respondJSONWithCache(w, http.StatusOK, cached, true)
+				respondJSONWithCache(w, http.StatusOK, h.visibleVolumes(r, cached), true)
 				return
 			}
 			log.Printf("[ERROR] Cache retrieval also failed: %v", cacheErr)
 		}
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
 	log.Printf("[INFO] Successfully retrieved %d volumes from Proxmox", len(volumes))
+	for i := range volumes {
+		h.classifyVolume(&volumes[i])
+	}
+	if h.registry != nil {
+		if err := h.registry.Reconcile(volumes); err != nil {
+			log.Printf("[ERROR] Failed to reconcile volume registry: %v", err)
+		}
+	}
 
 	// Cache the volumes
 	if h.cache != nil {
@@ -638,7 +1228,7 @@ func (h *Handler) ListVolumes(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	respondJSONWithCache(w, http.StatusOK, volumes, false)
+	respondJSONWithCache(w, http.StatusOK, h.visibleVolumes(r, volumes), false)
 }
 
 // GetVolume gets a specific volume
@@ -651,10 +1241,10 @@ func (h *Handler) GetVolume(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	volume, err := h.client.GetVolume(volid)
+	volume, err := h.client.GetVolume(r.Context(), volid)
 	if err != nil {
 		// Try to get from cache if Proxmox is down
-		if h.cache != nil {
+		if h.cache != nil && !proxmox.IsNotFound(err) {
 			cached, cacheErr := h.cache.GetVolume(volid)
 			if cacheErr == nil {
 				log.Printf("Serving volume %s from cache (Proxmox error: %v)", volid, err)
@@ -662,10 +1252,17 @@ func (h *Handler) GetVolume(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		}
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
+	h.classifyVolume(volume)
+	if h.registry != nil {
+		if err := h.registry.Merge(volume); err != nil {
+			log.Printf("[ERROR] Failed to merge volume %s with registry: %v", volid, err)
+		}
+	}
+
 	// Cache the volume
 	if h.cache != nil {
 		if err := h.cache.SetVolume(*volume); err != nil {
@@ -689,16 +1286,38 @@ func (h *Handler) CreateVolume(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusBadRequest, "name is required")
 		return
 	}
+
+	// An explicit storage without a class bypasses the storage classes
+	if req.Storage == "" || req.Type != "" {
+		class, status, err := h.resolveStorageClass(req.Type, req.ProjectID)
+		if err != nil {
+			respondError(w, status, err.Error())
+			return
+		}
+		if class != nil {
+			if err := applyClassSize(class, &req.Size); err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			req.Storage = class.Storage
+			req.Type = class.Name
+		}
+	}
+
 	if req.Size <= 0 {
 		respondError(w, http.StatusBadRequest, "size must be greater than 0")
 		return
 	}
 
-	volume, err := h.client.CreateVolume(req)
+	volume, err := h.client.CreateVolume(r.Context(), req)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
+	volume.ProjectID = req.ProjectID
+	volume.Description = req.Description
+	volume.Labels = req.Labels
+	h.registerVolume(*volume)
 
 	respondJSON(w, http.StatusCreated, volume)
 }
@@ -713,12 +1332,24 @@ func (h *Handler) DeleteVolume(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.client.DeleteVolume(volid); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	upid, err := h.client.DeleteVolume(r.Context(), volid)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
-	respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("delete volume %s", volid))
+	if !ok {
+		return
+	}
+
+	if h.registry != nil {
+		if err := h.registry.Delete(volid); err != nil {
+			log.Printf("[ERROR] Failed to remove volume %s from registry: %v", volid, err)
+		}
+	}
+
+	respondJSON(w, http.StatusOK, withTask(map[string]interface{}{"status": "deleted"}, task))
 }
 
 // AttachVolume attaches a volume to a container
@@ -746,10 +1377,20 @@ func (h *Handler) AttachVolume(w http.ResponseWriter, r *http.Request) {
 		req.VMID = vmid // Override with path parameter
 	}
 
-	if err := h.client.AttachVolume(volid, req); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	if req.MountPoint != "" && !proxmox.IsValidMountPoint(req.MountPoint) {
+		respondError(w, http.StatusBadRequest, "mountpoint must be mp0-mp255")
+		return
+	}
+	if req.Path != "" && !proxmox.IsValidMountPath(req.Path) {
+		respondError(w, http.StatusBadRequest, "path must be an absolute path of letters, digits and . _ -")
+		return
+	}
+
+	if err := h.client.AttachVolume(r.Context(), volid, req); err != nil {
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
+	h.observeVolume(r.Context(), volid)
 
 	respondJSON(w, http.StatusOK, map[string]string{"status": "attached"})
 }
@@ -779,14 +1420,118 @@ func (h *Handler) DetachVolume(w http.ResponseWriter, r *http.Request) {
 		req.VMID = vmid // Override with path parameter
 	}
 
-	if err := h.client.DetachVolume(volid, req); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	if err := h.client.DetachVolume(r.Context(), volid, req); err != nil {
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
+	h.observeVolume(r.Context(), volid)
 
 	respondJSON(w, http.StatusOK, map[string]string{"status": "detached"})
 }
 
+// ResizeVolume grows a volume attached to a container through the container,
+// so the filesystem sees the new size. Proxmox can only resize disks through
+// a guest, so detached volumes are rejected.
+func (h *Handler) ResizeVolume(w http.ResponseWriter, r *http.Request) {
+	volid := mux.Vars(r)["volid"]
+
+	var req proxmox.ResizeVolumeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Size <= 0 {
+		respondError(w, http.StatusBadRequest, "size must be greater than 0")
+		return
+	}
+
+	volume, err := h.client.GetVolume(r.Context(), volid)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+	if int64(req.Size) <= volume.Size {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("volumes can only grow, %s is %dGB", volid, volume.Size))
+		return
+	}
+	if volume.AttachedTo == nil {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("volume %s is not attached, attach it to a container to resize it", volid))
+		return
+	}
+
+	disk := volume.MountPoint
+	if disk != "rootfs" && !proxmox.IsValidMountPoint(disk) {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("volume %s is attached to VM %d as %s and cannot be resized here", volid, *volume.AttachedTo, disk))
+		return
+	}
+	upid, err := h.client.ResizeContainerDisk(r.Context(), *volume.AttachedTo, disk, req.Size)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("resize container %d %s to %dGB", *volume.AttachedTo, disk, req.Size))
+	if !ok {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, withTask(map[string]interface{}{"status": "resized", "size": req.Size}, task))
+}
+
+// MoveVolume moves a volume attached to a container to another storage, for
+// example from local-lvm to local-zfs or a shared store. The container must be
+// stopped. The response carries the move task to poll.
+func (h *Handler) MoveVolume(w http.ResponseWriter, r *http.Request) {
+	volid := mux.Vars(r)["volid"]
+
+	var req proxmox.MoveVolumeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Storage == "" {
+		respondError(w, http.StatusBadRequest, "storage is required")
+		return
+	}
+
+	volume, err := h.client.GetVolume(r.Context(), volid)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+	if volume.AttachedTo == nil {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("volume %s must be attached to a container to be moved", volid))
+		return
+	}
+	disk := volume.MountPoint
+	if disk != "rootfs" && !proxmox.IsValidMountPoint(disk) {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("volume %s is attached to VM %d as %s and cannot be moved here", volid, *volume.AttachedTo, disk))
+		return
+	}
+	if volume.Storage == req.Storage {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("volume %s is already on storage %s", volid, req.Storage))
+		return
+	}
+
+	upid, err := h.client.MoveContainerVolume(r.Context(), *volume.AttachedTo, disk, req)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("move container %d %s to %s", *volume.AttachedTo, disk, req.Storage))
+	if !ok {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, withTask(map[string]interface{}{
+		"status":  "moving",
+		"vmid":    *volume.AttachedTo,
+		"disk":    disk,
+		"storage": req.Storage,
+	}, task))
+}
+
 // CreateSnapshot creates a snapshot of a volume
 func (h *Handler) CreateSnapshot(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -807,10 +1552,14 @@ func (h *Handler) CreateSnapshot(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusBadRequest, "snapshot name is required")
 		return
 	}
+	if class, ok := h.storageClassFor(volid); ok && !class.Snapshots {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("storage class %s does not allow snapshots", class.Name))
+		return
+	}
 
-	snapshot, err := h.client.CreateSnapshot(volid, req)
+	snapshot, err := h.client.CreateSnapshot(r.Context(), volid, req)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
@@ -827,9 +1576,9 @@ func (h *Handler) ListSnapshots(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	snapshots, err := h.client.GetSnapshots(volid)
+	snapshots, err := h.client.GetSnapshots(r.Context(), volid)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
@@ -857,12 +1606,18 @@ func (h *Handler) RestoreSnapshot(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.client.RestoreSnapshot(volid, req); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	upid, err := h.client.RestoreSnapshot(r.Context(), volid, req)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("restore volume %s from snapshot %s", volid, req.SnapshotName))
+	if !ok {
 		return
 	}
 
-	respondJSON(w, http.StatusOK, map[string]string{"status": "restored"})
+	respondJSON(w, http.StatusOK, withTask(map[string]interface{}{"status": "restored"}, task))
 }
 
 // CloneSnapshot clones a volume from a snapshot
@@ -891,11 +1646,12 @@ func (h *Handler) CloneSnapshot(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	volume, err := h.client.CloneSnapshot(volid, req)
+	volume, err := h.client.CloneSnapshot(r.Context(), volid, req)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
+	h.registerVolume(*volume)
 
 	respondJSON(w, http.StatusCreated, volume)
 }
@@ -909,11 +1665,20 @@ func (h *Handler) ListProjects(w http.ResponseWriter, r *http.Request) {
 
 	projects, err := h.projectStore.ListProjects()
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
-	respondJSON(w, http.StatusOK, projects)
+	// Only list the projects the caller is a member of
+	visible := h.visibleTo(r)
+	filtered := make([]*proxmox.Project, 0, len(projects))
+	for _, project := range projects {
+		if visible(project.ID) {
+			filtered = append(filtered, project)
+		}
+	}
+
+	respondJSON(w, http.StatusOK, filtered)
 }
 
 // CreateProject creates a new project
@@ -969,21 +1734,21 @@ func (h *Handler) CreateProject(w http.ResponseWriter, r *http.Request) {
 
 		// Create per-project SDN zone with DHCP enabled
 		log.Printf("[INFO] Creating dedicated SDN zone: %s with DHCP enabled", zone)
-		if err := h.client.CreateSDNZone(zone, "simple", "", true); err != nil {
+		if err := h.client.CreateSDNZone(r.Context(), zone, "simple", "", true); err != nil {
 			log.Printf("[ERROR] Failed to create SDN zone: %v", err)
-			respondError(w, http.StatusInternalServerError, fmt.Sprintf("failed to create SDN zone: %v", err))
+			respondError(w, errorStatus(err), fmt.Sprintf("failed to create SDN zone: %v", err))
 			return
 		}
 
 		// Create VNet in the new zone
 		log.Printf("[INFO] Creating VNet: %s in zone: %s", vnetID, zone)
-		if err := h.client.CreateVNet(vnetID, zone, req.Network.VLanTag); err != nil {
+		if err := h.client.CreateVNet(r.Context(), vnetID, zone, req.Network.VLanTag); err != nil {
 			log.Printf("[ERROR] Failed to create VNet: %v, attempting to cleanup zone", err)
 			// Cleanup zone on failure
-			if cleanupErr := h.client.DeleteSDNZone(zone); cleanupErr != nil {
+			if cleanupErr := h.client.DeleteSDNZone(r.Context(), zone); cleanupErr != nil {
 				log.Printf("[WARNING] Failed to cleanup zone after VNet creation failure: %v", cleanupErr)
 			}
-			respondError(w, http.StatusInternalServerError, fmt.Sprintf("failed to create VNet: %v", err))
+			respondError(w, errorStatus(err), fmt.Sprintf("failed to create VNet: %v", err))
 			return
 		}
 
@@ -992,10 +1757,10 @@ func (h *Handler) CreateProject(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			log.Printf("[ERROR] Failed to calculate DHCP range: %v", err)
 			// Cleanup VNet and zone on failure
-			if delErr := h.client.DeleteVNet(vnetID); delErr != nil {
+			if delErr := h.client.DeleteVNet(r.Context(), vnetID); delErr != nil {
 				log.Printf("[ERROR] Failed to cleanup VNet: %v", delErr)
 			}
-			if delErr := h.client.DeleteSDNZone(zone); delErr != nil {
+			if delErr := h.client.DeleteSDNZone(r.Context(), zone); delErr != nil {
 				log.Printf("[ERROR] Failed to cleanup SDN zone: %v", delErr)
 			}
 			respondError(w, http.StatusInternalServerError, fmt.Sprintf("failed to calculate DHCP range: %v", err))
@@ -1004,22 +1769,22 @@ func (h *Handler) CreateProject(w http.ResponseWriter, r *http.Request) {
 
 		// Create Subnet with DHCP
 		log.Printf("[INFO] Creating subnet: %s with gateway: %s and DHCP range: %s", req.Network.Subnet, req.Network.Gateway, dhcpRange)
-		if err := h.client.CreateSubnet(vnetID, req.Network.Subnet, req.Network.Gateway, true, dhcpRange); err != nil {
+		if err := h.client.CreateSubnet(r.Context(), vnetID, req.Network.Subnet, req.Network.Gateway, true, dhcpRange); err != nil {
 			// Try to cleanup VNet and zone if subnet creation fails
 			log.Printf("[ERROR] Failed to create subnet: %v, attempting to cleanup VNet and zone", err)
-			if delErr := h.client.DeleteVNet(vnetID); delErr != nil {
+			if delErr := h.client.DeleteVNet(r.Context(), vnetID); delErr != nil {
 				log.Printf("[ERROR] Failed to cleanup VNet: %v", delErr)
 			}
-			if delErr := h.client.DeleteSDNZone(zone); delErr != nil {
+			if delErr := h.client.DeleteSDNZone(r.Context(), zone); delErr != nil {
 				log.Printf("[ERROR] Failed to cleanup SDN zone: %v", delErr)
 			}
-			respondError(w, http.StatusInternalServerError, fmt.Sprintf("failed to create subnet: %v", err))
+			respondError(w, errorStatus(err), fmt.Sprintf("failed to create subnet: %v", err))
 			return
 		}
 
 		// Apply SDN configuration
 		log.Printf("[INFO] Applying SDN configuration")
-		if err := h.client.ApplySDNConfig(); err != nil {
+		if err := h.client.ApplySDNConfig(r.Context()); err != nil {
 			log.Printf("[ERROR] Failed to apply SDN config: %v", err)
 			// Note: We don't fail the whole operation here as the resources were created
 			// The user can manually apply the config from Proxmox GUI
@@ -1036,7 +1801,7 @@ func (h *Handler) CreateProject(w http.ResponseWriter, r *http.Request) {
 
 	project, err := h.projectStore.CreateProjectWithID(projectID, req)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
@@ -1078,9 +1843,17 @@ func (h *Handler) UpdateProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Project admins may rename and describe their project, but the network,
+	// ID range and backup storage are shared infrastructure
+	if req.Network != nil || req.ContainerIDStart != nil || req.ContainerIDEnd != nil || req.BackupStorage != nil {
+		if _, ok := h.requireAdmin(w, r); !ok {
+			return
+		}
+	}
+
 	project, err := h.projectStore.UpdateProject(id, req)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
@@ -1105,17 +1878,21 @@ func (h *Handler) DeleteProject(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get all containers to verify which ones still exist
-	existingContainers, err := h.client.GetContainers()
+	existing, err := h.client.GetInventory(r.Context(), false)
 	if err != nil {
 		log.Printf("[WARNING] Failed to get containers for cleanup check: %v", err)
 		// Continue anyway, as this is just a cleanup attempt
+		existing = &proxmox.Inventory{}
 	}
 
 	// Create a map of existing container VMIDs for quick lookup
 	existingVMIDs := make(map[int]bool)
-	for _, c := range existingContainers {
+	for _, c := range existing.Containers {
 		existingVMIDs[c.VMID] = true
 	}
+	for _, vm := range existing.VMs {
+		existingVMIDs[vm.VMID] = true
+	}
 
 	// Get containers assigned to this project
 	assignedVMIDs := h.projectStore.GetProjectContainers(id)
@@ -1152,7 +1929,7 @@ func (h *Handler) DeleteProject(w http.ResponseWriter, r *http.Request) {
 		// This must be done before deleting the vnet itself
 		if project.Network.Subnet != "" {
 			log.Printf("[INFO] Deleting subnet: %s from VNet: %s", project.Network.Subnet, vnetID)
-			if err := h.client.DeleteSubnet(vnetID, project.Network.Subnet); err != nil {
+			if err := h.client.DeleteSubnet(r.Context(), vnetID, project.Network.Subnet); err != nil {
 				log.Printf("[WARNING] Failed to delete subnet: %v (continuing with cleanup)", err)
 				// Continue even if subnet deletion fails - the VNet deletion might cascade
 			}
@@ -1161,7 +1938,7 @@ func (h *Handler) DeleteProject(w http.ResponseWriter, r *http.Request) {
 		// Step 2: Delete vnet
 		// This can only succeed if all subnets are removed
 		log.Printf("[INFO] Deleting VNet: %s", vnetID)
-		if err := h.client.DeleteVNet(vnetID); err != nil {
+		if err := h.client.DeleteVNet(r.Context(), vnetID); err != nil {
 			log.Printf("[WARNING] Failed to delete VNet: %v (continuing with cleanup)", err)
 			// Continue to try zone deletion
 		}
@@ -1170,7 +1947,7 @@ func (h *Handler) DeleteProject(w http.ResponseWriter, r *http.Request) {
 		// This can only succeed if all vnets are removed from the zone
 		if project.Network.AutoCreatedZone {
 			log.Printf("[INFO] Deleting auto-created SDN zone: %s", zoneID)
-			if err := h.client.DeleteSDNZone(zoneID); err != nil {
+			if err := h.client.DeleteSDNZone(r.Context(), zoneID); err != nil {
 				log.Printf("[WARNING] Failed to delete SDN zone: %v", err)
 				// Don't fail the whole operation
 			}
@@ -1179,7 +1956,7 @@ func (h *Handler) DeleteProject(w http.ResponseWriter, r *http.Request) {
 		// Step 4: Apply SDN config to commit all changes
 		// This is equivalent to clicking "Apply" in the Proxmox UI
 		log.Printf("[INFO] Applying SDN configuration after cleanup")
-		if err := h.client.ApplySDNConfig(); err != nil {
+		if err := h.client.ApplySDNConfig(r.Context()); err != nil {
 			log.Printf("[WARNING] Failed to apply SDN config after cleanup: %v", err)
 		} else {
 			log.Printf("[INFO] SDN configuration applied successfully")
@@ -1188,7 +1965,7 @@ func (h *Handler) DeleteProject(w http.ResponseWriter, r *http.Request) {
 
 	// Delete project from store
 	if err := h.projectStore.DeleteProject(id); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
@@ -1218,13 +1995,14 @@ func (h *Handler) GetProjectContainers(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("[DEBUG] Project found: %+v", project)
 
-	// Get all containers
-	containers, err := h.client.GetContainers()
+	// Get all containers and VMs
+	inventory, err := h.client.GetInventory(r.Context(), true)
 	if err != nil {
 		log.Printf("[ERROR] Failed to get containers: %v", err)
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
+	containers := inventory.Containers
 
 	log.Printf("[DEBUG] Total containers from Proxmox: %d", len(containers))
 
@@ -1246,6 +2024,14 @@ func (h *Handler) GetProjectContainers(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("[DEBUG] Filtered containers for project %s: %d", id, len(projectContainers))
 
+	projectVMs := []proxmox.VM{}
+	for _, vm := range inventory.VMs {
+		if h.projectStore.GetContainerProject(vm.VMID) == id {
+			vm.ProjectID = id
+			projectVMs = append(projectVMs, vm)
+		}
+	}
+
 	// Calculate aggregates
 	totalCPU := 0
 	totalMemMB := int64(0)
@@ -1264,12 +2050,24 @@ func (h *Handler) GetProjectContainers(w http.ResponseWriter, r *http.Request) {
 			stopped++
 		}
 	}
+	for _, vm := range projectVMs {
+		totalCPU += vm.CPUs
+		totalMemMB += vm.MaxMem / 1024 / 1024
+		usedMemMB += vm.Mem / 1024 / 1024
+		if vm.Status == "running" {
+			running++
+		} else {
+			stopped++
+		}
+	}
 
 	result := map[string]interface{}{
 		"project":    project,
 		"containers": projectContainers,
+		"vms":        projectVMs,
 		"aggregate": map[string]interface{}{
 			"total_containers": len(projectContainers),
+			"total_vms":        len(projectVMs),
 			"running":          running,
 			"stopped":          stopped,
 			"total_cpu_cores":  totalCPU,
@@ -1302,10 +2100,17 @@ func (h *Handler) AssignContainerProject(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Verify container exists
-	_, err = h.client.GetContainer(vmid)
+	// Verify the container (or VM) exists
+	_, err = h.client.GetContainer(r.Context(), vmid)
+	if proxmox.IsNotFound(err) {
+		_, err = h.client.GetVM(r.Context(), vmid)
+	}
 	if err != nil {
-		respondError(w, http.StatusNotFound, "container not found")
+		if proxmox.IsNotFound(err) {
+			respondError(w, http.StatusNotFound, "container not found")
+			return
+		}
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
@@ -1320,7 +2125,7 @@ func (h *Handler) AssignContainerProject(w http.ResponseWriter, r *http.Request)
 
 	// Assign/unassign container (empty string means unassign)
 	if err := h.projectStore.AssignContainer(vmid, req.ProjectID); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
@@ -1368,7 +2173,11 @@ func (h *Handler) GetStorage(w http.ResponseWriter, r *http.Request) {
 		req.Target = target
 	}
 
-	storages, err := h.client.GetStorage(req)
+	if node := r.URL.Query().Get("node"); node != "" {
+		req.Node = node
+	}
+
+	storages, err := h.client.GetStorage(r.Context(), req)
 	if err != nil {
 		log.Printf("[ERROR] GetStorage failed: %v", err)
 		// Try to get from cache if Proxmox is down
@@ -1381,7 +2190,7 @@ func (h *Handler) GetStorage(w http.ResponseWriter, r *http.Request) {
 			}
 			log.Printf("[ERROR] Cache retrieval also failed: %v", cacheErr)
 		}
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
@@ -1412,9 +2221,13 @@ func (h *Handler) GetContainerTermProxy(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Check if container exists and is running
-	container, err := h.client.GetContainer(vmid)
+	container, err := h.client.GetContainer(r.Context(), vmid)
 	if err != nil {
-		respondError(w, http.StatusNotFound, "container not found")
+		if proxmox.IsNotFound(err) {
+			respondError(w, http.StatusNotFound, "container not found")
+			return
+		}
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
@@ -1424,13 +2237,32 @@ func (h *Handler) GetContainerTermProxy(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Create terminal proxy using the container's actual node
-	proxyData, err := h.client.CreateTermProxy(container.Node, vmid)
+	proxyData, err := h.client.CreateTermProxy(r.Context(), container.Node, vmid)
 	if err != nil {
 		log.Printf("[ERROR] Failed to create terminal proxy for container %d on node %s: %v", vmid, container.Node, err)
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, errorStatus(err), err.Error())
 		return
 	}
 
 	log.Printf("[INFO] Terminal proxy created for container %d on node %s", vmid, container.Node)
 	respondJSON(w, http.StatusOK, proxyData)
 }
+
+// GetTask returns the status and log of a Proxmox task
+func (h *Handler) GetTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	upid := vars["id"]
+
+	if _, err := proxmox.ParseUPID(upid); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid task id")
+		return
+	}
+
+	task, err := h.tasks.Get(r.Context(), upid)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, task)
+}
@@ -0,0 +1,2094 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/audit"
+	"github.com/MasonD-007/proxicloud/backend/internal/auth"
+	"github.com/MasonD-007/proxicloud/backend/internal/middleware"
+	"github.com/MasonD-007/proxicloud/backend/internal/oidc"
+	"github.com/MasonD-007/proxicloud/backend/internal/oidc/oidctest"
+	"github.com/MasonD-007/proxicloud/backend/internal/proxmox"
+	"github.com/MasonD-007/proxicloud/backend/internal/proxmox/proxmoxtest"
+	"github.com/MasonD-007/proxicloud/backend/internal/scheduler"
+	"github.com/MasonD-007/proxicloud/backend/internal/volumes"
+	"github.com/gorilla/mux"
+)
+
+// newTestRouter wires a Handler to a fake Proxmox server and registers the
+// routes under test. Options configure the Handler before it serves requests.
+func newTestRouter(t *testing.T, opts ...func(*Handler)) (*mux.Router, *proxmoxtest.Server, *proxmox.ProjectStore) {
+	t.Helper()
+
+	fake := proxmoxtest.NewServer()
+	t.Cleanup(fake.Close)
+
+	store, err := proxmox.NewProjectStore(filepath.Join(t.TempDir(), "projects.json"))
+	if err != nil {
+		t.Fatalf("NewProjectStore() error = %v", err)
+	}
+
+	policyStore, err := scheduler.NewPolicyStore(filepath.Join(t.TempDir(), "policies.json"))
+	if err != nil {
+		t.Fatalf("NewPolicyStore() error = %v", err)
+	}
+	policies := scheduler.NewScheduler(fake.Client(), policyStore, store)
+	policies.SetPollInterval(10 * time.Millisecond)
+	t.Cleanup(policies.Stop)
+
+	registry, err := volumes.NewRegistry(filepath.Join(t.TempDir(), "volumes.db"))
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	t.Cleanup(func() { registry.Close() })
+
+	authStore, err := auth.NewStore(filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	t.Cleanup(func() { authStore.Close() })
+
+	auditStore, err := audit.NewStore(filepath.Join(t.TempDir(), "audit.db"))
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	t.Cleanup(func() { auditStore.Close() })
+
+	h := NewHandler(fake.Client(), nil, nil, store, policies, registry)
+	h.tasks.SetPollInterval(10 * time.Millisecond)
+	h.SetAuth(authStore, time.Hour)
+	h.SetAudit(auditStore)
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	router := mux.NewRouter()
+	api := router.PathPrefix("/api").Subrouter()
+	api.HandleFunc("/health", h.Health).Methods("GET")
+	api.HandleFunc("/auth/login", h.Login).Methods("POST")
+	api.HandleFunc("/auth/providers", h.GetAuthProviders).Methods("GET")
+	api.HandleFunc("/auth/oidc/login", h.OIDCLogin).Methods("GET")
+	api.HandleFunc("/auth/oidc/callback", h.OIDCCallback).Methods("GET")
+	api.HandleFunc("/auth/oidc/exchange", h.OIDCExchange).Methods("POST")
+	api.HandleFunc("/auth/logout", h.Logout).Methods("POST")
+	api.HandleFunc("/auth/me", h.GetCurrentUser).Methods("GET")
+	api.HandleFunc("/auth/password", h.ChangePassword).Methods("PUT")
+	api.HandleFunc("/auth/keys", h.ListAPIKeys).Methods("GET")
+	api.HandleFunc("/auth/keys", h.CreateAPIKey).Methods("POST")
+	api.HandleFunc("/auth/keys/{id}", h.DeleteAPIKey).Methods("DELETE")
+	api.HandleFunc("/auth/users", h.ListUsers).Methods("GET")
+	api.HandleFunc("/auth/users", h.CreateUser).Methods("POST")
+	api.HandleFunc("/auth/users/{username}", h.DeleteUser).Methods("DELETE")
+	api.HandleFunc("/audit", h.ListAudit).Methods("GET")
+	api.HandleFunc("/dashboard", h.Dashboard).Methods("GET")
+	api.HandleFunc("/analytics/stats", h.GetAnalyticsStats).Methods("GET")
+	api.HandleFunc("/nodes", h.ListNodes).Methods("GET")
+	api.HandleFunc("/nodes/{node}/drain", h.DrainNode).Methods("POST")
+	api.HandleFunc("/nodes/{node}/drain", h.GetNodeDrain).Methods("GET")
+	api.HandleFunc("/containers", h.ListContainers).Methods("GET")
+	api.HandleFunc("/containers", h.CreateContainer).Methods("POST")
+	api.HandleFunc("/containers/{vmid}", h.GetContainer).Methods("GET")
+	api.HandleFunc("/containers/{vmid}", h.UpdateContainer).Methods("PATCH")
+	api.HandleFunc("/containers/{vmid}", h.DeleteContainer).Methods("DELETE")
+	api.HandleFunc("/containers/{vmid}/pending", h.GetContainerPending).Methods("GET")
+	api.HandleFunc("/containers/{vmid}/clone", h.CloneContainer).Methods("POST")
+	api.HandleFunc("/containers/{vmid}/template", h.ConvertContainerToTemplate).Methods("POST")
+	api.HandleFunc("/containers/{vmid}/migrate", h.MigrateContainer).Methods("POST")
+	api.HandleFunc("/containers/{vmid}/snapshots", h.ListContainerSnapshots).Methods("GET")
+	api.HandleFunc("/containers/{vmid}/snapshots", h.CreateContainerSnapshot).Methods("POST")
+	api.HandleFunc("/containers/{vmid}/snapshots/{name}", h.DeleteContainerSnapshot).Methods("DELETE")
+	api.HandleFunc("/containers/{vmid}/snapshots/{name}/rollback", h.RollbackContainerSnapshot).Methods("POST")
+	api.HandleFunc("/containers/{vmid}/start", h.StartContainer).Methods("POST")
+	api.HandleFunc("/containers/{vmid}/stop", h.StopContainer).Methods("POST")
+	api.HandleFunc("/tasks/{id}", h.GetTask).Methods("GET")
+	api.HandleFunc("/vms", h.ListVMs).Methods("GET")
+	api.HandleFunc("/vms", h.CreateVM).Methods("POST")
+	api.HandleFunc("/vms/{vmid}", h.GetVM).Methods("GET")
+	api.HandleFunc("/vms/{vmid}", h.DeleteVM).Methods("DELETE")
+	api.HandleFunc("/vms/{vmid}/stop", h.StopVM).Methods("POST")
+	api.HandleFunc("/volumes", h.ListVolumes).Methods("GET")
+	api.HandleFunc("/volumes", h.CreateVolume).Methods("POST")
+	api.HandleFunc("/volumes/orphans", h.ListOrphanedVolumes).Methods("GET")
+	api.HandleFunc("/volumes/orphans/cleanup", h.CleanupOrphanedVolumes).Methods("POST")
+	api.HandleFunc("/volumes/{volid}", h.GetVolume).Methods("GET")
+	api.HandleFunc("/volumes/{volid}", h.UpdateVolume).Methods("PATCH")
+	api.HandleFunc("/volumes/{volid}", h.DeleteVolume).Methods("DELETE")
+	api.HandleFunc("/volumes/{volid}/history", h.GetVolumeHistory).Methods("GET")
+	api.HandleFunc("/volumes/{volid}/attach/{vmid}", h.AttachVolume).Methods("POST")
+	api.HandleFunc("/volumes/{volid}/detach/{vmid}", h.DetachVolume).Methods("POST")
+	api.HandleFunc("/volumes/{volid}/resize", h.ResizeVolume).Methods("POST")
+	api.HandleFunc("/volumes/{volid}/move", h.MoveVolume).Methods("POST")
+	api.HandleFunc("/volumes/{volid}/snapshots", h.ListSnapshots).Methods("GET")
+	api.HandleFunc("/volumes/{volid}/snapshots", h.CreateSnapshot).Methods("POST")
+	api.HandleFunc("/storage-classes", h.ListStorageClasses).Methods("GET")
+	api.HandleFunc("/backups", h.ListBackups).Methods("GET")
+	api.HandleFunc("/backups", h.CreateBackup).Methods("POST")
+	api.HandleFunc("/backups/restore", h.RestoreBackup).Methods("POST")
+	api.HandleFunc("/backups/{volid:.+}", h.DeleteBackup).Methods("DELETE")
+	api.HandleFunc("/policies", h.ListPolicies).Methods("GET")
+	api.HandleFunc("/policies", h.CreatePolicy).Methods("POST")
+	api.HandleFunc("/policies/{id}", h.GetPolicy).Methods("GET")
+	api.HandleFunc("/policies/{id}", h.UpdatePolicy).Methods("PUT")
+	api.HandleFunc("/policies/{id}", h.DeletePolicy).Methods("DELETE")
+	api.HandleFunc("/policies/{id}/runs", h.ListPolicyRuns).Methods("GET")
+	api.HandleFunc("/policies/{id}/run", h.RunPolicy).Methods("POST")
+	api.HandleFunc("/projects", h.ListProjects).Methods("GET")
+	api.HandleFunc("/projects", h.CreateProject).Methods("POST")
+	api.HandleFunc("/projects/{id}", h.UpdateProject).Methods("PUT")
+	api.HandleFunc("/projects/{id}", h.DeleteProject).Methods("DELETE")
+	api.HandleFunc("/projects/{id}/members", h.ListProjectMembers).Methods("GET")
+	api.HandleFunc("/projects/{id}/members/{username}", h.SetProjectMember).Methods("PUT")
+	api.HandleFunc("/projects/{id}/members/{username}", h.RemoveProjectMember).Methods("DELETE")
+	api.HandleFunc("/containers/{vmid}/project", h.AssignContainerProject).Methods("POST")
+
+	router.Use(actAsAdmin, h.Audit, h.Authorize)
+
+	return router, fake, store
+}
+
+// actAsAdmin lets requests that did not go through the auth middleware act as
+// a global admin, so tests that are not about access control need not log in
+func actAsAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := auth.UserFrom(r.Context()); !ok {
+			r = r.WithContext(auth.WithUser(r.Context(), &auth.User{Username: "test-admin", Admin: true}))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// doJSON performs a request against the router and decodes the JSON response into out
+func doJSON(t *testing.T, router http.Handler, method, path string, body interface{}, out interface{}) int {
+	t.Helper()
+	return doJSONAs(t, router, "", method, path, body, out)
+}
+
+// doJSONAs is doJSON with a bearer token, for requests through the auth middleware
+func doJSONAs(t *testing.T, router http.Handler, token, method, path string, body interface{}, out interface{}) int {
+	t.Helper()
+
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			t.Fatalf("failed to encode request body: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, &reqBody)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: failed to decode response %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestContainerLifecycle(t *testing.T) {
+	router, fake, _ := newTestRouter(t)
+	template := fake.AddTemplate("local", "debian-12-standard_12.2-1_amd64.tar.zst")
+
+	var created struct {
+		VMID   int    `json:"vmid"`
+		TaskID string `json:"task_id"`
+	}
+	status := doJSON(t, router, "POST", "/api/containers", proxmox.CreateContainerRequest{
+		Hostname:   "web01",
+		Cores:      1,
+		Memory:     512,
+		Disk:       8,
+		OSTemplate: template,
+		IPAddress:  "192.168.1.50/24",
+		Gateway:    "192.168.1.1",
+	}, &created)
+	if status != http.StatusCreated {
+		t.Fatalf("CreateContainer status = %d, want %d", status, http.StatusCreated)
+	}
+	vmid := created.VMID
+	if vmid != 100 {
+		t.Errorf("CreateContainer vmid = %d, want 100", vmid)
+	}
+	if created.TaskID == "" {
+		t.Errorf("CreateContainer returned no task_id")
+	}
+
+	var containers []proxmox.Container
+	if status := doJSON(t, router, "GET", "/api/containers", nil, &containers); status != http.StatusOK {
+		t.Fatalf("ListContainers status = %d, want %d", status, http.StatusOK)
+	}
+	if len(containers) != 1 || containers[0].Name != "web01" {
+		t.Fatalf("ListContainers = %+v, want one container named web01", containers)
+	}
+	if containers[0].IPAddress != "192.168.1.50/24" {
+		t.Errorf("container IP = %q, want %q", containers[0].IPAddress, "192.168.1.50/24")
+	}
+
+	if status := doJSON(t, router, "POST", "/api/containers/100/start", nil, nil); status != http.StatusOK {
+		t.Fatalf("StartContainer status = %d, want %d", status, http.StatusOK)
+	}
+	if ct, _, _ := fake.Container(vmid); ct.Status != "running" {
+		t.Errorf("container status after start = %q, want running", ct.Status)
+	}
+
+	// Proxmox refuses to destroy a running container
+	if status := doJSON(t, router, "DELETE", "/api/containers/100", nil, nil); status != http.StatusConflict {
+		t.Errorf("DeleteContainer on running container status = %d, want %d", status, http.StatusConflict)
+	}
+
+	if status := doJSON(t, router, "POST", "/api/containers/100/stop", nil, nil); status != http.StatusOK {
+		t.Fatalf("StopContainer status = %d, want %d", status, http.StatusOK)
+	}
+	if status := doJSON(t, router, "DELETE", "/api/containers/100", nil, nil); status != http.StatusOK {
+		t.Fatalf("DeleteContainer status = %d, want %d", status, http.StatusOK)
+	}
+	if _, _, exists := fake.Container(vmid); exists {
+		t.Errorf("container %d still exists after delete", vmid)
+	}
+	if fake.HasVolume("local-lvm:vm-100-disk-0") {
+		t.Errorf("rootfs volume still exists after container delete")
+	}
+	if status := doJSON(t, router, "GET", "/api/containers/100", nil, nil); status != http.StatusNotFound {
+		t.Errorf("GetContainer after delete status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestUpdateContainer(t *testing.T) {
+	router, fake, _ := newTestRouter(t)
+	template := fake.AddTemplate("local", "debian-12-standard_12.2-1_amd64.tar.zst")
+
+	if status := doJSON(t, router, "POST", "/api/containers?wait=true", proxmox.CreateContainerRequest{
+		Hostname:   "web01",
+		Cores:      1,
+		Memory:     512,
+		Disk:       8,
+		OSTemplate: template,
+	}, nil); status != http.StatusCreated {
+		t.Fatalf("CreateContainer status = %d, want %d", status, http.StatusCreated)
+	}
+	if status := doJSON(t, router, "POST", "/api/containers/100/start", nil, nil); status != http.StatusOK {
+		t.Fatalf("StartContainer status = %d, want %d", status, http.StatusOK)
+	}
+
+	for name, body := range map[string]map[string]interface{}{
+		"no changes":   {},
+		"zero cores":   {"cores": 0},
+		"bad hostname": {"hostname": "web_01"},
+		"bad dns":      {"nameserver": "dns.example.com"},
+		"shrink disk":  {"disk": 4},
+	} {
+		if status := doJSON(t, router, "PATCH", "/api/containers/100", body, nil); status != http.StatusBadRequest {
+			t.Errorf("UpdateContainer (%s) status = %d, want %d", name, status, http.StatusBadRequest)
+		}
+	}
+
+	var updated struct {
+		Status         string                  `json:"status"`
+		RebootRequired bool                    `json:"reboot_required"`
+		Pending        []proxmox.PendingChange `json:"pending"`
+		TaskID         string                  `json:"task_id"`
+	}
+	status := doJSON(t, router, "PATCH", "/api/containers/100?wait=true", map[string]interface{}{
+		"memory":   1024,
+		"swap":     256,
+		"hostname": "web02",
+		"disk":     16,
+	}, &updated)
+	if status != http.StatusOK {
+		t.Fatalf("UpdateContainer status = %d, want %d", status, http.StatusOK)
+	}
+	if updated.TaskID == "" {
+		t.Errorf("UpdateContainer returned no task_id for the resize")
+	}
+
+	// Memory and swap are hot-plugged, the hostname waits for a restart
+	if !updated.RebootRequired || len(updated.Pending) != 1 || updated.Pending[0].Key != "hostname" || updated.Pending[0].Pending != "web02" {
+		t.Errorf("UpdateContainer pending = %+v (reboot_required %v), want the hostname change", updated.Pending, updated.RebootRequired)
+	}
+	ct, config, _ := fake.Container(100)
+	if config["memory"] != "1024" || config["swap"] != "256" {
+		t.Errorf("config memory/swap = %q/%q, want 1024/256", config["memory"], config["swap"])
+	}
+	if config["rootfs"] != "local-lvm:vm-100-disk-0,size=16G" || ct.MaxDisk != 16*1024*1024*1024 {
+		t.Errorf("rootfs = %q (maxdisk %d), want it grown to 16G", config["rootfs"], ct.MaxDisk)
+	}
+
+	var pending struct {
+		RebootRequired bool                    `json:"reboot_required"`
+		Pending        []proxmox.PendingChange `json:"pending"`
+	}
+	if status := doJSON(t, router, "GET", "/api/containers/100/pending", nil, &pending); status != http.StatusOK {
+		t.Fatalf("GetContainerPending status = %d, want %d", status, http.StatusOK)
+	}
+	if !pending.RebootRequired || len(pending.Pending) != 1 {
+		t.Errorf("GetContainerPending = %+v, want the hostname change", pending)
+	}
+
+	// Restarting applies the pending change
+	doJSON(t, router, "POST", "/api/containers/100/stop", nil, nil)
+	doJSON(t, router, "POST", "/api/containers/100/start", nil, nil)
+	if ct, _, _ := fake.Container(100); ct.Name != "web02" {
+		t.Errorf("container name after restart = %q, want web02", ct.Name)
+	}
+	if status := doJSON(t, router, "GET", "/api/containers/100/pending", nil, &pending); status != http.StatusOK || pending.RebootRequired {
+		t.Errorf("GetContainerPending after restart = %+v (status %d), want nothing pending", pending, status)
+	}
+
+	if status := doJSON(t, router, "PATCH", "/api/containers/999", map[string]interface{}{"cores": 2}, nil); status != http.StatusNotFound {
+		t.Errorf("UpdateContainer on missing container status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestCloneContainer(t *testing.T) {
+	router, fake, store := newTestRouter(t)
+	rootfs := fake.AddVolume("local-lvm", "vm-100-disk-0", 8, 100)
+	fake.AddContainer(proxmox.Container{VMID: 100, Name: "golden", Status: "running"}, map[string]string{
+		"rootfs": rootfs + ",size=8G",
+		"cores":  "2",
+	})
+
+	// A running container cannot become a template, and linked clones need a template
+	if status := doJSON(t, router, "POST", "/api/containers/100/template", nil, nil); status != http.StatusConflict {
+		t.Errorf("ConvertContainerToTemplate on running container status = %d, want %d", status, http.StatusConflict)
+	}
+	doJSON(t, router, "POST", "/api/containers/100/stop", nil, nil)
+	if status := doJSON(t, router, "POST", "/api/containers/100/clone?wait=true", proxmox.CloneContainerRequest{NewID: 101}, nil); status != http.StatusBadRequest {
+		t.Errorf("linked clone of a regular container status = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	if status := doJSON(t, router, "POST", "/api/containers/100/template", nil, nil); status != http.StatusOK {
+		t.Fatalf("ConvertContainerToTemplate status = %d, want %d", status, http.StatusOK)
+	}
+	var golden proxmox.Container
+	doJSON(t, router, "GET", "/api/containers/100", nil, &golden)
+	if !golden.Template {
+		t.Errorf("container 100 is not reported as a template")
+	}
+
+	start, end := 300, 310
+	project, err := store.CreateProject(proxmox.CreateProjectRequest{Name: "web", ContainerIDStart: &start, ContainerIDEnd: &end})
+	if err != nil {
+		t.Fatalf("CreateProject() error = %v", err)
+	}
+
+	var cloned struct {
+		VMID   int    `json:"vmid"`
+		TaskID string `json:"task_id"`
+	}
+	status := doJSON(t, router, "POST", "/api/containers/100/clone?wait=true", proxmox.CloneContainerRequest{
+		Hostname:  "web-1",
+		Full:      true,
+		ProjectID: project.ID,
+	}, &cloned)
+	if status != http.StatusCreated {
+		t.Fatalf("CloneContainer status = %d, want %d", status, http.StatusCreated)
+	}
+	if cloned.VMID != 300 || cloned.TaskID == "" {
+		t.Errorf("CloneContainer = %+v, want vmid 300 from the project range with a task", cloned)
+	}
+	if store.GetContainerProject(300) != project.ID {
+		t.Errorf("clone was not assigned to project %s", project.ID)
+	}
+
+	ct, config, ok := fake.Container(300)
+	if !ok || ct.Name != "web-1" || ct.Template {
+		t.Fatalf("clone = %+v (exists %v), want a regular container named web-1", ct, ok)
+	}
+	if config["rootfs"] != "local-lvm:vm-300-disk-0,size=8G" || !fake.HasVolume("local-lvm:vm-300-disk-0") {
+		t.Errorf("clone rootfs = %q, want its own copy of the disk", config["rootfs"])
+	}
+	if config["cores"] != "2" {
+		t.Errorf("clone cores = %q, want 2", config["cores"])
+	}
+
+	if status := doJSON(t, router, "POST", "/api/containers/100/clone", proxmox.CloneContainerRequest{Hostname: "bad_name"}, nil); status != http.StatusBadRequest {
+		t.Errorf("CloneContainer with invalid hostname status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestVolumeAttachAndSnapshot(t *testing.T) {
+	router, fake, _ := newTestRouter(t)
+	fake.AddContainer(proxmox.Container{VMID: 200, Name: "db01"}, map[string]string{
+		"rootfs": "local-lvm:vm-200-disk-0,size=8G",
+	})
+
+	var volume proxmox.Volume
+	status := doJSON(t, router, "POST", "/api/volumes", proxmox.CreateVolumeRequest{
+		Name:    "vm-200-disk-1",
+		Size:    10,
+		Storage: "local-zfs",
+	}, &volume)
+	if status != http.StatusCreated {
+		t.Fatalf("CreateVolume status = %d, want %d", status, http.StatusCreated)
+	}
+	if volume.VolID != "local-zfs:vm-200-disk-1" {
+		t.Errorf("CreateVolume volid = %q, want %q", volume.VolID, "local-zfs:vm-200-disk-1")
+	}
+	if status := doJSON(t, router, "POST", "/api/volumes", proxmox.CreateVolumeRequest{
+		Name:    "vm-200-disk-1",
+		Size:    10,
+		Storage: "local-zfs",
+	}, nil); status != http.StatusConflict {
+		t.Errorf("CreateVolume with existing name status = %d, want %d", status, http.StatusConflict)
+	}
+
+	if status := doJSON(t, router, "POST", "/api/volumes/"+volume.VolID+"/attach/200", nil, nil); status != http.StatusOK {
+		t.Fatalf("AttachVolume status = %d, want %d", status, http.StatusOK)
+	}
+
+	var volumes []proxmox.Volume
+	if status := doJSON(t, router, "GET", "/api/volumes", nil, &volumes); status != http.StatusOK {
+		t.Fatalf("ListVolumes status = %d, want %d", status, http.StatusOK)
+	}
+	found := false
+	for _, v := range volumes {
+		if v.VolID == volume.VolID {
+			found = true
+			if v.AttachedTo == nil || *v.AttachedTo != 200 {
+				t.Errorf("volume attached_to = %v, want 200", v.AttachedTo)
+			}
+			if v.Size != 10 {
+				t.Errorf("volume size = %d, want 10", v.Size)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("ListVolumes did not return %s", volume.VolID)
+	}
+
+	path := "/api/volumes/" + volume.VolID + "/snapshots"
+	if status := doJSON(t, router, "POST", path, proxmox.CreateSnapshotRequest{Name: "before-upgrade"}, nil); status != http.StatusCreated {
+		t.Fatalf("CreateSnapshot status = %d, want %d", status, http.StatusCreated)
+	}
+	var snapshots []proxmox.Snapshot
+	if status := doJSON(t, router, "GET", path, nil, &snapshots); status != http.StatusOK {
+		t.Fatalf("ListSnapshots status = %d, want %d", status, http.StatusOK)
+	}
+	if len(snapshots) != 1 || snapshots[0].Name != "before-upgrade" {
+		t.Errorf("ListSnapshots = %+v, want one snapshot named before-upgrade", snapshots)
+	}
+}
+
+func TestAttachDetachMountPoints(t *testing.T) {
+	router, fake, _ := newTestRouter(t)
+	logs := fake.AddVolume("local-zfs", "vm-300-disk-1", 4, 300)
+	data := fake.AddVolume("local-zfs", "vm-300-disk-2", 4, 300)
+	cache := fake.AddVolume("local-zfs", "vm-300-disk-3", 4, 300)
+	fake.AddContainer(proxmox.Container{VMID: 300, Name: "app01"}, map[string]string{
+		"rootfs": "local-lvm:vm-300-disk-0,size=8G",
+		"mp0":    logs + ",mp=/var/log/app",
+	})
+
+	if status := doJSON(t, router, "POST", "/api/volumes/"+data+"/attach/300", nil, nil); status != http.StatusOK {
+		t.Fatalf("AttachVolume status = %d, want %d", status, http.StatusOK)
+	}
+	backup := true
+	req := proxmox.AttachVolumeRequest{Path: "/srv/cache", Backup: &backup, ReadOnly: true}
+	if status := doJSON(t, router, "POST", "/api/volumes/"+cache+"/attach/300", req, nil); status != http.StatusOK {
+		t.Fatalf("AttachVolume with options status = %d, want %d", status, http.StatusOK)
+	}
+	_, config, _ := fake.Container(300)
+	if config["mp1"] != data+",mp=/mnt/vm-300-disk-2" {
+		t.Errorf("mp1 = %q, want %s at /mnt/vm-300-disk-2", config["mp1"], data)
+	}
+	if config["mp2"] != cache+",mp=/srv/cache,backup=1,ro=1" {
+		t.Errorf("mp2 = %q, want %s at /srv/cache with backup and ro", config["mp2"], cache)
+	}
+
+	if status := doJSON(t, router, "POST", "/api/volumes/"+cache+"/attach/300", nil, nil); status != http.StatusConflict {
+		t.Errorf("AttachVolume of attached volume status = %d, want %d", status, http.StatusConflict)
+	}
+	spare := fake.AddVolume("local-zfs", "vm-300-disk-4", 4, 300)
+	if status := doJSON(t, router, "POST", "/api/volumes/"+spare+"/attach/300", proxmox.AttachVolumeRequest{MountPoint: "mp0"}, nil); status != http.StatusConflict {
+		t.Errorf("AttachVolume at used mount point status = %d, want %d", status, http.StatusConflict)
+	}
+	if status := doJSON(t, router, "POST", "/api/volumes/"+spare+"/attach/300", proxmox.AttachVolumeRequest{MountPoint: "rootfs"}, nil); status != http.StatusBadRequest {
+		t.Errorf("AttachVolume at rootfs status = %d, want %d", status, http.StatusBadRequest)
+	}
+	for _, path := range []string{"srv/data", "/srv/$(reboot)", "/srv/a,mp=/etc", "/srv/../etc"} {
+		if status := doJSON(t, router, "POST", "/api/volumes/"+spare+"/attach/300", proxmox.AttachVolumeRequest{Path: path}, nil); status != http.StatusBadRequest {
+			t.Errorf("AttachVolume at path %q status = %d, want %d", path, status, http.StatusBadRequest)
+		}
+	}
+
+	// Detaching the second volume leaves the others in place
+	if status := doJSON(t, router, "POST", "/api/volumes/"+data+"/detach/300", nil, nil); status != http.StatusOK {
+		t.Fatalf("DetachVolume status = %d, want %d", status, http.StatusOK)
+	}
+	_, config, _ = fake.Container(300)
+	if _, ok := config["mp1"]; ok || config["mp0"] == "" || config["mp2"] == "" {
+		t.Errorf("config after detach = %v, want only mp1 removed", config)
+	}
+	if status := doJSON(t, router, "POST", "/api/volumes/"+data+"/detach/300", nil, nil); status != http.StatusNotFound {
+		t.Errorf("DetachVolume of detached volume status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestResizeAndMoveVolume(t *testing.T) {
+	router, fake, _ := newTestRouter(t)
+	data := fake.AddVolume("local-lvm", "vm-300-disk-1", 4, 300)
+	spare := fake.AddVolume("local-lvm", "vm-300-disk-2", 4, 300)
+	fake.AddContainer(proxmox.Container{VMID: 300, Name: "app01"}, map[string]string{
+		"rootfs": "local-lvm:vm-300-disk-0,size=8G",
+		"mp0":    data + ",mp=/srv/data,size=4G",
+	})
+
+	// Attached volumes grow through the container and keep their options
+	if status := doJSON(t, router, "POST", "/api/volumes/"+data+"/resize?wait=true", proxmox.ResizeVolumeRequest{Size: 10}, nil); status != http.StatusOK {
+		t.Fatalf("ResizeVolume attached status = %d, want %d", status, http.StatusOK)
+	}
+	if _, config, _ := fake.Container(300); config["mp0"] != data+",mp=/srv/data,size=10G" {
+		t.Errorf("mp0 after resize = %q, want %s at /srv/data with size=10G", config["mp0"], data)
+	}
+	if status := doJSON(t, router, "POST", "/api/volumes/"+data+"/resize", proxmox.ResizeVolumeRequest{Size: 6}, nil); status != http.StatusBadRequest {
+		t.Errorf("ResizeVolume shrink status = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	// Proxmox only resizes disks through a guest
+	if status := doJSON(t, router, "POST", "/api/volumes/"+spare+"/resize?wait=true", proxmox.ResizeVolumeRequest{Size: 20}, nil); status != http.StatusBadRequest {
+		t.Errorf("ResizeVolume detached status = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	if status := doJSON(t, router, "POST", "/api/volumes/"+spare+"/move", proxmox.MoveVolumeRequest{Storage: "local-zfs"}, nil); status != http.StatusBadRequest {
+		t.Errorf("MoveVolume detached status = %d, want %d", status, http.StatusBadRequest)
+	}
+	if status := doJSON(t, router, "POST", "/api/volumes/"+data+"/move", proxmox.MoveVolumeRequest{Storage: "local-lvm"}, nil); status != http.StatusBadRequest {
+		t.Errorf("MoveVolume to same storage status = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	var moved map[string]interface{}
+	if status := doJSON(t, router, "POST", "/api/volumes/"+data+"/move?wait=true", proxmox.MoveVolumeRequest{Storage: "local-zfs"}, &moved); status != http.StatusOK {
+		t.Fatalf("MoveVolume status = %d, want %d", status, http.StatusOK)
+	}
+	if moved["task_id"] == nil || moved["task_status"] != "OK" {
+		t.Errorf("MoveVolume response = %v, want a finished task", moved)
+	}
+	_, config, _ := fake.Container(300)
+	if config["mp0"] != "local-zfs:vm-300-disk-0,mp=/srv/data,size=10G" {
+		t.Errorf("mp0 after move = %q, want it on local-zfs", config["mp0"])
+	}
+	if fake.HasVolume(data) {
+		t.Errorf("source volume %s still exists after move", data)
+	}
+}
+
+func TestStorageClasses(t *testing.T) {
+	router, fake, store := newTestRouter(t, func(h *Handler) {
+		h.SetStorageClasses([]proxmox.StorageClass{
+			{Name: "ssd", Storage: "local-zfs", Default: true, DefaultSize: 8, MaxSize: 100, Snapshots: true},
+			{Name: "hdd", Storage: "local-lvm", MaxSize: 500},
+			{Name: "reserved", Storage: "local-zfs", Projects: []string{"team-a"}},
+		})
+	})
+	if _, err := store.CreateProjectWithID("team-a", proxmox.CreateProjectRequest{Name: "team-a"}); err != nil {
+		t.Fatalf("CreateProjectWithID() error = %v", err)
+	}
+
+	var classes []proxmox.StorageClassStatus
+	if status := doJSON(t, router, "GET", "/api/storage-classes", nil, &classes); status != http.StatusOK {
+		t.Fatalf("ListStorageClasses status = %d, want %d", status, http.StatusOK)
+	}
+	if len(classes) != 3 || classes[0].Name != "hdd" || !classes[0].Available || classes[0].Avail == nil {
+		t.Errorf("ListStorageClasses = %+v, want 3 classes with capacity, hdd first", classes)
+	}
+	doJSON(t, router, "GET", "/api/storage-classes?project_id=team-b", nil, &classes)
+	if len(classes) != 2 {
+		t.Errorf("ListStorageClasses for team-b = %d classes, want 2", len(classes))
+	}
+
+	// The default class picks the pool and the size
+	var volume proxmox.Volume
+	if status := doJSON(t, router, "POST", "/api/volumes", proxmox.CreateVolumeRequest{Name: "vm-0-disk-5"}, &volume); status != http.StatusCreated {
+		t.Fatalf("CreateVolume status = %d, want %d", status, http.StatusCreated)
+	}
+	if volume.Storage != "local-zfs" || volume.Type != "ssd" || volume.Size != 8 {
+		t.Errorf("CreateVolume = %s on %s (%dGB), want ssd on local-zfs (8GB)", volume.Type, volume.Storage, volume.Size)
+	}
+
+	tests := []struct {
+		name string
+		req  proxmox.CreateVolumeRequest
+		want int
+	}{
+		{"named class", proxmox.CreateVolumeRequest{Name: "vm-0-disk-6", Type: "hdd", Size: 200}, http.StatusCreated},
+		{"unknown class", proxmox.CreateVolumeRequest{Name: "vm-0-disk-7", Type: "nvme", Size: 10}, http.StatusBadRequest},
+		{"over the limit", proxmox.CreateVolumeRequest{Name: "vm-0-disk-7", Type: "ssd", Size: 101}, http.StatusBadRequest},
+		{"restricted without project", proxmox.CreateVolumeRequest{Name: "vm-0-disk-7", Type: "reserved", Size: 10}, http.StatusForbidden},
+		{"restricted to other project", proxmox.CreateVolumeRequest{Name: "vm-0-disk-7", Type: "reserved", Size: 10, ProjectID: "team-b"}, http.StatusForbidden},
+		{"allowed project", proxmox.CreateVolumeRequest{Name: "vm-0-disk-7", Type: "reserved", Size: 10, ProjectID: "team-a"}, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		if status := doJSON(t, router, "POST", "/api/volumes", tt.req, nil); status != tt.want {
+			t.Errorf("%s: CreateVolume status = %d, want %d", tt.name, status, tt.want)
+		}
+	}
+
+	// Volumes are listed with the class of their pool
+	if doJSON(t, router, "GET", "/api/volumes/local-lvm:vm-0-disk-6", nil, &volume); volume.Type != "hdd" {
+		t.Errorf("GetVolume type = %q, want hdd", volume.Type)
+	}
+	if status := doJSON(t, router, "POST", "/api/volumes/local-lvm:vm-0-disk-6/snapshots", proxmox.CreateSnapshotRequest{Name: "before"}, nil); status != http.StatusBadRequest {
+		t.Errorf("CreateSnapshot in class without snapshots status = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	// Containers get their rootfs from the class
+	template := fake.AddTemplate("local", "debian-12-standard_12.2-1_amd64.tar.zst")
+	req := proxmox.CreateContainerRequest{Hostname: "web01", StorageClass: "hdd", OSTemplate: template}
+	var created map[string]interface{}
+	if status := doJSON(t, router, "POST", "/api/containers?wait=true", req, &created); status != http.StatusCreated {
+		t.Fatalf("CreateContainer status = %d, want %d", status, http.StatusCreated)
+	}
+	_, config, _ := fake.Container(int(created["vmid"].(float64)))
+	if !strings.HasPrefix(config["rootfs"], "local-lvm:") {
+		t.Errorf("rootfs = %q, want it on local-lvm", config["rootfs"])
+	}
+}
+
+func TestContainerSnapshots(t *testing.T) {
+	router, fake, _ := newTestRouter(t)
+	rootfs := fake.AddVolume("local-lvm", "vm-100-disk-0", 8, 100)
+	fake.AddContainer(proxmox.Container{VMID: 100, Name: "web01"}, map[string]string{"rootfs": rootfs + ",size=8G", "cores": "1"})
+
+	snapshot := func(name string) int {
+		return doJSON(t, router, "POST", "/api/containers/100/snapshots?wait=true", proxmox.CreateSnapshotRequest{Name: name}, nil)
+	}
+	if status := snapshot("1st"); status != http.StatusBadRequest {
+		t.Errorf("CreateContainerSnapshot with invalid name status = %d, want %d", status, http.StatusBadRequest)
+	}
+	if status := snapshot("base"); status != http.StatusCreated {
+		t.Fatalf("CreateContainerSnapshot status = %d, want %d", status, http.StatusCreated)
+	}
+	if status := snapshot("base"); status != http.StatusConflict {
+		t.Errorf("CreateContainerSnapshot with duplicate name status = %d, want %d", status, http.StatusConflict)
+	}
+
+	// Rolling back restores the config of the snapshot
+	doJSON(t, router, "PATCH", "/api/containers/100", map[string]interface{}{"cores": 4}, nil)
+	if status := snapshot("upgraded"); status != http.StatusCreated {
+		t.Fatalf("CreateContainerSnapshot status = %d, want %d", status, http.StatusCreated)
+	}
+	if status := doJSON(t, router, "POST", "/api/containers/100/snapshots/base/rollback?wait=true", nil, nil); status != http.StatusOK {
+		t.Fatalf("RollbackContainerSnapshot status = %d, want %d", status, http.StatusOK)
+	}
+	if _, config, _ := fake.Container(100); config["cores"] != "1" {
+		t.Errorf("cores after rollback = %q, want 1", config["cores"])
+	}
+	if status := snapshot("experiment"); status != http.StatusCreated {
+		t.Fatalf("CreateContainerSnapshot status = %d, want %d", status, http.StatusCreated)
+	}
+
+	var listed struct {
+		Current   string                      `json:"current"`
+		Snapshots []proxmox.Snapshot          `json:"snapshots"`
+		Tree      []*proxmox.SnapshotTreeNode `json:"tree"`
+	}
+	if status := doJSON(t, router, "GET", "/api/containers/100/snapshots", nil, &listed); status != http.StatusOK {
+		t.Fatalf("ListContainerSnapshots status = %d, want %d", status, http.StatusOK)
+	}
+	if len(listed.Snapshots) != 3 || listed.Current != "experiment" {
+		t.Fatalf("ListContainerSnapshots = %+v, want 3 snapshots with experiment current", listed)
+	}
+	// base has two branches: upgraded and experiment
+	if len(listed.Tree) != 1 || listed.Tree[0].Name != "base" || len(listed.Tree[0].Children) != 2 {
+		t.Fatalf("snapshot tree = %+v, want base with two children", listed.Tree)
+	}
+	for _, child := range listed.Tree[0].Children {
+		if child.Parent != "base" {
+			t.Errorf("snapshot %s parent = %q, want base", child.Name, child.Parent)
+		}
+	}
+
+	if status := doJSON(t, router, "DELETE", "/api/containers/100/snapshots/base?wait=true", nil, nil); status != http.StatusOK {
+		t.Fatalf("DeleteContainerSnapshot status = %d, want %d", status, http.StatusOK)
+	}
+	doJSON(t, router, "GET", "/api/containers/100/snapshots", nil, &listed)
+	if len(listed.Snapshots) != 2 || len(listed.Tree) != 2 {
+		t.Errorf("snapshots after deleting base = %+v, want upgraded and experiment as roots", listed.Snapshots)
+	}
+	if status := doJSON(t, router, "DELETE", "/api/containers/100/snapshots/base", nil, nil); status != http.StatusNotFound {
+		t.Errorf("DeleteContainerSnapshot of missing snapshot status = %d, want %d", status, http.StatusNotFound)
+	}
+
+	// Directory storage cannot snapshot
+	dirRootfs := fake.AddVolume("local", "vm-101-disk-0", 8, 101)
+	fake.AddContainer(proxmox.Container{VMID: 101, Name: "web02"}, map[string]string{"rootfs": dirRootfs + ",size=8G"})
+	if status := doJSON(t, router, "POST", "/api/containers/101/snapshots", proxmox.CreateSnapshotRequest{Name: "base"}, nil); status != http.StatusBadRequest {
+		t.Errorf("CreateContainerSnapshot on dir storage status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	router, fake, store := newTestRouter(t)
+	rootfs := fake.AddVolume("local-lvm", "vm-100-disk-0", 8, 100)
+	fake.AddContainer(proxmox.Container{VMID: 100, Name: "web01"}, map[string]string{"rootfs": rootfs + ",size=8G", "hostname": "web01", "cores": "1"})
+
+	// The project's default backup storage is used when no storage is given
+	project, err := store.CreateProject(proxmox.CreateProjectRequest{Name: "web", BackupStorage: "local-lvm"})
+	if err != nil {
+		t.Fatalf("CreateProject() error = %v", err)
+	}
+	if err := store.AssignContainer(100, project.ID); err != nil {
+		t.Fatalf("AssignContainer() error = %v", err)
+	}
+	if status := doJSON(t, router, "POST", "/api/backups", proxmox.CreateBackupRequest{VMID: 100}, nil); status != http.StatusBadRequest {
+		t.Errorf("CreateBackup to project storage without backup content status = %d, want %d", status, http.StatusBadRequest)
+	}
+	if status := doJSON(t, router, "POST", "/api/backups", proxmox.CreateBackupRequest{VMID: 100, Storage: "local", Mode: "hibernate"}, nil); status != http.StatusBadRequest {
+		t.Errorf("CreateBackup with invalid mode status = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	req := proxmox.CreateBackupRequest{VMID: 100, Storage: "local", Notes: "{{guestname}} nightly"}
+	if status := doJSON(t, router, "POST", "/api/backups?wait=true", req, nil); status != http.StatusCreated {
+		t.Fatalf("CreateBackup status = %d, want %d", status, http.StatusCreated)
+	}
+
+	var backups []proxmox.Backup
+	if status := doJSON(t, router, "GET", "/api/backups?vmid=100", nil, &backups); status != http.StatusOK {
+		t.Fatalf("ListBackups status = %d, want %d", status, http.StatusOK)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("ListBackups = %+v, want one backup", backups)
+	}
+	backup := backups[0]
+	if backup.Type != "lxc" || backup.VMID != 100 || backup.Storage != "local" || backup.Notes != "web01 nightly" {
+		t.Errorf("backup = %+v, want lxc backup of 100 in local with notes", backup)
+	}
+
+	// Restore into a new container on other storage
+	newID := 150
+	restore := proxmox.RestoreBackupRequest{VolID: backup.VolID, VMID: &newID, Storage: "local-zfs", ProjectID: project.ID}
+	if status := doJSON(t, router, "POST", "/api/backups/restore?wait=true", restore, nil); status != http.StatusCreated {
+		t.Fatalf("RestoreBackup status = %d, want %d", status, http.StatusCreated)
+	}
+	ct, config, ok := fake.Container(150)
+	if !ok || ct.Name != "web01" || config["rootfs"] != "local-zfs:vm-150-disk-0,size=8G" {
+		t.Errorf("restored container = %+v %v, want web01 with rootfs on local-zfs", ct, config)
+	}
+	if got := store.GetContainerProject(150); got != project.ID {
+		t.Errorf("restored container project = %q, want %q", got, project.ID)
+	}
+
+	// An existing guest is only overwritten with force
+	oldID := 100
+	restore = proxmox.RestoreBackupRequest{VolID: backup.VolID, VMID: &oldID}
+	if status := doJSON(t, router, "POST", "/api/backups/restore", restore, nil); status != http.StatusConflict {
+		t.Errorf("RestoreBackup over existing guest status = %d, want %d", status, http.StatusConflict)
+	}
+	doJSON(t, router, "PATCH", "/api/containers/100", map[string]interface{}{"cores": 4}, nil)
+	restore.Force = true
+	if status := doJSON(t, router, "POST", "/api/backups/restore?wait=true", restore, nil); status != http.StatusCreated {
+		t.Fatalf("RestoreBackup with force status = %d, want %d", status, http.StatusCreated)
+	}
+	if _, config, _ := fake.Container(100); config["cores"] != "1" {
+		t.Errorf("cores after restore = %q, want 1", config["cores"])
+	}
+
+	path := "/api/backups/" + url.PathEscape(backup.VolID) + "?wait=true"
+	if status := doJSON(t, router, "DELETE", path, nil, nil); status != http.StatusOK {
+		t.Fatalf("DeleteBackup status = %d, want %d", status, http.StatusOK)
+	}
+	doJSON(t, router, "GET", "/api/backups", nil, &backups)
+	if len(backups) != 0 {
+		t.Errorf("ListBackups after delete = %+v, want none", backups)
+	}
+}
+
+func TestProjectSDNLifecycle(t *testing.T) {
+	router, fake, store := newTestRouter(t)
+
+	var project proxmox.Project
+	status := doJSON(t, router, "POST", "/api/projects", proxmox.CreateProjectRequest{
+		Name: "staging",
+		Network: &proxmox.ProjectNetwork{
+			Subnet:  "10.20.0.0/24",
+			Gateway: "10.20.0.1",
+		},
+	}, &project)
+	if status != http.StatusCreated {
+		t.Fatalf("CreateProject status = %d, want %d", status, http.StatusCreated)
+	}
+	if project.Network == nil || project.Network.VNetID == "" {
+		t.Fatalf("CreateProject network = %+v, want VNet to be set", project.Network)
+	}
+	vnetID := project.Network.VNetID
+	if !fake.HasSDNZone(project.Network.Zone) || !fake.HasVNet(vnetID) {
+		t.Fatalf("SDN zone/vnet %s not created on Proxmox", vnetID)
+	}
+
+	if status := doJSON(t, router, "DELETE", "/api/projects/"+project.ID, nil, nil); status != http.StatusOK {
+		t.Fatalf("DeleteProject status = %d, want %d", status, http.StatusOK)
+	}
+	if fake.HasVNet(vnetID) || fake.HasSDNZone(project.Network.Zone) {
+		t.Errorf("SDN zone/vnet %s still exists after project delete", vnetID)
+	}
+	if _, err := store.GetProject(project.ID); err == nil {
+		t.Errorf("project %s still in store after delete", project.ID)
+	}
+}
+
+func TestTaskTracking(t *testing.T) {
+	router, fake, _ := newTestRouter(t)
+	fake.AddContainer(proxmox.Container{VMID: 100, Name: "web01"}, nil)
+
+	var started map[string]interface{}
+	if status := doJSON(t, router, "POST", "/api/containers/100/start?wait=true", nil, &started); status != http.StatusOK {
+		t.Fatalf("StartContainer?wait=true status = %d, want %d", status, http.StatusOK)
+	}
+	if started["task_status"] != "OK" {
+		t.Errorf("StartContainer?wait=true task_status = %v, want OK", started["task_status"])
+	}
+
+	var task proxmox.Task
+	taskID, _ := started["task_id"].(string)
+	if status := doJSON(t, router, "GET", "/api/tasks/"+taskID, nil, &task); status != http.StatusOK {
+		t.Fatalf("GetTask status = %d, want %d", status, http.StatusOK)
+	}
+	if task.Type != "vzstart" || !task.Succeeded() {
+		t.Errorf("GetTask = %+v, want a successful vzstart task", task)
+	}
+
+	// A task that fails after Proxmox accepted the request must not be reported as success
+	fake.FailTasks("vzstop", "command 'lxc-stop -n 100' failed: exit code 1")
+
+	var stopped map[string]interface{}
+	if status := doJSON(t, router, "POST", "/api/containers/100/stop", nil, &stopped); status != http.StatusOK {
+		t.Fatalf("StopContainer status = %d, want %d", status, http.StatusOK)
+	}
+	if _, ok := stopped["task_id"]; !ok {
+		t.Errorf("StopContainer response %v has no task_id", stopped)
+	}
+
+	var failed map[string]interface{}
+	if status := doJSON(t, router, "POST", "/api/containers/100/stop?wait=true", nil, &failed); status != http.StatusInternalServerError {
+		t.Fatalf("StopContainer?wait=true status = %d, want %d", status, http.StatusInternalServerError)
+	}
+	if failed["error"] == nil || failed["log"] == nil {
+		t.Errorf("StopContainer?wait=true response = %v, want error and task log", failed)
+	}
+
+	if status := doJSON(t, router, "GET", "/api/tasks/not-a-upid", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("GetTask with invalid id status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestMultiNodeCluster(t *testing.T) {
+	router, fake, _ := newTestRouter(t)
+	fake.AddNode("pve2")
+	fake.AddNode("pve3")
+	fake.AddContainer(proxmox.Container{VMID: 100, Name: "web01", Status: "running"}, nil)
+	fake.AddContainer(proxmox.Container{VMID: 200, Name: "db01", Node: "pve2"}, nil)
+	template := fake.AddTemplate("local", "debian-12-standard_12.2-1_amd64.tar.zst")
+
+	var nodes []proxmox.Node
+	if status := doJSON(t, router, "GET", "/api/nodes", nil, &nodes); status != http.StatusOK {
+		t.Fatalf("ListNodes status = %d, want %d", status, http.StatusOK)
+	}
+	if len(nodes) != 3 {
+		t.Fatalf("ListNodes = %+v, want 3 nodes", nodes)
+	}
+
+	// Per-guest calls are routed to the node the guest lives on
+	if status := doJSON(t, router, "POST", "/api/containers/200/start", nil, nil); status != http.StatusOK {
+		t.Fatalf("StartContainer on pve2 status = %d, want %d", status, http.StatusOK)
+	}
+	if ct, _, _ := fake.Container(200); ct.Status != "running" {
+		t.Errorf("container 200 status = %q, want running", ct.Status)
+	}
+
+	var created map[string]interface{}
+	if status := doJSON(t, router, "POST", "/api/containers", proxmox.CreateContainerRequest{
+		Hostname:   "cache01",
+		Disk:       8,
+		OSTemplate: template,
+		Node:       "pve3",
+	}, &created); status != http.StatusCreated {
+		t.Fatalf("CreateContainer on pve3 status = %d, want %d", status, http.StatusCreated)
+	}
+	if created["node"] != "pve3" {
+		t.Errorf("CreateContainer node = %v, want pve3", created["node"])
+	}
+	if ct, _, _ := fake.Container(101); ct.Node != "pve3" {
+		t.Errorf("container 101 node = %q, want pve3", ct.Node)
+	}
+
+	var containers []proxmox.Container
+	if status := doJSON(t, router, "GET", "/api/containers", nil, &containers); status != http.StatusOK {
+		t.Fatalf("ListContainers status = %d, want %d", status, http.StatusOK)
+	}
+	placement := make(map[int]string)
+	for _, ct := range containers {
+		placement[ct.VMID] = ct.Node
+	}
+	want := map[int]string{100: "pve", 200: "pve2", 101: "pve3"}
+	for vmid, node := range want {
+		if placement[vmid] != node {
+			t.Errorf("container %d node = %q, want %q", vmid, placement[vmid], node)
+		}
+	}
+
+	var dashboard struct {
+		RunningContainers int `json:"running_containers"`
+		Nodes             []struct {
+			Node       string `json:"node"`
+			Containers int    `json:"containers"`
+			Running    int    `json:"running"`
+		} `json:"nodes"`
+	}
+	if status := doJSON(t, router, "GET", "/api/dashboard", nil, &dashboard); status != http.StatusOK {
+		t.Fatalf("Dashboard status = %d, want %d", status, http.StatusOK)
+	}
+	if dashboard.RunningContainers != 2 || len(dashboard.Nodes) != 3 {
+		t.Fatalf("Dashboard = %+v, want 2 running containers on 3 nodes", dashboard)
+	}
+	for _, n := range dashboard.Nodes {
+		if n.Containers != 1 {
+			t.Errorf("Dashboard node %s has %d containers, want 1", n.Node, n.Containers)
+		}
+	}
+}
+
+func TestMigrateAndDrainNode(t *testing.T) {
+	router, fake, _ := newTestRouter(t)
+	fake.AddNode("pve2")
+	rootfs := fake.AddVolume("local-lvm", "vm-100-disk-0", 8, 100)
+	fake.AddContainer(proxmox.Container{VMID: 100, Name: "web01", Status: "running"}, map[string]string{"rootfs": rootfs + ",size=8G"})
+	fake.AddContainer(proxmox.Container{VMID: 101, Name: "web02"}, nil)
+	fake.AddContainer(proxmox.Container{VMID: 102, Name: "web03", Status: "running"}, nil)
+	fake.AddContainer(proxmox.Container{VMID: 200, Name: "db01", Node: "pve2"}, nil)
+
+	// Containers cannot move live, a running one needs restart mode
+	if status := doJSON(t, router, "POST", "/api/containers/102/migrate", proxmox.MigrateContainerRequest{Target: "pve2"}, nil); status != http.StatusConflict {
+		t.Errorf("MigrateContainer without restart status = %d, want %d", status, http.StatusConflict)
+	}
+	if status := doJSON(t, router, "POST", "/api/containers/102/migrate?wait=true", proxmox.MigrateContainerRequest{Target: "pve2", Restart: true}, nil); status != http.StatusOK {
+		t.Fatalf("MigrateContainer status = %d, want %d", status, http.StatusOK)
+	}
+	if ct, _, _ := fake.Container(102); ct.Node != "pve2" || ct.Status != "running" {
+		t.Errorf("container 102 after migration = %+v, want running on pve2", ct)
+	}
+
+	if status := doJSON(t, router, "POST", "/api/nodes/pve/drain", proxmox.DrainNodeRequest{Target: "pve"}, nil); status != http.StatusBadRequest {
+		t.Errorf("DrainNode onto itself status = %d, want %d", status, http.StatusBadRequest)
+	}
+	if status := doJSON(t, router, "POST", "/api/nodes/pve/drain", proxmox.DrainNodeRequest{Target: "pve9"}, nil); status != http.StatusBadRequest {
+		t.Errorf("DrainNode onto unknown node status = %d, want %d", status, http.StatusBadRequest)
+	}
+	if status := doJSON(t, router, "POST", "/api/nodes/pve9/drain", proxmox.DrainNodeRequest{Target: "pve"}, nil); status != http.StatusNotFound {
+		t.Errorf("DrainNode of unknown node status = %d, want %d", status, http.StatusNotFound)
+	}
+
+	var drain proxmox.NodeDrain
+	if status := doJSON(t, router, "POST", "/api/nodes/pve/drain", proxmox.DrainNodeRequest{Target: "pve2", TargetStorage: "local", Concurrency: 2}, &drain); status != http.StatusAccepted {
+		t.Fatalf("DrainNode status = %d, want %d", status, http.StatusAccepted)
+	}
+	if len(drain.Containers) != 2 {
+		t.Fatalf("DrainNode containers = %+v, want 100 and 101", drain.Containers)
+	}
+
+	drain = waitForDrain(t, router, "pve")
+	if drain.Status != proxmox.DrainCompleted {
+		t.Fatalf("drain = %+v, want completed", drain)
+	}
+	for _, item := range drain.Containers {
+		if item.Status != proxmox.DrainMigrated || item.TaskID == "" {
+			t.Errorf("drain item = %+v, want migrated with a task", item)
+		}
+	}
+	ct, config, _ := fake.Container(100)
+	if ct.Node != "pve2" || ct.Status != "running" {
+		t.Errorf("container 100 after drain = %+v, want running on pve2", ct)
+	}
+	if config["rootfs"] != "local:vm-100-disk-0,size=8G" || !fake.HasVolume("local:vm-100-disk-0") {
+		t.Errorf("container 100 rootfs = %q, want it moved to storage local", config["rootfs"])
+	}
+
+	// Failed migrations are reported per container
+	fake.FailTasks("vzmigrate", "migration aborted")
+	if status := doJSON(t, router, "POST", "/api/nodes/pve2/drain", proxmox.DrainNodeRequest{Target: "pve"}, nil); status != http.StatusAccepted {
+		t.Fatalf("DrainNode pve2 status = %d, want %d", status, http.StatusAccepted)
+	}
+	drain = waitForDrain(t, router, "pve2")
+	if drain.Status != proxmox.DrainFailed || len(drain.Containers) != 4 {
+		t.Fatalf("drain = %+v, want 4 failed containers", drain)
+	}
+	for _, item := range drain.Containers {
+		if item.Status != proxmox.DrainFailed || item.Error != "migration aborted" {
+			t.Errorf("drain item = %+v, want failed with the task error", item)
+		}
+	}
+}
+
+// waitForDrain polls the drain of a node until it has finished
+func waitForDrain(t *testing.T, router http.Handler, node string) proxmox.NodeDrain {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var drain proxmox.NodeDrain
+		if status := doJSON(t, router, "GET", "/api/nodes/"+node+"/drain", nil, &drain); status != http.StatusOK {
+			t.Fatalf("GetNodeDrain status = %d, want %d", status, http.StatusOK)
+		}
+		if drain.Status != proxmox.DrainRunning {
+			return drain
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("drain of %s still running: %+v", node, drain)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestVMLifecycle(t *testing.T) {
+	router, fake, _ := newTestRouter(t)
+	base := fake.AddVolume("local-lvm", "base-9000-disk-0", 8, 9000)
+	fake.AddVM(proxmox.VM{VMID: 9000, Name: "ubuntu-cloud", Template: true}, map[string]string{
+		"cores": "1",
+		"scsi0": base + ",size=8G",
+		"ide2":  "local-lvm:cloudinit,media=cdrom",
+	})
+
+	if status := doJSON(t, router, "POST", "/api/vms", proxmox.CreateVMRequest{Name: "app01"}, nil); status != http.StatusBadRequest {
+		t.Errorf("CreateVM without template status = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	var created struct {
+		VMID   int    `json:"vmid"`
+		TaskID string `json:"task_id"`
+	}
+	status := doJSON(t, router, "POST", "/api/vms", proxmox.CreateVMRequest{
+		Name:     "app01",
+		Template: 9000,
+		Start:    true,
+		VMConfigRequest: proxmox.VMConfigRequest{
+			Cores:     2,
+			CIUser:    "ubuntu",
+			SSHKeys:   "ssh-ed25519 AAAA+key user@host",
+			IPConfig0: "ip=10.0.0.5/24,gw=10.0.0.1",
+		},
+	}, &created)
+	if status != http.StatusCreated {
+		t.Fatalf("CreateVM status = %d, want %d", status, http.StatusCreated)
+	}
+	if created.TaskID == "" {
+		t.Errorf("CreateVM returned no task_id")
+	}
+
+	vm, config, ok := fake.VM(created.VMID)
+	if !ok {
+		t.Fatalf("VM %d was not created", created.VMID)
+	}
+	if vm.Status != "running" || vm.Template {
+		t.Errorf("VM %d = %+v, want a running non-template VM", created.VMID, vm)
+	}
+	if config["cores"] != "2" || config["ciuser"] != "ubuntu" {
+		t.Errorf("VM config = %v, want cores 2 and ciuser ubuntu", config)
+	}
+	if want := "ssh-ed25519%20AAAA%2Bkey%20user%40host"; config["sshkeys"] != want {
+		t.Errorf("VM sshkeys = %q, want %q", config["sshkeys"], want)
+	}
+
+	var vms []proxmox.VM
+	if status := doJSON(t, router, "GET", "/api/vms", nil, &vms); status != http.StatusOK {
+		t.Fatalf("ListVMs status = %d, want %d", status, http.StatusOK)
+	}
+	if len(vms) != 2 || vms[0].VMID != created.VMID || vms[0].IPAddress != "10.0.0.5/24" || !vms[1].Template {
+		t.Errorf("ListVMs = %+v, want the new VM with its IP and the template", vms)
+	}
+
+	// The cloned disk shows up as a volume attached to the VM; the cloud-init drive does not
+	var volumes []proxmox.Volume
+	if status := doJSON(t, router, "GET", "/api/volumes", nil, &volumes); status != http.StatusOK {
+		t.Fatalf("ListVolumes status = %d, want %d", status, http.StatusOK)
+	}
+	attached := 0
+	for _, vol := range volumes {
+		if vol.AttachedTo != nil && *vol.AttachedTo == created.VMID {
+			attached++
+			if vol.MountPoint != "scsi0" {
+				t.Errorf("volume %s mountpoint = %q, want scsi0", vol.VolID, vol.MountPoint)
+			}
+		}
+	}
+	if attached != 1 {
+		t.Errorf("found %d volumes attached to VM %d, want 1", attached, created.VMID)
+	}
+
+	path := fmt.Sprintf("/api/vms/%d", created.VMID)
+	if status := doJSON(t, router, "DELETE", path, nil, nil); status != http.StatusConflict {
+		t.Errorf("DeleteVM while running status = %d, want %d", status, http.StatusConflict)
+	}
+	if status := doJSON(t, router, "POST", path+"/stop", nil, nil); status != http.StatusOK {
+		t.Fatalf("StopVM status = %d, want %d", status, http.StatusOK)
+	}
+	if status := doJSON(t, router, "DELETE", path, nil, nil); status != http.StatusOK {
+		t.Fatalf("DeleteVM status = %d, want %d", status, http.StatusOK)
+	}
+	if status := doJSON(t, router, "GET", path, nil, nil); status != http.StatusNotFound {
+		t.Errorf("GetVM after delete status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	router, fake, store := newTestRouter(t)
+	fake.AddContainer(proxmox.Container{VMID: 100, Name: "db01"}, map[string]string{"rootfs": "local-lvm:vm-100-disk-0,size=8G", "hostname": "db01"})
+	fake.AddContainer(proxmox.Container{VMID: 101, Name: "db02"}, map[string]string{"rootfs": "local-lvm:vm-101-disk-0,size=8G", "hostname": "db02"})
+
+	project, err := store.CreateProject(proxmox.CreateProjectRequest{Name: "databases", BackupStorage: "local"})
+	if err != nil {
+		t.Fatalf("CreateProject() error = %v", err)
+	}
+	for _, vmid := range []int{100, 101} {
+		if err := store.AssignContainer(vmid, project.ID); err != nil {
+			t.Fatalf("AssignContainer() error = %v", err)
+		}
+	}
+
+	invalid := scheduler.CreatePolicyRequest{Name: "bad", Schedule: "0 25 * * *", Action: scheduler.ActionSnapshot, Target: scheduler.Target{Type: scheduler.TargetContainer, VMID: 100}}
+	if status := doJSON(t, router, "POST", "/api/policies", invalid, nil); status != http.StatusBadRequest {
+		t.Errorf("CreatePolicy with invalid schedule status = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	var policy scheduler.Policy
+	status := doJSON(t, router, "POST", "/api/policies", scheduler.CreatePolicyRequest{
+		Name:      "nightly",
+		Schedule:  "0 2 * * *",
+		Action:    scheduler.ActionBackup,
+		Target:    scheduler.Target{Type: scheduler.TargetProject, ProjectID: project.ID},
+		Retention: scheduler.Retention{KeepLast: 1},
+	}, &policy)
+	if status != http.StatusCreated {
+		t.Fatalf("CreatePolicy status = %d, want %d", status, http.StatusCreated)
+	}
+	if !policy.Enabled || policy.NextRunAt <= time.Now().Unix() {
+		t.Errorf("policy = %+v, want enabled with a future next run", policy)
+	}
+
+	// The second run prunes the backups of the first
+	path := "/api/policies/" + policy.ID
+	var run scheduler.PolicyRun
+	for i := 0; i < 2; i++ {
+		if status := doJSON(t, router, "POST", path+"/run?wait=true", nil, &run); status != http.StatusOK {
+			t.Fatalf("RunPolicy status = %d, want %d", status, http.StatusOK)
+		}
+		if run.Status != scheduler.RunSucceeded || len(run.Items) != 2 {
+			t.Fatalf("run = %+v, want succeeded with two items", run)
+		}
+	}
+	for _, item := range run.Items {
+		if item.Created == "" || len(item.Pruned) != 1 {
+			t.Errorf("run item = %+v, want one backup created and one pruned", item)
+		}
+	}
+	var backups []proxmox.Backup
+	doJSON(t, router, "GET", "/api/backups?storage=local", nil, &backups)
+	if len(backups) != 2 {
+		t.Errorf("backups after pruning = %+v, want one per container", backups)
+	}
+
+	var runs []scheduler.PolicyRun
+	if status := doJSON(t, router, "GET", path+"/runs", nil, &runs); status != http.StatusOK {
+		t.Fatalf("ListPolicyRuns status = %d, want %d", status, http.StatusOK)
+	}
+	if len(runs) != 2 || runs[0].ID != run.ID || runs[0].Trigger != scheduler.TriggerManual {
+		t.Errorf("runs = %+v, want two manual runs, newest first", runs)
+	}
+
+	// A snapshot policy on one container; failures are recorded in the run
+	fake.FailTasks("vzsnapshot", "snapshot feature is not available")
+	var snapPolicy scheduler.Policy
+	doJSON(t, router, "POST", "/api/policies", scheduler.CreatePolicyRequest{
+		Name:     "hourly",
+		Schedule: "@hourly",
+		Action:   scheduler.ActionSnapshot,
+		Target:   scheduler.Target{Type: scheduler.TargetContainer, VMID: 100},
+	}, &snapPolicy)
+	doJSON(t, router, "POST", "/api/policies/"+snapPolicy.ID+"/run?wait=true", nil, &run)
+	if run.Status != scheduler.RunFailed || len(run.Items) != 1 || run.Items[0].Error == "" {
+		t.Errorf("run with failing snapshot task = %+v, want failed with an item error", run)
+	}
+
+	disabled := false
+	var updated scheduler.Policy
+	if status := doJSON(t, router, "PUT", path, scheduler.UpdatePolicyRequest{Enabled: &disabled}, &updated); status != http.StatusOK {
+		t.Fatalf("UpdatePolicy status = %d, want %d", status, http.StatusOK)
+	}
+	if updated.Enabled || updated.NextRunAt != 0 {
+		t.Errorf("disabled policy = %+v, want no next run", updated)
+	}
+
+	if status := doJSON(t, router, "DELETE", path, nil, nil); status != http.StatusOK {
+		t.Fatalf("DeletePolicy status = %d, want %d", status, http.StatusOK)
+	}
+	if status := doJSON(t, router, "GET", path+"/runs", nil, nil); status != http.StatusNotFound {
+		t.Errorf("ListPolicyRuns after delete status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestVolumeRegistry(t *testing.T) {
+	router, fake, _ := newTestRouter(t)
+	fake.AddContainer(proxmox.Container{VMID: 300, Name: "app01"}, map[string]string{
+		"rootfs": "local-lvm:vm-300-disk-0,size=8G",
+	})
+
+	var created proxmox.Volume
+	req := proxmox.CreateVolumeRequest{
+		Name:        "vm-300-disk-1",
+		Size:        4,
+		Storage:     "local-lvm",
+		Description: "Postgres data",
+		Labels:      map[string]string{"app": "db"},
+	}
+	if status := doJSON(t, router, "POST", "/api/volumes", req, &created); status != http.StatusCreated {
+		t.Fatalf("CreateVolume status = %d, want %d", status, http.StatusCreated)
+	}
+
+	var volume proxmox.Volume
+	doJSON(t, router, "GET", "/api/volumes/"+created.VolID, nil, &volume)
+	if volume.Description != "Postgres data" || volume.Labels["app"] != "db" || volume.CreatedAt == 0 {
+		t.Errorf("GetVolume = %+v, want the description, labels and creation time it was created with", volume)
+	}
+
+	project := "team-a"
+	update := proxmox.UpdateVolumeRequest{ProjectID: &project, Labels: map[string]string{"app": "db", "tier": "gold"}}
+	if status := doJSON(t, router, "PATCH", "/api/volumes/"+created.VolID, update, &volume); status != http.StatusOK {
+		t.Fatalf("UpdateVolume status = %d, want %d", status, http.StatusOK)
+	}
+	if volume.ProjectID != "team-a" || volume.Labels["tier"] != "gold" || volume.Description != "Postgres data" {
+		t.Errorf("UpdateVolume = %+v, want the project and labels changed and the description kept", volume)
+	}
+
+	if status := doJSON(t, router, "POST", "/api/volumes/"+created.VolID+"/attach/300?wait=true", proxmox.AttachVolumeRequest{Path: "/srv/db"}, nil); status != http.StatusOK {
+		t.Fatalf("AttachVolume status = %d, want %d", status, http.StatusOK)
+	}
+
+	// Moving the disk changes its volid; the registry follows it on the next list
+	if status := doJSON(t, router, "POST", "/api/volumes/"+created.VolID+"/move?wait=true", proxmox.MoveVolumeRequest{Storage: "local-zfs"}, nil); status != http.StatusOK {
+		t.Fatalf("MoveVolume status = %d, want %d", status, http.StatusOK)
+	}
+	var list []proxmox.Volume
+	doJSON(t, router, "GET", "/api/volumes", nil, &list)
+	var moved *proxmox.Volume
+	for i := range list {
+		if strings.HasPrefix(list[i].VolID, "local-zfs:") && list[i].AttachedTo != nil && *list[i].AttachedTo == 300 {
+			moved = &list[i]
+		}
+	}
+	if moved == nil {
+		t.Fatalf("ListVolumes = %+v, want the moved volume on local-zfs", list)
+	}
+	if moved.Description != "Postgres data" || moved.ProjectID != "team-a" {
+		t.Errorf("moved volume = %+v, want its metadata kept", *moved)
+	}
+
+	var events []volumes.Event
+	if status := doJSON(t, router, "GET", "/api/volumes/"+moved.VolID+"/history", nil, &events); status != http.StatusOK {
+		t.Fatalf("GetVolumeHistory status = %d, want %d", status, http.StatusOK)
+	}
+	kinds := make([]string, len(events))
+	for i, event := range events {
+		kinds[i] = event.Kind
+	}
+	want := []string{volumes.EventMoved, volumes.EventAttached, volumes.EventUpdated, volumes.EventCreated}
+	if strings.Join(kinds, ",") != strings.Join(want, ",") {
+		t.Errorf("history = %v, want %v", kinds, want)
+	}
+
+	// Volumes removed outside the API are marked missing but keep their history
+	spare := fake.AddVolume("local-lvm", "vm-0-disk-9", 2, 0)
+	doJSON(t, router, "GET", "/api/volumes", nil, &list)
+	if _, err := fake.Client().DeleteVolume(context.Background(), spare); err != nil {
+		t.Fatalf("DeleteVolume() error = %v", err)
+	}
+	doJSON(t, router, "GET", "/api/volumes", nil, &list)
+	doJSON(t, router, "GET", "/api/volumes/"+spare+"/history", nil, &events)
+	if len(events) != 2 || events[0].Kind != volumes.EventMissing || events[1].Kind != volumes.EventDiscovered {
+		t.Errorf("history of removed volume = %+v, want missing after discovered", events)
+	}
+
+	if status := doJSON(t, router, "GET", "/api/volumes/local-lvm:vm-0-disk-404/history", nil, nil); status != http.StatusNotFound {
+		t.Errorf("GetVolumeHistory unknown status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestOrphanedVolumes(t *testing.T) {
+	router, fake, _ := newTestRouter(t)
+	old := fake.AddVolume("local-lvm", "vm-999-disk-0", 6, 999)
+	fresh := fake.AddVolume("local-zfs", "vm-998-disk-0", 2, 998)
+	fake.AgeVolume(old, 10*24*time.Hour)
+	detached := fake.AddVolume("local-lvm", "vm-300-disk-3", 4, 300)
+	borrowed := fake.AddVolume("local-lvm", "vm-997-disk-0", 4, 997)
+	standalone := fake.AddVolume("local-lvm", "vm-0-disk-1", 4, 0)
+	fake.AddContainer(proxmox.Container{VMID: 300, Name: "app01"}, map[string]string{
+		"rootfs": "local-lvm:vm-300-disk-0,size=8G",
+		"mp0":    borrowed + ",mp=/srv/data,size=4G",
+	})
+
+	var orphans []proxmox.OrphanedVolume
+	if status := doJSON(t, router, "GET", "/api/volumes/orphans", nil, &orphans); status != http.StatusOK {
+		t.Fatalf("ListOrphanedVolumes status = %d, want %d", status, http.StatusOK)
+	}
+	if len(orphans) != 2 || orphans[0].VolID != old || orphans[1].VolID != fresh {
+		t.Fatalf("ListOrphanedVolumes = %+v, want %s and %s", orphans, old, fresh)
+	}
+	if orphans[0].VMID != 999 || orphans[0].Size != 6 || orphans[0].Age < 10*24*60*60 {
+		t.Errorf("orphan %s = %+v, want owner 999, 6GB and 10 days old", old, orphans[0])
+	}
+
+	// Without confirm nothing is deleted
+	var resp proxmox.OrphanCleanupResponse
+	if status := doJSON(t, router, "POST", "/api/volumes/orphans/cleanup", nil, &resp); status != http.StatusOK {
+		t.Fatalf("CleanupOrphanedVolumes dry run status = %d, want %d", status, http.StatusOK)
+	}
+	if !resp.DryRun || len(resp.Volumes) != 2 || resp.Reclaimed != 8 || resp.Volumes[0].Status != "would_delete" {
+		t.Errorf("dry run = %+v, want both orphans, 8GB reclaimable", resp)
+	}
+	if !fake.HasVolume(old) || !fake.HasVolume(fresh) {
+		t.Fatal("dry run deleted volumes")
+	}
+
+	// A confirmed cleanup only deletes the volumes it names
+	req := proxmox.OrphanCleanupRequest{MinAge: 7, Confirm: true}
+	if status := doJSON(t, router, "POST", "/api/volumes/orphans/cleanup", req, nil); status != http.StatusBadRequest {
+		t.Errorf("CleanupOrphanedVolumes without volids status = %d, want %d", status, http.StatusBadRequest)
+	}
+	if !fake.HasVolume(old) || !fake.HasVolume(fresh) {
+		t.Fatal("cleanup without volids deleted volumes")
+	}
+
+	req.VolIDs = []string{old, fresh}
+	if status := doJSON(t, router, "POST", "/api/volumes/orphans/cleanup", req, &resp); status != http.StatusOK {
+		t.Fatalf("CleanupOrphanedVolumes status = %d, want %d", status, http.StatusOK)
+	}
+	if resp.DryRun || resp.Reclaimed != 6 || resp.Volumes[0].Status != "deleted" || resp.Volumes[1].Status != "skipped" {
+		t.Errorf("cleanup = %+v, want only the old orphan deleted", resp)
+	}
+	if fake.HasVolume(old) || !fake.HasVolume(fresh) {
+		t.Errorf("after cleanup old exists = %v, fresh exists = %v; want only fresh left", fake.HasVolume(old), fake.HasVolume(fresh))
+	}
+
+	// Volumes that are not orphaned are never deleted, even when named
+	req = proxmox.OrphanCleanupRequest{VolIDs: []string{detached, borrowed, standalone}, Confirm: true}
+	doJSON(t, router, "POST", "/api/volumes/orphans/cleanup", req, &resp)
+	for _, result := range resp.Volumes {
+		if result.Status != "skipped" {
+			t.Errorf("cleanup of %s status = %q, want skipped", result.VolID, result.Status)
+		}
+	}
+	if !fake.HasVolume(detached) || !fake.HasVolume(borrowed) || !fake.HasVolume(standalone) {
+		t.Error("cleanup deleted a volume that is not orphaned")
+	}
+
+	// Listing guests node by node can miss some, so the scan refuses to guess
+	fake.FailRequests(1, http.StatusForbidden, "Permission check failed")
+	if status := doJSON(t, router, "GET", "/api/volumes/orphans", nil, nil); status != http.StatusInternalServerError {
+		t.Errorf("ListOrphanedVolumes without cluster resources status = %d, want %d", status, http.StatusInternalServerError)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	var authStore *auth.Store
+	router, _, _ := newTestRouter(t, func(h *Handler) { authStore = h.auth })
+	handler := middleware.Auth(authStore, "/api/health", "/api/auth/login")(router)
+
+	if _, err := authStore.CreateUser("admin", "admin-password", true); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	// Only the health check and login are open
+	if status := doJSON(t, handler, "GET", "/api/health", nil, nil); status != http.StatusOK {
+		t.Fatalf("health status = %d, want 200", status)
+	}
+	if status := doJSON(t, handler, "GET", "/api/containers", nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated status = %d, want 401", status)
+	}
+	if status := doJSONAs(t, handler, "not-a-session", "GET", "/api/containers", nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("bad token status = %d, want 401", status)
+	}
+
+	if status := doJSON(t, handler, "POST", "/api/auth/login", LoginRequest{Username: "admin", Password: "wrong-password"}, nil); status != http.StatusUnauthorized {
+		t.Fatalf("wrong password status = %d, want 401", status)
+	}
+	var login struct {
+		Token string    `json:"token"`
+		User  auth.User `json:"user"`
+	}
+	if status := doJSON(t, handler, "POST", "/api/auth/login", LoginRequest{Username: "admin", Password: "admin-password"}, &login); status != http.StatusOK {
+		t.Fatalf("login status = %d, want 200", status)
+	}
+	if login.Token == "" || !login.User.Admin {
+		t.Fatalf("login = %+v, want a token for an admin", login)
+	}
+	adminToken := login.Token
+
+	var me auth.User
+	if status := doJSONAs(t, handler, adminToken, "GET", "/api/auth/me", nil, &me); status != http.StatusOK || me.Username != "admin" {
+		t.Fatalf("me = %d %+v, want admin", status, me)
+	}
+	if status := doJSONAs(t, handler, adminToken, "GET", "/api/containers", nil, nil); status != http.StatusOK {
+		t.Fatalf("authenticated status = %d, want 200", status)
+	}
+
+	// The session cookie works as well as the bearer token
+	req := httptest.NewRequest("GET", "/api/auth/me", nil)
+	req.AddCookie(&http.Cookie{Name: middleware.SessionCookie, Value: adminToken})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("cookie status = %d, want 200", rec.Code)
+	}
+
+	// Users are managed by admins only
+	if status := doJSONAs(t, handler, adminToken, "POST", "/api/auth/users", CreateUserRequest{Username: "dev", Password: "short"}, nil); status != http.StatusBadRequest {
+		t.Fatalf("short password status = %d, want 400", status)
+	}
+	if status := doJSONAs(t, handler, adminToken, "POST", "/api/auth/users", CreateUserRequest{Username: "dev", Password: "dev-password"}, nil); status != http.StatusCreated {
+		t.Fatalf("create user status = %d, want 201", status)
+	}
+	if status := doJSONAs(t, handler, adminToken, "POST", "/api/auth/users", CreateUserRequest{Username: "dev", Password: "dev-password"}, nil); status != http.StatusConflict {
+		t.Fatalf("duplicate user status = %d, want 409", status)
+	}
+	if status := doJSON(t, handler, "POST", "/api/auth/login", LoginRequest{Username: "dev", Password: "dev-password"}, &login); status != http.StatusOK {
+		t.Fatalf("dev login status = %d, want 200", status)
+	}
+	devToken := login.Token
+	if status := doJSONAs(t, handler, devToken, "GET", "/api/auth/users", nil, nil); status != http.StatusForbidden {
+		t.Fatalf("non-admin list users status = %d, want 403", status)
+	}
+	if status := doJSONAs(t, handler, adminToken, "DELETE", "/api/auth/users/admin", nil, nil); status != http.StatusBadRequest {
+		t.Fatalf("delete self status = %d, want 400", status)
+	}
+
+	// API keys authenticate as their owner until revoked, and are stored hashed
+	var created struct {
+		Key    string      `json:"key"`
+		APIKey auth.APIKey `json:"api_key"`
+	}
+	if status := doJSONAs(t, handler, devToken, "POST", "/api/auth/keys", CreateAPIKeyRequest{Name: "ci", ExpiresInDays: 30}, &created); status != http.StatusCreated {
+		t.Fatalf("create key status = %d, want 201", status)
+	}
+	if !strings.HasPrefix(created.Key, auth.APIKeyPrefix) || created.APIKey.ExpiresAt == 0 {
+		t.Fatalf("created key = %+v, want a %s key with an expiry", created, auth.APIKeyPrefix)
+	}
+	var keys []auth.APIKey
+	if status := doJSONAs(t, handler, created.Key, "GET", "/api/auth/keys", nil, &keys); status != http.StatusOK || len(keys) != 1 {
+		t.Fatalf("list keys = %d %+v, want the one key", status, keys)
+	}
+	if keys[0].Prefix == created.Key {
+		t.Fatalf("key listing exposes the full key")
+	}
+
+	req = httptest.NewRequest("GET", "/api/auth/me", nil)
+	req.Header.Set("X-API-Key", created.Key)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if err := json.Unmarshal(rec.Body.Bytes(), &me); err != nil || rec.Code != http.StatusOK || me.Username != "dev" {
+		t.Fatalf("X-API-Key me = %d %s, want dev", rec.Code, rec.Body.String())
+	}
+
+	if status := doJSONAs(t, handler, adminToken, "DELETE", "/api/auth/keys/"+created.APIKey.ID, nil, nil); status != http.StatusNotFound {
+		t.Fatalf("revoke another user's key status = %d, want 404", status)
+	}
+	if status := doJSONAs(t, handler, devToken, "DELETE", "/api/auth/keys/"+created.APIKey.ID, nil, nil); status != http.StatusOK {
+		t.Fatalf("revoke key status = %d, want 200", status)
+	}
+	if status := doJSONAs(t, handler, created.Key, "GET", "/api/auth/me", nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("revoked key status = %d, want 401", status)
+	}
+
+	// Deleting a user ends their sessions
+	if status := doJSONAs(t, handler, adminToken, "DELETE", "/api/auth/users/dev", nil, nil); status != http.StatusOK {
+		t.Fatalf("delete user status = %d, want 200", status)
+	}
+	if status := doJSONAs(t, handler, devToken, "GET", "/api/auth/me", nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("deleted user's session status = %d, want 401", status)
+	}
+
+	// Logging out ends the session
+	if status := doJSONAs(t, handler, adminToken, "POST", "/api/auth/logout", nil, nil); status != http.StatusOK {
+		t.Fatalf("logout status = %d, want 200", status)
+	}
+	if status := doJSONAs(t, handler, adminToken, "GET", "/api/auth/me", nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("logged out session status = %d, want 401", status)
+	}
+}
+
+func TestProjectAccess(t *testing.T) {
+	var h *Handler
+	router, fake, store := newTestRouter(t, func(handler *Handler) { h = handler })
+	handler := middleware.Auth(h.auth, "/api/health", "/api/auth/login")(router)
+
+	login := func(username string, admin bool) string {
+		t.Helper()
+		if _, err := h.auth.CreateUser(username, username+"-password", admin); err != nil {
+			t.Fatalf("CreateUser(%s) error = %v", username, err)
+		}
+		token, _, err := h.auth.CreateSession(username, time.Hour)
+		if err != nil {
+			t.Fatalf("CreateSession(%s) error = %v", username, err)
+		}
+		return token
+	}
+	admin := login("admin", true)
+	dev := login("dev", false)
+
+	var teamA, teamB proxmox.Project
+	if status := doJSONAs(t, handler, admin, "POST", "/api/projects", proxmox.CreateProjectRequest{Name: "team-a"}, &teamA); status != http.StatusCreated {
+		t.Fatalf("create team-a status = %d, want 201", status)
+	}
+	if status := doJSONAs(t, handler, admin, "POST", "/api/projects", proxmox.CreateProjectRequest{Name: "team-b"}, &teamB); status != http.StatusCreated {
+		t.Fatalf("create team-b status = %d, want 201", status)
+	}
+
+	fake.AddContainer(proxmox.Container{VMID: 200, Name: "a-web", Status: "stopped"}, map[string]string{"rootfs": "local-lvm:vm-200-disk-0,size=8G"})
+	fake.AddContainer(proxmox.Container{VMID: 300, Name: "b-web", Status: "stopped"}, map[string]string{"rootfs": "local-lvm:vm-300-disk-0,size=8G"})
+	fake.AddContainer(proxmox.Container{VMID: 400, Name: "unassigned", Status: "stopped"}, map[string]string{"rootfs": "local-lvm:vm-400-disk-0,size=8G"})
+	volumeA := fake.AddVolume("local-zfs", "vm-200-disk-1", 4, 200)
+	volumeB := fake.AddVolume("local-zfs", "vm-300-disk-1", 4, 300)
+	if err := store.AssignContainer(200, teamA.ID); err != nil {
+		t.Fatalf("AssignContainer() error = %v", err)
+	}
+	if err := store.AssignContainer(300, teamB.ID); err != nil {
+		t.Fatalf("AssignContainer() error = %v", err)
+	}
+
+	// Non-members see nothing and cannot grant themselves roles
+	var containers []proxmox.Container
+	if status := doJSONAs(t, handler, dev, "GET", "/api/containers", nil, &containers); status != http.StatusOK || len(containers) != 0 {
+		t.Fatalf("non-member containers = %d %+v, want none", status, containers)
+	}
+	if status := doJSONAs(t, handler, dev, "PUT", "/api/projects/"+teamA.ID+"/members/dev", map[string]string{"role": "admin"}, nil); status != http.StatusForbidden {
+		t.Fatalf("self-grant status = %d, want 403", status)
+	}
+
+	if status := doJSONAs(t, handler, admin, "PUT", "/api/projects/"+teamA.ID+"/members/dev", map[string]string{"role": "owner"}, nil); status != http.StatusBadRequest {
+		t.Fatalf("invalid role status = %d, want 400", status)
+	}
+	if status := doJSONAs(t, handler, admin, "PUT", "/api/projects/"+teamA.ID+"/members/nobody", map[string]string{"role": "viewer"}, nil); status != http.StatusNotFound {
+		t.Fatalf("unknown user status = %d, want 404", status)
+	}
+	if status := doJSONAs(t, handler, admin, "PUT", "/api/projects/"+teamA.ID+"/members/dev", map[string]string{"role": "operator"}, nil); status != http.StatusOK {
+		t.Fatalf("grant operator status = %d, want 200", status)
+	}
+
+	// Listings only show team-a's resources
+	if status := doJSONAs(t, handler, dev, "GET", "/api/containers", nil, &containers); status != http.StatusOK || len(containers) != 1 || containers[0].VMID != 200 {
+		t.Fatalf("operator containers = %d %+v, want only 200", status, containers)
+	}
+	var projects []proxmox.Project
+	if status := doJSONAs(t, handler, dev, "GET", "/api/projects", nil, &projects); status != http.StatusOK || len(projects) != 1 || projects[0].ID != teamA.ID {
+		t.Fatalf("operator projects = %d %+v, want only team-a", status, projects)
+	}
+	var volumes []proxmox.Volume
+	if status := doJSONAs(t, handler, dev, "GET", "/api/volumes", nil, &volumes); status != http.StatusOK {
+		t.Fatalf("operator volumes status = %d, want 200", status)
+	}
+	for _, volume := range volumes {
+		if volume.VolID != volumeA && volume.VolID != "local-lvm:vm-200-disk-0" {
+			t.Errorf("operator sees volume %s outside team-a", volume.VolID)
+		}
+	}
+	if status := doJSONAs(t, handler, admin, "GET", "/api/containers", nil, &containers); status != http.StatusOK || len(containers) != 3 {
+		t.Fatalf("admin containers = %d %+v, want all 3", status, containers)
+	}
+	var dashboard struct {
+		TotalContainers int `json:"total_containers"`
+	}
+	if status := doJSONAs(t, handler, dev, "GET", "/api/dashboard", nil, &dashboard); status != http.StatusOK || dashboard.TotalContainers != 1 {
+		t.Fatalf("operator dashboard = %d %+v, want only 200 counted", status, dashboard)
+	}
+	if status := doJSONAs(t, handler, admin, "GET", "/api/dashboard", nil, &dashboard); status != http.StatusOK || dashboard.TotalContainers != 3 {
+		t.Fatalf("admin dashboard = %d %+v, want all 3 counted", status, dashboard)
+	}
+
+	var me struct {
+		Username string            `json:"username"`
+		Projects map[string]string `json:"projects"`
+	}
+	if status := doJSONAs(t, handler, dev, "GET", "/api/auth/me", nil, &me); status != http.StatusOK || me.Projects[teamA.ID] != "operator" {
+		t.Fatalf("me = %d %+v, want operator in team-a", status, me)
+	}
+
+	// Operators may start and stop their project's containers but not delete
+	// volumes, reconfigure the project or touch other projects
+	checks := []struct {
+		method, path string
+		body         interface{}
+		want         int
+	}{
+		{"POST", "/api/containers/200/start", nil, http.StatusOK},
+		{"POST", "/api/containers/200/stop", nil, http.StatusOK},
+		{"GET", "/api/volumes/" + volumeA, nil, http.StatusOK},
+		{"POST", "/api/containers/300/start", nil, http.StatusForbidden},
+		{"POST", "/api/containers/400/start", nil, http.StatusForbidden},
+		{"DELETE", "/api/containers/200", nil, http.StatusForbidden},
+		{"DELETE", "/api/volumes/" + volumeA, nil, http.StatusForbidden},
+		{"GET", "/api/volumes/" + volumeB, nil, http.StatusForbidden},
+		{"PUT", "/api/projects/" + teamA.ID, proxmox.UpdateProjectRequest{Description: "mine"}, http.StatusForbidden},
+		{"PUT", "/api/projects/" + teamB.ID, proxmox.UpdateProjectRequest{Network: &proxmox.ProjectNetwork{Subnet: "10.9.0.0/24"}}, http.StatusForbidden},
+		{"POST", "/api/projects", proxmox.CreateProjectRequest{Name: "team-c"}, http.StatusForbidden},
+		{"GET", "/api/volumes/orphans", nil, http.StatusForbidden},
+		{"GET", "/api/analytics/stats", nil, http.StatusForbidden},
+	}
+	for _, c := range checks {
+		if status := doJSONAs(t, handler, dev, c.method, c.path, c.body, nil); status != c.want {
+			t.Errorf("operator %s %s status = %d, want %d", c.method, c.path, status, c.want)
+		}
+	}
+
+	// Policies are visible to those who may see what they target
+	var policyA, policyB scheduler.Policy
+	for _, p := range []struct {
+		into   *scheduler.Policy
+		target scheduler.Target
+	}{
+		{&policyA, scheduler.Target{Type: scheduler.TargetContainer, VMID: 200}},
+		{&policyB, scheduler.Target{Type: scheduler.TargetProject, ProjectID: teamB.ID}},
+	} {
+		req := scheduler.CreatePolicyRequest{Name: "nightly-" + p.target.Type, Schedule: "0 2 * * *", Action: scheduler.ActionSnapshot, Target: p.target}
+		if status := doJSONAs(t, handler, admin, "POST", "/api/policies", req, p.into); status != http.StatusCreated {
+			t.Fatalf("create policy status = %d, want 201", status)
+		}
+	}
+	var policies []scheduler.Policy
+	if status := doJSONAs(t, handler, dev, "GET", "/api/policies", nil, &policies); status != http.StatusOK || len(policies) != 1 || policies[0].ID != policyA.ID {
+		t.Errorf("operator policies = %d %+v, want only %s", status, policies, policyA.ID)
+	}
+	policyChecks := []struct {
+		path string
+		want int
+	}{
+		{"/api/policies/" + policyA.ID, http.StatusOK},
+		{"/api/policies/" + policyA.ID + "/runs", http.StatusOK},
+		{"/api/policies/" + policyB.ID, http.StatusForbidden},
+		{"/api/policies/" + policyB.ID + "/runs", http.StatusForbidden},
+		{"/api/policies/missing", http.StatusNotFound},
+	}
+	for _, c := range policyChecks {
+		if status := doJSONAs(t, handler, dev, "GET", c.path, nil, nil); status != c.want {
+			t.Errorf("operator GET %s status = %d, want %d", c.path, status, c.want)
+		}
+	}
+
+	// Tasks are visible to those who may see the guest they are for
+	var started, other struct {
+		TaskID string `json:"task_id"`
+	}
+	doJSONAs(t, handler, dev, "POST", "/api/containers/200/start", nil, &started)
+	doJSONAs(t, handler, admin, "POST", "/api/containers/300/start", nil, &other)
+	tasks := []struct {
+		upid string
+		want int
+	}{
+		{started.TaskID, http.StatusOK},
+		{other.TaskID, http.StatusForbidden},
+		{"UPID:pve:00001234:00005678:65A1B2C3:aptupdate::root@pam:", http.StatusForbidden},
+	}
+	for _, task := range tasks {
+		if status := doJSONAs(t, handler, dev, "GET", "/api/tasks/"+task.upid, nil, nil); status != task.want {
+			t.Errorf("operator GET task %q status = %d, want %d", task.upid, status, task.want)
+		}
+	}
+
+	// Project admins may delete and reassign within their projects only
+	if status := doJSONAs(t, handler, admin, "PUT", "/api/projects/"+teamA.ID+"/members/dev", map[string]string{"role": "admin"}, nil); status != http.StatusOK {
+		t.Fatalf("grant admin status = %d, want 200", status)
+	}
+	if status := doJSONAs(t, handler, dev, "POST", "/api/containers/200/project", proxmox.AssignProjectRequest{ProjectID: teamB.ID}, nil); status != http.StatusForbidden {
+		t.Errorf("move to another project status = %d, want 403", status)
+	}
+	if status := doJSONAs(t, handler, dev, "POST", "/api/containers/200/project", proxmox.AssignProjectRequest{}, nil); status != http.StatusForbidden {
+		t.Errorf("remove from project status = %d, want 403", status)
+	}
+	if status := doJSONAs(t, handler, dev, "POST", "/api/volumes", proxmox.CreateVolumeRequest{Name: "scratch", Size: 1, Storage: "local-zfs"}, nil); status != http.StatusForbidden {
+		t.Errorf("create volume outside a project status = %d, want 403", status)
+	}
+	if status := doJSONAs(t, handler, dev, "DELETE", "/api/volumes/"+volumeA, nil, nil); status != http.StatusOK {
+		t.Errorf("project admin delete volume status = %d, want 200", status)
+	}
+	if status := doJSONAs(t, handler, dev, "PUT", "/api/projects/"+teamA.ID, proxmox.UpdateProjectRequest{Description: "mine"}, nil); status != http.StatusOK {
+		t.Errorf("project admin update project status = %d, want 200", status)
+	}
+	start, storage := 5000, "nfs-backups"
+	infrastructure := []proxmox.UpdateProjectRequest{
+		{Network: &proxmox.ProjectNetwork{Subnet: "10.9.0.0/24"}},
+		{ContainerIDStart: &start},
+		{ContainerIDEnd: &start},
+		{BackupStorage: &storage},
+	}
+	for _, req := range infrastructure {
+		if status := doJSONAs(t, handler, dev, "PUT", "/api/projects/"+teamA.ID, req, nil); status != http.StatusForbidden {
+			t.Errorf("project admin update %+v status = %d, want 403", req, status)
+		}
+	}
+
+	// Removing the membership revokes access
+	if status := doJSONAs(t, handler, dev, "DELETE", "/api/projects/"+teamA.ID+"/members/dev", nil, nil); status != http.StatusOK {
+		t.Fatalf("remove member status = %d, want 200", status)
+	}
+	if status := doJSONAs(t, handler, dev, "GET", "/api/containers/200", nil, nil); status != http.StatusForbidden {
+		t.Errorf("removed member status = %d, want 403", status)
+	}
+}
+
+func TestSingleSignOn(t *testing.T) {
+	var h *Handler
+	router, _, store := newTestRouter(t, func(handler *Handler) { h = handler })
+	api := httptest.NewServer(middleware.Auth(h.Authenticator(), PublicPaths...)(router))
+	t.Cleanup(api.Close)
+
+	idp := oidctest.NewServer("proxicloud", "client-secret")
+	t.Cleanup(idp.Close)
+
+	web, err := store.CreateProject(proxmox.CreateProjectRequest{Name: "web"})
+	if err != nil {
+		t.Fatalf("CreateProject() error = %v", err)
+	}
+	h.SetOIDC(OIDCSettings{
+		Provider: oidc.NewProvider(oidc.Config{
+			Issuer:       idp.Issuer(),
+			ClientID:     "proxicloud",
+			ClientSecret: "client-secret",
+			RedirectURL:  api.URL + "/api/auth/oidc/callback",
+		}),
+		FrontendURL: "http://frontend.test",
+		AdminGroups: []string{"proxicloud-admins"},
+		ProjectRoles: []OIDCProjectRole{
+			{Group: "web-viewers", Project: "web", Role: auth.RoleViewer},
+			{Group: "web-ops", Project: web.ID, Role: auth.RoleOperator},
+		},
+	})
+
+	// ssoLogin runs the browser side of the flow and returns the fragment the
+	// frontend login page receives
+	ssoLogin := func(next string) url.Values {
+		t.Helper()
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatalf("cookiejar.New() error = %v", err)
+		}
+		browser := &http.Client{
+			Jar: jar,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if req.URL.Host == "frontend.test" {
+					return http.ErrUseLastResponse
+				}
+				return nil
+			},
+		}
+		resp, err := browser.Get(api.URL + "/api/auth/oidc/login?next=" + url.QueryEscape(next))
+		if err != nil {
+			t.Fatalf("login error = %v", err)
+		}
+		resp.Body.Close()
+		location, err := url.Parse(resp.Header.Get("Location"))
+		if resp.StatusCode != http.StatusFound || err != nil || location.Host != "frontend.test" || location.Path != "/login" {
+			t.Fatalf("login ended with %d %q, want a redirect to the frontend", resp.StatusCode, resp.Header.Get("Location"))
+		}
+		fragment, err := url.ParseQuery(location.Fragment)
+		if err != nil {
+			t.Fatalf("invalid fragment %q: %v", location.Fragment, err)
+		}
+		return fragment
+	}
+	// exchange trades the login page's one-time code for the session token
+	exchange := func(code string) string {
+		t.Helper()
+		var session struct {
+			Token string `json:"token"`
+		}
+		if status := doJSON(t, api.Config.Handler, "POST", "/api/auth/oidc/exchange", OIDCExchangeRequest{Code: code}, &session); status != http.StatusOK || session.Token == "" {
+			t.Fatalf("exchange = %d %+v, want a session token", status, session)
+		}
+		return session.Token
+	}
+	type me struct {
+		auth.User
+		Projects map[string]string `json:"projects"`
+	}
+	makeRefreshDue := func(token string) {
+		t.Helper()
+		refreshToken, _, err := h.auth.GetSessionRefresh(token)
+		if err != nil || refreshToken == "" {
+			t.Fatalf("GetSessionRefresh() = %q, %v, want a refresh token", refreshToken, err)
+		}
+		if err := h.auth.SetSessionRefresh(token, refreshToken, time.Now().Add(-time.Second)); err != nil {
+			t.Fatalf("SetSessionRefresh() error = %v", err)
+		}
+	}
+
+	var providers struct {
+		Password bool              `json:"password"`
+		OIDC     map[string]string `json:"oidc"`
+	}
+	if status := doJSON(t, api.Config.Handler, "GET", "/api/auth/providers", nil, &providers); status != http.StatusOK || !providers.Password || providers.OIDC["login_url"] == "" {
+		t.Fatalf("providers = %d %+v, want password and oidc", status, providers)
+	}
+
+	// Groups map to project roles; the highest role wins
+	idp.SetUser("alice", "web-viewers", "web-ops")
+	result := ssoLogin("/containers")
+	if result.Get("code") == "" || result.Get("token") != "" || result.Get("next") != "/containers" {
+		t.Fatalf("login result = %v, want a code without the token and next", result)
+	}
+	token := exchange(result.Get("code"))
+	if status := doJSON(t, api.Config.Handler, "POST", "/api/auth/oidc/exchange", OIDCExchangeRequest{Code: result.Get("code")}, nil); status != http.StatusUnauthorized {
+		t.Fatalf("second exchange status = %d, want 401", status)
+	}
+	var entries []audit.Entry
+	if status := doJSON(t, router, "GET", "/api/audit?action="+url.QueryEscape("POST /auth/oidc/exchange"), nil, &entries); status != http.StatusOK || len(entries) != 2 {
+		t.Fatalf("exchange audit entries = %d %+v, want 2", status, entries)
+	}
+	for _, entry := range entries {
+		if entry.Params["code"] != audit.Redacted {
+			t.Errorf("exchange params = %v, want the code redacted", entry.Params)
+		}
+	}
+	var alice me
+	if status := doJSONAs(t, api.Config.Handler, token, "GET", "/api/auth/me", nil, &alice); status != http.StatusOK {
+		t.Fatalf("me status = %d, want 200", status)
+	}
+	if !alice.External || alice.Admin || alice.Projects[web.ID] != "operator" {
+		t.Fatalf("me = %+v, want an external operator of web", alice)
+	}
+
+	// External users have no password
+	if status := doJSON(t, api.Config.Handler, "POST", "/api/auth/login", LoginRequest{Username: "alice", Password: ""}, nil); status != http.StatusUnauthorized {
+		t.Fatalf("password login status = %d, want 401", status)
+	}
+	if status := doJSONAs(t, api.Config.Handler, token, "PUT", "/api/auth/password", ChangePasswordRequest{NewPassword: "new-password"}, nil); status != http.StatusBadRequest {
+		t.Fatalf("change password status = %d, want 400", status)
+	}
+
+	// API keys would never check back with the identity provider
+	if status := doJSONAs(t, api.Config.Handler, token, "POST", "/api/auth/keys", CreateAPIKeyRequest{Name: "ci"}, nil); status != http.StatusForbidden {
+		t.Fatalf("create API key status = %d, want 403", status)
+	}
+	key, _, err := h.auth.CreateAPIKey("alice", "ci", 0)
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+	if status := doJSONAs(t, api.Config.Handler, key, "GET", "/api/auth/me", nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("me with an external user's API key status = %d, want 401", status)
+	}
+
+	// Refreshing picks up group changes at the identity provider
+	idp.SetUser("alice", "web-viewers", "proxicloud-admins")
+	makeRefreshDue(token)
+	alice = me{}
+	if status := doJSONAs(t, api.Config.Handler, token, "GET", "/api/auth/me", nil, &alice); status != http.StatusOK {
+		t.Fatalf("me after refresh status = %d, want 200", status)
+	}
+	if !alice.Admin || alice.Projects[web.ID] != "viewer" || idp.Refreshes() != 1 {
+		t.Fatalf("me after refresh = %+v with %d refreshes, want an admin viewer of web", alice, idp.Refreshes())
+	}
+	if status := doJSONAs(t, api.Config.Handler, token, "GET", "/api/auth/me", nil, nil); status != http.StatusOK || idp.Refreshes() != 1 {
+		t.Fatalf("me = %d with %d refreshes, want no refresh before it is due", status, idp.Refreshes())
+	}
+
+	// Concurrent requests with a due session refresh it once
+	makeRefreshDue(token)
+	var wg sync.WaitGroup
+	statuses := make([]int, 5)
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			statuses[i] = doJSONAs(t, api.Config.Handler, token, "GET", "/api/auth/me", nil, nil)
+		}(i)
+	}
+	wg.Wait()
+	for _, status := range statuses {
+		if status != http.StatusOK {
+			t.Fatalf("concurrent me statuses = %v, want all 200", statuses)
+		}
+	}
+	if idp.Refreshes() != 2 {
+		t.Fatalf("concurrent refreshes = %d, want 2 in total", idp.Refreshes())
+	}
+
+	// Sessions end when the identity provider no longer accepts them
+	idp.RevokeRefreshTokens()
+	makeRefreshDue(token)
+	if status := doJSONAs(t, api.Config.Handler, token, "GET", "/api/auth/me", nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("me after revocation status = %d, want 401", status)
+	}
+
+	// Leaving every mapped group removes the membership on the next login
+	idp.SetUser("alice")
+	token = exchange(ssoLogin("/").Get("code"))
+	alice = me{}
+	if status := doJSONAs(t, api.Config.Handler, token, "GET", "/api/auth/me", nil, &alice); status != http.StatusOK {
+		t.Fatalf("me status = %d, want 200", status)
+	}
+	if alice.Admin || len(alice.Projects) != 0 {
+		t.Fatalf("me = %+v, want no admin and no projects", alice)
+	}
+
+	// Accounts are matched on their subject: a renamed account keeps its
+	// user, and another account with the same username is refused
+	idp.SetUser("alice-renamed")
+	idp.SetSubject("user-alice")
+	token = exchange(ssoLogin("/").Get("code"))
+	alice = me{}
+	if status := doJSONAs(t, api.Config.Handler, token, "GET", "/api/auth/me", nil, &alice); status != http.StatusOK || alice.Username != "alice" {
+		t.Fatalf("me after rename = %d %+v, want alice", status, alice)
+	}
+	idp.SetUser("alice")
+	idp.SetSubject("user-mallory")
+	if result := ssoLogin("/"); result.Get("code") != "" || result.Get("error") == "" {
+		t.Fatalf("login as another account's username = %v, want an error", result)
+	}
+	makeRefreshDue(token)
+	if status := doJSONAs(t, api.Config.Handler, token, "GET", "/api/auth/me", nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("me after refresh for another account status = %d, want 401", status)
+	}
+
+	// Local users cannot be taken over through single sign-on
+	if _, err := h.auth.CreateUser("bob", "bob-password", false); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	idp.SetUser("bob", "proxicloud-admins")
+	if result := ssoLogin("/"); result.Get("code") != "" || result.Get("error") == "" {
+		t.Fatalf("login as local user = %v, want an error", result)
+	}
+
+	// Callbacks without a matching login are rejected
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/auth/oidc/callback?code=x&state=forged", nil))
+	if location := rec.Header().Get("Location"); rec.Code != http.StatusFound || !strings.Contains(location, "#error=") {
+		t.Fatalf("forged callback = %d %q, want an error redirect", rec.Code, location)
+	}
+
+	h.SetPasswordLogin(false)
+	if status := doJSON(t, api.Config.Handler, "POST", "/api/auth/login", LoginRequest{Username: "bob", Password: "bob-password"}, nil); status != http.StatusForbidden {
+		t.Fatalf("disabled password login status = %d, want 403", status)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	var h *Handler
+	router, fake, store := newTestRouter(t, func(handler *Handler) { h = handler })
+	handler := middleware.Auth(h.auth, PublicPaths...)(router)
+	template := fake.AddTemplate("local", "debian-12-standard_12.2-1_amd64.tar.zst")
+
+	team, err := store.CreateProject(proxmox.CreateProjectRequest{Name: "team"})
+	if err != nil {
+		t.Fatalf("CreateProject() error = %v", err)
+	}
+	var created struct {
+		VMID   int    `json:"vmid"`
+		TaskID string `json:"task_id"`
+	}
+	if status := doJSON(t, router, "POST", "/api/containers?wait=true", proxmox.CreateContainerRequest{
+		Hostname:   "web01",
+		Cores:      1,
+		Memory:     512,
+		Disk:       8,
+		OSTemplate: template,
+		Password:   "root-password",
+		ProjectID:  team.ID,
+	}, &created); status != http.StatusCreated {
+		t.Fatalf("CreateContainer status = %d, want 201", status)
+	}
+	if status := doJSON(t, router, "DELETE", fmt.Sprintf("/api/containers/%d", created.VMID), nil, nil); status != http.StatusOK {
+		t.Fatalf("DeleteContainer status = %d, want 200", status)
+	}
+
+	// A user who is not a member is denied and recorded
+	if _, err := h.auth.CreateUser("dev", "dev-password", false); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	token, _, err := h.auth.CreateSession("dev", time.Hour)
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	fake.AddContainer(proxmox.Container{VMID: 214, Name: "db", Status: "stopped"}, map[string]string{"rootfs": "local-lvm:vm-214-disk-0,size=8G"})
+	if status := doJSONAs(t, handler, token, "DELETE", "/api/containers/214", nil, nil); status != http.StatusForbidden {
+		t.Fatalf("DeleteContainer as dev status = %d, want 403", status)
+	}
+	// Reads are not recorded
+	if status := doJSON(t, router, "GET", "/api/containers", nil, nil); status != http.StatusOK {
+		t.Fatalf("ListContainers status = %d, want 200", status)
+	}
+
+	var entries []audit.Entry
+	if status := doJSON(t, router, "GET", "/api/audit", nil, &entries); status != http.StatusOK {
+		t.Fatalf("ListAudit status = %d, want 200", status)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("audit entries = %+v, want 3", entries)
+	}
+
+	create := entries[2]
+	if create.Actor != "test-admin" || create.Action != "POST /containers" || create.VMID != created.VMID ||
+		create.Project != team.ID || create.Result != audit.ResultSuccess || create.Status != http.StatusCreated {
+		t.Errorf("create entry = %+v, want a successful create of %d in %s by test-admin", create, created.VMID, team.ID)
+	}
+	if create.Params["password"] != audit.Redacted || create.Params["hostname"] != "web01" || create.Params["wait"] != "true" {
+		t.Errorf("create params = %v, want the password redacted", create.Params)
+	}
+	if len(create.UPIDs) != 1 || create.UPIDs[0] != created.TaskID {
+		t.Errorf("create UPIDs = %v, want [%s]", create.UPIDs, created.TaskID)
+	}
+
+	// The project is recorded even though deleting removed the assignment
+	remove := entries[1]
+	if remove.Action != "DELETE /containers/{vmid}" || remove.VMID != created.VMID || remove.Project != team.ID || len(remove.UPIDs) != 1 {
+		t.Errorf("delete entry = %+v, want a delete of %d in %s with a task", remove, created.VMID, team.ID)
+	}
+
+	denied := entries[0]
+	if denied.Actor != "dev" || denied.VMID != 214 || denied.Result != audit.ResultDenied || denied.Error == "" || denied.SourceIP == "" {
+		t.Errorf("denied entry = %+v, want a denied delete of 214 by dev", denied)
+	}
+
+	// Filters
+	if status := doJSON(t, router, "GET", "/api/audit?vmid=214", nil, &entries); status != http.StatusOK || len(entries) != 1 || entries[0].ID != denied.ID {
+		t.Errorf("vmid filter = %d %+v, want the denied entry", status, entries)
+	}
+	if status := doJSON(t, router, "GET", "/api/audit?actor=test-admin&result=success&limit=1", nil, &entries); status != http.StatusOK || len(entries) != 1 || entries[0].ID != remove.ID {
+		t.Errorf("actor filter = %d %+v, want the latest admin entry", status, entries)
+	}
+	if status := doJSON(t, router, "GET", "/api/audit?action="+url.QueryEscape("POST /containers")+"&project="+team.ID, nil, &entries); status != http.StatusOK || len(entries) != 1 || entries[0].ID != create.ID {
+		t.Errorf("action filter = %d %+v, want the create entry", status, entries)
+	}
+	if status := doJSON(t, router, "GET", "/api/audit?since=2999-01-01T00:00:00Z", nil, &entries); status != http.StatusOK || len(entries) != 0 {
+		t.Errorf("since filter = %d %+v, want no entries", status, entries)
+	}
+	if status := doJSON(t, router, "GET", "/api/audit?result=maybe", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("invalid result filter status = %d, want 400", status)
+	}
+
+	// Export as JSON lines, oldest first
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/audit?format=jsonl", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("export = %d %q, want JSON lines", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	var first audit.Entry
+	if len(lines) != 3 || json.Unmarshal([]byte(lines[0]), &first) != nil || first.ID != create.ID {
+		t.Fatalf("export = %q, want 3 lines starting with the create entry", rec.Body.String())
+	}
+
+	// Only admins may read the log
+	if status := doJSONAs(t, handler, token, "GET", "/api/audit", nil, nil); status != http.StatusForbidden {
+		t.Errorf("ListAudit as dev status = %d, want 403", status)
+	}
+}
@@ -0,0 +1,552 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/auth"
+	"github.com/MasonD-007/proxicloud/backend/internal/middleware"
+	"github.com/MasonD-007/proxicloud/backend/internal/oidc"
+)
+
+// PublicPaths are the API paths that need no session token or API key
+var PublicPaths = []string{
+	"/api/health",
+	"/api/auth/login",
+	"/api/auth/providers",
+	"/api/auth/oidc/login",
+	"/api/auth/oidc/callback",
+	"/api/auth/oidc/exchange",
+}
+
+const (
+	// oidcStateCookie ties the browser that started a single sign-on login to
+	// the callback that finishes it
+	oidcStateCookie = "proxicloud_oidc_state"
+	// oidcLoginTimeout is how long a user has to sign in at the identity provider
+	oidcLoginTimeout = 10 * time.Minute
+	// oidcExchangeTimeout is how long the frontend has to exchange the code
+	// it was redirected with for the session token
+	oidcExchangeTimeout = time.Minute
+	// oidcRetryInterval is how long to wait before retrying a refresh the
+	// identity provider could not be reached for
+	oidcRetryInterval = time.Minute
+)
+
+// OIDCSettings configures single sign-on through an OpenID Connect identity
+// provider
+type OIDCSettings struct {
+	Provider     *oidc.Provider
+	DisplayName  string   // Shown on the login button
+	FrontendURL  string   // Where the browser is sent after signing in
+	AdminGroups  []string // Groups whose members are global admins
+	ProjectRoles []OIDCProjectRole
+}
+
+// OIDCProjectRole gives the members of an identity provider group a role in
+// a project
+type OIDCProjectRole struct {
+	Group   string
+	Project string // Project ID or name
+	Role    auth.Role
+}
+
+// singleSignOn holds the single sign-on settings and the logins in progress
+type singleSignOn struct {
+	settings OIDCSettings
+
+	mu         sync.Mutex
+	pending    map[string]pendingLogin  // Keyed by state
+	exchanges  map[string]loginExchange // Keyed by exchange code
+	refreshing map[string]*sessionLock  // Keyed by session token
+}
+
+// sessionLock serialises the refreshes of one session so concurrent requests
+// with it do not each spend its refresh token, without holding up requests
+// with other sessions while the identity provider answers
+type sessionLock struct {
+	sync.Mutex
+	users int // Requests holding or waiting for the lock
+}
+
+// lockSession locks a session for refreshing and returns the unlock function
+func (s *singleSignOn) lockSession(token string) func() {
+	s.mu.Lock()
+	lock, ok := s.refreshing[token]
+	if !ok {
+		lock = &sessionLock{}
+		s.refreshing[token] = lock
+	}
+	lock.users++
+	s.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		s.mu.Lock()
+		if lock.users--; lock.users == 0 {
+			delete(s.refreshing, token)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// pendingLogin is a single sign-on login waiting for the identity provider
+// to redirect back
+type pendingLogin struct {
+	nonce    string
+	verifier string
+	next     string
+	expires  time.Time
+}
+
+// loginExchange is a finished single sign-on login waiting for the frontend
+// to exchange its one-time code for the session token
+type loginExchange struct {
+	token          string
+	sessionExpires time.Time
+	expires        time.Time
+}
+
+// SetOIDC enables single sign-on
+func (h *Handler) SetOIDC(settings OIDCSettings) {
+	if settings.DisplayName == "" {
+		settings.DisplayName = "Single sign-on"
+	}
+	settings.FrontendURL = strings.TrimSuffix(settings.FrontendURL, "/")
+	h.sso = &singleSignOn{
+		settings:   settings,
+		pending:    make(map[string]pendingLogin),
+		exchanges:  make(map[string]loginExchange),
+		refreshing: make(map[string]*sessionLock),
+	}
+}
+
+// SetPasswordLogin sets whether local users may log in with a password
+func (h *Handler) SetPasswordLogin(enabled bool) {
+	h.passwordLoginDisabled = !enabled
+}
+
+// GetAuthProviders returns the ways users can log in, for the login page
+func (h *Handler) GetAuthProviders(w http.ResponseWriter, r *http.Request) {
+	providers := map[string]interface{}{
+		"password": !h.passwordLoginDisabled,
+	}
+	if h.sso != nil {
+		providers["oidc"] = map[string]string{
+			"name":      h.sso.settings.DisplayName,
+			"login_url": "/api/auth/oidc/login",
+		}
+	}
+	respondJSON(w, http.StatusOK, providers)
+}
+
+// OIDCLogin starts a single sign-on login by sending the browser to the
+// identity provider. The optional next parameter is the frontend path to
+// return to afterwards.
+func (h *Handler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.sso == nil || h.auth == nil {
+		respondError(w, http.StatusNotFound, "single sign-on is not configured")
+		return
+	}
+
+	var login pendingLogin
+	state, err := oidc.RandomString()
+	if err == nil {
+		login.nonce, err = oidc.RandomString()
+	}
+	if err == nil {
+		login.verifier, err = oidc.RandomString()
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	login.next = safeNext(r.URL.Query().Get("next"))
+	login.expires = time.Now().Add(oidcLoginTimeout)
+
+	target, err := h.sso.settings.Provider.AuthCodeURL(r.Context(), state, login.nonce, login.verifier)
+	if err != nil {
+		log.Printf("[ERROR] Single sign-on unavailable: %v", err)
+		respondError(w, http.StatusBadGateway, "identity provider unavailable")
+		return
+	}
+
+	h.sso.mu.Lock()
+	for key, pending := range h.sso.pending {
+		if time.Now().After(pending.expires) {
+			delete(h.sso.pending, key)
+		}
+	}
+	h.sso.pending[state] = login
+	h.sso.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc",
+		MaxAge:   int(oidcLoginTimeout / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// OIDCCallback finishes a single sign-on login. The identity provider's
+// authorization code is exchanged for an ID token, the user's groups are
+// mapped to roles, and the browser is sent to the frontend login page with
+// the session cookie set and a one-time code for OIDCExchange in the URL
+// fragment. The session token itself never appears in a URL.
+func (h *Handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.sso == nil || h.auth == nil {
+		respondError(w, http.StatusNotFound, "single sign-on is not configured")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		Path:     "/api/auth/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := r.URL.Query()
+	state := query.Get("state")
+	h.sso.mu.Lock()
+	login, ok := h.sso.pending[state]
+	delete(h.sso.pending, state)
+	h.sso.mu.Unlock()
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if !ok || err != nil || cookie.Value != state || time.Now().After(login.expires) {
+		h.finishSSO(w, r, url.Values{"error": {"login expired or was started in another browser, please try again"}})
+		return
+	}
+	if message := query.Get("error"); message != "" {
+		if description := query.Get("error_description"); description != "" {
+			message += ": " + description
+		}
+		log.Printf("[WARNING] Identity provider refused single sign-on from %s: %s", r.RemoteAddr, message)
+		h.finishSSO(w, r, url.Values{"error": {message}})
+		return
+	}
+
+	tokens, err := h.sso.settings.Provider.Exchange(r.Context(), query.Get("code"), login.verifier)
+	if err != nil {
+		log.Printf("[ERROR] Single sign-on code exchange failed: %v", err)
+		h.finishSSO(w, r, url.Values{"error": {"identity provider rejected the login"}})
+		return
+	}
+	identity, err := h.sso.settings.Provider.Verify(r.Context(), tokens.IDToken, login.nonce)
+	if err != nil {
+		log.Printf("[ERROR] Single sign-on ID token rejected: %v", err)
+		h.finishSSO(w, r, url.Values{"error": {"identity provider returned an invalid ID token"}})
+		return
+	}
+	user, err := h.applyIdentity(identity)
+	if err != nil {
+		log.Printf("[ERROR] Single sign-on for %s failed: %v", identity.Username, err)
+		h.finishSSO(w, r, url.Values{"error": {err.Error()}})
+		return
+	}
+
+	token, sessionExpires, err := h.auth.CreateSession(user.Username, h.sessionTTL)
+	if err == nil && tokens.RefreshToken != "" {
+		err = h.auth.SetSessionRefresh(token, tokens.RefreshToken, refreshDue(tokens, identity))
+	}
+	var code string
+	if err == nil {
+		code, err = oidc.RandomString()
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("[INFO] User %s logged in through single sign-on (admin: %v)", user.Username, user.Admin)
+	// Unlike password logins the cookie has no expiry, as refreshes extend
+	// the session without a response to renew the cookie with
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.SessionCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	h.sso.mu.Lock()
+	for key, exchange := range h.sso.exchanges {
+		if time.Now().After(exchange.expires) {
+			delete(h.sso.exchanges, key)
+		}
+	}
+	h.sso.exchanges[code] = loginExchange{
+		token:          token,
+		sessionExpires: sessionExpires,
+		expires:        time.Now().Add(oidcExchangeTimeout),
+	}
+	h.sso.mu.Unlock()
+
+	h.finishSSO(w, r, url.Values{
+		"code": {code},
+		"next": {login.next},
+	})
+}
+
+// OIDCExchangeRequest holds the one-time code of a finished single sign-on
+// login
+type OIDCExchangeRequest struct {
+	Code string `json:"code"`
+}
+
+// OIDCExchange trades the one-time code OIDCCallback sent the frontend for
+// the session token, in the same response as a password login. Each code can
+// be used once, within a minute.
+func (h *Handler) OIDCExchange(w http.ResponseWriter, r *http.Request) {
+	if h.sso == nil || h.auth == nil {
+		respondError(w, http.StatusNotFound, "single sign-on is not configured")
+		return
+	}
+
+	var req OIDCExchangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	h.sso.mu.Lock()
+	exchange, ok := h.sso.exchanges[req.Code]
+	delete(h.sso.exchanges, req.Code)
+	h.sso.mu.Unlock()
+	if !ok || req.Code == "" || time.Now().After(exchange.expires) {
+		respondError(w, http.StatusUnauthorized, "invalid or expired login code")
+		return
+	}
+
+	user, err := h.auth.LookupSession(exchange.token)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, "invalid or expired login code")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"token":      exchange.token,
+		"expires_at": exchange.sessionExpires.Unix(),
+		"user":       user,
+	})
+}
+
+// finishSSO sends the browser back to the frontend login page with the result
+// of a single sign-on login in the URL fragment, which is not sent to servers
+func (h *Handler) finishSSO(w http.ResponseWriter, r *http.Request, result url.Values) {
+	http.Redirect(w, r, h.sso.settings.FrontendURL+"/login#"+result.Encode(), http.StatusFound)
+}
+
+// safeNext only lets the login return to paths on the frontend itself
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// refreshDue returns when a session's refresh token should next be used: when
+// the tokens it was last refreshed with expire
+func refreshDue(tokens *oidc.Tokens, identity *oidc.Identity) time.Time {
+	if !tokens.Expiry.IsZero() {
+		return tokens.Expiry
+	}
+	if identity != nil {
+		return identity.Expiry
+	}
+	return time.Now().Add(time.Hour)
+}
+
+// applyIdentity creates or updates the user an ID token was issued for and
+// syncs their admin flag and project roles with their groups. Only projects
+// named in the group mappings are synced, so memberships granted by hand in
+// other projects are kept.
+func (h *Handler) applyIdentity(identity *oidc.Identity) (*auth.User, error) {
+	groups := make(map[string]bool, len(identity.Groups))
+	for _, group := range identity.Groups {
+		groups[group] = true
+	}
+
+	admin := false
+	for _, group := range h.sso.settings.AdminGroups {
+		admin = admin || groups[group]
+	}
+	user, err := h.auth.EnsureExternalUser(identity.Issuer, identity.Subject, identity.Username, admin)
+	if err != nil {
+		return nil, err
+	}
+	if h.projectStore == nil {
+		return user, nil
+	}
+
+	// The highest role any of the user's groups has in each mapped project
+	roles := make(map[string]auth.Role)
+	for _, mapping := range h.sso.settings.ProjectRoles {
+		projectID := h.resolveProject(mapping.Project)
+		if projectID == "" {
+			log.Printf("[WARNING] Single sign-on group %s is mapped to unknown project %s", mapping.Group, mapping.Project)
+			continue
+		}
+		if _, ok := roles[projectID]; !ok {
+			roles[projectID] = ""
+		}
+		if groups[mapping.Group] && mapping.Role.Outranks(roles[projectID]) {
+			roles[projectID] = mapping.Role
+		}
+	}
+
+	for projectID, role := range roles {
+		current := auth.Role(h.projectStore.GetMemberRole(projectID, user.Username))
+		switch {
+		case role == current:
+		case role == "":
+			err = h.projectStore.RemoveMember(projectID, user.Username)
+		default:
+			err = h.projectStore.SetMember(projectID, user.Username, string(role))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// resolveProject returns the ID of a project given by ID or name, or "" if
+// there is no such project
+func (h *Handler) resolveProject(project string) string {
+	if _, err := h.projectStore.GetProject(project); err == nil {
+		return project
+	}
+	projects, err := h.projectStore.ListProjects()
+	if err != nil {
+		return ""
+	}
+	for _, p := range projects {
+		if p.Name == project {
+			return p.ID
+		}
+	}
+	return ""
+}
+
+// Authenticator returns the authenticator for the auth middleware. Sessions
+// from single sign-on logins are refreshed with the identity provider when
+// their tokens expire, which re-syncs the user's roles and extends the
+// session, or ends it if the provider no longer accepts the user.
+func (h *Handler) Authenticator() middleware.Authenticator {
+	return ssoAuthenticator{h}
+}
+
+type ssoAuthenticator struct {
+	h *Handler
+}
+
+func (a ssoAuthenticator) LookupAPIKey(key string) (*auth.User, error) {
+	return a.h.auth.LookupAPIKey(key)
+}
+
+func (a ssoAuthenticator) LookupSession(token string) (*auth.User, error) {
+	user, err := a.h.auth.LookupSession(token)
+	if err != nil || !user.External || a.h.sso == nil {
+		return user, err
+	}
+	return a.h.refreshSession(token, user)
+}
+
+// refreshSession refreshes a single sign-on session if it is due
+func (h *Handler) refreshSession(token string, user *auth.User) (*auth.User, error) {
+	refreshToken, due, err := h.auth.GetSessionRefresh(token)
+	if err != nil || refreshToken == "" || time.Now().Before(due) {
+		return user, err
+	}
+
+	unlock := h.sso.lockSession(token)
+	defer unlock()
+
+	// Another request may have refreshed the session while this one waited
+	refreshToken, due, err = h.auth.GetSessionRefresh(token)
+	if err != nil {
+		return nil, err
+	}
+	if refreshToken == "" || time.Now().Before(due) {
+		return h.auth.LookupSession(token)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	provider := h.sso.settings.Provider
+
+	tokens, err := provider.Refresh(ctx, refreshToken)
+	if err != nil {
+		if errors.Is(err, oidc.ErrRefreshRejected) {
+			log.Printf("[INFO] Ending single sign-on session of %s: %v", user.Username, err)
+			return nil, h.endSession(token)
+		}
+		// Keep the session while the identity provider is unreachable
+		log.Printf("[WARNING] Failed to refresh single sign-on session of %s: %v", user.Username, err)
+		return user, h.auth.SetSessionRefresh(token, refreshToken, time.Now().Add(oidcRetryInterval))
+	}
+
+	var identity *oidc.Identity
+	if tokens.IDToken != "" {
+		identity, err = provider.Verify(ctx, tokens.IDToken, "")
+		if err == nil {
+			err = h.sameAccount(user, identity)
+		}
+		if err != nil {
+			log.Printf("[WARNING] Ending single sign-on session of %s: %v", user.Username, err)
+			return nil, h.endSession(token)
+		}
+		if user, err = h.applyIdentity(identity); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := h.auth.SetSessionRefresh(token, tokens.RefreshToken, refreshDue(tokens, identity)); err != nil {
+		return nil, err
+	}
+	if _, err := h.auth.ExtendSession(token, h.sessionTTL); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// sameAccount checks that an ID token is for the identity provider account
+// the user is linked to. Usernames can change at the provider; the issuer and
+// subject cannot.
+func (h *Handler) sameAccount(user *auth.User, identity *oidc.Identity) error {
+	issuer, subject, err := h.auth.ExternalIdentity(user.Username)
+	if err != nil {
+		return err
+	}
+	if issuer != identity.Issuer || subject != identity.Subject {
+		return fmt.Errorf("refreshed ID token is for account %s of %s", identity.Subject, identity.Issuer)
+	}
+	return nil
+}
+
+// endSession deletes a session the identity provider no longer accepts
+func (h *Handler) endSession(token string) error {
+	if err := h.auth.DeleteSession(token); err != nil {
+		return err
+	}
+	return auth.ErrInvalidCredentials
+}
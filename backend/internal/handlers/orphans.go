@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/proxmox"
+)
+
+// ListOrphanedVolumes lists the volumes left behind by guests that no longer exist
+func (h *Handler) ListOrphanedVolumes(w http.ResponseWriter, r *http.Request) {
+	orphans, err := h.client.FindOrphanedVolumes(r.Context())
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, orphans)
+}
+
+// CleanupOrphanedVolumes deletes orphaned volumes. The storages are scanned
+// again first so only volumes that are still orphaned are deleted. Without
+// "confirm": true it is a dry run that reports what would be deleted; a
+// confirmed cleanup only deletes the volumes it names.
+func (h *Handler) CleanupOrphanedVolumes(w http.ResponseWriter, r *http.Request) {
+	var req proxmox.OrphanCleanupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.MinAge < 0 {
+		respondError(w, http.StatusBadRequest, "min_age_days must not be negative")
+		return
+	}
+	if req.Confirm && len(req.VolIDs) == 0 {
+		respondError(w, http.StatusBadRequest, "volids is required to delete orphaned volumes")
+		return
+	}
+
+	orphans, err := h.client.FindOrphanedVolumes(r.Context())
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	var selected []proxmox.OrphanCleanupResult
+	if len(req.VolIDs) == 0 {
+		for _, orphan := range orphans {
+			selected = append(selected, proxmox.OrphanCleanupResult{OrphanedVolume: orphan})
+		}
+	} else {
+		byID := make(map[string]proxmox.OrphanedVolume, len(orphans))
+		for _, orphan := range orphans {
+			byID[orphan.VolID] = orphan
+		}
+		for _, volid := range req.VolIDs {
+			orphan, ok := byID[volid]
+			if !ok {
+				selected = append(selected, proxmox.OrphanCleanupResult{
+					OrphanedVolume: proxmox.OrphanedVolume{VolID: volid},
+					Status:         "skipped",
+					Reason:         "not an orphaned volume",
+				})
+				continue
+			}
+			selected = append(selected, proxmox.OrphanCleanupResult{OrphanedVolume: orphan})
+		}
+	}
+
+	resp := proxmox.OrphanCleanupResponse{DryRun: !req.Confirm, Volumes: []proxmox.OrphanCleanupResult{}}
+	minAge := int64(req.MinAge) * 24 * 60 * 60
+	for _, result := range selected {
+		switch {
+		case result.Status != "":
+		case minAge > 0 && result.CreatedAt == 0:
+			result.Status, result.Reason = "skipped", "age unknown"
+		case minAge > 0 && result.Age < minAge:
+			result.Status, result.Reason = "skipped", fmt.Sprintf("younger than %d days", req.MinAge)
+		case !req.Confirm:
+			result.Status = "would_delete"
+			resp.Reclaimed += result.Size
+		default:
+			h.deleteOrphan(r, &result)
+			if result.Status == "deleted" {
+				resp.Reclaimed += result.Size
+			}
+		}
+		resp.Volumes = append(resp.Volumes, result)
+	}
+
+	if req.Confirm {
+		log.Printf("[INFO] Orphan cleanup reclaimed %dGB", resp.Reclaimed)
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// deleteOrphan deletes one orphaned volume, waits for the delete task and
+// records the outcome in result
+func (h *Handler) deleteOrphan(r *http.Request, result *proxmox.OrphanCleanupResult) {
+	upid, err := h.client.DeleteOrphanedVolume(r.Context(), result.OrphanedVolume)
+	if err != nil {
+		result.Status, result.Reason = "failed", err.Error()
+		return
+	}
+	if upid != "" {
+		result.TaskID = upid
+		noteTask(r, upid)
+		h.tasks.Track(upid, fmt.Sprintf("delete orphaned volume %s", result.VolID))
+		task, err := h.tasks.Wait(r.Context(), upid)
+		if err != nil {
+			result.Status, result.Reason = "failed", fmt.Sprintf("stopped waiting for task %s: %v", upid, err)
+			return
+		}
+		if !task.Succeeded() {
+			result.Status, result.Reason = "failed", fmt.Sprintf("task %s failed: %s", upid, task.ExitStatus)
+			return
+		}
+	}
+
+	result.Status = "deleted"
+	if h.registry != nil {
+		if err := h.registry.Delete(result.VolID); err != nil {
+			log.Printf("[ERROR] Failed to remove volume %s from registry: %v", result.VolID, err)
+		}
+	}
+}
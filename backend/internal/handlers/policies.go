@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/scheduler"
+	"github.com/gorilla/mux"
+)
+
+// policyErrorStatus maps an error from the policy store or scheduler to an HTTP status code
+func policyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, scheduler.ErrPolicyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, scheduler.ErrInvalidPolicy):
+		return http.StatusBadRequest
+	case errors.Is(err, scheduler.ErrPolicyRunning):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// ListPolicies lists the snapshot and backup policies for targets the calling
+// user may see
+func (h *Handler) ListPolicies(w http.ResponseWriter, r *http.Request) {
+	if h.policies == nil {
+		respondError(w, http.StatusServiceUnavailable, "policy scheduler not available")
+		return
+	}
+
+	visible := h.visibleTo(r)
+	policies := h.policies.Store().ListPolicies()
+	filtered := make([]*scheduler.Policy, 0, len(policies))
+	for _, policy := range policies {
+		if visible(h.targetProject(policy.Target)) {
+			filtered = append(filtered, policy)
+		}
+	}
+	respondJSON(w, http.StatusOK, filtered)
+}
+
+// CreatePolicy creates a snapshot or backup policy
+func (h *Handler) CreatePolicy(w http.ResponseWriter, r *http.Request) {
+	if h.policies == nil {
+		respondError(w, http.StatusServiceUnavailable, "policy scheduler not available")
+		return
+	}
+
+	var req scheduler.CreatePolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	policy, err := h.policies.Store().CreatePolicy(req)
+	if err != nil {
+		respondError(w, policyErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, policy)
+}
+
+// GetPolicy returns a policy
+func (h *Handler) GetPolicy(w http.ResponseWriter, r *http.Request) {
+	if h.policies == nil {
+		respondError(w, http.StatusServiceUnavailable, "policy scheduler not available")
+		return
+	}
+
+	policy, err := h.policies.Store().GetPolicy(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, policyErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, policy)
+}
+
+// UpdatePolicy updates a policy
+func (h *Handler) UpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	if h.policies == nil {
+		respondError(w, http.StatusServiceUnavailable, "policy scheduler not available")
+		return
+	}
+
+	var req scheduler.UpdatePolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	policy, err := h.policies.Store().UpdatePolicy(mux.Vars(r)["id"], req)
+	if err != nil {
+		respondError(w, policyErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, policy)
+}
+
+// DeletePolicy deletes a policy and its run history. The snapshots and
+// backups it created are kept.
+func (h *Handler) DeletePolicy(w http.ResponseWriter, r *http.Request) {
+	if h.policies == nil {
+		respondError(w, http.StatusServiceUnavailable, "policy scheduler not available")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if err := h.policies.Store().DeletePolicy(id); err != nil {
+		respondError(w, policyErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "deleted", "id": id})
+}
+
+// ListPolicyRuns returns the run history of a policy, newest first
+func (h *Handler) ListPolicyRuns(w http.ResponseWriter, r *http.Request) {
+	if h.policies == nil {
+		respondError(w, http.StatusServiceUnavailable, "policy scheduler not available")
+		return
+	}
+
+	runs, err := h.policies.Store().ListRuns(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, policyErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, runs)
+}
+
+// RunPolicy runs a policy now. With ?wait=true it blocks until the run
+// finishes and returns its outcome.
+func (h *Handler) RunPolicy(w http.ResponseWriter, r *http.Request) {
+	if h.policies == nil {
+		respondError(w, http.StatusServiceUnavailable, "policy scheduler not available")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	run, err := h.policies.RunNow(id)
+	if err != nil {
+		respondError(w, policyErrorStatus(err), err.Error())
+		return
+	}
+
+	if !wantsWait(r) {
+		respondJSON(w, http.StatusAccepted, run)
+		return
+	}
+
+	finished, err := h.policies.WaitRun(r.Context(), id, run.ID)
+	if err != nil {
+		respondError(w, http.StatusGatewayTimeout, "stopped waiting for policy run: "+err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, finished)
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/proxmox"
+)
+
+// SetStorageClasses sets the admin-defined storage classes that volumes and
+// container disks are created in
+func (h *Handler) SetStorageClasses(classes []proxmox.StorageClass) {
+	h.storageClasses = make(map[string]proxmox.StorageClass, len(classes))
+	for _, class := range classes {
+		h.storageClasses[class.Name] = class
+	}
+}
+
+// resolveStorageClass returns the storage class a request asked for, or the
+// default class if it named none. It returns nil if no classes are configured
+// or none was named and there is no default. On failure it also returns the
+// HTTP status to respond with.
+func (h *Handler) resolveStorageClass(name, projectID string) (*proxmox.StorageClass, int, error) {
+	if len(h.storageClasses) == 0 {
+		return nil, 0, nil
+	}
+
+	var class proxmox.StorageClass
+	if name != "" {
+		var ok bool
+		if class, ok = h.storageClasses[name]; !ok {
+			return nil, http.StatusBadRequest, fmt.Errorf("unknown storage class %q", name)
+		}
+	} else {
+		found := false
+		for _, c := range h.storageClasses {
+			if c.Default {
+				class, found = c, true
+				break
+			}
+		}
+		if !found {
+			return nil, 0, nil
+		}
+	}
+
+	if !class.AllowsProject(projectID) {
+		if projectID == "" {
+			return nil, http.StatusForbidden, fmt.Errorf("storage class %s is restricted to projects; a project_id is required", class.Name)
+		}
+		return nil, http.StatusForbidden, fmt.Errorf("project %s may not use storage class %s", projectID, class.Name)
+	}
+	return &class, 0, nil
+}
+
+// applyClassSize fills in the class's default size if size is 0 and checks it
+// against the class's limit
+func applyClassSize(class *proxmox.StorageClass, size *int) error {
+	if *size == 0 {
+		*size = class.DefaultSize
+	}
+	if class.MaxSize > 0 && *size > class.MaxSize {
+		return fmt.Errorf("storage class %s allows at most %dGB", class.Name, class.MaxSize)
+	}
+	return nil
+}
+
+// storageClassFor returns the storage class backed by the storage a volume is
+// on. If several classes share the storage the default class wins, then the
+// first by name.
+func (h *Handler) storageClassFor(volid string) (proxmox.StorageClass, bool) {
+	storage := strings.SplitN(volid, ":", 2)[0]
+	var match *proxmox.StorageClass
+	for name := range h.storageClasses {
+		class := h.storageClasses[name]
+		if class.Storage != storage {
+			continue
+		}
+		if match == nil || class.Default || (!match.Default && class.Name < match.Name) {
+			match = &class
+		}
+	}
+	if match == nil {
+		return proxmox.StorageClass{}, false
+	}
+	return *match, true
+}
+
+// classifyVolume sets a volume's type to the storage class of its storage, if any
+func (h *Handler) classifyVolume(volume *proxmox.Volume) {
+	if class, ok := h.storageClassFor(volume.VolID); ok {
+		volume.Type = class.Name
+	}
+}
+
+// ListStorageClasses lists the storage classes with the capacity of their
+// pools. With a project_id query parameter only the classes the project may
+// use are listed.
+func (h *Handler) ListStorageClasses(w http.ResponseWriter, r *http.Request) {
+	projectID := r.URL.Query().Get("project_id")
+
+	storages, err := h.client.GetStorage(r.Context(), nil)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+	pools := make(map[string]proxmox.Storage, len(storages))
+	for _, storage := range storages {
+		pools[storage.Storage] = storage
+	}
+
+	classes := make([]proxmox.StorageClassStatus, 0, len(h.storageClasses))
+	for _, class := range h.storageClasses {
+		if projectID != "" && !class.AllowsProject(projectID) {
+			continue
+		}
+		status := proxmox.StorageClassStatus{StorageClass: class}
+		if pool, ok := pools[class.Storage]; ok {
+			status.Available = (pool.Enabled == nil || *pool.Enabled) && (pool.Active == nil || *pool.Active)
+			status.Total = pool.Total
+			status.Used = pool.Used
+			status.Avail = pool.Avail
+		}
+		classes = append(classes, status)
+	}
+	sort.Slice(classes, func(i, j int) bool { return classes[i].Name < classes[j].Name })
+
+	respondJSON(w, http.StatusOK, classes)
+}
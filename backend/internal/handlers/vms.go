@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/proxmox"
+	"github.com/gorilla/mux"
+)
+
+// ListVMs lists all virtual machines
+func (h *Handler) ListVMs(w http.ResponseWriter, r *http.Request) {
+	vms, err := h.client.GetVMs(r.Context())
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	// Enrich with project information
+	if h.projectStore != nil {
+		for i := range vms {
+			vms[i].ProjectID = h.projectStore.GetContainerProject(vms[i].VMID)
+		}
+	}
+
+	visible := h.visibleTo(r)
+	filtered := make([]proxmox.VM, 0, len(vms))
+	for _, vm := range vms {
+		if visible(vm.ProjectID) {
+			filtered = append(filtered, vm)
+		}
+	}
+
+	respondJSON(w, http.StatusOK, filtered)
+}
+
+// GetVM gets a specific virtual machine
+func (h *Handler) GetVM(w http.ResponseWriter, r *http.Request) {
+	vmid, err := strconv.Atoi(mux.Vars(r)["vmid"])
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid vmid")
+		return
+	}
+
+	vm, err := h.client.GetVM(r.Context(), vmid)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	if h.projectStore != nil {
+		vm.ProjectID = h.projectStore.GetContainerProject(vmid)
+	}
+
+	respondJSON(w, http.StatusOK, vm)
+}
+
+// CreateVM creates a virtual machine by cloning a VM template. The clone is
+// waited for so the cloud-init and hardware settings can be applied before
+// the VM is (optionally) started.
+func (h *Handler) CreateVM(w http.ResponseWriter, r *http.Request) {
+	var req proxmox.CreateVMRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Template <= 0 {
+		respondError(w, http.StatusBadRequest, "template (VMID of a VM template) is required")
+		return
+	}
+
+	vmid, status, err := h.allocateVMID(r, req.VMID, req.ProjectID)
+	if err != nil {
+		respondError(w, status, err.Error())
+		return
+	}
+
+	// If the VM is assigned to a project with network configuration, attach it to the project's VNet
+	if req.ProjectID != "" && h.projectStore != nil {
+		project, err := h.projectStore.GetProject(req.ProjectID)
+		if err == nil && project.Network != nil && project.Network.VNetID != "" {
+			log.Printf("[INFO] VM assigned to project %s with VNet %s", req.ProjectID, project.Network.VNetID)
+			req.Bridge = project.Network.VNetID
+			if req.Nameserver == "" {
+				req.Nameserver = project.Network.Nameserver
+			}
+		}
+	}
+
+	upid, err := h.client.CreateVM(r.Context(), vmid, req)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.waitTask(w, r, upid, fmt.Sprintf("create VM %d", vmid))
+	if !ok {
+		return
+	}
+
+	// Assign to project if specified
+	if req.ProjectID != "" && h.projectStore != nil {
+		if err := h.projectStore.AssignContainer(vmid, req.ProjectID); err != nil {
+			log.Printf("[WARNING] Failed to assign VM %d to project %s: %v", vmid, req.ProjectID, err)
+		}
+	}
+
+	if err := h.client.UpdateVMConfig(r.Context(), vmid, req.VMConfigRequest); err != nil {
+		respondError(w, errorStatus(err), fmt.Sprintf("VM %d was created but could not be configured: %v", vmid, err))
+		return
+	}
+
+	// The clone task runs on the template's node, the VM may have been created elsewhere
+	node := req.Node
+	if node == "" {
+		node = task.Node
+	}
+	body := map[string]interface{}{"vmid": vmid, "node": node}
+	if req.Start {
+		upid, err := h.client.StartVM(r.Context(), vmid)
+		if err != nil {
+			respondError(w, errorStatus(err), fmt.Sprintf("VM %d was created but could not be started: %v", vmid, err))
+			return
+		}
+		startTask, ok := h.trackTask(w, r, upid, fmt.Sprintf("start VM %d", vmid))
+		if !ok {
+			return
+		}
+		if startTask != nil {
+			body["start_task_id"] = startTask.UPID
+		}
+	}
+
+	respondJSON(w, http.StatusCreated, withTask(body, task))
+}
+
+// CloneVM clones a virtual machine or VM template
+func (h *Handler) CloneVM(w http.ResponseWriter, r *http.Request) {
+	vmid, err := strconv.Atoi(mux.Vars(r)["vmid"])
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid vmid")
+		return
+	}
+
+	var req proxmox.CloneVMRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	newID, status, err := h.allocateVMID(r, &req.NewID, req.ProjectID)
+	if err != nil {
+		respondError(w, status, err.Error())
+		return
+	}
+	req.NewID = newID
+
+	upid, err := h.client.CloneVM(r.Context(), vmid, req)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("clone VM %d to %d", vmid, newID))
+	if !ok {
+		return
+	}
+
+	// Assign to project if specified
+	if req.ProjectID != "" && h.projectStore != nil {
+		if err := h.projectStore.AssignContainer(newID, req.ProjectID); err != nil {
+			log.Printf("[WARNING] Failed to assign VM %d to project %s: %v", newID, req.ProjectID, err)
+		}
+	}
+
+	respondJSON(w, http.StatusCreated, withTask(map[string]interface{}{"vmid": newID}, task))
+}
+
+// UpdateVMConfig changes the hardware and cloud-init settings of a virtual machine
+func (h *Handler) UpdateVMConfig(w http.ResponseWriter, r *http.Request) {
+	vmid, err := strconv.Atoi(mux.Vars(r)["vmid"])
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid vmid")
+		return
+	}
+
+	var req proxmox.VMConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := h.client.UpdateVMConfig(r.Context(), vmid, req); err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "updated"})
+}
+
+// StartVM starts a virtual machine
+func (h *Handler) StartVM(w http.ResponseWriter, r *http.Request) {
+	h.vmAction(w, r, "start", "started", h.client.StartVM)
+}
+
+// StopVM stops a virtual machine
+func (h *Handler) StopVM(w http.ResponseWriter, r *http.Request) {
+	h.vmAction(w, r, "stop", "stopped", h.client.StopVM)
+}
+
+// RebootVM reboots a virtual machine
+func (h *Handler) RebootVM(w http.ResponseWriter, r *http.Request) {
+	h.vmAction(w, r, "reboot", "rebooting", h.client.RebootVM)
+}
+
+// DeleteVM deletes a virtual machine and its disks
+func (h *Handler) DeleteVM(w http.ResponseWriter, r *http.Request) {
+	vmid, ok := h.vmAction(w, r, "delete", "deleted", h.client.DeleteVM)
+	if !ok {
+		return
+	}
+
+	// Remove the VM from its project
+	if h.projectStore != nil {
+		if err := h.projectStore.AssignContainer(vmid, ""); err != nil {
+			log.Printf("[WARNING] Failed to remove VM %d from project assignment: %v", vmid, err)
+		}
+	}
+}
+
+// vmAction runs a power or delete action on the VM in the request path and
+// writes the response. It returns the VMID and whether the action succeeded.
+func (h *Handler) vmAction(w http.ResponseWriter, r *http.Request, action, status string, run func(ctx context.Context, vmid int) (string, error)) (int, bool) {
+	vmid, err := strconv.Atoi(mux.Vars(r)["vmid"])
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid vmid")
+		return 0, false
+	}
+
+	upid, err := run(r.Context(), vmid)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return 0, false
+	}
+
+	task, ok := h.trackTask(w, r, upid, fmt.Sprintf("%s VM %d", action, vmid))
+	if !ok {
+		return 0, false
+	}
+
+	respondJSON(w, http.StatusOK, withTask(map[string]interface{}{"status": status}, task))
+	return vmid, true
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/proxmox"
+	"github.com/MasonD-007/proxicloud/backend/internal/volumes"
+	"github.com/gorilla/mux"
+)
+
+// registerVolume records a volume created through the API in the registry
+func (h *Handler) registerVolume(volume proxmox.Volume) {
+	if h.registry == nil {
+		return
+	}
+	if err := h.registry.Register(volume); err != nil {
+		log.Printf("[ERROR] Failed to register volume %s: %v", volume.VolID, err)
+	}
+}
+
+// observeVolume reads a volume back from Proxmox after a change so the
+// registry records it in the volume's history
+func (h *Handler) observeVolume(ctx context.Context, volid string) {
+	if h.registry == nil {
+		return
+	}
+	volume, err := h.client.GetVolume(ctx, volid)
+	if err != nil {
+		log.Printf("[WARNING] Failed to read volume %s for the registry: %v", volid, err)
+		return
+	}
+	h.classifyVolume(volume)
+	if err := h.registry.Merge(volume); err != nil {
+		log.Printf("[ERROR] Failed to merge volume %s with registry: %v", volid, err)
+	}
+}
+
+// UpdateVolume changes the name, description, project or labels of a volume
+func (h *Handler) UpdateVolume(w http.ResponseWriter, r *http.Request) {
+	if h.registry == nil {
+		respondError(w, http.StatusServiceUnavailable, "volume registry not available")
+		return
+	}
+
+	volid := mux.Vars(r)["volid"]
+	var req proxmox.UpdateVolumeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Name != nil && *req.Name == "" {
+		respondError(w, http.StatusBadRequest, "name must not be empty")
+		return
+	}
+
+	// The volume must exist in Proxmox; merging it creates its record if needed
+	volume, err := h.client.GetVolume(r.Context(), volid)
+	if err != nil {
+		respondError(w, errorStatus(err), err.Error())
+		return
+	}
+	h.classifyVolume(volume)
+	if err := h.registry.Merge(volume); err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := h.registry.Update(volid, req); err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := h.registry.Merge(volume); err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, volume)
+}
+
+// GetVolumeHistory returns the recorded history of a volume, newest first.
+// The history of deleted volumes is kept.
+func (h *Handler) GetVolumeHistory(w http.ResponseWriter, r *http.Request) {
+	if h.registry == nil {
+		respondError(w, http.StatusServiceUnavailable, "volume registry not available")
+		return
+	}
+
+	volid := mux.Vars(r)["volid"]
+	events, err := h.registry.History(volid)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(events) == 0 {
+		respondError(w, http.StatusNotFound, volumes.ErrNotFound.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, events)
+}
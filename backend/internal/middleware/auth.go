@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/auth"
+)
+
+// SessionCookie is the cookie a login session token is kept in by browsers
+const SessionCookie = "proxicloud_session"
+
+// Authenticator resolves the user a session token or API key belongs to
+type Authenticator interface {
+	LookupSession(token string) (*auth.User, error)
+	LookupAPIKey(key string) (*auth.User, error)
+}
+
+// RequestToken returns the token a request authenticates with: an
+// "Authorization: Bearer" header, an X-API-Key header or the session cookie
+func RequestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// Auth middleware rejects requests without a valid session token or API key
+// with 401 Unauthorized, except for the given public paths. The user is added
+// to the request context for handlers to read with auth.UserFrom.
+func Auth(authenticator Authenticator, public ...string) func(http.Handler) http.Handler {
+	publicPaths := make(map[string]bool, len(public))
+	for _, path := range public {
+		publicPaths[path] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if publicPaths[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token := RequestToken(r)
+			if token == "" {
+				unauthorized(w, "authentication required")
+				return
+			}
+
+			var user *auth.User
+			var err error
+			if strings.HasPrefix(token, auth.APIKeyPrefix) {
+				user, err = authenticator.LookupAPIKey(token)
+			} else {
+				user, err = authenticator.LookupSession(token)
+			}
+			if err != nil {
+				if !errors.Is(err, auth.ErrInvalidCredentials) {
+					log.Printf("[ERROR] Failed to authenticate request: %v", err)
+				}
+				unauthorized(w, "invalid or expired credentials")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), user)))
+		})
+	}
+}
+
+// unauthorized writes a 401 response in the API's error format
+func unauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("WWW-Authenticate", `Bearer realm="proxicloud"`)
+	w.WriteHeader(http.StatusUnauthorized)
+	if _, err := w.Write([]byte(`{"error":"` + message + `"}`)); err != nil {
+		log.Printf("Failed to write error response: %v", err)
+	}
+}
@@ -0,0 +1,306 @@
+// Package oidctest provides a mock OpenID Connect identity provider for
+// testing the single sign-on flow without a real one
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/oidc"
+)
+
+const keyID = "oidctest"
+
+// Server is an identity provider that approves every authorization request
+// as the user set with SetUser. It checks PKCE and client credentials like a
+// real provider and rotates refresh tokens on every refresh.
+type Server struct {
+	*httptest.Server
+
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+
+	mu            sync.Mutex
+	username      string
+	subject       string
+	groups        []string
+	tokenTTL      time.Duration
+	codes         map[string]authorization
+	refreshTokens map[string]bool
+	refreshes     int
+}
+
+// authorization is an issued authorization code waiting to be exchanged
+type authorization struct {
+	redirectURI string
+	nonce       string
+	challenge   string
+}
+
+// NewServer starts a mock identity provider for a client. An empty secret
+// makes it a public client.
+func NewServer(clientID, clientSecret string) *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic("oidctest: failed to generate key: " + err.Error())
+	}
+
+	s := &Server{
+		clientID:      clientID,
+		clientSecret:  clientSecret,
+		key:           key,
+		username:      "alice",
+		tokenTTL:      time.Hour,
+		codes:         make(map[string]authorization),
+		refreshTokens: make(map[string]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.handleDiscovery)
+	mux.HandleFunc("/keys", s.handleKeys)
+	mux.HandleFunc("/authorize", s.handleAuthorize)
+	mux.HandleFunc("/token", s.handleToken)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Issuer returns the issuer URL to configure the client with
+func (s *Server) Issuer() string {
+	return s.URL
+}
+
+// SetUser sets the user and groups the next tokens are issued for. The
+// subject is "user-" and the username unless SetSubject changes it.
+func (s *Server) SetUser(username string, groups ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.username = username
+	s.subject = ""
+	s.groups = groups
+}
+
+// SetSubject sets the subject of the next tokens, e.g. to give a different
+// account the username of another, or to rename an account
+func (s *Server) SetSubject(subject string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subject = subject
+}
+
+// SetTokenTTL sets how long issued ID and access tokens are valid
+func (s *Server) SetTokenTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenTTL = ttl
+}
+
+// RevokeRefreshTokens invalidates every refresh token issued so far, as if
+// the user had been signed out at the provider
+func (s *Server) RevokeRefreshTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshTokens = make(map[string]bool)
+}
+
+// Refreshes returns how many refresh token grants were accepted
+func (s *Server) Refreshes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshes
+}
+
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.URL,
+		"authorization_endpoint":                s.URL + "/authorize",
+		"token_endpoint":                        s.URL + "/token",
+		"jwks_uri":                              s.URL + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	pub := s.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// handleAuthorize approves the request straight away and redirects back with
+// a code, as a provider would after the user signed in
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI := query.Get("redirect_uri")
+	if query.Get("client_id") != s.clientID || redirectURI == "" {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	}
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" ||
+		query.Get("code_challenge") == "" {
+		http.Error(w, "authorization code flow with S256 PKCE required", http.StatusBadRequest)
+		return
+	}
+	if !strings.Contains(" "+query.Get("scope")+" ", " openid ") {
+		http.Error(w, "openid scope required", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = authorization{
+		redirectURI: redirectURI,
+		nonce:       query.Get("nonce"),
+		challenge:   query.Get("code_challenge"),
+	}
+	s.mu.Unlock()
+
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	params := target.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	target.RawQuery = params.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+	}
+	if clientID != s.clientID || secret != s.clientSecret {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nonce := ""
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		auth, ok := s.codes[code]
+		delete(s.codes, code)
+		if !ok || auth.redirectURI != r.PostForm.Get("redirect_uri") ||
+			oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != auth.challenge {
+			tokenError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		nonce = auth.nonce
+	case "refresh_token":
+		token := r.PostForm.Get("refresh_token")
+		if !s.refreshTokens[token] {
+			tokenError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		delete(s.refreshTokens, token)
+		s.refreshes++
+	default:
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	subject := s.subject
+	if subject == "" {
+		subject = "user-" + s.username
+	}
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":                s.URL,
+		"sub":                subject,
+		"aud":                s.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(s.tokenTTL).Unix(),
+		"preferred_username": s.username,
+		"groups":             s.groups,
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	idToken, err := s.sign(claims)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	refreshToken := randomString()
+	s.refreshTokens[refreshToken] = true
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  randomString(),
+		"token_type":    "Bearer",
+		"expires_in":    int64(s.tokenTTL / time.Second),
+		"id_token":      idToken,
+		"refresh_token": refreshToken,
+	})
+}
+
+// sign makes an RS256 JWT
+func (s *Server) sign(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func randomString() string {
+	s, err := oidc.RandomString()
+	if err != nil {
+		panic("oidctest: " + err.Error())
+	}
+	return s
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,355 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRefreshRejected is returned by Refresh when the identity provider no
+// longer accepts a refresh token, for example because the user was disabled
+// or signed out. The session it belongs to should end.
+var ErrRefreshRejected = errors.New("refresh token rejected by identity provider")
+
+// Config describes the OpenID Connect client ProxiCloud registers with an
+// identity provider
+type Config struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string // Empty for public clients, which rely on PKCE alone
+	RedirectURL   string
+	Scopes        []string // Requested in addition to openid (default: profile, email, groups, offline_access)
+	UsernameClaim string   // ID token claim holding the username (default: preferred_username)
+	GroupsClaim   string   // ID token claim holding the user's groups (default: groups)
+}
+
+// Tokens are the tokens returned by the identity provider's token endpoint
+type Tokens struct {
+	IDToken      string // May be empty after a refresh
+	AccessToken  string
+	RefreshToken string
+	Expiry       time.Time
+}
+
+// Identity is the user an ID token was issued for
+type Identity struct {
+	Issuer   string
+	Subject  string // Unique and stable at the issuer, unlike the username
+	Username string
+	Groups   []string
+	Expiry   time.Time
+}
+
+// discovery is the part of the provider metadata ProxiCloud uses
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider runs the authorization code flow with PKCE against an OpenID
+// Connect identity provider. Its metadata is discovered on first use so
+// ProxiCloud can start while the provider is unreachable.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *discovery
+	keys     map[string]interface{} // Signing keys by kid
+}
+
+// NewProvider creates a provider for an issuer
+func NewProvider(config Config) *Provider {
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"profile", "email", "groups", "offline_access"}
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "preferred_username"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	return &Provider{
+		config: config,
+		client: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// RandomString returns a random URL-safe string for states, nonces and PKCE
+// verifiers
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random string: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge returns the S256 PKCE challenge for a verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the identity provider URL to send the browser to
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.config.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange trades an authorization code and its PKCE verifier for tokens
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*Tokens, error) {
+	return p.token(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+	})
+}
+
+// Refresh trades a refresh token for new tokens. Providers that rotate
+// refresh tokens return a new one, which replaces the old one.
+func (p *Provider) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	tokens, err := p.token(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tokens.RefreshToken == "" {
+		tokens.RefreshToken = refreshToken
+	}
+	return tokens, nil
+}
+
+// token makes a request to the token endpoint
+func (p *Provider) token(ctx context.Context, form url.Values) (*Tokens, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form.Set("client_id", p.config.ClientID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Failed to close response body: %v", err)
+		}
+	}()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	var result struct {
+		IDToken          string `json:"id_token"`
+		AccessToken      string `json:"access_token"`
+		RefreshToken     string `json:"refresh_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || result.Error != "" {
+		message := result.Error
+		if result.ErrorDescription != "" {
+			message += ": " + result.ErrorDescription
+		}
+		if message == "" {
+			message = resp.Status
+		}
+		if form.Get("grant_type") == "refresh_token" && (result.Error == "invalid_grant" ||
+			(resp.StatusCode >= 400 && resp.StatusCode < 500)) {
+			return nil, fmt.Errorf("%w: %s", ErrRefreshRejected, message)
+		}
+		return nil, fmt.Errorf("token request failed: %s", message)
+	}
+	// Providers need not return a new ID token when refreshing
+	if result.IDToken == "" && form.Get("grant_type") != "refresh_token" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+
+	tokens := &Tokens{
+		IDToken:      result.IDToken,
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
+	}
+	if result.ExpiresIn > 0 {
+		tokens.Expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+	return tokens, nil
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of an ID
+// token and returns the identity it was issued for. The nonce is not checked
+// when empty, as refreshed ID tokens do not carry one.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (*Identity, error) {
+	claims, err := p.verifySignature(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if issuer, _ := claims["iss"].(string); strings.TrimSuffix(issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("id token issued by %q, expected %q", issuer, p.config.Issuer)
+	}
+	if !hasAudience(claims["aud"], p.config.ClientID) {
+		return nil, fmt.Errorf("id token not issued for client %s", p.config.ClientID)
+	}
+
+	expiry, ok := numericClaim(claims["exp"])
+	if !ok {
+		return nil, fmt.Errorf("id token has no expiry")
+	}
+	// Allow for a little clock skew between ProxiCloud and the provider
+	if time.Now().After(expiry.Add(time.Minute)) {
+		return nil, fmt.Errorf("id token expired at %s", expiry.Format(time.RFC3339))
+	}
+	if nonce != "" {
+		if got, _ := claims["nonce"].(string); got != nonce {
+			return nil, fmt.Errorf("id token nonce does not match")
+		}
+	}
+
+	identity := &Identity{Issuer: p.config.Issuer, Expiry: expiry}
+	identity.Subject, _ = claims["sub"].(string)
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("id token has no sub claim")
+	}
+	identity.Username, _ = claims[p.config.UsernameClaim].(string)
+	if identity.Username == "" {
+		return nil, fmt.Errorf("id token has no %s claim", p.config.UsernameClaim)
+	}
+	switch groups := claims[p.config.GroupsClaim].(type) {
+	case []interface{}:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	case string:
+		identity.Groups = []string{groups}
+	}
+	return identity, nil
+}
+
+// hasAudience reports whether an aud claim, a string or a list, contains the
+// client ID
+func hasAudience(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// numericClaim reads a NumericDate claim
+func numericClaim(value interface{}) (time.Time, bool) {
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// discover fetches and caches the provider metadata
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var metadata discovery
+	if err := p.getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, fmt.Errorf("failed to discover identity provider: %w", err)
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("identity provider reports issuer %q, expected %q", metadata.Issuer, p.config.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("identity provider metadata is missing endpoints")
+	}
+
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// getJSON fetches a JSON document
+func (p *Provider) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Failed to close response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
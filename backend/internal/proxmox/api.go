@@ -0,0 +1,96 @@
+package proxmox
+
+import "context"
+
+// ProxmoxAPI is the set of Proxmox operations used by the handlers and the
+// analytics collector. *Client is the production implementation; tests can
+// point a Client at the fake server in the proxmoxtest package instead.
+// Every call takes a context so request cancellation reaches Proxmox, and
+// asynchronous operations return the UPID of the task they started.
+type ProxmoxAPI interface {
+	// Containers
+	GetContainers(ctx context.Context) ([]Container, error)
+	GetInventory(ctx context.Context, withConfigs bool) (*Inventory, error)
+	GetContainer(ctx context.Context, vmid int) (*Container, error)
+	CreateContainer(ctx context.Context, vmid int, req CreateContainerRequest) (string, error)
+	StartContainer(ctx context.Context, vmid int) (string, error)
+	StopContainer(ctx context.Context, vmid int) (string, error)
+	RebootContainer(ctx context.Context, vmid int) (string, error)
+	DeleteContainer(ctx context.Context, vmid int) (string, error)
+	CloneContainer(ctx context.Context, vmid int, req CloneContainerRequest) (string, error)
+	ConvertToTemplate(ctx context.Context, vmid int) error
+	MigrateContainer(ctx context.Context, vmid int, req MigrateContainerRequest) (string, error)
+	UpdateContainerConfig(ctx context.Context, vmid int, req UpdateContainerRequest) error
+	ResizeContainerDisk(ctx context.Context, vmid int, disk string, sizeGB int) (string, error)
+	MoveContainerVolume(ctx context.Context, vmid int, disk string, req MoveVolumeRequest) (string, error)
+	GetContainerPending(ctx context.Context, vmid int) ([]PendingChange, error)
+	GetNextVMID(ctx context.Context) (int, error)
+	CreateTermProxy(ctx context.Context, node string, vmid int) (*TermProxyResponse, error)
+
+	// Virtual machines
+	GetVMs(ctx context.Context) ([]VM, error)
+	GetVM(ctx context.Context, vmid int) (*VM, error)
+	CreateVM(ctx context.Context, vmid int, req CreateVMRequest) (string, error)
+	CloneVM(ctx context.Context, vmid int, req CloneVMRequest) (string, error)
+	UpdateVMConfig(ctx context.Context, vmid int, req VMConfigRequest) error
+	StartVM(ctx context.Context, vmid int) (string, error)
+	StopVM(ctx context.Context, vmid int) (string, error)
+	RebootVM(ctx context.Context, vmid int) (string, error)
+	DeleteVM(ctx context.Context, vmid int) (string, error)
+
+	// Templates
+	GetTemplates(ctx context.Context) ([]Template, error)
+	UploadTemplate(ctx context.Context, storage string, filename string, fileData []byte) (string, error)
+
+	// Volumes
+	CreateVolume(ctx context.Context, req CreateVolumeRequest) (*Volume, error)
+	GetVolumes(ctx context.Context) ([]Volume, error)
+	GetVolume(ctx context.Context, volid string) (*Volume, error)
+	DeleteVolume(ctx context.Context, volid string) (string, error)
+	FindOrphanedVolumes(ctx context.Context) ([]OrphanedVolume, error)
+	DeleteOrphanedVolume(ctx context.Context, orphan OrphanedVolume) (string, error)
+	AttachVolume(ctx context.Context, volid string, req AttachVolumeRequest) error
+	DetachVolume(ctx context.Context, volid string, req DetachVolumeRequest) error
+
+	// Snapshots
+	CreateSnapshot(ctx context.Context, volid string, req CreateSnapshotRequest) (*Snapshot, error)
+	GetSnapshots(ctx context.Context, volid string) ([]Snapshot, error)
+	RestoreSnapshot(ctx context.Context, volid string, req RestoreSnapshotRequest) (string, error)
+	DeleteSnapshot(ctx context.Context, volid string, snapname string) (string, error)
+	CloneSnapshot(ctx context.Context, volid string, req CloneSnapshotRequest) (*Volume, error)
+	GetContainerSnapshots(ctx context.Context, vmid int) ([]Snapshot, error)
+	CreateContainerSnapshot(ctx context.Context, vmid int, req CreateSnapshotRequest) (string, error)
+	RollbackContainerSnapshot(ctx context.Context, vmid int, snapname string) (string, error)
+	DeleteContainerSnapshot(ctx context.Context, vmid int, snapname string) (string, error)
+
+	// Backups
+	CreateBackup(ctx context.Context, req CreateBackupRequest) (string, error)
+	GetBackups(ctx context.Context, storage string, vmid int) ([]Backup, error)
+	DeleteBackup(ctx context.Context, node, volid string) (string, error)
+	RestoreBackup(ctx context.Context, vmid int, req RestoreBackupRequest) (string, error)
+
+	// SDN
+	GetSDNZones(ctx context.Context) ([]SDNZone, error)
+	CreateSDNZone(ctx context.Context, zoneID string, zoneType string, nodes string, dhcp bool) error
+	DeleteSDNZone(ctx context.Context, zoneID string) error
+	CreateVNet(ctx context.Context, vnetID string, zone string, tag int) error
+	DeleteVNet(ctx context.Context, vnetID string) error
+	CreateSubnet(ctx context.Context, vnetID string, subnet string, gateway string, snat bool, dhcpRange string) error
+	GetSubnets(ctx context.Context, vnetID string) ([]map[string]interface{}, error)
+	DeleteSubnet(ctx context.Context, vnetID string, subnet string) error
+	ApplySDNConfig(ctx context.Context) error
+
+	// Cluster
+	GetNodes(ctx context.Context) ([]Node, error)
+
+	// Storage
+	GetStorage(ctx context.Context, req *GetStorageRequest) ([]Storage, error)
+	FindStorage(ctx context.Context, node string, content string) (string, error)
+
+	// Tasks
+	GetTaskStatus(ctx context.Context, upid string) (*TaskStatus, error)
+	GetTaskLog(ctx context.Context, upid string, start, limit int) ([]TaskLogLine, error)
+}
+
+// Ensure Client implements ProxmoxAPI
+var _ ProxmoxAPI = (*Client)(nil)
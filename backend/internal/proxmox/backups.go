@@ -0,0 +1,248 @@
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Backup defaults used when the request does not set them. Without a storage
+// the backup goes to the first backup storage on the guest's node.
+const (
+	DefaultBackupMode     = "snapshot"
+	DefaultBackupCompress = "zstd"
+)
+
+// backupArchivePattern matches vzdump archive names such as
+// backup/vzdump-lxc-100-2024_01_31-02_00_00.tar.zst and the ct/100 or vm/100
+// paths Proxmox Backup Server uses
+var backupArchivePattern = regexp.MustCompile(`(?:vzdump-(lxc|qemu)-|(ct|vm)/)(\d+)`)
+
+// backupGuestType returns the guest type ("lxc" or "qemu") and VMID an archive
+// was made from, or "" and 0 if the volid is not a recognisable backup
+func backupGuestType(volid string) (string, int) {
+	match := backupArchivePattern.FindStringSubmatch(extractVolumeName(volid))
+	if match == nil {
+		return "", 0
+	}
+	vmid, err := strconv.Atoi(match[3])
+	if err != nil {
+		return "", 0
+	}
+	switch {
+	case match[1] != "":
+		return match[1], vmid
+	case match[2] == "ct":
+		return "lxc", vmid
+	default:
+		return "qemu", vmid
+	}
+}
+
+// BackupOwner returns the VMID of the guest a backup archive was made from, or
+// 0 if the volid is not a recognisable backup
+func BackupOwner(volid string) int {
+	_, vmid := backupGuestType(volid)
+	return vmid
+}
+
+// CreateBackup starts a vzdump backup of a guest on the guest's node and
+// returns the UPID of the backup task
+func (c *Client) CreateBackup(ctx context.Context, req CreateBackupRequest) (string, error) {
+	node := c.nodeFor(ctx, req.VMID)
+	path := fmt.Sprintf("/nodes/%s/vzdump", node)
+
+	storage := req.Storage
+	if storage == "" {
+		var err error
+		if storage, err = c.FindStorage(ctx, node, "backup"); err != nil {
+			return "", fmt.Errorf("failed to pick backup storage: %w", err)
+		}
+	}
+	mode := req.Mode
+	if mode == "" {
+		mode = DefaultBackupMode
+	}
+	compress := req.Compress
+	if compress == "" {
+		compress = DefaultBackupCompress
+	}
+	params := map[string]interface{}{
+		"vmid":     req.VMID,
+		"storage":  storage,
+		"mode":     mode,
+		"compress": compress,
+	}
+	if req.Notes != "" {
+		params["notes-template"] = req.Notes
+	}
+
+	fmt.Printf("[DEBUG] CreateBackup: requesting path=%s (vmid=%d, storage=%s, mode=%s)\n", path, req.VMID, storage, mode)
+	respBody, err := c.doRequest(ctx, "POST", path, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to start backup: %w", err)
+	}
+	return parseUPIDResponse(respBody)
+}
+
+// GetBackups lists the backup archives in a storage, newest first. Archives of
+// only one guest are listed when vmid is set. Storage that is not shared is
+// listed on every online node; shared storage is only reported once.
+func (c *Client) GetBackups(ctx context.Context, storage string, vmid int) ([]Backup, error) {
+	nodes, err := c.onlineNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	backups := make([]Backup, 0)
+	var lastErr error
+	listed := 0
+	for _, node := range nodes {
+		path := fmt.Sprintf("/nodes/%s/storage/%s/content?content=backup", node, storage)
+		if vmid > 0 {
+			path += fmt.Sprintf("&vmid=%d", vmid)
+		}
+		fmt.Printf("[DEBUG] GetBackups: requesting path=%s\n", path)
+
+		respBody, err := c.doRequest(ctx, "GET", path, nil)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			// The storage may not be available on every node
+			fmt.Printf("[WARNING] Failed to list backups in storage '%s' on node %s: %v\n", storage, node, err)
+			lastErr = err
+			continue
+		}
+		listed++
+
+		var response struct {
+			Data []struct {
+				VolID     string      `json:"volid"`
+				Format    string      `json:"format"`
+				Size      int64       `json:"size"`
+				CTime     int64       `json:"ctime"`
+				VMID      int         `json:"vmid"`
+				Notes     string      `json:"notes"`
+				Protected interface{} `json:"protected"`
+				Subtype   string      `json:"subtype"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(respBody, &response); err != nil {
+			return nil, fmt.Errorf("failed to parse backups response: %w", err)
+		}
+
+		for _, item := range response.Data {
+			if seen[item.VolID] {
+				continue
+			}
+			seen[item.VolID] = true
+
+			backup := Backup{
+				VolID:     item.VolID,
+				Storage:   storage,
+				Node:      node,
+				VMID:      item.VMID,
+				Type:      item.Subtype,
+				Format:    item.Format,
+				Size:      item.Size,
+				Notes:     item.Notes,
+				CreatedAt: item.CTime,
+			}
+			if protected := parseBoolOrIntValue(item.Protected); protected != nil {
+				backup.Protected = *protected
+			}
+			if guestType, owner := backupGuestType(item.VolID); guestType != "" {
+				if backup.Type == "" {
+					backup.Type = guestType
+				}
+				if backup.VMID == 0 {
+					backup.VMID = owner
+				}
+			}
+			backups = append(backups, backup)
+		}
+	}
+	if listed == 0 && lastErr != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", lastErr)
+	}
+
+	sort.SliceStable(backups, func(i, j int) bool { return backups[i].CreatedAt > backups[j].CreatedAt })
+	fmt.Printf("[INFO] GetBackups: found %d backups in storage '%s'\n", len(backups), storage)
+	return backups, nil
+}
+
+// DeleteBackup removes a backup archive and returns the UPID of the delete
+// task. Archives on storage that is not shared must be deleted through the
+// node holding them; an empty node uses the configured node.
+func (c *Client) DeleteBackup(ctx context.Context, node, volid string) (string, error) {
+	parts := strings.SplitN(volid, ":", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid volid format: %s", volid)
+	}
+	storage := parts[0]
+	if node == "" {
+		node = c.node
+	}
+
+	// Backup volids contain a "/", which has to be escaped to stay one path segment
+	path := fmt.Sprintf("/nodes/%s/storage/%s/content/%s", node, storage, url.PathEscape(volid))
+	fmt.Printf("[DEBUG] DeleteBackup: requesting path=%s\n", path)
+
+	respBody, err := c.doRequest(ctx, "DELETE", path, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to delete backup: %w", err)
+	}
+	return parseUPIDResponse(respBody)
+}
+
+// RestoreBackup restores a backup archive into the guest vmid and returns the
+// UPID of the restore task. Containers and VMs are restored through their own
+// create endpoints; an existing guest is only overwritten with req.Force and
+// must be stopped.
+func (c *Client) RestoreBackup(ctx context.Context, vmid int, req RestoreBackupRequest) (string, error) {
+	guestType, _ := backupGuestType(req.VolID)
+	if guestType == "" {
+		return "", fmt.Errorf("'%s' is not a vzdump backup archive", req.VolID)
+	}
+
+	node := req.Node
+	if node == "" {
+		node = c.node
+		if req.Force {
+			node = c.nodeFor(ctx, vmid)
+		}
+	}
+
+	params := map[string]interface{}{
+		"vmid": vmid,
+	}
+	if guestType == "lxc" {
+		params["ostemplate"] = req.VolID
+		params["restore"] = 1
+	} else {
+		params["archive"] = req.VolID
+	}
+	if req.Storage != "" {
+		params["storage"] = req.Storage
+	}
+	if req.Force {
+		params["force"] = 1
+	}
+
+	path := fmt.Sprintf("/nodes/%s/%s", node, guestType)
+	fmt.Printf("[DEBUG] RestoreBackup: requesting path=%s (archive=%s, vmid=%d)\n", path, req.VolID, vmid)
+	respBody, err := c.doRequest(ctx, "POST", path, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to restore backup: %w", err)
+	}
+
+	c.rememberGuestNode(vmid, node)
+	return parseUPIDResponse(respBody)
+}
@@ -2,25 +2,55 @@ package proxmox
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// DefaultRequestTimeout is the deadline applied to a Proxmox API call when
+// the caller's context does not already carry one
+const DefaultRequestTimeout = 60 * time.Second
+
+// Default circuit breaker settings: trip after 5 consecutive failed requests
+// and probe Proxmox again after 30 seconds
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// bytesPerGB converts the byte counts Proxmox reports to the GB the API shows
+const bytesPerGB = 1024 * 1024 * 1024
+
 // Client represents a Proxmox API client
 type Client struct {
-	baseURL     string
-	node        string
-	tokenID     string
-	tokenSecret string
-	httpClient  *http.Client
+	baseURL        string
+	node           string
+	tokenID        string
+	tokenSecret    string
+	httpClient     *http.Client
+	requestTimeout time.Duration
+	retry          RetryPolicy
+	breaker        *CircuitBreaker
+	configWorkers  int
+	executor       ContainerExecutor // Measures mount point usage the storage does not report
+
+	// Node placement of guests, used to route per-guest calls in a cluster
+	guestMu      sync.Mutex
+	guestNodes   map[int]string
+	guestNodesAt time.Time
 }
 
 // NewClient creates a new Proxmox API client
@@ -50,14 +80,54 @@ func NewClient(host, node, tokenID, tokenSecret string, insecure bool) *Client {
 		tokenSecret: tokenSecret,
 		httpClient: &http.Client{
 			Transport: tr,
-			Timeout:   60 * time.Second, // Increased from 30s to 60s for slower networks
 		},
+		requestTimeout: DefaultRequestTimeout,
+		guestNodes:     make(map[int]string),
+		retry:          DefaultRetryPolicy,
+		configWorkers:  DefaultConfigConcurrency,
+		breaker:        NewCircuitBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown),
 	}
 }
 
-// doRequest performs an HTTP request to the Proxmox API
-func (c *Client) doRequest(method, path string, body interface{}) ([]byte, error) {
-	var reqBody io.Reader
+// SetRequestTimeout sets the per-call deadline used when the caller's context has none
+func (c *Client) SetRequestTimeout(timeout time.Duration) {
+	c.requestTimeout = timeout
+}
+
+// SetRetryPolicy sets how transient failures of idempotent requests are retried
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retry = policy
+}
+
+// SetConfigConcurrency sets how many guest configs are read in parallel when
+// building the inventory
+func (c *Client) SetConfigConcurrency(workers int) {
+	c.configWorkers = workers
+}
+
+// SetCircuitBreaker replaces the circuit breaker; a threshold of 0 disables it
+func (c *Client) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	c.breaker = NewCircuitBreaker(threshold, cooldown)
+}
+
+// CircuitState returns the state of the client's circuit breaker
+func (c *Client) CircuitState() string {
+	return c.breaker.State()
+}
+
+// withDeadline applies the client's per-call timeout unless ctx already has a deadline
+func (c *Client) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || c.requestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.requestTimeout)
+}
+
+// doRequest performs an HTTP request to the Proxmox API. Transient failures of
+// idempotent requests are retried with jittered exponential backoff, and
+// requests fail fast with ErrCircuitOpen while the circuit breaker is tripped.
+func (c *Client) doRequest(ctx context.Context, method, path string, body interface{}) ([]byte, error) {
+	var reqBody []byte
 	var contentType string
 
 	if body != nil {
@@ -81,7 +151,7 @@ func (c *Client) doRequest(method, path string, body interface{}) ([]byte, error
 
 			formData := formValues.Encode()
 			fmt.Printf("[DEBUG] Form data: %s\n", formData)
-			reqBody = strings.NewReader(formData)
+			reqBody = []byte(formData)
 			contentType = "application/x-www-form-urlencoded"
 		} else {
 			// For GET/DELETE, use JSON if body present
@@ -89,17 +159,87 @@ func (c *Client) doRequest(method, path string, body interface{}) ([]byte, error
 			if err != nil {
 				return nil, fmt.Errorf("failed to marshal request body: %w", err)
 			}
-			reqBody = bytes.NewBuffer(jsonData)
+			reqBody = jsonData
 			contentType = "application/json"
 		}
 	}
 
 	fullURL := c.baseURL + path
-	fmt.Printf("[DEBUG] Proxmox API Request: %s %s\n", method, fullURL)
 
-	req, err := http.NewRequest(method, fullURL, reqBody)
+	if !c.breaker.Allow() {
+		fmt.Printf("[DEBUG] Proxmox API Request: %s %s skipped, circuit breaker open\n", method, fullURL)
+		return nil, fmt.Errorf("%s %s: %w", method, path, ErrCircuitOpen)
+	}
+
+	callerCtx := ctx
+	ctx, cancel := c.withDeadline(ctx)
+	defer cancel()
+
+	maxRetries := 0
+	if isRetryableRequest(method, path) {
+		maxRetries = c.retry.MaxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			delay := c.retry.backoff(attempt)
+			fmt.Printf("[DEBUG] Retrying Proxmox API request in %v (attempt %d of %d)\n", delay, attempt+1, maxRetries+1)
+			if err := sleep(ctx, delay); err != nil {
+				return nil, c.recordFailure(callerCtx, fmt.Errorf("failed to execute request: %w", err))
+			}
+		}
+
+		fmt.Printf("[DEBUG] Proxmox API Request: %s %s\n", method, fullURL)
+		respBody, resp, err := c.send(ctx, method, fullURL, reqBody, contentType)
+
+		transient := true
+		if err == nil {
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				c.breaker.RecordSuccess()
+				return respBody, nil
+			}
+			fmt.Printf("[ERROR] Proxmox API error response: %s %s\n", resp.Status, string(respBody))
+			apiErr := newAPIError(method, path, resp, respBody)
+			transient = apiErr.IsTransient()
+			err = apiErr
+		} else {
+			fmt.Printf("[ERROR] Proxmox API request failed: %v\n", err)
+			err = fmt.Errorf("failed to execute request: %w", err)
+		}
+
+		if !transient {
+			// Proxmox answered, so it is reachable even though the request failed
+			c.breaker.RecordSuccess()
+			return nil, err
+		}
+		if attempt >= maxRetries || ctx.Err() != nil {
+			return nil, c.recordFailure(callerCtx, err)
+		}
+	}
+}
+
+// recordFailure counts a failed request against the circuit breaker unless
+// the caller itself gave up on it, and returns err unchanged
+func (c *Client) recordFailure(callerCtx context.Context, err error) error {
+	if callerCtx.Err() != nil {
+		c.breaker.abandon()
+	} else {
+		c.breaker.RecordFailure()
+	}
+	return err
+}
+
+// send performs a single HTTP round trip and returns the response body
+// along with the (already closed) response for its status
+func (c *Client) send(ctx context.Context, method, fullURL string, body []byte, contentType string) ([]byte, *http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, fullURL, reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s=%s", c.tokenID, c.tokenSecret))
@@ -109,8 +249,7 @@ func (c *Client) doRequest(method, path string, body interface{}) ([]byte, error
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		fmt.Printf("[ERROR] Proxmox API request failed: %v\n", err)
-		return nil, fmt.Errorf("failed to execute request: %w", err)
+		return nil, nil, err
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
@@ -120,7 +259,7 @@ func (c *Client) doRequest(method, path string, body interface{}) ([]byte, error
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	fmt.Printf("[DEBUG] Proxmox API Response: status=%d, body_length=%d bytes\n", resp.StatusCode, len(respBody))
@@ -134,57 +273,18 @@ func (c *Client) doRequest(method, path string, body interface{}) ([]byte, error
 		fmt.Printf("[DEBUG] Response body preview: %s\n", preview)
 	}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		fmt.Printf("[ERROR] Proxmox API error response: %s\n", string(respBody))
-		return nil, fmt.Errorf("proxmox API error (status %d): %s", resp.StatusCode, string(respBody))
-	}
-
-	return respBody, nil
+	return respBody, resp, nil
 }
 
-// GetContainers retrieves all LXC containers on the node
-func (c *Client) GetContainers() ([]Container, error) {
-	path := fmt.Sprintf("/nodes/%s/lxc", c.node)
-	fmt.Printf("[DEBUG] GetContainers: requesting path=%s\n", path)
-
-	respBody, err := c.doRequest("GET", path, nil)
+// GetContainers retrieves all LXC containers in the cluster, including their IP addresses
+func (c *Client) GetContainers(ctx context.Context) ([]Container, error) {
+	inventory, err := c.buildInventory(ctx, true, false)
 	if err != nil {
 		return nil, err
 	}
 
-	var response struct {
-		Data []Container `json:"data"`
-	}
-	if err := json.Unmarshal(respBody, &response); err != nil {
-		fmt.Printf("[ERROR] Failed to unmarshal containers response: %v\n", err)
-		fmt.Printf("[DEBUG] Raw response body: %s\n", string(respBody))
-		return nil, fmt.Errorf("failed to parse containers response: %w", err)
-	}
-
-	fmt.Printf("[INFO] GetContainers: parsed %d containers from Proxmox API\n", len(response.Data))
-
-	// Fetch IP addresses for each container
-	for i := range response.Data {
-		container := &response.Data[i]
-		configPath := fmt.Sprintf("/nodes/%s/lxc/%d/config", c.node, container.VMID)
-		configBody, err := c.doRequest("GET", configPath, nil)
-		if err == nil {
-			var configResponse struct {
-				Data map[string]interface{} `json:"data"`
-			}
-			if err := json.Unmarshal(configBody, &configResponse); err == nil {
-				// Extract IP address from net0 configuration
-				if net0, ok := configResponse.Data["net0"].(string); ok {
-					container.IPAddress = extractIPFromNetConfig(net0)
-				}
-			}
-		}
-
-		fmt.Printf("[DEBUG] Container %d: VMID=%d, Name=%s, Status=%s, IP=%s, CPU=%.2f, Mem=%d, MaxMem=%d\n",
-			i, container.VMID, container.Name, container.Status, container.IPAddress, container.CPU, container.Mem, container.MaxMem)
-	}
-
-	return response.Data, nil
+	fmt.Printf("[INFO] GetContainers: found %d containers\n", len(inventory.Containers))
+	return inventory.Containers, nil
 }
 
 // extractIPFromNetConfig extracts the IP address from a Proxmox network config string
@@ -204,46 +304,50 @@ func extractIPFromNetConfig(netConfig string) string {
 }
 
 // GetContainer retrieves a specific LXC container
-func (c *Client) GetContainer(vmid int) (*Container, error) {
-	path := fmt.Sprintf("/nodes/%s/lxc/%d/status/current", c.node, vmid)
-	respBody, err := c.doRequest("GET", path, nil)
+func (c *Client) GetContainer(ctx context.Context, vmid int) (*Container, error) {
+	node := c.nodeFor(ctx, vmid)
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/status/current", node, vmid)
+	respBody, err := c.doRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	var response struct {
-		Data Container `json:"data"`
+		Data containerStatus `json:"data"`
 	}
 	if err := json.Unmarshal(respBody, &response); err != nil {
 		return nil, fmt.Errorf("failed to parse container response: %w", err)
 	}
 
-	container := &response.Data
-
-	// Set the node field since it's not returned in status/current endpoint
-	container.Node = c.node
+	// The node is not part of the status/current response
+	container := response.Data.container(node)
 
 	// Fetch container config to get network information
-	configPath := fmt.Sprintf("/nodes/%s/lxc/%d/config", c.node, vmid)
-	configBody, err := c.doRequest("GET", configPath, nil)
-	if err == nil {
-		var configResponse struct {
-			Data map[string]interface{} `json:"data"`
-		}
-		if err := json.Unmarshal(configBody, &configResponse); err == nil {
-			// Extract IP address from net0 configuration
-			if net0, ok := configResponse.Data["net0"].(string); ok {
-				container.IPAddress = extractIPFromNetConfig(net0)
-			}
+	if config, err := c.getContainerConfig(ctx, node, vmid); err == nil {
+		if net0, ok := config["net0"].(string); ok {
+			container.IPAddress = extractIPFromNetConfig(net0)
 		}
 	}
 
-	return container, nil
+	return &container, nil
 }
 
-// CreateContainer creates a new LXC container
-func (c *Client) CreateContainer(vmid int, req CreateContainerRequest) error {
-	path := fmt.Sprintf("/nodes/%s/lxc", c.node)
+// CreateContainer creates a new LXC container on req.Node (or the default node)
+// and returns the UPID of the create task
+func (c *Client) CreateContainer(ctx context.Context, vmid int, req CreateContainerRequest) (string, error) {
+	node := req.Node
+	if node == "" {
+		node = c.node
+	}
+	path := fmt.Sprintf("/nodes/%s/lxc", node)
+
+	storage := req.Storage
+	if storage == "" {
+		var err error
+		if storage, err = c.FindStorage(ctx, node, "rootdir"); err != nil {
+			return "", fmt.Errorf("failed to pick rootfs storage: %w", err)
+		}
+	}
 
 	// Build create request
 	params := map[string]interface{}{
@@ -251,7 +355,7 @@ func (c *Client) CreateContainer(vmid int, req CreateContainerRequest) error {
 		"hostname":   req.Hostname,
 		"cores":      req.Cores,
 		"memory":     req.Memory,
-		"rootfs":     fmt.Sprintf("local-lvm:%d", req.Disk),
+		"rootfs":     fmt.Sprintf("%s:%d", storage, req.Disk),
 		"ostemplate": req.OSTemplate,
 	}
 
@@ -300,50 +404,276 @@ func (c *Client) CreateContainer(vmid int, req CreateContainerRequest) error {
 		params["unprivileged"] = 1
 	}
 
-	_, err := c.doRequest("POST", path, params)
-	return err
+	respBody, err := c.doRequest(ctx, "POST", path, params)
+	if err != nil {
+		return "", err
+	}
+	c.rememberGuestNode(vmid, node)
+	return parseUPIDResponse(respBody)
 }
 
-// StartContainer starts a container
-func (c *Client) StartContainer(vmid int) error {
-	path := fmt.Sprintf("/nodes/%s/lxc/%d/status/start", c.node, vmid)
-	_, err := c.doRequest("POST", path, nil)
-	return err
+// StartContainer starts a container and returns the UPID of the Proxmox task
+func (c *Client) StartContainer(ctx context.Context, vmid int) (string, error) {
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/status/start", c.nodeFor(ctx, vmid), vmid)
+	respBody, err := c.doRequest(ctx, "POST", path, nil)
+	if err != nil {
+		return "", err
+	}
+	return parseUPIDResponse(respBody)
 }
 
-// StopContainer stops a container
-func (c *Client) StopContainer(vmid int) error {
-	path := fmt.Sprintf("/nodes/%s/lxc/%d/status/stop", c.node, vmid)
-	_, err := c.doRequest("POST", path, nil)
-	return err
+// StopContainer stops a container and returns the UPID of the Proxmox task
+func (c *Client) StopContainer(ctx context.Context, vmid int) (string, error) {
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/status/stop", c.nodeFor(ctx, vmid), vmid)
+	respBody, err := c.doRequest(ctx, "POST", path, nil)
+	if err != nil {
+		return "", err
+	}
+	return parseUPIDResponse(respBody)
 }
 
-// RebootContainer reboots a container
-func (c *Client) RebootContainer(vmid int) error {
-	path := fmt.Sprintf("/nodes/%s/lxc/%d/status/reboot", c.node, vmid)
-	_, err := c.doRequest("POST", path, nil)
-	return err
+// RebootContainer reboots a container and returns the UPID of the Proxmox task
+func (c *Client) RebootContainer(ctx context.Context, vmid int) (string, error) {
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/status/reboot", c.nodeFor(ctx, vmid), vmid)
+	respBody, err := c.doRequest(ctx, "POST", path, nil)
+	if err != nil {
+		return "", err
+	}
+	return parseUPIDResponse(respBody)
 }
 
-// DeleteContainer deletes a container
-func (c *Client) DeleteContainer(vmid int) error {
-	path := fmt.Sprintf("/nodes/%s/lxc/%d", c.node, vmid)
-	_, err := c.doRequest("DELETE", path, nil)
-	return err
+// DeleteContainer deletes a container and returns the UPID of the Proxmox task
+func (c *Client) DeleteContainer(ctx context.Context, vmid int) (string, error) {
+	path := fmt.Sprintf("/nodes/%s/lxc/%d", c.nodeFor(ctx, vmid), vmid)
+	respBody, err := c.doRequest(ctx, "DELETE", path, nil)
+	if err != nil {
+		return "", err
+	}
+	c.forgetGuestNode(vmid)
+	return parseUPIDResponse(respBody)
+}
+
+// CloneContainer clones a container or container template and returns the
+// UPID of the clone task. Proxmox always makes a full clone of a regular
+// container; a template is cloned as a linked clone unless req.Full is set.
+func (c *Client) CloneContainer(ctx context.Context, vmid int, req CloneContainerRequest) (string, error) {
+	node := c.nodeFor(ctx, vmid)
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/clone", node, vmid)
+
+	params := map[string]interface{}{
+		"newid": req.NewID,
+	}
+	if req.Hostname != "" {
+		params["hostname"] = req.Hostname
+	}
+	if req.Full {
+		params["full"] = 1
+		if req.Storage != "" {
+			params["storage"] = req.Storage
+		}
+	}
+	target := node
+	if req.Node != "" && req.Node != node {
+		params["target"] = req.Node
+		target = req.Node
+	}
+
+	fmt.Printf("[DEBUG] CloneContainer: requesting path=%s (vmid=%d -> newid=%d on %s)\n", path, vmid, req.NewID, target)
+	respBody, err := c.doRequest(ctx, "POST", path, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to clone container: %w", err)
+	}
+
+	c.rememberGuestNode(req.NewID, target)
+	return parseUPIDResponse(respBody)
+}
+
+// ConvertToTemplate turns a stopped container into a template. Templates
+// cannot be started, only cloned.
+func (c *Client) ConvertToTemplate(ctx context.Context, vmid int) error {
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/template", c.nodeFor(ctx, vmid), vmid)
+	fmt.Printf("[DEBUG] ConvertToTemplate: requesting path=%s\n", path)
+	if _, err := c.doRequest(ctx, "POST", path, nil); err != nil {
+		return fmt.Errorf("failed to convert container to template: %w", err)
+	}
+	return nil
 }
 
-// GetTemplates retrieves available container templates
-func (c *Client) GetTemplates() ([]Template, error) {
-	// Try multiple storage locations based on user's storage configuration
-	storageLocations := []string{"local", "local-lvm"}
+// MigrateContainer moves a container to another cluster node and returns the
+// UPID of the migration task. Containers cannot be migrated live: a running
+// container needs req.Restart, which stops it, moves it and starts it again.
+func (c *Client) MigrateContainer(ctx context.Context, vmid int, req MigrateContainerRequest) (string, error) {
+	if req.Target == "" {
+		return "", fmt.Errorf("a target node is required to migrate a container")
+	}
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/migrate", c.nodeFor(ctx, vmid), vmid)
+
+	params := map[string]interface{}{
+		"target": req.Target,
+	}
+	if req.Restart {
+		params["restart"] = 1
+		if req.Timeout > 0 {
+			params["timeout"] = req.Timeout
+		}
+	}
+	if req.TargetStorage != "" {
+		params["target-storage"] = req.TargetStorage
+	}
+
+	fmt.Printf("[DEBUG] MigrateContainer: requesting path=%s (vmid=%d -> %s)\n", path, vmid, req.Target)
+	respBody, err := c.doRequest(ctx, "POST", path, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to migrate container: %w", err)
+	}
+
+	// The container changes node once the task finishes, look it up again then
+	c.forgetGuestNode(vmid)
+	return parseUPIDResponse(respBody)
+}
+
+// UpdateContainerConfig changes the settings of a container. On a running
+// container Proxmox applies what it can hot-plug and keeps the rest as
+// pending changes until the next restart (see GetContainerPending). The
+// rootfs size is changed with ResizeContainerDisk instead.
+func (c *Client) UpdateContainerConfig(ctx context.Context, vmid int, req UpdateContainerRequest) error {
+	params := make(map[string]interface{})
+	var deletes []string
+	if req.Cores != nil {
+		params["cores"] = *req.Cores
+	}
+	if req.Memory != nil {
+		params["memory"] = *req.Memory
+	}
+	if req.Swap != nil {
+		params["swap"] = *req.Swap
+	}
+	if req.Hostname != nil {
+		params["hostname"] = *req.Hostname
+	}
+	if req.Nameserver != nil {
+		if *req.Nameserver == "" {
+			deletes = append(deletes, "nameserver")
+		} else {
+			params["nameserver"] = *req.Nameserver
+		}
+	}
+	if req.OnBoot != nil {
+		if *req.OnBoot {
+			params["onboot"] = 1
+		} else {
+			params["onboot"] = 0
+		}
+	}
+	if len(deletes) > 0 {
+		params["delete"] = strings.Join(deletes, ",")
+	}
+	if len(params) == 0 {
+		return nil
+	}
+
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/config", c.nodeFor(ctx, vmid), vmid)
+	fmt.Printf("[DEBUG] UpdateContainerConfig: requesting path=%s\n", path)
+	if _, err := c.doRequest(ctx, "PUT", path, params); err != nil {
+		return fmt.Errorf("failed to update container config: %w", err)
+	}
+	return nil
+}
+
+// ResizeContainerDisk grows a container disk ("rootfs" or "mpN") to sizeGB and
+// returns the UPID of the resize task. Proxmox refuses to shrink disks.
+func (c *Client) ResizeContainerDisk(ctx context.Context, vmid int, disk string, sizeGB int) (string, error) {
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/resize", c.nodeFor(ctx, vmid), vmid)
+	params := map[string]interface{}{
+		"disk": disk,
+		"size": fmt.Sprintf("%dG", sizeGB),
+	}
+
+	fmt.Printf("[DEBUG] ResizeContainerDisk: requesting path=%s (disk=%s, size=%dG)\n", path, disk, sizeGB)
+	respBody, err := c.doRequest(ctx, "PUT", path, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to resize container disk: %w", err)
+	}
+	return parseUPIDResponse(respBody)
+}
+
+// MoveContainerVolume moves a container disk (rootfs or mpN) to another
+// storage and returns the UPID of the move task. The source volume is removed
+// unless req.KeepSource is set, in which case it stays as an unused disk.
+func (c *Client) MoveContainerVolume(ctx context.Context, vmid int, disk string, req MoveVolumeRequest) (string, error) {
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/move_volume", c.nodeFor(ctx, vmid), vmid)
+	params := map[string]interface{}{
+		"volume":  disk,
+		"storage": req.Storage,
+	}
+	if !req.KeepSource {
+		params["delete"] = 1
+	}
+
+	fmt.Printf("[DEBUG] MoveContainerVolume: requesting path=%s (disk=%s, storage=%s)\n", path, disk, req.Storage)
+	respBody, err := c.doRequest(ctx, "POST", path, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to move container volume: %w", err)
+	}
+	return parseUPIDResponse(respBody)
+}
+
+// GetContainerPending returns the config keys of a container that have
+// changes waiting for the next restart
+func (c *Client) GetContainerPending(ctx context.Context, vmid int) ([]PendingChange, error) {
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/pending", c.nodeFor(ctx, vmid), vmid)
+	respBody, err := c.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending container config: %w", err)
+	}
+
+	var response struct {
+		Data []struct {
+			Key     string      `json:"key"`
+			Value   interface{} `json:"value"`
+			Pending interface{} `json:"pending"`
+			Delete  int         `json:"delete"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse pending container config: %w", err)
+	}
+
+	// Proxmox lists every config key; only keep the ones that will change
+	changes := make([]PendingChange, 0)
+	for _, entry := range response.Data {
+		if entry.Pending == nil && entry.Delete == 0 {
+			continue
+		}
+		changes = append(changes, PendingChange{
+			Key:     entry.Key,
+			Value:   entry.Value,
+			Pending: entry.Pending,
+			Delete:  entry.Delete != 0,
+		})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes, nil
+}
+
+// GetTemplates retrieves the container templates on every storage of the
+// default node that holds them
+func (c *Client) GetTemplates(ctx context.Context) ([]Template, error) {
+	storageLocations, err := c.discoverStorages(ctx, "", "vztmpl")
+	if err != nil {
+		return nil, err
+	}
 
 	var allTemplates []Template
 
 	for _, storage := range storageLocations {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		path := fmt.Sprintf("/nodes/%s/storage/%s/content", c.node, storage)
 		fmt.Printf("[DEBUG] GetTemplates: requesting path=%s\n", path)
 
-		respBody, err := c.doRequest("GET", path, nil)
+		respBody, err := c.doRequest(ctx, "GET", path, nil)
 		if err != nil {
 			fmt.Printf("[WARNING] Failed to get templates from storage '%s': %v\n", storage, err)
 			continue // Try next storage location
@@ -383,9 +713,9 @@ func (c *Client) GetTemplates() ([]Template, error) {
 }
 
 // GetNextVMID returns the next available VMID
-func (c *Client) GetNextVMID() (int, error) {
+func (c *Client) GetNextVMID(ctx context.Context) (int, error) {
 	path := "/cluster/nextid"
-	respBody, err := c.doRequest("GET", path, nil)
+	respBody, err := c.doRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return 0, err
 	}
@@ -427,8 +757,8 @@ func (c *Client) GetNextVMID() (int, error) {
 	return 0, fmt.Errorf("failed to parse nextid data: expected int or string, got: %s", string(response.Data))
 }
 
-// UploadTemplate uploads a new container template to Proxmox storage
-func (c *Client) UploadTemplate(storage string, filename string, fileData []byte) error {
+// UploadTemplate uploads a new container template to Proxmox storage and returns the UPID of the copy task
+func (c *Client) UploadTemplate(ctx context.Context, storage string, filename string, fileData []byte) (string, error) {
 	path := fmt.Sprintf("/nodes/%s/storage/%s/upload", c.node, storage)
 	fmt.Printf("[DEBUG] UploadTemplate: uploading to path=%s, filename=%s, size=%d bytes\n", path, filename, len(fileData))
 
@@ -438,31 +768,40 @@ func (c *Client) UploadTemplate(storage string, filename string, fileData []byte
 
 	// Add content type field
 	if err := writer.WriteField("content", "vztmpl"); err != nil {
-		return fmt.Errorf("failed to write content field: %w", err)
+		return "", fmt.Errorf("failed to write content field: %w", err)
 	}
 
 	// Add the file
 	part, err := writer.CreateFormFile("filename", filename)
 	if err != nil {
-		return fmt.Errorf("failed to create form file: %w", err)
+		return "", fmt.Errorf("failed to create form file: %w", err)
 	}
 
 	if _, err := part.Write(fileData); err != nil {
-		return fmt.Errorf("failed to write file data: %w", err)
+		return "", fmt.Errorf("failed to write file data: %w", err)
 	}
 
 	contentType := writer.FormDataContentType()
 	if err := writer.Close(); err != nil {
-		return fmt.Errorf("failed to close multipart writer: %w", err)
+		return "", fmt.Errorf("failed to close multipart writer: %w", err)
 	}
 
 	// Make the request with custom content type
 	fullURL := c.baseURL + path
 	fmt.Printf("[DEBUG] Proxmox API Upload Request: POST %s\n", fullURL)
 
-	req, err := http.NewRequest("POST", fullURL, &requestBody)
+	// Uploads are never retried, but still respect and feed the circuit breaker
+	if !c.breaker.Allow() {
+		return "", fmt.Errorf("POST %s: %w", path, ErrCircuitOpen)
+	}
+
+	callerCtx := ctx
+	ctx, cancel := c.withDeadline(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fullURL, &requestBody)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return "", fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s=%s", c.tokenID, c.tokenSecret))
@@ -471,7 +810,7 @@ func (c *Client) UploadTemplate(storage string, filename string, fileData []byte
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		fmt.Printf("[ERROR] Proxmox API upload request failed: %v\n", err)
-		return fmt.Errorf("failed to execute upload request: %w", err)
+		return "", c.recordFailure(callerCtx, fmt.Errorf("failed to execute upload request: %w", err))
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
@@ -481,27 +820,28 @@ func (c *Client) UploadTemplate(storage string, filename string, fileData []byte
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read upload response: %w", err)
+		return "", fmt.Errorf("failed to read upload response: %w", err)
 	}
 
 	fmt.Printf("[DEBUG] Proxmox API Upload Response: status=%d, body_length=%d bytes\n", resp.StatusCode, len(respBody))
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		fmt.Printf("[ERROR] Proxmox API upload error response: %s\n", string(respBody))
-		return fmt.Errorf("proxmox API upload error (status %d): %s", resp.StatusCode, string(respBody))
+		fmt.Printf("[ERROR] Proxmox API upload error response: %s %s\n", resp.Status, string(respBody))
+		apiErr := newAPIError("POST", path, resp, respBody)
+		if apiErr.IsTransient() {
+			return "", c.recordFailure(callerCtx, apiErr)
+		}
+		c.breaker.RecordSuccess()
+		return "", apiErr
 	}
+	c.breaker.RecordSuccess()
 
 	fmt.Printf("[INFO] Template uploaded successfully: %s\n", filename)
-	return nil
+	return parseUPIDResponse(respBody)
 }
 
 // CreateVolume creates a new persistent volume (ZFS zvol)
-func (c *Client) CreateVolume(req CreateVolumeRequest) (*Volume, error) {
-	storage := req.Storage
-	if storage == "" {
-		storage = "local-lvm"
-	}
-
+func (c *Client) CreateVolume(ctx context.Context, req CreateVolumeRequest) (*Volume, error) {
 	volumeType := req.Type
 	if volumeType == "" {
 		volumeType = "ssd"
@@ -512,6 +852,14 @@ func (c *Client) CreateVolume(req CreateVolumeRequest) (*Volume, error) {
 		node = c.node
 	}
 
+	storage := req.Storage
+	if storage == "" {
+		var err error
+		if storage, err = c.FindStorage(ctx, node, "images"); err != nil {
+			return nil, fmt.Errorf("failed to pick volume storage: %w", err)
+		}
+	}
+
 	// Create ZFS zvol using Proxmox storage API
 	path := fmt.Sprintf("/nodes/%s/storage/%s/content", node, storage)
 	fmt.Printf("[DEBUG] CreateVolume: requesting path=%s\n", path)
@@ -522,7 +870,7 @@ func (c *Client) CreateVolume(req CreateVolumeRequest) (*Volume, error) {
 		"vmid":     0, // Not attached to any VM initially
 	}
 
-	respBody, err := c.doRequest("POST", path, params)
+	respBody, err := c.doRequest(ctx, "POST", path, params)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create volume: %w", err)
 	}
@@ -541,6 +889,7 @@ func (c *Client) CreateVolume(req CreateVolumeRequest) (*Volume, error) {
 		VolID:     response.Data,
 		Name:      req.Name,
 		Size:      int64(req.Size),
+		SizeBytes: int64(req.Size) * bytesPerGB,
 		Node:      node,
 		Storage:   storage,
 		Type:      volumeType,
@@ -552,70 +901,98 @@ func (c *Client) CreateVolume(req CreateVolumeRequest) (*Volume, error) {
 	return volume, nil
 }
 
-// GetVolumes retrieves all volumes on the node
-func (c *Client) GetVolumes() ([]Volume, error) {
-	// Query multiple storage pools
-	storageLocations := []string{"local-lvm", "local-zfs"}
+// GetVolumes retrieves all volumes across the online cluster nodes, from every
+// storage that holds disk images or container volumes
+func (c *Client) GetVolumes(ctx context.Context) ([]Volume, error) {
+	nodes, err := c.onlineNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	// Use a map to track unique volumes by volid
 	volumeMap := make(map[string]Volume)
+	// Volumes whose usage the storage listing reports, even if it is 0
+	usageReported := make(map[string]bool)
 
-	for _, storage := range storageLocations {
-		path := fmt.Sprintf("/nodes/%s/storage/%s/content", c.node, storage)
-		fmt.Printf("[DEBUG] GetVolumes: requesting path=%s\n", path)
-
-		respBody, err := c.doRequest("GET", path, nil)
+	for _, node := range nodes {
+		storageLocations, err := c.discoverStorages(ctx, node, "images", "rootdir")
 		if err != nil {
-			fmt.Printf("[WARNING] Failed to get volumes from storage '%s': %v\n", storage, err)
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			fmt.Printf("[WARNING] Failed to discover storage on node '%s': %v\n", node, err)
 			continue
 		}
+		for _, storage := range storageLocations {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			path := fmt.Sprintf("/nodes/%s/storage/%s/content", node, storage)
+			fmt.Printf("[DEBUG] GetVolumes: requesting path=%s\n", path)
 
-		var response struct {
-			Data []struct {
-				VolID   string `json:"volid"`
-				Size    int64  `json:"size"`
-				Format  string `json:"format"`
-				Content string `json:"content"`
-			} `json:"data"`
-		}
-		if err := json.Unmarshal(respBody, &response); err != nil {
-			fmt.Printf("[ERROR] Failed to unmarshal volumes response from '%s': %v\n", storage, err)
-			continue
-		}
+			respBody, err := c.doRequest(ctx, "GET", path, nil)
+			if err != nil {
+				fmt.Printf("[WARNING] Failed to get volumes from storage '%s': %v\n", storage, err)
+				continue
+			}
+
+			var response struct {
+				Data []struct {
+					VolID   string `json:"volid"`
+					Size    int64  `json:"size"`
+					Used    *int64 `json:"used"` // Reported by thin-provisioned storages
+					Format  string `json:"format"`
+					Content string `json:"content"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(respBody, &response); err != nil {
+				fmt.Printf("[ERROR] Failed to unmarshal volumes response from '%s': %v\n", storage, err)
+				continue
+			}
 
-		// Filter for images (volumes)
-		for _, item := range response.Data {
-			if item.Content == "images" {
-				volume := Volume{
-					VolID:   item.VolID,
-					Name:    extractVolumeName(item.VolID),
-					Size:    item.Size / (1024 * 1024 * 1024), // Convert bytes to GB
-					Node:    c.node,
-					Storage: storage,
-					Format:  item.Format,
-					Status:  "available", // Default status
+			// Filter for images (volumes)
+			for _, item := range response.Data {
+				if item.Content == "images" {
+					volume := Volume{
+						VolID:   item.VolID,
+						Name:    extractVolumeName(item.VolID),
+						Node:    node,
+						Storage: storage,
+						Format:  item.Format,
+						Status:  "available", // Default status
+					}
+					volume.setSize(item.Size)
+					if item.Used != nil {
+						volume.setUsed(*item.Used)
+						usageReported[item.VolID] = true
+					}
+					volumeMap[item.VolID] = volume
 				}
-				volumeMap[item.VolID] = volume
 			}
 		}
 	}
 
-	// Now check all containers to see which volumes are attached
+	// Now check all guests to see which volumes are attached
 	// This will also discover volumes that weren't in the storage content list
-	containers, err := c.GetContainers()
+	inventory, err := c.GetInventory(ctx, true)
 	if err != nil {
-		fmt.Printf("[WARNING] Failed to get containers for volume attachment check: %v\n", err)
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		fmt.Printf("[WARNING] Failed to get guests for volume attachment check: %v\n", err)
 		// Continue anyway, just won't have attachment info
 	} else {
-		// For each container, get its config to check for attached volumes
-		for _, container := range containers {
-			attachments, err := c.getContainerVolumeAttachments(container.VMID)
-			if err != nil {
-				fmt.Printf("[WARNING] Failed to get volume attachments for container %d: %v\n", container.VMID, err)
+		for _, guest := range inventory.diskHolders() {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			config, ok := inventory.Configs[guest.VMID]
+			if !ok {
 				continue
 			}
+			attachments := volumeAttachments(guest.VMID, config)
 
-			fmt.Printf("[DEBUG] Container %d has %d attachments\n", container.VMID, len(attachments))
+			fmt.Printf("[DEBUG] Container %d has %d attachments\n", guest.VMID, len(attachments))
 			for volid := range attachments {
 				fmt.Printf("[DEBUG]   - %s\n", volid)
 			}
@@ -624,22 +1001,20 @@ func (c *Client) GetVolumes() ([]Volume, error) {
 			for volid, attachment := range attachments {
 				if vol, exists := volumeMap[volid]; exists {
 					// Update existing volume with attachment info
-					fmt.Printf("[DEBUG] Updating existing volume %s: attaching to container %d (was: %v)\n", volid, container.VMID, vol.AttachedTo)
-					vmid := container.VMID // Create a copy to avoid pointer issues with loop variable
+					fmt.Printf("[DEBUG] Updating existing volume %s: attaching to container %d (was: %v)\n", volid, guest.VMID, vol.AttachedTo)
+					vmid := guest.VMID // Create a copy to avoid pointer issues with loop variable
 					vol.Status = "in-use"
 					vol.AttachedTo = &vmid
 					vol.MountPoint = attachment.MountPoint
+					vol.Node = guest.Node
 
-					// Try to get disk usage if not already set
-					if vol.Used == 0 {
-						diskUsed := c.getVolumeUsageFromContainer(vmid, attachment.MountPoint)
-						if diskUsed > 0 {
-							vol.Used = diskUsed / (1024 * 1024 * 1024) // Convert bytes to GB
-						}
+					// Measure the usage if the storage does not report it
+					if !usageReported[volid] {
+						vol.setUsed(c.guestVolumeUsage(ctx, guest, attachment.MountPoint, config))
 					}
 
 					volumeMap[volid] = vol
-					fmt.Printf("[DEBUG] Volume %s now attached to: %d, used: %d GB\n", volid, *vol.AttachedTo, vol.Used)
+					fmt.Printf("[DEBUG] Volume %s now attached to: %d, used: %d bytes\n", volid, *vol.AttachedTo, vol.UsedBytes)
 				} else {
 					// Volume not found in storage list, add it now
 					// Parse storage from volid
@@ -649,47 +1024,48 @@ func (c *Client) GetVolumes() ([]Volume, error) {
 						storage = parts[0]
 					}
 
+					vmid := guest.VMID // Create a copy to avoid pointer issues with loop variable
+					volume := Volume{
+						VolID:      volid,
+						Name:       extractVolumeName(volid),
+						Node:       guest.Node,
+						Storage:    storage,
+						Format:     "raw",
+						Status:     "in-use",
+						AttachedTo: &vmid,
+						MountPoint: attachment.MountPoint,
+					}
+
 					// Try to get size info from storage API
-					size := int64(0)
-					format := "raw"
-					volumePath := fmt.Sprintf("/nodes/%s/storage/%s/content/%s", c.node, storage, volid)
-					respBody, err := c.doRequest("GET", volumePath, nil)
+					var used *int64
+					volumePath := fmt.Sprintf("/nodes/%s/storage/%s/content/%s", guest.Node, storage, volid)
+					respBody, err := c.doRequest(ctx, "GET", volumePath, nil)
 					if err == nil {
 						var volResponse struct {
 							Data struct {
 								Size   int64  `json:"size"`
+								Used   *int64 `json:"used"`
 								Format string `json:"format"`
 							} `json:"data"`
 						}
 						if err := json.Unmarshal(respBody, &volResponse); err == nil {
-							size = volResponse.Data.Size / (1024 * 1024 * 1024) // Convert to GB
+							volume.setSize(volResponse.Data.Size)
+							used = volResponse.Data.Used
 							if volResponse.Data.Format != "" {
-								format = volResponse.Data.Format
+								volume.Format = volResponse.Data.Format
 							}
 						}
 					}
 
-					vmid := container.VMID // Create a copy to avoid pointer issues with loop variable
-					volume := Volume{
-						VolID:      volid,
-						Name:       extractVolumeName(volid),
-						Size:       size,
-						Node:       c.node,
-						Storage:    storage,
-						Format:     format,
-						Status:     "in-use",
-						AttachedTo: &vmid,
-						MountPoint: attachment.MountPoint,
-					}
-
-					// Try to get disk usage
-					diskUsed := c.getVolumeUsageFromContainer(vmid, attachment.MountPoint)
-					if diskUsed > 0 {
-						volume.Used = diskUsed / (1024 * 1024 * 1024) // Convert bytes to GB
+					// Measure the usage if the storage does not report it
+					if used != nil {
+						volume.setUsed(*used)
+					} else {
+						volume.setUsed(c.guestVolumeUsage(ctx, guest, attachment.MountPoint, config))
 					}
 
 					volumeMap[volid] = volume
-					fmt.Printf("[INFO] Discovered volume %s from container %d config, attached_to=%d, used=%d GB\n", volid, container.VMID, vmid, volume.Used)
+					fmt.Printf("[INFO] Discovered volume %s from container %d config, attached_to=%d, used=%d bytes\n", volid, guest.VMID, vmid, volume.UsedBytes)
 				}
 			}
 		}
@@ -710,48 +1086,32 @@ func (c *Client) GetVolumes() ([]Volume, error) {
 	return allVolumes, nil
 }
 
-// getContainerVolumeAttachments gets all volume attachments for a container
-// Returns a map of volid -> attachment info
-func (c *Client) getContainerVolumeAttachments(vmid int) (map[string]struct{ MountPoint string }, error) {
-	path := fmt.Sprintf("/nodes/%s/lxc/%d/config", c.node, vmid)
-	fmt.Printf("[DEBUG] getContainerVolumeAttachments: requesting path=%s\n", path)
-
-	respBody, err := c.doRequest("GET", path, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get container config: %w", err)
-	}
-
-	var response struct {
-		Data map[string]interface{} `json:"data"`
-	}
-	if err := json.Unmarshal(respBody, &response); err != nil {
-		return nil, fmt.Errorf("failed to parse container config: %w", err)
-	}
+// guestDiskKeyPattern matches config keys that attach a volume: container
+// rootfs and mount points, and VM disks
+var guestDiskKeyPattern = regexp.MustCompile(`^(?:rootfs|mp\d+|scsi\d+|virtio\d+|sata\d+|ide\d+|efidisk0|tpmstate0)$`)
 
+// volumeAttachments extracts the volumes attached to a guest from its config,
+// keyed by volid. The mount point is the config key (rootfs, mp0, scsi0, ...).
+func volumeAttachments(vmid int, config map[string]interface{}) map[string]struct{ MountPoint string } {
 	attachments := make(map[string]struct{ MountPoint string })
 
-	// Check rootfs
-	if rootfs, ok := response.Data["rootfs"].(string); ok {
-		// Parse rootfs format: "storage:volume,size=XG" or "storage:vm-XXX-disk-0,size=XG"
-		if volid := extractVolIDFromConfig(rootfs); volid != "" {
-			attachments[volid] = struct{ MountPoint string }{MountPoint: "rootfs"}
-			fmt.Printf("[DEBUG] Found rootfs volume: %s for container %d\n", volid, vmid)
+	for key, value := range config {
+		disk, ok := value.(string)
+		if !ok || !guestDiskKeyPattern.MatchString(key) {
+			continue
 		}
-	}
-
-	// Check mount points (mp0 through mp9)
-	for i := 0; i < 10; i++ {
-		mpKey := fmt.Sprintf("mp%d", i)
-		if mp, ok := response.Data[mpKey].(string); ok {
-			// Parse mount point format: "storage:volume,mp=/path"
-			if volid := extractVolIDFromConfig(mp); volid != "" {
-				attachments[volid] = struct{ MountPoint string }{MountPoint: mpKey}
-				fmt.Printf("[DEBUG] Found mount point %s volume: %s for container %d\n", mpKey, volid, vmid)
-			}
+		// CD-ROM drives reference ISO images rather than volumes
+		if disk == "none" || strings.Contains(disk, "media=cdrom") {
+			continue
+		}
+		// Formats: "storage:vm-XXX-disk-0,size=XG" or "storage:volume,mp=/path"
+		if volid := extractVolIDFromConfig(disk); volid != "" {
+			attachments[volid] = struct{ MountPoint string }{MountPoint: key}
+			fmt.Printf("[DEBUG] Found %s volume: %s for guest %d\n", key, volid, vmid)
 		}
 	}
 
-	return attachments, nil
+	return attachments
 }
 
 // extractVolIDFromConfig extracts the volume ID from a config string
@@ -777,18 +1137,19 @@ func extractVolIDFromConfig(configStr string) string {
 }
 
 // GetVolume retrieves a specific volume by volid
-func (c *Client) GetVolume(volid string) (*Volume, error) {
+func (c *Client) GetVolume(ctx context.Context, volid string) (*Volume, error) {
 	// Parse storage from volid (format: "storage:volume-name")
 	parts := strings.SplitN(volid, ":", 2)
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("invalid volid format: %s", volid)
 	}
 	storage := parts[0]
+	node := c.volumeNode(ctx, volid)
 
-	path := fmt.Sprintf("/nodes/%s/storage/%s/content/%s", c.node, storage, volid)
+	path := fmt.Sprintf("/nodes/%s/storage/%s/content/%s", node, storage, volid)
 	fmt.Printf("[DEBUG] GetVolume: requesting path=%s\n", path)
 
-	respBody, err := c.doRequest("GET", path, nil)
+	respBody, err := c.doRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get volume: %w", err)
 	}
@@ -805,6 +1166,7 @@ func (c *Client) GetVolume(volid string) (*Volume, error) {
 	var volumeID string
 	var size int64
 	var used int64
+	var usageReported bool
 	var format string
 
 	if v, ok := response.Data["volid"].(string); ok {
@@ -820,6 +1182,7 @@ func (c *Client) GetVolume(volid string) (*Volume, error) {
 	// Try to get 'used' field (may not be present for all storage types)
 	if v, ok := response.Data["used"].(float64); ok {
 		used = int64(v)
+		usageReported = true
 		fmt.Printf("[DEBUG] GetVolume: found 'used' field in API response: %d bytes\n", used)
 	} else {
 		fmt.Printf("[DEBUG] GetVolume: 'used' field not present in API response\n")
@@ -828,45 +1191,56 @@ func (c *Client) GetVolume(volid string) (*Volume, error) {
 	volume := &Volume{
 		VolID:   volumeID,
 		Name:    extractVolumeName(volumeID),
-		Size:    size / (1024 * 1024 * 1024), // Convert bytes to GB
-		Used:    used / (1024 * 1024 * 1024), // Convert bytes to GB (will be 0 if not present)
-		Node:    c.node,
+		Node:    node,
 		Storage: storage,
 		Format:  format,
 		Status:  "available",
 	}
+	volume.setSize(size)
+	volume.setUsed(used)
+
+	// Check if volume is attached to any container. Volumes are usually
+	// attached to the container their name refers to, so check that one first.
+	owner := VolumeOwner(volid)
+	if owner > 0 {
+		config, err := c.getContainerConfig(ctx, node, owner)
+		if err != nil && !IsNotFound(err) {
+			fmt.Printf("[WARNING] Failed to get volume attachments for container %d: %v\n", owner, err)
+		}
+		if attachment, found := volumeAttachments(owner, config)[volid]; found {
+			setVolumeAttachment(volume, owner, attachment.MountPoint)
+			if !usageReported {
+				volume.setUsed(c.getVolumeUsageFromContainer(ctx, node, owner, attachment.MountPoint, config))
+			}
+			fmt.Printf("[DEBUG] GetVolume final result - Size: %d GB, Used: %d GB\n", volume.Size, volume.Used)
+			return volume, nil
+		}
+	}
 
-	// Check if volume is attached to any container
-	containers, err := c.GetContainers()
+	inventory, err := c.GetInventory(ctx, true)
 	if err != nil {
-		fmt.Printf("[WARNING] Failed to get containers for volume attachment check: %v\n", err)
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		fmt.Printf("[WARNING] Failed to get guests for volume attachment check: %v\n", err)
 		// Continue anyway, just won't have attachment info
 	} else {
-		// For each container, get its config to check for attached volumes
-		for _, container := range containers {
-			attachments, err := c.getContainerVolumeAttachments(container.VMID)
-			if err != nil {
-				fmt.Printf("[WARNING] Failed to get volume attachments for container %d: %v\n", container.VMID, err)
+		for _, guest := range inventory.diskHolders() {
+			config, ok := inventory.Configs[guest.VMID]
+			if !ok || guest.VMID == owner {
 				continue
 			}
 
 			// Check if our volume is in the attachments
-			if attachment, found := attachments[volid]; found {
-				vmid := container.VMID
-				volume.Status = "in-use"
-				volume.AttachedTo = &vmid
-				volume.MountPoint = attachment.MountPoint
-				fmt.Printf("[DEBUG] Volume %s is attached to container %d at %s\n", volid, vmid, attachment.MountPoint)
+			if attachment, found := volumeAttachments(guest.VMID, config)[volid]; found {
+				setVolumeAttachment(volume, guest.VMID, attachment.MountPoint)
 
 				// If used space is not available from the API, try to get it from container stats
-				if volume.Used == 0 {
-					diskUsed := c.getVolumeUsageFromContainer(vmid, attachment.MountPoint)
-					if diskUsed > 0 {
-						volume.Used = diskUsed / (1024 * 1024 * 1024) // Convert bytes to GB
-						fmt.Printf("[DEBUG] Got volume usage from container stats: %d GB\n", volume.Used)
-					}
+				if !usageReported {
+					volume.setUsed(c.guestVolumeUsage(ctx, guest, attachment.MountPoint, config))
+					fmt.Printf("[DEBUG] Got volume usage from container stats: %d bytes\n", volume.UsedBytes)
 				}
-				break // Found attachment, no need to check other containers
+				break // Found attachment, no need to check other guests
 			}
 		}
 	}
@@ -875,85 +1249,167 @@ func (c *Client) GetVolume(volid string) (*Volume, error) {
 	return volume, nil
 }
 
-// getVolumeUsageFromContainer attempts to get disk usage for a volume from container stats
-func (c *Client) getVolumeUsageFromContainer(vmid int, mountPoint string) int64 {
-	// For rootfs, we can get usage from container stats
+// setSize sets a volume's size from bytes; Size is rounded down to whole GB
+func (v *Volume) setSize(bytes int64) {
+	v.SizeBytes = bytes
+	v.Size = bytes / bytesPerGB
+}
+
+// setUsed sets a volume's usage from bytes; Used is rounded down to whole GB
+func (v *Volume) setUsed(bytes int64) {
+	v.UsedBytes = bytes
+	v.Used = bytes / bytesPerGB
+}
+
+// setVolumeAttachment marks a volume as attached to a container
+func setVolumeAttachment(volume *Volume, vmid int, mountPoint string) {
+	volume.Status = "in-use"
+	volume.AttachedTo = &vmid
+	volume.MountPoint = mountPoint
+	fmt.Printf("[DEBUG] Volume %s is attached to container %d at %s\n", volume.VolID, vmid, mountPoint)
+}
+
+// diskHolder is a guest whose config can reference volumes
+type diskHolder struct {
+	VMID     int
+	Node     string
+	RootDisk int64 // rootfs usage in bytes, containers only
+	Running  bool  // Running container that commands can be run in
+}
+
+// diskHolders returns the containers and VMs of the inventory
+func (inv *Inventory) diskHolders() []diskHolder {
+	holders := make([]diskHolder, 0, len(inv.Containers)+len(inv.VMs))
+	for _, ct := range inv.Containers {
+		holders = append(holders, diskHolder{VMID: ct.VMID, Node: ct.Node, RootDisk: ct.Disk, Running: ct.Status == "running"})
+	}
+	for _, vm := range inv.VMs {
+		holders = append(holders, diskHolder{VMID: vm.VMID, Node: vm.Node})
+	}
+	return holders
+}
+
+// guestVolumeUsage returns the usage of a volume attached to a guest. The
+// container rootfs usage comes from inventory stats; mount points of running
+// containers are probed with df if a container executor is set. Other disks
+// return 0.
+func (c *Client) guestVolumeUsage(ctx context.Context, guest diskHolder, mountPoint string, config map[string]interface{}) int64 {
 	if mountPoint == "rootfs" {
-		statsPath := fmt.Sprintf("/nodes/%s/lxc/%d/status/current", c.node, vmid)
-		statsBody, err := c.doRequest("GET", statsPath, nil)
-		if err != nil {
-			fmt.Printf("[DEBUG] Failed to get container stats: %v\n", err)
-			return 0
-		}
+		return guest.RootDisk
+	}
+	if !guest.Running {
+		return 0
+	}
+	value, _ := config[mountPoint].(string)
+	return c.probeMountUsage(ctx, guest.Node, guest.VMID, mountPoint, value)
+}
 
-		var statsResponse struct {
-			Data struct {
-				Disk    int64 `json:"disk"`    // Current disk usage in bytes
-				MaxDisk int64 `json:"maxdisk"` // Maximum disk size in bytes
-			} `json:"data"`
-		}
-		if err := json.Unmarshal(statsBody, &statsResponse); err != nil {
-			return 0
-		}
+// getVolumeUsageFromContainer gets the usage of a volume attached to a
+// container from its stats (rootfs) or by probing the mount point (mp0-mpN)
+func (c *Client) getVolumeUsageFromContainer(ctx context.Context, node string, vmid int, mountPoint string, config map[string]interface{}) int64 {
+	if mountPoint != "rootfs" && c.executor == nil {
+		return 0
+	}
 
-		fmt.Printf("[DEBUG] Container %d rootfs disk usage: %d bytes (max: %d bytes)\n", vmid, statsResponse.Data.Disk, statsResponse.Data.MaxDisk)
-		return statsResponse.Data.Disk
+	statsPath := fmt.Sprintf("/nodes/%s/lxc/%d/status/current", node, vmid)
+	statsBody, err := c.doRequest(ctx, "GET", statsPath, nil)
+	if err != nil {
+		fmt.Printf("[DEBUG] Failed to get container stats: %v\n", err)
+		return 0
 	}
 
-	// For mount points (mp0-mp9), we cannot easily get individual usage from Proxmox API
-	// The disk usage in container stats only reflects rootfs
-	// Would need to exec into container and run 'df' command to get mount point usage
-	fmt.Printf("[DEBUG] Cannot get disk usage for mount point %s without executing commands in container\n", mountPoint)
-	return 0
+	var statsResponse struct {
+		Data struct {
+			Status  string `json:"status"`
+			Disk    int64  `json:"disk"`    // Current rootfs usage in bytes
+			MaxDisk int64  `json:"maxdisk"` // Maximum rootfs size in bytes
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(statsBody, &statsResponse); err != nil {
+		return 0
+	}
+
+	guest := diskHolder{VMID: vmid, Node: node, RootDisk: statsResponse.Data.Disk, Running: statsResponse.Data.Status == "running"}
+	if mountPoint == "rootfs" {
+		fmt.Printf("[DEBUG] Container %d rootfs disk usage: %d bytes (max: %d bytes)\n", vmid, statsResponse.Data.Disk, statsResponse.Data.MaxDisk)
+	}
+	return c.guestVolumeUsage(ctx, guest, mountPoint, config)
 }
 
-// DeleteVolume deletes a volume
-func (c *Client) DeleteVolume(volid string) error {
+// DeleteVolume deletes a volume and returns the UPID of the Proxmox task
+func (c *Client) DeleteVolume(ctx context.Context, volid string) (string, error) {
 	// Parse storage from volid
 	parts := strings.SplitN(volid, ":", 2)
 	if len(parts) != 2 {
-		return fmt.Errorf("invalid volid format: %s", volid)
+		return "", fmt.Errorf("invalid volid format: %s", volid)
 	}
-	storage := parts[0]
+	return c.deleteVolumeOn(ctx, c.volumeNode(ctx, volid), parts[0], volid)
+}
 
-	path := fmt.Sprintf("/nodes/%s/storage/%s/content/%s", c.node, storage, volid)
+// deleteVolumeOn deletes a volume through the storage API of node
+func (c *Client) deleteVolumeOn(ctx context.Context, node string, storage string, volid string) (string, error) {
+	path := fmt.Sprintf("/nodes/%s/storage/%s/content/%s", node, storage, volid)
 	fmt.Printf("[DEBUG] DeleteVolume: requesting path=%s\n", path)
 
-	_, err := c.doRequest("DELETE", path, nil)
+	respBody, err := c.doRequest(ctx, "DELETE", path, nil)
 	if err != nil {
-		return fmt.Errorf("failed to delete volume: %w", err)
+		return "", fmt.Errorf("failed to delete volume: %w", err)
 	}
 
 	fmt.Printf("[INFO] DeleteVolume: deleted volume %s\n", volid)
-	return nil
+	return parseUPIDResponse(respBody)
 }
 
-// AttachVolume attaches a volume to a container
-func (c *Client) AttachVolume(volid string, req AttachVolumeRequest) error {
-	// Determine mount point (auto-detect if not provided)
+// maxMountPoints is the number of container mount point slots (mp0-mp255)
+const maxMountPoints = 256
+
+// mountPointPattern matches container mount point config keys
+var mountPointPattern = regexp.MustCompile(`^mp(\d+)$`)
+
+// mountPathPattern matches the absolute paths volumes may be mounted at.
+// Anything else could alter the mount point config or reach a shell later.
+var mountPathPattern = regexp.MustCompile(`^(/[A-Za-z0-9._-]+)+/?$`)
+
+// AttachVolume attaches a volume to a container as a mount point. Without
+// req.MountPoint the first free mpN in the container config is used.
+func (c *Client) AttachVolume(ctx context.Context, volid string, req AttachVolumeRequest) error {
+	node := c.nodeFor(ctx, req.VMID)
+	config, err := c.getContainerConfig(ctx, node, req.VMID)
+	if err != nil {
+		return fmt.Errorf("failed to get container: %w", err)
+	}
+
+	if attachment, ok := volumeAttachments(req.VMID, config)[volid]; ok {
+		return fmt.Errorf("%w: %s is already attached to container %d at %s", ErrMountPointInUse, volid, req.VMID, attachment.MountPoint)
+	}
+
 	mountPoint := req.MountPoint
 	if mountPoint == "" {
-		// Auto-detect next available mount point (mp0-mp9)
-		_, err := c.GetContainer(req.VMID)
-		if err != nil {
-			return fmt.Errorf("failed to get container: %w", err)
+		if mountPoint, err = freeMountPoint(config); err != nil {
+			return fmt.Errorf("%w: container %d", err, req.VMID)
 		}
-
-		// Find next available mount point
-		// This is a simplified version - in production, you'd parse the container config
-		mountPoint = "mp0"
 		fmt.Printf("[INFO] Auto-detected mount point: %s\n", mountPoint)
+	} else {
+		if !IsValidMountPoint(mountPoint) {
+			return fmt.Errorf("invalid mount point '%s': must be mp0-mp%d", mountPoint, maxMountPoints-1)
+		}
+		if _, used := config[mountPoint]; used {
+			return fmt.Errorf("%w: %s of container %d", ErrMountPointInUse, mountPoint, req.VMID)
+		}
+	}
+	if req.Path != "" && !IsValidMountPath(req.Path) {
+		return fmt.Errorf("invalid mount path '%s': must be an absolute path of letters, digits and . _ -", req.Path)
 	}
 
-	path := fmt.Sprintf("/nodes/%s/lxc/%d/config", c.node, req.VMID)
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/config", node, req.VMID)
 	fmt.Printf("[DEBUG] AttachVolume: requesting path=%s\n", path)
 
 	// Attach volume using mount point configuration
 	params := map[string]interface{}{
-		mountPoint: fmt.Sprintf("%s,mp=/mnt/%s", volid, extractVolumeName(volid)),
+		mountPoint: mountPointConfig(volid, req),
 	}
 
-	_, err := c.doRequest("PUT", path, params)
+	_, err = c.doRequest(ctx, "PUT", path, params)
 	if err != nil {
 		return fmt.Errorf("failed to attach volume: %w", err)
 	}
@@ -962,10 +1418,74 @@ func (c *Client) AttachVolume(volid string, req AttachVolumeRequest) error {
 	return nil
 }
 
-// DetachVolume detaches a volume from a container
-func (c *Client) DetachVolume(volid string, req DetachVolumeRequest) error {
+// IsValidMountPoint reports whether name is a container mount point key (mp0-mp255)
+func IsValidMountPoint(name string) bool {
+	match := mountPointPattern.FindStringSubmatch(name)
+	if match == nil {
+		return false
+	}
+	n, err := strconv.Atoi(match[1])
+	return err == nil && n < maxMountPoints
+}
+
+// IsValidMountPath reports whether path is an absolute path inside a container
+// made of letters, digits and . _ - only, without . or .. components
+func IsValidMountPath(path string) bool {
+	if !mountPathPattern.MatchString(path) {
+		return false
+	}
+	for _, part := range strings.Split(path, "/") {
+		if part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// freeMountPoint returns the lowest mpN not used in a container config
+func freeMountPoint(config map[string]interface{}) (string, error) {
+	for i := 0; i < maxMountPoints; i++ {
+		key := fmt.Sprintf("mp%d", i)
+		if _, used := config[key]; !used {
+			return key, nil
+		}
+	}
+	return "", fmt.Errorf("%w: no free mount point", ErrMountPointInUse)
+}
+
+// mountPointConfig builds the mpN config value for attaching a volume
+func mountPointConfig(volid string, req AttachVolumeRequest) string {
+	containerPath := req.Path
+	if containerPath == "" {
+		containerPath = "/mnt/" + extractVolumeName(volid)
+	}
+
+	value := fmt.Sprintf("%s,mp=%s", volid, containerPath)
+	if req.Backup != nil {
+		value += ",backup=" + boolFlag(*req.Backup)
+	}
+	if req.ReadOnly {
+		value += ",ro=1"
+	}
+	if req.Quota {
+		value += ",quota=1"
+	}
+	return value
+}
+
+// boolFlag formats a boolean as a Proxmox 0/1 flag
+func boolFlag(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// DetachVolume detaches a volume from a container, removing the mount point
+// that holds it
+func (c *Client) DetachVolume(ctx context.Context, volid string, req DetachVolumeRequest) error {
 	// Get container config to find mount point
-	container, err := c.GetContainer(req.VMID)
+	container, err := c.GetContainer(ctx, req.VMID)
 	if err != nil {
 		return fmt.Errorf("failed to get container: %w", err)
 	}
@@ -975,11 +1495,22 @@ func (c *Client) DetachVolume(volid string, req DetachVolumeRequest) error {
 		return fmt.Errorf("container is running, use force=true to detach")
 	}
 
+	config, err := c.getContainerConfig(ctx, container.Node, req.VMID)
+	if err != nil {
+		return fmt.Errorf("failed to get container config: %w", err)
+	}
+
 	// Find which mount point has this volume
-	// This is simplified - in production, you'd parse the container config
-	mountPoint := "mp0"
+	attachment, ok := volumeAttachments(req.VMID, config)[volid]
+	if !ok {
+		return fmt.Errorf("%w: %s is not attached to container %d", ErrVolumeNotAttached, volid, req.VMID)
+	}
+	mountPoint := attachment.MountPoint
+	if !mountPointPattern.MatchString(mountPoint) {
+		return fmt.Errorf("cannot detach %s from container %d: it is the container's %s", volid, req.VMID, mountPoint)
+	}
 
-	path := fmt.Sprintf("/nodes/%s/lxc/%d/config", c.node, req.VMID)
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/config", container.Node, req.VMID)
 	fmt.Printf("[DEBUG] DetachVolume: requesting path=%s\n", path)
 
 	// Remove mount point configuration
@@ -987,17 +1518,17 @@ func (c *Client) DetachVolume(volid string, req DetachVolumeRequest) error {
 		"delete": mountPoint,
 	}
 
-	_, err = c.doRequest("PUT", path, params)
+	_, err = c.doRequest(ctx, "PUT", path, params)
 	if err != nil {
 		return fmt.Errorf("failed to detach volume: %w", err)
 	}
 
-	fmt.Printf("[INFO] DetachVolume: detached %s from container %d\n", volid, req.VMID)
+	fmt.Printf("[INFO] DetachVolume: detached %s from container %d at %s\n", volid, req.VMID, mountPoint)
 	return nil
 }
 
 // CreateSnapshot creates a snapshot of a volume
-func (c *Client) CreateSnapshot(volid string, req CreateSnapshotRequest) (*Snapshot, error) {
+func (c *Client) CreateSnapshot(ctx context.Context, volid string, req CreateSnapshotRequest) (*Snapshot, error) {
 	// Parse storage from volid
 	parts := strings.SplitN(volid, ":", 2)
 	if len(parts) != 2 {
@@ -1005,13 +1536,12 @@ func (c *Client) CreateSnapshot(volid string, req CreateSnapshotRequest) (*Snaps
 	}
 	storage := parts[0]
 
-	// Check if storage supports snapshots
-	// LVM-thin (local-lvm) doesn't support volume-level snapshots via this API
-	if storage == "local-lvm" || storage == "local" {
-		return nil, fmt.Errorf("storage type '%s' does not support volume-level snapshots. Use ZFS or another snapshot-capable storage backend", storage)
+	node := c.volumeNode(ctx, volid)
+	if err := c.checkVolumeSnapshots(ctx, node, storage); err != nil {
+		return nil, err
 	}
 
-	path := fmt.Sprintf("/nodes/%s/storage/%s/content/%s/snapshot", c.node, storage, volid)
+	path := fmt.Sprintf("/nodes/%s/storage/%s/content/%s/snapshot", node, storage, volid)
 	fmt.Printf("[DEBUG] CreateSnapshot: requesting path=%s\n", path)
 
 	params := map[string]interface{}{
@@ -1021,7 +1551,7 @@ func (c *Client) CreateSnapshot(volid string, req CreateSnapshotRequest) (*Snaps
 		params["description"] = req.Description
 	}
 
-	respBody, err := c.doRequest("POST", path, params)
+	respBody, err := c.doRequest(ctx, "POST", path, params)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create snapshot: %w", err)
 	}
@@ -1045,7 +1575,7 @@ func (c *Client) CreateSnapshot(volid string, req CreateSnapshotRequest) (*Snaps
 }
 
 // GetSnapshots retrieves all snapshots for a volume
-func (c *Client) GetSnapshots(volid string) ([]Snapshot, error) {
+func (c *Client) GetSnapshots(ctx context.Context, volid string) ([]Snapshot, error) {
 	// Parse storage from volid
 	parts := strings.SplitN(volid, ":", 2)
 	if len(parts) != 2 {
@@ -1053,23 +1583,20 @@ func (c *Client) GetSnapshots(volid string) ([]Snapshot, error) {
 	}
 	storage := parts[0]
 
-	// Check if storage supports snapshots
-	// LVM-thin (local-lvm) doesn't support volume-level snapshots via this API
-	// Only ZFS and some other storage types support this
-	if storage == "local-lvm" || storage == "local" {
-		fmt.Printf("[INFO] GetSnapshots: storage type '%s' does not support volume-level snapshots\n", storage)
+	node := c.volumeNode(ctx, volid)
+	if err := c.checkVolumeSnapshots(ctx, node, storage); err != nil {
+		fmt.Printf("[INFO] GetSnapshots: %v\n", err)
 		return []Snapshot{}, nil // Return empty list, not an error
 	}
 
-	path := fmt.Sprintf("/nodes/%s/storage/%s/content/%s/snapshots", c.node, storage, volid)
+	path := fmt.Sprintf("/nodes/%s/storage/%s/content/%s/snapshots", node, storage, volid)
 	fmt.Printf("[DEBUG] GetSnapshots: requesting path=%s\n", path)
 
-	respBody, err := c.doRequest("GET", path, nil)
+	respBody, err := c.doRequest(ctx, "GET", path, nil)
 	if err != nil {
-		// If we get an error about illegal characters or unsupported operation, return empty list
-		if strings.Contains(err.Error(), "illegal characters") ||
-			strings.Contains(err.Error(), "not supported") ||
-			strings.Contains(err.Error(), "500") {
+		// Storages without volume-level snapshots reject the request (or the volid) outright
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && (apiErr.IsUnsupported() || apiErr.IsValidation()) {
 			fmt.Printf("[INFO] GetSnapshots: storage does not support snapshots for %s\n", volid)
 			return []Snapshot{}, nil
 		}
@@ -1101,34 +1628,60 @@ func (c *Client) GetSnapshots(volid string) ([]Snapshot, error) {
 	return snapshots, nil
 }
 
-// RestoreSnapshot restores a volume from a snapshot
-func (c *Client) RestoreSnapshot(volid string, req RestoreSnapshotRequest) error {
+// RestoreSnapshot restores a volume from a snapshot and returns the UPID of the rollback task
+func (c *Client) RestoreSnapshot(ctx context.Context, volid string, req RestoreSnapshotRequest) (string, error) {
 	// Parse storage from volid
 	parts := strings.SplitN(volid, ":", 2)
 	if len(parts) != 2 {
-		return fmt.Errorf("invalid volid format: %s", volid)
+		return "", fmt.Errorf("invalid volid format: %s", volid)
 	}
 	storage := parts[0]
 
-	// Check if storage supports snapshots
-	if storage == "local-lvm" || storage == "local" {
-		return fmt.Errorf("storage type '%s' does not support volume-level snapshots", storage)
+	node := c.volumeNode(ctx, volid)
+	if err := c.checkVolumeSnapshots(ctx, node, storage); err != nil {
+		return "", err
 	}
 
-	path := fmt.Sprintf("/nodes/%s/storage/%s/content/%s/snapshot/%s/rollback", c.node, storage, volid, req.SnapshotName)
+	path := fmt.Sprintf("/nodes/%s/storage/%s/content/%s/snapshot/%s/rollback", node, storage, volid, req.SnapshotName)
 	fmt.Printf("[DEBUG] RestoreSnapshot: requesting path=%s\n", path)
 
-	_, err := c.doRequest("POST", path, nil)
+	respBody, err := c.doRequest(ctx, "POST", path, nil)
 	if err != nil {
-		return fmt.Errorf("failed to restore snapshot: %w", err)
+		return "", fmt.Errorf("failed to restore snapshot: %w", err)
 	}
 
 	fmt.Printf("[INFO] RestoreSnapshot: restored volume %s from snapshot %s\n", volid, req.SnapshotName)
-	return nil
+	return parseUPIDResponse(respBody)
+}
+
+// DeleteSnapshot deletes a volume snapshot and returns the UPID of the delete task
+func (c *Client) DeleteSnapshot(ctx context.Context, volid string, snapname string) (string, error) {
+	// Parse storage from volid
+	parts := strings.SplitN(volid, ":", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid volid format: %s", volid)
+	}
+	storage := parts[0]
+
+	node := c.volumeNode(ctx, volid)
+	if err := c.checkVolumeSnapshots(ctx, node, storage); err != nil {
+		return "", err
+	}
+
+	path := fmt.Sprintf("/nodes/%s/storage/%s/content/%s/snapshot/%s", node, storage, volid, snapname)
+	fmt.Printf("[DEBUG] DeleteSnapshot: requesting path=%s\n", path)
+
+	respBody, err := c.doRequest(ctx, "DELETE", path, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to delete snapshot: %w", err)
+	}
+
+	fmt.Printf("[INFO] DeleteSnapshot: deleted snapshot %s of volume %s\n", snapname, volid)
+	return parseUPIDResponse(respBody)
 }
 
 // CloneSnapshot clones a volume from a snapshot
-func (c *Client) CloneSnapshot(volid string, req CloneSnapshotRequest) (*Volume, error) {
+func (c *Client) CloneSnapshot(ctx context.Context, volid string, req CloneSnapshotRequest) (*Volume, error) {
 	// Parse storage from volid
 	parts := strings.SplitN(volid, ":", 2)
 	if len(parts) != 2 {
@@ -1139,19 +1692,18 @@ func (c *Client) CloneSnapshot(volid string, req CloneSnapshotRequest) (*Volume,
 		storage = req.Storage
 	}
 
-	// Check if storage supports snapshots
-	if storage == "local-lvm" || storage == "local" {
-		return nil, fmt.Errorf("storage type '%s' does not support volume-level snapshots", storage)
+	node := c.volumeNode(ctx, volid)
+	if err := c.checkVolumeSnapshots(ctx, node, storage); err != nil {
+		return nil, err
 	}
-
-	path := fmt.Sprintf("/nodes/%s/storage/%s/content/%s/snapshot/%s/clone", c.node, storage, volid, req.SnapshotName)
+	path := fmt.Sprintf("/nodes/%s/storage/%s/content/%s/snapshot/%s/clone", node, storage, volid, req.SnapshotName)
 	fmt.Printf("[DEBUG] CloneSnapshot: requesting path=%s\n", path)
 
 	params := map[string]interface{}{
 		"target": req.NewName,
 	}
 
-	respBody, err := c.doRequest("POST", path, params)
+	respBody, err := c.doRequest(ctx, "POST", path, params)
 	if err != nil {
 		return nil, fmt.Errorf("failed to clone snapshot: %w", err)
 	}
@@ -1166,7 +1718,7 @@ func (c *Client) CloneSnapshot(volid string, req CloneSnapshotRequest) (*Volume,
 	volume := &Volume{
 		VolID:     response.Data,
 		Name:      req.NewName,
-		Node:      c.node,
+		Node:      node,
 		Storage:   storage,
 		Format:    "raw",
 		Status:    "available",
@@ -1188,7 +1740,7 @@ func extractVolumeName(volid string) string {
 }
 
 // CreateVNet creates a VNet in a specified SDN zone
-func (c *Client) CreateVNet(vnetID string, zone string, tag int) error {
+func (c *Client) CreateVNet(ctx context.Context, vnetID string, zone string, tag int) error {
 	path := "/cluster/sdn/vnets"
 	fmt.Printf("[DEBUG] CreateVNet: requesting path=%s, vnet=%s, zone=%s, tag=%d\n", path, vnetID, zone, tag)
 
@@ -1201,7 +1753,7 @@ func (c *Client) CreateVNet(vnetID string, zone string, tag int) error {
 		params["tag"] = tag
 	}
 
-	_, err := c.doRequest("POST", path, params)
+	_, err := c.doRequest(ctx, "POST", path, params)
 	if err != nil {
 		return fmt.Errorf("failed to create VNet: %w", err)
 	}
@@ -1213,7 +1765,7 @@ func (c *Client) CreateVNet(vnetID string, zone string, tag int) error {
 // CreateSubnet creates a subnet within a VNet
 // Note: Proxmox will internally create a subnet ID in the format {vnetID}-{subnet-with-dashes}
 // Example: VNet "prj5e587" + subnet "10.0.0.0/24" creates ID "prj5e587-10.0.0.0-24"
-func (c *Client) CreateSubnet(vnetID string, subnet string, gateway string, snat bool, dhcpRange string) error {
+func (c *Client) CreateSubnet(ctx context.Context, vnetID string, subnet string, gateway string, snat bool, dhcpRange string) error {
 	path := fmt.Sprintf("/cluster/sdn/vnets/%s/subnets", vnetID)
 	fmt.Printf("[DEBUG] CreateSubnet: requesting path=%s, subnet=%s, gateway=%s, snat=%v\n", path, subnet, gateway, snat)
 
@@ -1234,7 +1786,7 @@ func (c *Client) CreateSubnet(vnetID string, subnet string, gateway string, snat
 		params["dhcp-range"] = dhcpRange
 	}
 
-	_, err := c.doRequest("POST", path, params)
+	_, err := c.doRequest(ctx, "POST", path, params)
 	if err != nil {
 		return fmt.Errorf("failed to create subnet: %w", err)
 	}
@@ -1245,11 +1797,11 @@ func (c *Client) CreateSubnet(vnetID string, subnet string, gateway string, snat
 }
 
 // GetSubnets retrieves all subnets for a VNet
-func (c *Client) GetSubnets(vnetID string) ([]map[string]interface{}, error) {
+func (c *Client) GetSubnets(ctx context.Context, vnetID string) ([]map[string]interface{}, error) {
 	path := fmt.Sprintf("/cluster/sdn/vnets/%s/subnets", vnetID)
 	fmt.Printf("[DEBUG] GetSubnets: requesting path=%s\n", path)
 
-	respBody, err := c.doRequest("GET", path, nil)
+	respBody, err := c.doRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get subnets: %w", err)
 	}
@@ -1268,11 +1820,11 @@ func (c *Client) GetSubnets(vnetID string) ([]map[string]interface{}, error) {
 // DeleteSubnet deletes a subnet from a VNet
 // Proxmox SDN subnet identifier format: {vnetID}-{subnet-with-dashes}
 // Example: For VNet "prj5e587" and subnet "10.0.0.0/24", the ID is "prj5e587-10.0.0.0-24"
-func (c *Client) DeleteSubnet(vnetID string, subnet string) error {
+func (c *Client) DeleteSubnet(ctx context.Context, vnetID string, subnet string) error {
 	fmt.Printf("[DEBUG] DeleteSubnet: attempting to delete subnet %s from VNet %s\n", subnet, vnetID)
 
 	// Get current subnets to verify it exists and see the actual identifiers
-	subnets, err := c.GetSubnets(vnetID)
+	subnets, err := c.GetSubnets(ctx, vnetID)
 	if err != nil {
 		fmt.Printf("[WARNING] Failed to list subnets: %v, proceeding anyway\n", err)
 	} else {
@@ -1291,7 +1843,7 @@ func (c *Client) DeleteSubnet(vnetID string, subnet string) error {
 	path := fmt.Sprintf("/cluster/sdn/vnets/%s/subnets/%s", vnetID, subnetID)
 	fmt.Printf("[DEBUG] DeleteSubnet: DELETE path=%s (subnetID=%s)\n", path, subnetID)
 
-	_, err = c.doRequest("DELETE", path, nil)
+	_, err = c.doRequest(ctx, "DELETE", path, nil)
 	if err != nil {
 		return fmt.Errorf("failed to delete subnet: %w", err)
 	}
@@ -1301,11 +1853,11 @@ func (c *Client) DeleteSubnet(vnetID string, subnet string) error {
 }
 
 // ApplySDNConfig applies the SDN configuration (equivalent to pressing "Apply" in GUI)
-func (c *Client) ApplySDNConfig() error {
+func (c *Client) ApplySDNConfig(ctx context.Context) error {
 	path := "/cluster/sdn"
 	fmt.Printf("[DEBUG] ApplySDNConfig: requesting path=%s\n", path)
 
-	_, err := c.doRequest("PUT", path, nil)
+	_, err := c.doRequest(ctx, "PUT", path, nil)
 	if err != nil {
 		return fmt.Errorf("failed to apply SDN config: %w", err)
 	}
@@ -1315,11 +1867,11 @@ func (c *Client) ApplySDNConfig() error {
 }
 
 // DeleteVNet deletes a VNet
-func (c *Client) DeleteVNet(vnetID string) error {
+func (c *Client) DeleteVNet(ctx context.Context, vnetID string) error {
 	path := fmt.Sprintf("/cluster/sdn/vnets/%s", vnetID)
 	fmt.Printf("[DEBUG] DeleteVNet: requesting path=%s\n", path)
 
-	_, err := c.doRequest("DELETE", path, nil)
+	_, err := c.doRequest(ctx, "DELETE", path, nil)
 	if err != nil {
 		return fmt.Errorf("failed to delete VNet: %w", err)
 	}
@@ -1329,11 +1881,11 @@ func (c *Client) DeleteVNet(vnetID string) error {
 }
 
 // GetSDNZones retrieves all available SDN zones
-func (c *Client) GetSDNZones() ([]SDNZone, error) {
+func (c *Client) GetSDNZones(ctx context.Context) ([]SDNZone, error) {
 	path := "/cluster/sdn/zones"
 	fmt.Printf("[DEBUG] GetSDNZones: requesting path=%s\n", path)
 
-	respBody, err := c.doRequest("GET", path, nil)
+	respBody, err := c.doRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get SDN zones: %w", err)
 	}
@@ -1350,7 +1902,7 @@ func (c *Client) GetSDNZones() ([]SDNZone, error) {
 }
 
 // CreateSDNZone creates a new SDN zone
-func (c *Client) CreateSDNZone(zoneID string, zoneType string, nodes string, dhcp bool) error {
+func (c *Client) CreateSDNZone(ctx context.Context, zoneID string, zoneType string, nodes string, dhcp bool) error {
 	path := "/cluster/sdn/zones"
 	fmt.Printf("[DEBUG] CreateSDNZone: requesting path=%s, zone=%s, type=%s, nodes=%s, dhcp=%v\n", path, zoneID, zoneType, nodes, dhcp)
 
@@ -1367,7 +1919,7 @@ func (c *Client) CreateSDNZone(zoneID string, zoneType string, nodes string, dhc
 		params["dhcp"] = "dnsmasq"
 	}
 
-	_, err := c.doRequest("POST", path, params)
+	_, err := c.doRequest(ctx, "POST", path, params)
 	if err != nil {
 		return fmt.Errorf("failed to create SDN zone: %w", err)
 	}
@@ -1377,11 +1929,11 @@ func (c *Client) CreateSDNZone(zoneID string, zoneType string, nodes string, dhc
 }
 
 // DeleteSDNZone deletes an SDN zone
-func (c *Client) DeleteSDNZone(zoneID string) error {
+func (c *Client) DeleteSDNZone(ctx context.Context, zoneID string) error {
 	path := fmt.Sprintf("/cluster/sdn/zones/%s", zoneID)
 	fmt.Printf("[DEBUG] DeleteSDNZone: requesting path=%s\n", path)
 
-	_, err := c.doRequest("DELETE", path, nil)
+	_, err := c.doRequest(ctx, "DELETE", path, nil)
 	if err != nil {
 		return fmt.Errorf("failed to delete SDN zone: %w", err)
 	}
@@ -1390,9 +1942,13 @@ func (c *Client) DeleteSDNZone(zoneID string) error {
 	return nil
 }
 
-// GetStorage retrieves status for all datastores on the node
-func (c *Client) GetStorage(req *GetStorageRequest) ([]Storage, error) {
-	path := fmt.Sprintf("/nodes/%s/storage", c.node)
+// GetStorage retrieves status for all datastores on req.Node (or the default node)
+func (c *Client) GetStorage(ctx context.Context, req *GetStorageRequest) ([]Storage, error) {
+	node := c.node
+	if req != nil && req.Node != "" {
+		node = req.Node
+	}
+	path := fmt.Sprintf("/nodes/%s/storage", node)
 	fmt.Printf("[DEBUG] GetStorage: requesting path=%s\n", path)
 
 	// Build query parameters if request is provided
@@ -1428,7 +1984,7 @@ func (c *Client) GetStorage(req *GetStorageRequest) ([]Storage, error) {
 		path = path + "?" + strings.Join(queryParams, "&")
 	}
 
-	respBody, err := c.doRequest("GET", path, nil)
+	respBody, err := c.doRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1454,11 +2010,11 @@ func (c *Client) GetStorage(req *GetStorageRequest) ([]Storage, error) {
 }
 
 // CreateTermProxy creates a terminal proxy connection for a container
-func (c *Client) CreateTermProxy(node string, vmid int) (*TermProxyResponse, error) {
+func (c *Client) CreateTermProxy(ctx context.Context, node string, vmid int) (*TermProxyResponse, error) {
 	path := fmt.Sprintf("/nodes/%s/lxc/%d/termproxy", node, vmid)
 	fmt.Printf("[DEBUG] CreateTermProxy: requesting path=%s, node=%s\n", path, node)
 
-	respBody, err := c.doRequest("POST", path, nil)
+	respBody, err := c.doRequest(ctx, "POST", path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create terminal proxy: %w", err)
 	}
@@ -0,0 +1,490 @@
+package proxmox_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/proxmox"
+	"github.com/MasonD-007/proxicloud/backend/internal/proxmox/proxmoxtest"
+)
+
+func TestClientCancelledContext(t *testing.T) {
+	fake := proxmoxtest.NewServer()
+	defer fake.Close()
+	fake.AddContainer(proxmox.Container{VMID: 100, Name: "web01"}, nil)
+
+	client := fake.Client()
+
+	containers, err := client.GetContainers(context.Background())
+	if err != nil {
+		t.Fatalf("GetContainers() error = %v", err)
+	}
+	if len(containers) != 1 {
+		t.Fatalf("GetContainers() returned %d containers, want 1", len(containers))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := client.GetVolumes(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetVolumes() with cancelled context error = %v, want context.Canceled", err)
+	}
+}
+
+func TestClientRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	client := proxmox.NewClient(slow.URL, "pve", "root@pam!test", "secret", false)
+	client.SetRequestTimeout(50 * time.Millisecond)
+
+	start := time.Now()
+	_, err := client.GetNextVMID(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetNextVMID() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("GetNextVMID() took %v, want it to stop at the per-call deadline", elapsed)
+	}
+
+	// A caller-supplied deadline takes precedence over the client default
+	client.SetRequestTimeout(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.GetNextVMID(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetNextVMID() with caller deadline error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestClientRetriesTransientErrors(t *testing.T) {
+	fake := proxmoxtest.NewServer()
+	defer fake.Close()
+	fake.AddContainer(proxmox.Container{VMID: 100, Name: "web01"}, nil)
+
+	client := fake.Client()
+	client.SetRetryPolicy(proxmox.RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond})
+
+	fake.FailRequests(2, http.StatusInternalServerError, "got timeout")
+	if _, err := client.GetNextVMID(context.Background()); err != nil {
+		t.Fatalf("GetNextVMID() after transient failures error = %v", err)
+	}
+	if got := fake.Requests(); got != 3 {
+		t.Errorf("GetNextVMID() sent %d requests, want 3", got)
+	}
+
+	// Errors reported by Proxmox itself are not retried
+	fake.FailRequests(1, http.StatusInternalServerError, "unable to parse value of 'vmid'")
+	if _, err := client.GetNextVMID(context.Background()); err == nil {
+		t.Errorf("GetNextVMID() error = nil, want the Proxmox error")
+	}
+	if got := fake.Requests(); got != 4 {
+		t.Errorf("GetNextVMID() with a permanent error sent %d requests, want 4", got)
+	}
+
+	// Non-idempotent writes are not retried
+	if _, err := client.GetContainer(context.Background(), 100); err != nil {
+		t.Fatalf("GetContainer() error = %v", err)
+	}
+	before := fake.Requests()
+	fake.FailRequests(1, 596, "Connection timed out")
+	if _, err := client.StartContainer(context.Background(), 100); err == nil {
+		t.Errorf("StartContainer() error = nil, want the proxy error")
+	}
+	if got := fake.Requests() - before; got != 1 {
+		t.Errorf("StartContainer() sent %d requests, want 1", got)
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	fake := proxmoxtest.NewServer()
+	defer fake.Close()
+
+	client := fake.Client()
+	client.SetRetryPolicy(proxmox.RetryPolicy{})
+	client.SetCircuitBreaker(2, 50*time.Millisecond)
+
+	fake.FailRequests(2, http.StatusServiceUnavailable, "service unavailable")
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetNextVMID(context.Background()); err == nil || errors.Is(err, proxmox.ErrCircuitOpen) {
+			t.Fatalf("GetNextVMID() call %d error = %v, want the upstream error", i+1, err)
+		}
+	}
+	if state := client.CircuitState(); state != proxmox.CircuitOpen {
+		t.Fatalf("CircuitState() = %q, want %q", state, proxmox.CircuitOpen)
+	}
+
+	// While open, calls fail fast without reaching Proxmox
+	if _, err := client.GetNextVMID(context.Background()); !errors.Is(err, proxmox.ErrCircuitOpen) {
+		t.Errorf("GetNextVMID() with open circuit error = %v, want ErrCircuitOpen", err)
+	}
+	if got := fake.Requests(); got != 2 {
+		t.Errorf("server received %d requests, want 2", got)
+	}
+
+	// After the cooldown a probe is let through and closes the circuit
+	time.Sleep(60 * time.Millisecond)
+	if _, err := client.GetNextVMID(context.Background()); err != nil {
+		t.Fatalf("GetNextVMID() after cooldown error = %v", err)
+	}
+	if state := client.CircuitState(); state != proxmox.CircuitClosed {
+		t.Errorf("CircuitState() after successful probe = %q, want %q", state, proxmox.CircuitClosed)
+	}
+}
+
+func TestClientAPIErrors(t *testing.T) {
+	fake := proxmoxtest.NewServer()
+	defer fake.Close()
+	fake.AddContainer(proxmox.Container{VMID: 100, Name: "web01", Status: "running"}, nil)
+
+	client := fake.Client()
+	ctx := context.Background()
+
+	_, err := client.GetContainer(ctx, 999)
+	var apiErr *proxmox.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("GetContainer() error = %v, want *proxmox.APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusInternalServerError || !apiErr.IsNotFound() {
+		t.Errorf("GetContainer() error = %+v, want a not-found error", apiErr)
+	}
+
+	if _, err := client.StartContainer(ctx, 100); !proxmox.IsConflict(err) {
+		t.Errorf("StartContainer() on running container error = %v, want a conflict", err)
+	}
+
+	_, err = client.CreateContainer(ctx, 101, proxmox.CreateContainerRequest{Hostname: "web02"})
+	if !errors.As(err, &apiErr) || !apiErr.IsValidation() {
+		t.Fatalf("CreateContainer() without template error = %v, want a validation error", err)
+	}
+	if _, ok := apiErr.Errors["ostemplate"]; !ok {
+		t.Errorf("CreateContainer() parameter errors = %v, want an ostemplate entry", apiErr.Errors)
+	}
+}
+
+func TestClientInventoryRequests(t *testing.T) {
+	fake := proxmoxtest.NewServer()
+	defer fake.Close()
+
+	const count = 20
+	for vmid := 100; vmid < 100+count; vmid++ {
+		volid := fake.AddVolume("local-lvm", fmt.Sprintf("vm-%d-disk-1", vmid), 4, vmid)
+		fake.AddContainer(proxmox.Container{VMID: vmid, Name: fmt.Sprintf("ct%d", vmid)}, map[string]string{
+			"net0": fmt.Sprintf("name=eth0,bridge=vmbr0,ip=10.0.0.%d/24", vmid-90),
+			"mp0":  volid + ",mp=/data",
+		})
+	}
+
+	client := fake.Client()
+	client.SetConfigConcurrency(3)
+	ctx := context.Background()
+
+	// The status-only inventory is a single cluster-wide request
+	before := fake.Requests()
+	inventory, err := client.GetInventory(ctx, false)
+	if err != nil {
+		t.Fatalf("GetInventory() error = %v", err)
+	}
+	if len(inventory.Containers) != count || inventory.Configs != nil {
+		t.Fatalf("GetInventory() = %d containers, configs %v; want %d containers, no configs", len(inventory.Containers), inventory.Configs != nil, count)
+	}
+	if got := fake.Requests() - before; got != 1 {
+		t.Errorf("GetInventory() made %d requests, want 1", got)
+	}
+
+	// GetContainers adds one config read per container for the IP address
+	before = fake.Requests()
+	containers, err := client.GetContainers(ctx)
+	if err != nil {
+		t.Fatalf("GetContainers() error = %v", err)
+	}
+	if got := fake.Requests() - before; got != 1+count {
+		t.Errorf("GetContainers() made %d requests, want %d", got, 1+count)
+	}
+	for i, ct := range containers {
+		if ct.VMID != 100+i {
+			t.Fatalf("GetContainers()[%d].VMID = %d, want %d (sorted)", i, ct.VMID, 100+i)
+		}
+		if want := fmt.Sprintf("10.0.0.%d/24", ct.VMID-90); ct.IPAddress != want {
+			t.Errorf("container %d IP = %q, want %q", ct.VMID, ct.IPAddress, want)
+		}
+	}
+
+	// GetVolumes reads each config once, not once for the IP and again for attachments
+	before = fake.Requests()
+	volumes, err := client.GetVolumes(ctx)
+	if err != nil {
+		t.Fatalf("GetVolumes() error = %v", err)
+	}
+	// node discovery + storage discovery + two storage listings + inventory + configs
+	if got, want := fake.Requests()-before, 1+1+2+1+count; got != want {
+		t.Errorf("GetVolumes() made %d requests, want %d", got, want)
+	}
+	attached := 0
+	for _, vol := range volumes {
+		if vol.AttachedTo != nil && vol.MountPoint == "mp0" {
+			attached++
+		}
+	}
+	if attached != count {
+		t.Errorf("GetVolumes() found %d attached volumes, want %d", attached, count)
+	}
+
+	// GetVolume checks the owning container before scanning the inventory
+	before = fake.Requests()
+	vol, err := client.GetVolume(ctx, "local-lvm:vm-105-disk-1")
+	if err != nil {
+		t.Fatalf("GetVolume() error = %v", err)
+	}
+	if vol.AttachedTo == nil || *vol.AttachedTo != 105 {
+		t.Errorf("GetVolume().AttachedTo = %v, want 105", vol.AttachedTo)
+	}
+	if got := fake.Requests() - before; got != 2 {
+		t.Errorf("GetVolume() made %d requests, want 2", got)
+	}
+}
+
+func TestClientStorageDiscovery(t *testing.T) {
+	fake := proxmoxtest.NewServer()
+	defer fake.Close()
+	fake.AddStorage("ceph", "rbd", "images,rootdir")
+	fake.AddStorage("nfs", "nfs", "vztmpl,backup")
+	template := fake.AddTemplate("nfs", "debian-12-standard_12.2-1_amd64.tar.zst")
+	cephVol := fake.AddVolume("ceph", "vm-0-disk-0", 4, 0)
+	lvmVol := fake.AddVolume("local-lvm", "vm-0-disk-1", 4, 0)
+	client := fake.Client()
+	ctx := context.Background()
+
+	templates, err := client.GetTemplates(ctx)
+	if err != nil {
+		t.Fatalf("GetTemplates() error = %v", err)
+	}
+	if len(templates) != 1 || templates[0].VolID != template {
+		t.Errorf("GetTemplates() = %v, want only %s", templates, template)
+	}
+
+	volumes, err := client.GetVolumes(ctx)
+	if err != nil {
+		t.Fatalf("GetVolumes() error = %v", err)
+	}
+	found := false
+	for _, vol := range volumes {
+		if vol.VolID == cephVol && vol.Storage == "ceph" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("GetVolumes() = %v, want %s on ceph", volumes, cephVol)
+	}
+
+	if storage, err := client.FindStorage(ctx, "", "backup"); err != nil || storage != "local" {
+		t.Errorf("FindStorage(backup) = %q, %v; want local", storage, err)
+	}
+	if _, err := client.FindStorage(ctx, "", "iso-missing"); err == nil {
+		t.Error("FindStorage() of unknown content error = nil, want error")
+	}
+
+	if _, err := client.CreateContainer(ctx, 100, proxmox.CreateContainerRequest{Hostname: "web01", Disk: 8, Storage: "ceph", OSTemplate: template}); err != nil {
+		t.Fatalf("CreateContainer() error = %v", err)
+	}
+	if _, config, _ := fake.Container(100); config["rootfs"] != "ceph:vm-100-disk-0,size=8G" {
+		t.Errorf("rootfs = %q, want it on ceph", config["rootfs"])
+	}
+
+	// Snapshot support follows the storage type, not its name
+	if _, err := client.CreateSnapshot(ctx, cephVol, proxmox.CreateSnapshotRequest{Name: "before"}); err != nil {
+		t.Errorf("CreateSnapshot() on rbd error = %v", err)
+	}
+	if _, err := client.CreateSnapshot(ctx, lvmVol, proxmox.CreateSnapshotRequest{Name: "before"}); !errors.Is(err, proxmox.ErrSnapshotsUnsupported) {
+		t.Errorf("CreateSnapshot() on lvmthin error = %v, want ErrSnapshotsUnsupported", err)
+	}
+}
+
+// dfExecutor answers df in containers with a fixed usage per mount path
+type dfExecutor struct {
+	used  map[string]int64 // KiB by path
+	calls int
+}
+
+func (e *dfExecutor) Exec(ctx context.Context, node string, vmid int, command ...string) ([]byte, error) {
+	e.calls++
+	path := command[len(command)-1]
+	used, ok := e.used[path]
+	if !ok {
+		return nil, fmt.Errorf("df: %s: No such file or directory", path)
+	}
+	return []byte(fmt.Sprintf("Filesystem 1024-blocks Used Available Capacity Mounted on\n/dev/loop1 10218772 %d 8000000 25%% %s\n", used, path)), nil
+}
+
+func TestClientVolumeUsage(t *testing.T) {
+	const gb = 1024 * 1024 * 1024
+	fake := proxmoxtest.NewServer()
+	defer fake.Close()
+	thin := fake.AddVolume("local-lvm", "vm-300-disk-1", 10, 300)
+	probed := fake.AddVolume("local-lvm", "vm-300-disk-2", 10, 300)
+	empty := fake.AddVolume("local-lvm", "vm-300-disk-3", 10, 300)
+	small := fake.AddVolume("local-lvm", "vm-300-disk-4", 10, 300)
+	fake.SetVolumeUsed(thin, 3*gb)
+	fake.SetVolumeUsed(empty, 0)
+	fake.AddContainer(proxmox.Container{VMID: 300, Name: "app01", Status: "running"}, map[string]string{
+		"rootfs": "local-lvm:vm-300-disk-0,size=8G",
+		"mp0":    thin + ",mp=/srv/thin,size=10G",
+		"mp1":    probed + ",mp=/srv/probed,size=10G",
+		"mp2":    empty + ",mp=/srv/empty,size=10G",
+		"mp3":    small + ",mp=/srv/small,size=10G",
+	})
+	client := fake.Client()
+	ctx := context.Background()
+
+	usage := func() map[string]int64 {
+		t.Helper()
+		volumes, err := client.GetVolumes(ctx)
+		if err != nil {
+			t.Fatalf("GetVolumes() error = %v", err)
+		}
+		used := make(map[string]int64)
+		for _, vol := range volumes {
+			if vol.Used != vol.UsedBytes/gb {
+				t.Errorf("volume %s used = %dGB, want %d bytes rounded down", vol.VolID, vol.Used, vol.UsedBytes)
+			}
+			used[vol.VolID] = vol.UsedBytes
+		}
+		return used
+	}
+
+	// Without an executor only the storage's usage is known
+	if used := usage(); used[thin] != 3*gb || used[probed] != 0 {
+		t.Errorf("GetVolumes() usage = %v, want %s=3GB and %s=0", used, thin, probed)
+	}
+
+	// df only runs for volumes the storage reports no usage for, even 0
+	exec := &dfExecutor{used: map[string]int64{
+		"/srv/probed": 5 * 1024 * 1024,
+		"/srv/thin":   9 * 1024 * 1024,
+		"/srv/empty":  9 * 1024 * 1024,
+		"/srv/small":  512 * 1024,
+	}}
+	client.SetContainerExecutor(exec)
+	used := usage()
+	if used[thin] != 3*gb || used[empty] != 0 || used[probed] != 5*gb || used[small] != gb/2 {
+		t.Errorf("GetVolumes() usage = %v, want %s=3GB and %s=0 from storage, %s=5GB and %s=512MB from df", used, thin, empty, probed, small)
+	}
+	if exec.calls != 2 {
+		t.Errorf("df ran %d times, want only for the 2 volumes the storage reports no usage for", exec.calls)
+	}
+
+	volume, err := client.GetVolume(ctx, probed)
+	if err != nil {
+		t.Fatalf("GetVolume() error = %v", err)
+	}
+	if volume.Used != 5 || volume.UsedBytes != 5*gb {
+		t.Errorf("GetVolume() used = %dGB (%d bytes), want 5GB", volume.Used, volume.UsedBytes)
+	}
+	exec.calls = 0
+	if volume, err := client.GetVolume(ctx, empty); err != nil || volume.UsedBytes != 0 || exec.calls != 0 {
+		t.Errorf("GetVolume() of %s = %+v, %v with %d df runs, want the storage's 0 and no runs", empty, volume, err, exec.calls)
+	}
+
+	// Stopped containers are not probed
+	if _, err := client.StopContainer(ctx, 300); err != nil {
+		t.Fatalf("StopContainer() error = %v", err)
+	}
+	exec.calls = 0
+	if used := usage(); used[probed] != 0 || exec.calls != 0 {
+		t.Errorf("GetVolumes() of stopped container = %v with %d df runs, want no usage and no runs", used, exec.calls)
+	}
+}
+
+func TestTaskTrackerWaitSharesPolling(t *testing.T) {
+	fake := proxmoxtest.NewServer()
+	defer fake.Close()
+	fake.AddContainer(proxmox.Container{VMID: 100, Name: "web01"}, nil)
+
+	client := fake.Client()
+	tracker := proxmox.NewTaskTracker(client)
+	tracker.SetPollInterval(10 * time.Millisecond)
+	ctx := context.Background()
+
+	upid, err := client.StartContainer(ctx, 100)
+	if err != nil {
+		t.Fatalf("StartContainer() error = %v", err)
+	}
+
+	// One status and one log request, however many callers wait
+	before := fake.Requests()
+	tracker.Track(upid, "start container 100")
+	for i := 0; i < 3; i++ {
+		task, err := tracker.Wait(ctx, upid)
+		if err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+		if !task.Succeeded() || len(task.Log) == 0 {
+			t.Fatalf("Wait() = %+v, want a successful task with its log", task)
+		}
+	}
+	if got := fake.Requests() - before; got != 2 {
+		t.Errorf("Track() and Wait() made %d requests, want 2", got)
+	}
+
+	if _, err := tracker.Wait(ctx, "not-a-upid"); err == nil {
+		t.Errorf("Wait() with invalid UPID error = nil, want an error")
+	}
+
+	// Polling stops on errors that retrying will not fix
+	unknown := "UPID:pve:00000001:00000001:00000001:vzstart:101:root@pam:"
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	before = fake.Requests()
+	if _, err := tracker.Wait(waitCtx, unknown); err == nil || waitCtx.Err() != nil {
+		t.Errorf("Wait() for unknown task error = %v, want it to stop polling", err)
+	}
+	if got := fake.Requests() - before; got != 1 {
+		t.Errorf("Wait() for unknown task made %d requests, want 1", got)
+	}
+}
+
+func TestPctExecutorQuotesSSHCommand(t *testing.T) {
+	// Stand-ins for ssh, which hands its last argument to the remote shell,
+	// and pct, which prints the arguments it received one per line
+	bin := t.TempDir()
+	scripts := map[string]string{
+		"ssh": "#!/bin/sh\nfor last; do :; done\nexec sh -c \"$last\"\n",
+		"pct": "#!/bin/sh\nprintf '%s\\n' \"$@\"\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0o755); err != nil {
+			t.Fatalf("WriteFile(%s) error = %v", name, err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	marker := filepath.Join(t.TempDir(), "pwned")
+	path := "/x;touch " + marker + ";$(touch " + marker + ")'quoted'"
+	executor := &proxmox.PctExecutor{SSHUser: "root"}
+	out, err := executor.Exec(context.Background(), "pve", 300, "df", "-P", "-k", path)
+	if err != nil {
+		t.Fatalf("Exec() error = %v", err)
+	}
+
+	want := strings.Join([]string{"exec", "300", "--", "df", "-P", "-k", path}, "\n") + "\n"
+	if string(out) != want {
+		t.Errorf("pct received %q, want %q", out, want)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("the remote shell ran a command from the path")
+	}
+}
@@ -0,0 +1,216 @@
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// guestNodeTTL is how long a guest's node placement is trusted before
+// /cluster/resources is consulted again
+const guestNodeTTL = 30 * time.Second
+
+// Node represents a node of the Proxmox cluster
+type Node struct {
+	Node    string  `json:"node"`
+	Status  string  `json:"status"` // online, offline or unknown
+	CPU     float64 `json:"cpu"`
+	MaxCPU  int     `json:"maxcpu"`
+	Mem     int64   `json:"mem"`
+	MaxMem  int64   `json:"maxmem"`
+	Disk    int64   `json:"disk"`
+	MaxDisk int64   `json:"maxdisk"`
+	Uptime  int64   `json:"uptime"`
+}
+
+// ClusterResource is an entry returned by /cluster/resources
+type ClusterResource struct {
+	ID       string  `json:"id"`   // e.g. "lxc/100", "node/pve", "storage/pve/local"
+	Type     string  `json:"type"` // node, lxc, qemu, storage, sdn
+	Node     string  `json:"node,omitempty"`
+	VMID     int     `json:"vmid,omitempty"`
+	Name     string  `json:"name,omitempty"`
+	Status   string  `json:"status,omitempty"`
+	CPU      float64 `json:"cpu,omitempty"`
+	MaxCPU   float64 `json:"maxcpu,omitempty"`
+	Mem      int64   `json:"mem,omitempty"`
+	MaxMem   int64   `json:"maxmem,omitempty"`
+	Disk     int64   `json:"disk,omitempty"`
+	MaxDisk  int64   `json:"maxdisk,omitempty"`
+	Uptime   int64   `json:"uptime,omitempty"`
+	Template int     `json:"template,omitempty"` // 1 for templates
+	Storage  string  `json:"storage,omitempty"`
+}
+
+// GetClusterResources lists cluster resources, optionally filtered by type (vm, node, storage, sdn)
+func (c *Client) GetClusterResources(ctx context.Context, resourceType string) ([]ClusterResource, error) {
+	path := "/cluster/resources"
+	if resourceType != "" {
+		path += "?type=" + resourceType
+	}
+	fmt.Printf("[DEBUG] GetClusterResources: requesting path=%s\n", path)
+
+	respBody, err := c.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster resources: %w", err)
+	}
+
+	var response struct {
+		Data []ClusterResource `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse cluster resources response: %w", err)
+	}
+
+	return response.Data, nil
+}
+
+// GetNodes lists the nodes of the cluster
+func (c *Client) GetNodes(ctx context.Context) ([]Node, error) {
+	resources, err := c.GetClusterResources(ctx, "node")
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]Node, 0, len(resources))
+	for _, res := range resources {
+		if res.Type != "node" {
+			continue
+		}
+		nodes = append(nodes, Node{
+			Node:    res.Node,
+			Status:  res.Status,
+			CPU:     res.CPU,
+			MaxCPU:  int(res.MaxCPU),
+			Mem:     res.Mem,
+			MaxMem:  res.MaxMem,
+			Disk:    res.Disk,
+			MaxDisk: res.MaxDisk,
+			Uptime:  res.Uptime,
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node < nodes[j].Node })
+
+	fmt.Printf("[INFO] GetNodes: found %d cluster nodes\n", len(nodes))
+	return nodes, nil
+}
+
+// onlineNodes returns the names of the online cluster nodes. If discovery
+// fails, only the configured default node is used.
+func (c *Client) onlineNodes(ctx context.Context) ([]string, error) {
+	nodes, err := c.GetNodes(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		fmt.Printf("[WARNING] Failed to discover cluster nodes, using node %s only: %v\n", c.node, err)
+		return []string{c.node}, nil
+	}
+
+	names := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		if n.Status == "online" {
+			names = append(names, n.Node)
+		}
+	}
+	if len(names) == 0 {
+		return []string{c.node}, nil
+	}
+	return names, nil
+}
+
+// nodeFor returns the node a guest lives on, refreshing the placement map from
+// /cluster/resources when the guest is unknown or the map is stale. Unknown
+// guests fall back to the default node so Proxmox reports the real error.
+func (c *Client) nodeFor(ctx context.Context, vmid int) string {
+	c.guestMu.Lock()
+	node, ok := c.guestNodes[vmid]
+	fresh := time.Since(c.guestNodesAt) < guestNodeTTL
+	c.guestMu.Unlock()
+	if ok && fresh {
+		return node
+	}
+
+	if err := c.refreshGuestNodes(ctx); err != nil {
+		fmt.Printf("[WARNING] Failed to look up node for guest %d: %v\n", vmid, err)
+		if ok {
+			return node
+		}
+		return c.node
+	}
+
+	c.guestMu.Lock()
+	defer c.guestMu.Unlock()
+	if node, ok := c.guestNodes[vmid]; ok {
+		return node
+	}
+	return c.node
+}
+
+// refreshGuestNodes rebuilds the guest placement map from /cluster/resources
+func (c *Client) refreshGuestNodes(ctx context.Context) error {
+	resources, err := c.GetClusterResources(ctx, "vm")
+	if err != nil {
+		return err
+	}
+	c.setGuestNodes(resources)
+	return nil
+}
+
+// setGuestNodes replaces the guest placement map with the given vm resources
+func (c *Client) setGuestNodes(resources []ClusterResource) {
+	placement := make(map[int]string, len(resources))
+	for _, res := range resources {
+		if res.VMID > 0 && res.Node != "" {
+			placement[res.VMID] = res.Node
+		}
+	}
+
+	c.guestMu.Lock()
+	defer c.guestMu.Unlock()
+	c.guestNodes = placement
+	c.guestNodesAt = time.Now()
+}
+
+// rememberGuestNode records where a guest lives without a full refresh
+func (c *Client) rememberGuestNode(vmid int, node string) {
+	c.guestMu.Lock()
+	defer c.guestMu.Unlock()
+	c.guestNodes[vmid] = node
+}
+
+// forgetGuestNode drops a deleted guest from the placement map
+func (c *Client) forgetGuestNode(vmid int) {
+	c.guestMu.Lock()
+	defer c.guestMu.Unlock()
+	delete(c.guestNodes, vmid)
+}
+
+// volumeOwnerPattern matches Proxmox disk names such as vm-100-disk-0 or subvol-100-disk-1
+var volumeOwnerPattern = regexp.MustCompile(`^(?:vm|subvol|base)-(\d+)-`)
+
+// VolumeOwner returns the VMID encoded in a volume name, or 0 if there is none
+func VolumeOwner(volid string) int {
+	match := volumeOwnerPattern.FindStringSubmatch(extractVolumeName(volid))
+	if match == nil {
+		return 0
+	}
+	vmid, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0
+	}
+	return vmid
+}
+
+// volumeNode returns the node to address a volume's storage through: the node
+// of the guest that owns it, or the default node for unowned volumes
+func (c *Client) volumeNode(ctx context.Context, volid string) string {
+	if owner := VolumeOwner(volid); owner > 0 {
+		return c.nodeFor(ctx, owner)
+	}
+	return c.node
+}
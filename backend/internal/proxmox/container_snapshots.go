@@ -0,0 +1,129 @@
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// currentSnapshotName is the pseudo snapshot Proxmox lists for the guest's current state
+const currentSnapshotName = "current"
+
+// SnapshotTreeNode is a container snapshot together with the snapshots taken on top of it
+type SnapshotTreeNode struct {
+	Snapshot
+	Children []*SnapshotTreeNode `json:"children,omitempty"`
+}
+
+// GetContainerSnapshots lists the snapshots of a container, oldest first, with
+// their parent links. The snapshot the current state is based on is marked Current.
+func (c *Client) GetContainerSnapshots(ctx context.Context, vmid int) ([]Snapshot, error) {
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/snapshot", c.nodeFor(ctx, vmid), vmid)
+	fmt.Printf("[DEBUG] GetContainerSnapshots: requesting path=%s\n", path)
+
+	respBody, err := c.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container snapshots: %w", err)
+	}
+
+	var response struct {
+		Data []struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+			SnapTime    int64  `json:"snaptime"`
+			Parent      string `json:"parent"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse container snapshots response: %w", err)
+	}
+
+	current := ""
+	snapshots := make([]Snapshot, 0, len(response.Data))
+	for _, s := range response.Data {
+		if s.Name == currentSnapshotName {
+			current = s.Parent
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{
+			Name:        s.Name,
+			VMID:        vmid,
+			Description: s.Description,
+			CreatedAt:   s.SnapTime,
+			Parent:      s.Parent,
+		})
+	}
+	for i := range snapshots {
+		snapshots[i].Current = snapshots[i].Name == current
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt < snapshots[j].CreatedAt })
+
+	return snapshots, nil
+}
+
+// CreateContainerSnapshot snapshots a whole container (config and every disk)
+// and returns the UPID of the snapshot task
+func (c *Client) CreateContainerSnapshot(ctx context.Context, vmid int, req CreateSnapshotRequest) (string, error) {
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/snapshot", c.nodeFor(ctx, vmid), vmid)
+	params := map[string]interface{}{
+		"snapname": req.Name,
+	}
+	if req.Description != "" {
+		params["description"] = req.Description
+	}
+
+	fmt.Printf("[DEBUG] CreateContainerSnapshot: requesting path=%s (snapname=%s)\n", path, req.Name)
+	respBody, err := c.doRequest(ctx, "POST", path, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to create container snapshot: %w", err)
+	}
+	return parseUPIDResponse(respBody)
+}
+
+// RollbackContainerSnapshot returns a container to a snapshot and returns the
+// UPID of the rollback task. Proxmox stops a running container to roll it back.
+func (c *Client) RollbackContainerSnapshot(ctx context.Context, vmid int, snapname string) (string, error) {
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/snapshot/%s/rollback", c.nodeFor(ctx, vmid), vmid, url.PathEscape(snapname))
+	fmt.Printf("[DEBUG] RollbackContainerSnapshot: requesting path=%s\n", path)
+
+	respBody, err := c.doRequest(ctx, "POST", path, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to roll back container snapshot: %w", err)
+	}
+	return parseUPIDResponse(respBody)
+}
+
+// DeleteContainerSnapshot deletes a container snapshot and returns the UPID of
+// the delete task. Snapshots taken on top of it are re-parented by Proxmox.
+func (c *Client) DeleteContainerSnapshot(ctx context.Context, vmid int, snapname string) (string, error) {
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/snapshot/%s", c.nodeFor(ctx, vmid), vmid, url.PathEscape(snapname))
+	fmt.Printf("[DEBUG] DeleteContainerSnapshot: requesting path=%s\n", path)
+
+	respBody, err := c.doRequest(ctx, "DELETE", path, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to delete container snapshot: %w", err)
+	}
+	return parseUPIDResponse(respBody)
+}
+
+// BuildSnapshotTree arranges snapshots by their parent links. Snapshots whose
+// parent is missing from the list become roots.
+func BuildSnapshotTree(snapshots []Snapshot) []*SnapshotTreeNode {
+	nodes := make(map[string]*SnapshotTreeNode, len(snapshots))
+	for _, s := range snapshots {
+		nodes[s.Name] = &SnapshotTreeNode{Snapshot: s}
+	}
+
+	roots := make([]*SnapshotTreeNode, 0)
+	for _, s := range snapshots {
+		node := nodes[s.Name]
+		if parent, ok := nodes[s.Parent]; ok && s.Parent != s.Name {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
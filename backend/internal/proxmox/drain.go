@@ -0,0 +1,219 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultDrainConcurrency is the number of containers a node drain migrates at once
+const DefaultDrainConcurrency = 2
+
+// Drain and drain item status values
+const (
+	DrainRunning   = "running"
+	DrainCompleted = "completed"
+	DrainFailed    = "failed" // At least one container could not be migrated
+
+	DrainPending   = "pending"
+	DrainMigrating = "migrating"
+	DrainMigrated  = "migrated"
+)
+
+// ErrDrainRunning is returned when a node is drained while a drain of it is still running
+var ErrDrainRunning = errors.New("node is already being drained")
+
+// DrainNodeRequest holds parameters for migrating every container off a node
+type DrainNodeRequest struct {
+	Target        string `json:"target"`                   // Node to move the containers to
+	TargetStorage string `json:"target_storage,omitempty"` // Storage on the target, or a "source:target,..." mapping
+	Timeout       int    `json:"timeout,omitempty"`        // Seconds to wait for each container to shut down
+	Concurrency   int    `json:"concurrency,omitempty"`    // Migrations in flight at once (default: DefaultDrainConcurrency)
+}
+
+// NodeDrain is the progress of a node drain
+type NodeDrain struct {
+	Node       string      `json:"node"`
+	Target     string      `json:"target"`
+	Status     string      `json:"status"` // running, completed or failed
+	StartedAt  int64       `json:"started_at"`
+	FinishedAt int64       `json:"finished_at,omitempty"`
+	Containers []DrainItem `json:"containers"`
+}
+
+// DrainItem is the migration progress of one container of a node drain
+type DrainItem struct {
+	VMID   int    `json:"vmid"`
+	Name   string `json:"name"`
+	Status string `json:"status"` // pending, migrating, migrated or failed
+	TaskID string `json:"task_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// DrainManager runs node drains in the background and keeps the latest drain
+// of every node so its progress can be polled
+type DrainManager struct {
+	client  ProxmoxAPI
+	tasks   *TaskTracker
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	drains map[string]*NodeDrain
+}
+
+// NewDrainManager creates a drain manager that follows migrations through tasks
+func NewDrainManager(client ProxmoxAPI, tasks *TaskTracker) *DrainManager {
+	return &DrainManager{
+		client:  client,
+		tasks:   tasks,
+		timeout: 24 * time.Hour,
+		drains:  make(map[string]*NodeDrain),
+	}
+}
+
+// Start lists the containers on node and starts migrating them to req.Target
+// with restart migration, at most req.Concurrency at a time. It returns the
+// initial progress; only one drain per node can run at a time.
+func (m *DrainManager) Start(ctx context.Context, node string, req DrainNodeRequest) (*NodeDrain, error) {
+	if req.Target == "" {
+		return nil, fmt.Errorf("a target node is required to drain a node")
+	}
+	if req.Target == node {
+		return nil, fmt.Errorf("cannot drain node %s onto itself", node)
+	}
+
+	inventory, err := m.client.GetInventory(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	drain := &NodeDrain{
+		Node:       node,
+		Target:     req.Target,
+		Status:     DrainRunning,
+		StartedAt:  time.Now().Unix(),
+		Containers: make([]DrainItem, 0),
+	}
+	for _, ct := range inventory.Containers {
+		if ct.Node == node {
+			drain.Containers = append(drain.Containers, DrainItem{VMID: ct.VMID, Name: ct.Name, Status: DrainPending})
+		}
+	}
+
+	m.mu.Lock()
+	if existing, ok := m.drains[node]; ok && existing.Status == DrainRunning {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrDrainRunning, node)
+	}
+	m.drains[node] = drain
+	snapshot := drain.copy()
+	m.mu.Unlock()
+
+	go m.run(drain, req)
+	return snapshot, nil
+}
+
+// Get returns the progress of the latest drain of a node
+func (m *DrainManager) Get(node string) (*NodeDrain, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	drain, ok := m.drains[node]
+	if !ok {
+		return nil, false
+	}
+	return drain.copy(), true
+}
+
+// run migrates the containers of a drain through a bounded pool of workers
+func (m *DrainManager) run(drain *NodeDrain, req DrainNodeRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	workers := req.Concurrency
+	if workers <= 0 {
+		workers = DefaultDrainConcurrency
+	}
+	if workers > len(drain.Containers) {
+		workers = len(drain.Containers)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				m.migrate(ctx, drain, index, req)
+			}
+		}()
+	}
+	for index := range drain.Containers {
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	drain.Status = DrainCompleted
+	for _, item := range drain.Containers {
+		if item.Status != DrainMigrated {
+			drain.Status = DrainFailed
+		}
+	}
+	drain.FinishedAt = time.Now().Unix()
+	log.Printf("[INFO] Drain of node %s to %s %s", drain.Node, drain.Target, drain.Status)
+}
+
+// migrate moves one container of a drain and records the outcome
+func (m *DrainManager) migrate(ctx context.Context, drain *NodeDrain, index int, req DrainNodeRequest) {
+	vmid := drain.Containers[index].VMID
+	update := func(status, taskID, errMsg string) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		item := &drain.Containers[index]
+		item.Status = status
+		if taskID != "" {
+			item.TaskID = taskID
+		}
+		item.Error = errMsg
+	}
+
+	update(DrainMigrating, "", "")
+	upid, err := m.client.MigrateContainer(ctx, vmid, MigrateContainerRequest{
+		Target:        req.Target,
+		Restart:       true,
+		Timeout:       req.Timeout,
+		TargetStorage: req.TargetStorage,
+	})
+	if err != nil {
+		log.Printf("[WARNING] Drain of node %s: failed to migrate container %d: %v", drain.Node, vmid, err)
+		update(DrainFailed, "", err.Error())
+		return
+	}
+	update(DrainMigrating, upid, "")
+
+	m.tasks.Track(upid, fmt.Sprintf("migrate container %d to %s", vmid, req.Target))
+	task, err := m.tasks.Wait(ctx, upid)
+	switch {
+	case err != nil:
+		update(DrainFailed, "", fmt.Sprintf("stopped waiting for task: %v", err))
+	case !task.Succeeded():
+		log.Printf("[WARNING] Drain of node %s: migration of container %d failed: %s", drain.Node, vmid, task.ExitStatus)
+		update(DrainFailed, "", task.ExitStatus)
+	default:
+		update(DrainMigrated, "", "")
+	}
+}
+
+// copy returns a snapshot of the drain safe to hand to callers
+func (d *NodeDrain) copy() *NodeDrain {
+	c := *d
+	c.Containers = append([]DrainItem(nil), d.Containers...)
+	return &c
+}
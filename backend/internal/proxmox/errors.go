@@ -0,0 +1,154 @@
+package proxmox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrMountPointInUse is returned when attaching a volume to a mount point that is
+	// taken, or to a container that already has the volume or has no free mount point
+	ErrMountPointInUse = errors.New("mount point in use")
+	// ErrVolumeNotAttached is returned when detaching a volume the container does not have
+	ErrVolumeNotAttached = errors.New("volume not attached")
+	// ErrSnapshotsUnsupported is returned for volume snapshot operations on a
+	// storage type that cannot snapshot single volumes
+	ErrSnapshotsUnsupported = errors.New("storage does not support volume-level snapshots")
+)
+
+// APIError is an error response returned by the Proxmox API
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string            // Proxmox error message (or HTTP reason phrase)
+	Errors     map[string]string // Per-parameter validation errors, keyed by parameter name
+}
+
+// newAPIError builds an APIError from a non-2xx Proxmox response. Proxmox puts
+// the message in the HTTP reason phrase and, depending on the endpoint, in the
+// body's "message" field; parameter validation failures carry an "errors" map.
+func newAPIError(method, path string, resp *http.Response, respBody []byte) *APIError {
+	apiErr := &APIError{
+		Method:     method,
+		Path:       path,
+		StatusCode: resp.StatusCode,
+	}
+
+	var body struct {
+		Message string            `json:"message"`
+		Errors  map[string]string `json:"errors"`
+	}
+	if err := json.Unmarshal(respBody, &body); err == nil {
+		apiErr.Message = strings.TrimSpace(body.Message)
+		apiErr.Errors = body.Errors
+	}
+
+	if apiErr.Message == "" {
+		reason := strings.TrimSpace(strings.TrimPrefix(resp.Status, fmt.Sprintf("%d", resp.StatusCode)))
+		if reason != "" && reason != http.StatusText(resp.StatusCode) {
+			apiErr.Message = reason
+		}
+	}
+	if apiErr.Message == "" && len(respBody) > 0 && !json.Valid(respBody) {
+		apiErr.Message = strings.TrimSpace(string(respBody))
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+
+	return apiErr
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("proxmox API error (status %d): %s", e.StatusCode, e.Message)
+	if len(e.Errors) == 0 {
+		return msg
+	}
+
+	params := make([]string, 0, len(e.Errors))
+	for param := range e.Errors {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	for _, param := range params {
+		msg += fmt.Sprintf("; %s: %s", param, e.Errors[param])
+	}
+	return msg
+}
+
+// contains reports whether the error message contains any of the given phrases
+func (e *APIError) contains(phrases ...string) bool {
+	msg := strings.ToLower(e.Message)
+	for _, phrase := range phrases {
+		if strings.Contains(msg, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNotFound reports whether the requested guest, volume or object does not exist.
+// Proxmox returns most of these as 500, so the message is inspected as well.
+func (e *APIError) IsNotFound() bool {
+	return e.StatusCode == http.StatusNotFound ||
+		e.contains("does not exist", "no such", "not found", "unable to find")
+}
+
+// IsConflict reports whether the request clashes with the current state of the
+// object, e.g. it is locked, already exists or is in the wrong power state
+func (e *APIError) IsConflict() bool {
+	return e.StatusCode == http.StatusConflict ||
+		e.contains("already exists", "already defined", "already used", "already running",
+			"not running", "is running", "is locked", "can't lock", "lock file")
+}
+
+// IsValidation reports whether Proxmox rejected the request parameters
+func (e *APIError) IsValidation() bool {
+	return e.StatusCode == http.StatusBadRequest || len(e.Errors) > 0
+}
+
+// IsUnsupported reports whether the operation is not available for the target,
+// e.g. snapshots on a storage type that has none
+func (e *APIError) IsUnsupported() bool {
+	return e.StatusCode == http.StatusNotImplemented ||
+		e.contains("not supported", "does not support", "not implemented", "feature is not available")
+}
+
+// IsTransient reports whether the error indicates a temporary proxy or node
+// problem rather than an error in the request itself
+func (e *APIError) IsTransient() bool {
+	switch e.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout,
+		595, // pve-proxy: no route to node / connection refused
+		596: // pve-proxy: connection timed out
+		return true
+	case http.StatusInternalServerError:
+		// Proxmox reports most errors as 500, only retry the ones from its proxy layer
+		return e.contains("got timeout", "connection reset", "connection refused", "temporarily unavailable")
+	}
+	return false
+}
+
+// IsNotFound reports whether err is a Proxmox "does not exist" error
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.IsNotFound()
+}
+
+// IsConflict reports whether err is a Proxmox lock or state conflict
+func IsConflict(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.IsConflict()
+}
+
+// IsValidation reports whether err is a Proxmox parameter validation error
+func IsValidation(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.IsValidation()
+}
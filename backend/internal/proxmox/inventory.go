@@ -0,0 +1,324 @@
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DefaultConfigConcurrency is the number of guest config reads allowed in flight at once
+const DefaultConfigConcurrency = 8
+
+// Inventory is a snapshot of the guests in the cluster
+type Inventory struct {
+	Containers []Container
+	VMs        []VM
+	Configs    map[int]map[string]interface{} // Guest configs keyed by VMID, nil unless requested
+
+	// Partial is set when /cluster/resources failed and the guests were
+	// listed node by node, which misses guests on offline or unreachable nodes
+	Partial bool
+}
+
+// guestRef identifies a guest config to read; kind is "lxc" or "qemu"
+type guestRef struct {
+	kind string
+	node string
+	vmid int
+}
+
+// GetInventory lists every container and VM in the cluster with a single
+// /cluster/resources call. If withConfigs is set, each guest's config is read
+// as well (at most SetConfigConcurrency reads at a time) and its IP address is
+// filled in. Guests whose config cannot be read are still listed.
+func (c *Client) GetInventory(ctx context.Context, withConfigs bool) (*Inventory, error) {
+	return c.buildInventory(ctx, withConfigs, withConfigs)
+}
+
+// buildInventory lists the guests of the cluster and reads the configs of the
+// requested guest types only
+func (c *Client) buildInventory(ctx context.Context, containerConfigs, vmConfigs bool) (*Inventory, error) {
+	inventory, err := c.listGuests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !containerConfigs && !vmConfigs {
+		return inventory, nil
+	}
+
+	var guests []guestRef
+	if containerConfigs {
+		for _, ct := range inventory.Containers {
+			guests = append(guests, guestRef{kind: "lxc", node: ct.Node, vmid: ct.VMID})
+		}
+	}
+	if vmConfigs {
+		for _, vm := range inventory.VMs {
+			guests = append(guests, guestRef{kind: "qemu", node: vm.Node, vmid: vm.VMID})
+		}
+	}
+
+	inventory.Configs, err = c.getGuestConfigs(ctx, guests)
+	if err != nil {
+		return nil, err
+	}
+	for i := range inventory.Containers {
+		container := &inventory.Containers[i]
+		if net0, ok := inventory.Configs[container.VMID]["net0"].(string); ok {
+			container.IPAddress = extractIPFromNetConfig(net0)
+		}
+	}
+	for i := range inventory.VMs {
+		vm := &inventory.VMs[i]
+		if ipconfig, ok := inventory.Configs[vm.VMID]["ipconfig0"].(string); ok {
+			vm.IPAddress = extractIPFromNetConfig(ipconfig)
+		}
+	}
+
+	return inventory, nil
+}
+
+// listGuests returns the containers and VMs of the cluster sorted by VMID. The
+// status fields come from /cluster/resources; if that fails each online node
+// is listed instead.
+func (c *Client) listGuests(ctx context.Context) (*Inventory, error) {
+	resources, err := c.GetClusterResources(ctx, "vm")
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		fmt.Printf("[WARNING] Failed to list cluster resources, listing nodes instead: %v\n", err)
+		return c.listGuestsByNode(ctx)
+	}
+	c.setGuestNodes(resources)
+
+	inventory := &Inventory{}
+	for _, res := range resources {
+		switch res.Type {
+		case "lxc":
+			inventory.Containers = append(inventory.Containers, Container{
+				VMID:     res.VMID,
+				Name:     res.Name,
+				Status:   res.Status,
+				Node:     res.Node,
+				CPU:      res.CPU,
+				Mem:      res.Mem,
+				MaxMem:   res.MaxMem,
+				Disk:     res.Disk,
+				MaxDisk:  res.MaxDisk,
+				Uptime:   res.Uptime,
+				Template: res.Template == 1,
+			})
+		case "qemu":
+			inventory.VMs = append(inventory.VMs, VM{
+				VMID:     res.VMID,
+				Name:     res.Name,
+				Status:   res.Status,
+				Node:     res.Node,
+				CPU:      res.CPU,
+				CPUs:     int(res.MaxCPU),
+				Mem:      res.Mem,
+				MaxMem:   res.MaxMem,
+				Disk:     res.Disk,
+				MaxDisk:  res.MaxDisk,
+				Uptime:   res.Uptime,
+				Template: res.Template == 1,
+			})
+		}
+	}
+	inventory.sort()
+
+	fmt.Printf("[INFO] listGuests: found %d containers and %d VMs in cluster resources\n", len(inventory.Containers), len(inventory.VMs))
+	return inventory, nil
+}
+
+// listGuestsByNode lists the guests of every online node. It fails only if no
+// node could be reached, since partial results are more useful than none; the
+// inventory is marked Partial for callers that need every guest.
+func (c *Client) listGuestsByNode(ctx context.Context) (*Inventory, error) {
+	nodes, err := c.onlineNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	inventory := &Inventory{Partial: true}
+	var lastErr error
+	failed := 0
+	for _, node := range nodes {
+		nodeContainers, err := c.getNodeContainers(ctx, node)
+		if err == nil {
+			var nodeVMs []VM
+			nodeVMs, err = c.getNodeVMs(ctx, node)
+			inventory.VMs = append(inventory.VMs, nodeVMs...)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			fmt.Printf("[WARNING] Failed to list guests on node '%s': %v\n", node, err)
+			lastErr = err
+			failed++
+			continue
+		}
+		inventory.Containers = append(inventory.Containers, nodeContainers...)
+	}
+	if failed == len(nodes) && lastErr != nil {
+		return nil, lastErr
+	}
+
+	inventory.sort()
+	return inventory, nil
+}
+
+// sort orders the containers and VMs by VMID
+func (inv *Inventory) sort() {
+	sort.Slice(inv.Containers, func(i, j int) bool { return inv.Containers[i].VMID < inv.Containers[j].VMID })
+	sort.Slice(inv.VMs, func(i, j int) bool { return inv.VMs[i].VMID < inv.VMs[j].VMID })
+}
+
+// getNodeContainers retrieves the LXC containers on a single node
+func (c *Client) getNodeContainers(ctx context.Context, node string) ([]Container, error) {
+	path := fmt.Sprintf("/nodes/%s/lxc", node)
+	fmt.Printf("[DEBUG] getNodeContainers: requesting path=%s\n", path)
+
+	respBody, err := c.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Data []containerStatus `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		fmt.Printf("[ERROR] Failed to unmarshal containers response: %v\n", err)
+		fmt.Printf("[DEBUG] Raw response body: %s\n", string(respBody))
+		return nil, fmt.Errorf("failed to parse containers response: %w", err)
+	}
+
+	containers := make([]Container, 0, len(response.Data))
+	for _, status := range response.Data {
+		c.rememberGuestNode(status.VMID, node)
+		containers = append(containers, status.container(node))
+	}
+
+	fmt.Printf("[INFO] getNodeContainers: parsed %d containers from node '%s'\n", len(containers), node)
+	return containers, nil
+}
+
+// containerStatus is a container as returned by the lxc list and status
+// endpoints, which report the template flag as 0/1
+type containerStatus struct {
+	VMID     int     `json:"vmid"`
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	CPU      float64 `json:"cpu"`
+	Mem      int64   `json:"mem"`
+	MaxMem   int64   `json:"maxmem"`
+	Disk     int64   `json:"disk"`
+	MaxDisk  int64   `json:"maxdisk"`
+	Uptime   int64   `json:"uptime"`
+	Template int     `json:"template"`
+}
+
+func (s containerStatus) container(node string) Container {
+	return Container{
+		VMID:     s.VMID,
+		Name:     s.Name,
+		Status:   s.Status,
+		Node:     node,
+		CPU:      s.CPU,
+		Mem:      s.Mem,
+		MaxMem:   s.MaxMem,
+		Disk:     s.Disk,
+		MaxDisk:  s.MaxDisk,
+		Uptime:   s.Uptime,
+		Template: s.Template == 1,
+	}
+}
+
+// getContainerConfig reads the config of a single container
+func (c *Client) getContainerConfig(ctx context.Context, node string, vmid int) (map[string]interface{}, error) {
+	return c.getGuestConfig(ctx, "lxc", node, vmid)
+}
+
+// getGuestConfig reads the config of a container ("lxc") or VM ("qemu")
+func (c *Client) getGuestConfig(ctx context.Context, kind, node string, vmid int) (map[string]interface{}, error) {
+	path := fmt.Sprintf("/nodes/%s/%s/%d/config", node, kind, vmid)
+	fmt.Printf("[DEBUG] getGuestConfig: requesting path=%s\n", path)
+
+	respBody, err := c.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guest config: %w", err)
+	}
+
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse guest config: %w", err)
+	}
+
+	return response.Data, nil
+}
+
+// getGuestConfigs reads the configs of the given guests through a bounded
+// pool of workers. Configs that cannot be read are left out of the result;
+// only a cancelled ctx fails the whole call.
+func (c *Client) getGuestConfigs(ctx context.Context, guests []guestRef) (map[int]map[string]interface{}, error) {
+	workers := c.configWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > len(guests) {
+		workers = len(guests)
+	}
+
+	type result struct {
+		vmid   int
+		config map[string]interface{}
+	}
+	jobs := make(chan guestRef)
+	results := make(chan result, len(guests))
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for guest := range jobs {
+				config, err := c.getGuestConfig(ctx, guest.kind, guest.node, guest.vmid)
+				if err != nil {
+					if ctx.Err() == nil {
+						fmt.Printf("[WARNING] Failed to read config of guest %d: %v\n", guest.vmid, err)
+					}
+					continue
+				}
+				results <- result{vmid: guest.vmid, config: config}
+			}
+		}()
+	}
+
+feed:
+	for _, guest := range guests {
+		select {
+		case jobs <- guest:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(results)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	configs := make(map[int]map[string]interface{}, len(guests))
+	for r := range results {
+		configs[r.vmid] = r.config
+	}
+	return configs, nil
+}
@@ -0,0 +1,151 @@
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// referencedVolumes returns the volids referenced by any guest config:
+// attached disks, unused disks and the base images of linked clones
+func referencedVolumes(configs map[int]map[string]interface{}) map[string]bool {
+	referenced := make(map[string]bool)
+	for vmid, config := range configs {
+		for volid := range volumeAttachments(vmid, config) {
+			referenced[volid] = true
+		}
+		for key, value := range config {
+			disk, ok := value.(string)
+			if ok && strings.HasPrefix(key, "unused") {
+				referenced[extractVolIDFromConfig(disk)] = true
+			}
+		}
+	}
+
+	// Linked clones reference "storage:base-100-disk-0/vm-101-disk-0"; the
+	// base image must stay as long as a clone uses it
+	for volid := range referenced {
+		if storage, name, ok := strings.Cut(volid, ":"); ok {
+			if base, _, ok := strings.Cut(name, "/"); ok {
+				referenced[storage+":"+base] = true
+			}
+		}
+	}
+	return referenced
+}
+
+// FindOrphanedVolumes scans the disk image and container storages of every
+// online node for volumes that belonged to a guest that no longer exists and
+// that no guest config references. Volumes created through the API without an
+// owner are never reported. The scan fails rather than guess if the guest list
+// is incomplete or any guest config cannot be read.
+func (c *Client) FindOrphanedVolumes(ctx context.Context) ([]OrphanedVolume, error) {
+	inventory, err := c.GetInventory(ctx, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list guests: %w", err)
+	}
+	if inventory.Partial {
+		return nil, fmt.Errorf("failed to scan for orphaned volumes: the cluster guest list is unavailable, so guests on offline or unreachable nodes could be missed")
+	}
+	if guests := len(inventory.Containers) + len(inventory.VMs); len(inventory.Configs) < guests {
+		return nil, fmt.Errorf("failed to scan for orphaned volumes: could only read %d of %d guest configs", len(inventory.Configs), guests)
+	}
+
+	guests := make(map[int]bool, len(inventory.Configs))
+	for vmid := range inventory.Configs {
+		guests[vmid] = true
+	}
+	referenced := referencedVolumes(inventory.Configs)
+
+	nodes, err := c.onlineNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	orphans := make(map[string]OrphanedVolume)
+	for _, node := range nodes {
+		storages, err := c.discoverStorages(ctx, node, "images", "rootdir")
+		if err != nil {
+			return nil, err
+		}
+		for _, storage := range storages {
+			path := fmt.Sprintf("/nodes/%s/storage/%s/content", node, storage)
+			fmt.Printf("[DEBUG] FindOrphanedVolumes: requesting path=%s\n", path)
+
+			respBody, err := c.doRequest(ctx, "GET", path, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list storage '%s' on node %s: %w", storage, node, err)
+			}
+			var response struct {
+				Data []struct {
+					VolID   string `json:"volid"`
+					VMID    int    `json:"vmid"`
+					Size    int64  `json:"size"`
+					Content string `json:"content"`
+					CTime   int64  `json:"ctime"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(respBody, &response); err != nil {
+				return nil, fmt.Errorf("failed to parse storage content: %w", err)
+			}
+
+			for _, item := range response.Data {
+				if item.Content != "images" && item.Content != "rootdir" {
+					continue
+				}
+				// Shared storages are listed once per node
+				if _, seen := orphans[item.VolID]; seen {
+					continue
+				}
+				owner := item.VMID
+				if owner == 0 {
+					owner = VolumeOwner(item.VolID)
+				}
+				if owner == 0 || guests[owner] || referenced[item.VolID] {
+					continue
+				}
+
+				orphan := OrphanedVolume{
+					VolID:     item.VolID,
+					Node:      node,
+					Storage:   storage,
+					Size:      item.Size / (1024 * 1024 * 1024), // Convert bytes to GB
+					VMID:      owner,
+					CreatedAt: item.CTime,
+				}
+				if item.CTime > 0 {
+					orphan.Age = now - item.CTime
+				}
+				orphans[item.VolID] = orphan
+			}
+		}
+	}
+
+	list := make([]OrphanedVolume, 0, len(orphans))
+	for _, orphan := range orphans {
+		snapshots, err := c.GetSnapshots(ctx, orphan.VolID)
+		if err != nil {
+			fmt.Printf("[WARNING] Failed to list snapshots of orphaned volume %s: %v\n", orphan.VolID, err)
+		}
+		for _, snapshot := range snapshots {
+			orphan.Snapshots = append(orphan.Snapshots, snapshot.Name)
+		}
+		list = append(list, orphan)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].VolID < list[j].VolID })
+
+	fmt.Printf("[INFO] FindOrphanedVolumes: found %d orphaned volumes\n", len(list))
+	return list, nil
+}
+
+// DeleteOrphanedVolume deletes an orphaned volume, with its snapshots, through
+// the node it was found on and returns the UPID of the delete task, if any.
+// The owner of an orphan no longer exists, so the node cannot be derived from
+// the volid as DeleteVolume does.
+func (c *Client) DeleteOrphanedVolume(ctx context.Context, orphan OrphanedVolume) (string, error) {
+	return c.deleteVolumeOn(ctx, orphan.Node, orphan.Storage, orphan.VolID)
+}
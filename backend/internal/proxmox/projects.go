@@ -159,6 +159,7 @@ func (ps *ProjectStore) CreateProjectWithID(id string, req CreateProjectRequest)
 		Network:          req.Network,
 		ContainerIDStart: req.ContainerIDStart,
 		ContainerIDEnd:   req.ContainerIDEnd,
+		BackupStorage:    req.BackupStorage,
 		CreatedAt:        now,
 		UpdatedAt:        now,
 	}
@@ -233,6 +234,9 @@ func (ps *ProjectStore) UpdateProject(id string, req UpdateProjectRequest) (*Pro
 	if req.ContainerIDEnd != nil {
 		project.ContainerIDEnd = req.ContainerIDEnd
 	}
+	if req.BackupStorage != nil {
+		project.BackupStorage = *req.BackupStorage
+	}
 	project.UpdatedAt = time.Now().Unix()
 
 	if err := ps.save(); err != nil {
@@ -302,6 +306,117 @@ func (ps *ProjectStore) GetContainerProject(vmid int) string {
 	return ps.vmidMap[vmid]
 }
 
+// GetContainerBackupStorage returns the default backup storage of the project
+// a container belongs to, or "" if it has none
+func (ps *ProjectStore) GetContainerBackupStorage(vmid int) string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	if project, exists := ps.projects[ps.vmidMap[vmid]]; exists {
+		return project.BackupStorage
+	}
+	return ""
+}
+
+// SetMember grants a user a role in a project, replacing any role they had
+func (ps *ProjectStore) SetMember(projectID, username, role string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	project, exists := ps.projects[projectID]
+	if !exists {
+		return fmt.Errorf("project not found: %s", projectID)
+	}
+
+	// Copy the map so projects already handed out are not modified under them
+	members := make(map[string]string, len(project.Members)+1)
+	for name, r := range project.Members {
+		members[name] = r
+	}
+	members[username] = role
+	project.Members = members
+	project.UpdatedAt = time.Now().Unix()
+
+	return ps.save()
+}
+
+// RemoveMember revokes a user's role in a project
+func (ps *ProjectStore) RemoveMember(projectID, username string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	project, exists := ps.projects[projectID]
+	if !exists {
+		return fmt.Errorf("project not found: %s", projectID)
+	}
+	if _, isMember := project.Members[username]; !isMember {
+		return fmt.Errorf("user %s is not a member of project %s", username, projectID)
+	}
+
+	members := make(map[string]string, len(project.Members))
+	for name, r := range project.Members {
+		if name != username {
+			members[name] = r
+		}
+	}
+	project.Members = members
+	project.UpdatedAt = time.Now().Unix()
+
+	return ps.save()
+}
+
+// RemoveUser revokes a user's roles in all projects
+func (ps *ProjectStore) RemoveUser(username string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	changed := false
+	for _, project := range ps.projects {
+		if _, isMember := project.Members[username]; !isMember {
+			continue
+		}
+		members := make(map[string]string, len(project.Members))
+		for name, r := range project.Members {
+			if name != username {
+				members[name] = r
+			}
+		}
+		project.Members = members
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	return ps.save()
+}
+
+// GetMemberRole returns a user's role in a project, or "" if they have none
+func (ps *ProjectStore) GetMemberRole(projectID, username string) string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	if project, exists := ps.projects[projectID]; exists {
+		return project.Members[username]
+	}
+	return ""
+}
+
+// GetUserRoles returns a user's role in each project they are a member of,
+// keyed by project ID
+func (ps *ProjectStore) GetUserRoles(username string) map[string]string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	roles := make(map[string]string)
+	for id, project := range ps.projects {
+		if role, isMember := project.Members[username]; isMember {
+			roles[id] = role
+		}
+	}
+	return roles
+}
+
 // GetProjectContainers returns all VMIDs assigned to a project
 func (ps *ProjectStore) GetProjectContainers(projectID string) []int {
 	ps.mu.RLock()
@@ -0,0 +1,2417 @@
+// Package proxmoxtest provides an in-process fake of the Proxmox VE
+// /api2/json API for tests. It keeps containers, storage content and SDN
+// objects in memory and implements the endpoints used by proxmox.Client.
+package proxmoxtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/proxmox"
+	"github.com/gorilla/mux"
+)
+
+// Default credentials and node name used by the fake server
+const (
+	DefaultNode        = "pve"
+	DefaultTokenID     = "root@pam!proxicloud"
+	DefaultTokenSecret = "00000000-0000-0000-0000-000000000000"
+)
+
+// Server is a fake Proxmox VE API server backed by in-memory state
+type Server struct {
+	*httptest.Server
+
+	Node string
+
+	mu         sync.Mutex
+	nodes      []string
+	containers map[int]*container
+	storages   map[string]*storage
+	zones      map[string]proxmox.SDNZone
+	vnets      map[string]*vnet
+	tasks      map[string]*task
+	taskSeq    int
+	taskFails  map[string]string
+
+	requests    int
+	failNext    int
+	failStatus  int
+	failMessage string
+}
+
+// container is an LXC container or, with kind "qemu", a VM; both share the VMID space
+type container struct {
+	ct     proxmox.Container
+	kind   string // lxc or qemu
+	node   string
+	config map[string]string
+
+	// Changes made while running that are applied on the next (re)start
+	pending       map[string]string
+	pendingDelete map[string]bool
+
+	// Whole-guest snapshots and the one the current state is based on
+	snapshots  map[string]*guestSnapshot
+	parentSnap string
+	snapSeq    int
+}
+
+type guestSnapshot struct {
+	name        string
+	description string
+	snaptime    int64
+	parent      string
+	seq         int
+	config      map[string]string
+}
+
+// hotplugKeys are the config keys applied to a running guest immediately;
+// changes to any other key stay pending until the guest is restarted
+var hotplugKeys = regexp.MustCompile(`^(?:cores|memory|swap|cpulimit|cpuunits|onboot|description|protection|tags|net\d+|mp\d+)$`)
+
+type storage struct {
+	name    string
+	typ     string
+	content string
+	total   int64
+	volumes map[string]*volume
+}
+
+type volume struct {
+	volid     string
+	format    string
+	content   string
+	size      int64  // bytes
+	used      *int64 // bytes, reported like thin-provisioned storages do when set
+	vmid      int
+	snapshots []snapshot
+	ctime     int64
+
+	// Set for vzdump archives
+	notes   string
+	archive *archive
+}
+
+// archive is the guest a vzdump archive was made from, enough to restore it
+type archive struct {
+	kind   string
+	config map[string]string
+	disks  map[string]int64 // Config key to disk size in bytes
+}
+
+type snapshot struct {
+	name        string
+	description string
+	ctime       int64
+}
+
+type task struct {
+	upid       string
+	node       string
+	taskType   string
+	id         string
+	startTime  int64
+	exitStatus string
+	log        []string
+}
+
+type vnet struct {
+	zone    string
+	tag     int
+	subnets map[string]map[string]interface{}
+}
+
+// NewServer starts a fake Proxmox server with a single node and the default
+// local, local-lvm and local-zfs storages. Storages are shared by every node
+// added with AddNode. Callers must Close it.
+func NewServer() *Server {
+	s := &Server{
+		Node:       DefaultNode,
+		nodes:      []string{DefaultNode},
+		containers: make(map[int]*container),
+		storages:   make(map[string]*storage),
+		zones:      make(map[string]proxmox.SDNZone),
+		vnets:      make(map[string]*vnet),
+		tasks:      make(map[string]*task),
+		taskFails:  make(map[string]string),
+	}
+
+	s.addStorage("local", "dir", "vztmpl,iso,backup")
+	s.addStorage("local-lvm", "lvmthin", "images,rootdir")
+	s.addStorage("local-zfs", "zfspool", "images,rootdir")
+
+	s.Server = httptest.NewServer(s.router())
+	return s
+}
+
+// Client returns a proxmox.Client configured to talk to this server
+func (s *Server) Client() *proxmox.Client {
+	return proxmox.NewClient(s.URL, s.Node, DefaultTokenID, DefaultTokenSecret, false)
+}
+
+// AddNode adds a node to the cluster
+func (s *Server) AddNode(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.hasNode(name) {
+		s.nodes = append(s.nodes, name)
+	}
+}
+
+// hasNode reports whether name is a cluster node; callers must hold s.mu
+func (s *Server) hasNode(name string) bool {
+	for _, n := range s.nodes {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AddContainer seeds a container on ct.Node (default: s.Node). The config map
+// may be nil; ct.Template marks it as a container template.
+func (s *Server) AddContainer(ct proxmox.Container, config map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ct.Node == "" {
+		ct.Node = s.Node
+	}
+	if !s.hasNode(ct.Node) {
+		s.nodes = append(s.nodes, ct.Node)
+	}
+	if ct.Status == "" {
+		ct.Status = "stopped"
+	}
+	if config == nil {
+		config = make(map[string]string)
+	}
+	if _, ok := config["hostname"]; !ok && ct.Name != "" {
+		config["hostname"] = ct.Name
+	}
+	if ct.Template {
+		config["template"] = "1"
+	}
+	s.containers[ct.VMID] = &container{ct: ct, kind: "lxc", node: ct.Node, config: config}
+}
+
+// AddVM seeds a QEMU VM on vm.Node (default: s.Node). The config map may be
+// nil; vm.Template marks it as a VM template.
+func (s *Server) AddVM(vm proxmox.VM, config map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if vm.Node == "" {
+		vm.Node = s.Node
+	}
+	if !s.hasNode(vm.Node) {
+		s.nodes = append(s.nodes, vm.Node)
+	}
+	if vm.Status == "" {
+		vm.Status = "stopped"
+	}
+	if config == nil {
+		config = make(map[string]string)
+	}
+	if _, ok := config["name"]; !ok && vm.Name != "" {
+		config["name"] = vm.Name
+	}
+	if vm.Template {
+		config["template"] = "1"
+	}
+	ct := proxmox.Container{
+		VMID:    vm.VMID,
+		Name:    vm.Name,
+		Status:  vm.Status,
+		CPU:     vm.CPU,
+		Mem:     vm.Mem,
+		MaxMem:  vm.MaxMem,
+		Disk:    vm.Disk,
+		MaxDisk: vm.MaxDisk,
+		Uptime:  vm.Uptime,
+	}
+	s.containers[vm.VMID] = &container{ct: ct, kind: "qemu", node: vm.Node, config: config}
+}
+
+// AddTemplate seeds a container template in the given storage
+func (s *Server) AddTemplate(storageName, filename string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	volid := fmt.Sprintf("%s:vztmpl/%s", storageName, filename)
+	s.storages[storageName].volumes[volid] = &volume{
+		volid:   volid,
+		format:  "tgz",
+		content: "vztmpl",
+		size:    128 * 1024 * 1024,
+	}
+	return volid
+}
+
+// AddVolume seeds a disk image in the given storage, owned by vmid (0 for none)
+func (s *Server) AddVolume(storageName, name string, sizeGB int64, vmid int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	volid := fmt.Sprintf("%s:%s", storageName, name)
+	s.storages[storageName].volumes[volid] = &volume{
+		volid:   volid,
+		format:  "raw",
+		content: "images",
+		size:    sizeGB * 1024 * 1024 * 1024,
+		vmid:    vmid,
+		ctime:   time.Now().Unix(),
+	}
+	return volid
+}
+
+// Container returns a copy of a container's state and config
+func (s *Server) Container(vmid int) (proxmox.Container, map[string]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.containers[vmid]
+	if !ok {
+		return proxmox.Container{}, nil, false
+	}
+	config := make(map[string]string, len(c.config))
+	for k, v := range c.config {
+		config[k] = v
+	}
+	ct := c.ct
+	ct.Node = c.node
+	ct.Template = config["template"] == "1"
+	return ct, config, true
+}
+
+// VM returns a copy of a VM's state and config as the fake currently sees it
+func (s *Server) VM(vmid int) (proxmox.VM, map[string]string, bool) {
+	ct, config, ok := s.Container(vmid)
+	if !ok {
+		return proxmox.VM{}, nil, false
+	}
+	return proxmox.VM{
+		VMID:     ct.VMID,
+		Name:     ct.Name,
+		Status:   ct.Status,
+		Node:     ct.Node,
+		Mem:      ct.Mem,
+		MaxMem:   ct.MaxMem,
+		Disk:     ct.Disk,
+		MaxDisk:  ct.MaxDisk,
+		Uptime:   ct.Uptime,
+		Template: config["template"] == "1",
+	}, config, true
+}
+
+// HasVolume reports whether a volume exists in any storage
+func (s *Server) HasVolume(volid string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.findVolume(volid) != nil
+}
+
+// SetVolumeUsed sets the usage in bytes a volume's storage reports for it
+func (s *Server) SetVolumeUsed(volid string, usedBytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.findVolume(volid).used = &usedBytes
+}
+
+// AgeVolume moves a volume's creation time back by d
+func (s *Server) AgeVolume(volid string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.findVolume(volid).ctime -= int64(d / time.Second)
+}
+
+// HasSDNZone reports whether an SDN zone exists
+func (s *Server) HasSDNZone(zone string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.zones[zone]
+	return ok
+}
+
+// HasVNet reports whether an SDN VNet exists
+func (s *Server) HasVNet(vnetID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.vnets[vnetID]
+	return ok
+}
+
+// AddStorage adds a storage of the given type (e.g. "rbd" or "nfs") holding
+// the comma-separated content types
+func (s *Server) AddStorage(name, typ, content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addStorage(name, typ, content)
+}
+
+func (s *Server) addStorage(name, typ, content string) {
+	s.storages[name] = &storage{
+		name:    name,
+		typ:     typ,
+		content: content,
+		total:   500 * 1024 * 1024 * 1024,
+		volumes: make(map[string]*volume),
+	}
+}
+
+// storageNames returns the storage names in sorted order; callers must hold s.mu
+func (s *Server) storageNames() []string {
+	names := make([]string, 0, len(s.storages))
+	for name := range s.storages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// findVolume looks up a volume by volid; callers must hold s.mu
+func (s *Server) findVolume(volid string) *volume {
+	parts := strings.SplitN(volid, ":", 2)
+	st, ok := s.storages[parts[0]]
+	if !ok {
+		return nil
+	}
+	return st.volumes[volid]
+}
+
+// FailTasks makes every subsequent task of the given type (e.g. "vzstart")
+// finish with exitStatus instead of "OK". An empty exitStatus clears it.
+func (s *Server) FailTasks(taskType, exitStatus string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if exitStatus == "" {
+		delete(s.taskFails, taskType)
+		return
+	}
+	s.taskFails[taskType] = exitStatus
+}
+
+// newUPID records a finished task and returns its ID in the format Proxmox
+// uses; callers must hold s.mu
+func (s *Server) newUPID(node string, taskType string, id string) string {
+	s.taskSeq++
+	now := time.Now().Unix()
+	upid := fmt.Sprintf("UPID:%s:%08X:%08X:%08X:%s:%s:%s:", node, s.taskSeq, s.taskSeq, now, taskType, id, DefaultTokenID)
+
+	t := &task{
+		upid:       upid,
+		node:       node,
+		taskType:   taskType,
+		id:         id,
+		startTime:  now,
+		exitStatus: "OK",
+		log:        []string{fmt.Sprintf("starting %s %s", taskType, id), "TASK OK"},
+	}
+	if exitStatus, ok := s.taskFails[taskType]; ok {
+		t.exitStatus = exitStatus
+		t.log = []string{fmt.Sprintf("starting %s %s", taskType, id), "TASK ERROR: " + exitStatus}
+	}
+	s.tasks[upid] = t
+	return upid
+}
+
+// FailRequests makes the next n API requests fail with the given HTTP status
+// and message before reaching any handler, e.g. to simulate pve-proxy timeouts
+func (s *Server) FailRequests(n int, status int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failNext = n
+	s.failStatus = status
+	s.failMessage = message
+}
+
+// Requests returns the number of API requests the server has received
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *Server) router() http.Handler {
+	r := mux.NewRouter()
+	api := r.PathPrefix("/api2/json").Subrouter()
+	api.Use(s.injectFaults)
+	api.Use(s.authenticate)
+
+	api.HandleFunc("/cluster/nextid", s.handleNextID).Methods("GET")
+	api.HandleFunc("/cluster/resources", s.handleClusterResources).Methods("GET")
+
+	// SDN
+	api.HandleFunc("/cluster/sdn", s.handleApplySDN).Methods("PUT")
+	api.HandleFunc("/cluster/sdn/zones", s.handleListZones).Methods("GET")
+	api.HandleFunc("/cluster/sdn/zones", s.handleCreateZone).Methods("POST")
+	api.HandleFunc("/cluster/sdn/zones/{zone}", s.handleDeleteZone).Methods("DELETE")
+	api.HandleFunc("/cluster/sdn/vnets", s.handleCreateVNet).Methods("POST")
+	api.HandleFunc("/cluster/sdn/vnets/{vnet}", s.handleDeleteVNet).Methods("DELETE")
+	api.HandleFunc("/cluster/sdn/vnets/{vnet}/subnets", s.handleListSubnets).Methods("GET")
+	api.HandleFunc("/cluster/sdn/vnets/{vnet}/subnets", s.handleCreateSubnet).Methods("POST")
+	api.HandleFunc("/cluster/sdn/vnets/{vnet}/subnets/{subnet}", s.handleDeleteSubnet).Methods("DELETE")
+
+	node := api.PathPrefix("/nodes/{node}").Subrouter()
+	node.Use(s.checkNode)
+
+	// Tasks
+	node.HandleFunc("/tasks/{upid:.+}/status", s.handleTaskStatus).Methods("GET")
+	node.HandleFunc("/tasks/{upid:.+}/log", s.handleTaskLog).Methods("GET")
+
+	// Containers
+	node.HandleFunc("/lxc", s.handleListContainers).Methods("GET")
+	node.HandleFunc("/lxc", s.handleCreateContainer).Methods("POST")
+	node.HandleFunc("/lxc/{vmid:[0-9]+}", s.handleDeleteContainer).Methods("DELETE")
+	node.HandleFunc("/lxc/{vmid:[0-9]+}/status/current", s.handleContainerStatus).Methods("GET")
+	node.HandleFunc("/lxc/{vmid:[0-9]+}/status/{action}", s.handleContainerAction).Methods("POST")
+	node.HandleFunc("/lxc/{vmid:[0-9]+}/config", s.handleGetConfig).Methods("GET")
+	node.HandleFunc("/lxc/{vmid:[0-9]+}/config", s.handleUpdateConfig).Methods("PUT")
+	node.HandleFunc("/lxc/{vmid:[0-9]+}/pending", s.handleGetPending).Methods("GET")
+	node.HandleFunc("/lxc/{vmid:[0-9]+}/resize", s.handleResizeDisk).Methods("PUT")
+	node.HandleFunc("/lxc/{vmid:[0-9]+}/move_volume", s.handleMoveVolume).Methods("POST")
+	node.HandleFunc("/lxc/{vmid:[0-9]+}/clone", s.handleClone).Methods("POST")
+	node.HandleFunc("/lxc/{vmid:[0-9]+}/template", s.handleConvertToTemplate).Methods("POST")
+	node.HandleFunc("/lxc/{vmid:[0-9]+}/migrate", s.handleMigrate).Methods("POST")
+	node.HandleFunc("/lxc/{vmid:[0-9]+}/snapshot", s.handleListGuestSnapshots).Methods("GET")
+	node.HandleFunc("/lxc/{vmid:[0-9]+}/snapshot", s.handleCreateGuestSnapshot).Methods("POST")
+	node.HandleFunc("/lxc/{vmid:[0-9]+}/snapshot/{snap}", s.handleDeleteGuestSnapshot).Methods("DELETE")
+	node.HandleFunc("/lxc/{vmid:[0-9]+}/snapshot/{snap}/rollback", s.handleRollbackGuestSnapshot).Methods("POST")
+	node.HandleFunc("/lxc/{vmid:[0-9]+}/termproxy", s.handleTermProxy).Methods("POST")
+
+	// Virtual machines share the container handlers, which look at the path for the guest kind
+	node.HandleFunc("/qemu", s.handleListContainers).Methods("GET")
+	node.HandleFunc("/qemu", s.handleCreateVM).Methods("POST")
+	node.HandleFunc("/qemu/{vmid:[0-9]+}", s.handleDeleteContainer).Methods("DELETE")
+	node.HandleFunc("/qemu/{vmid:[0-9]+}/status/current", s.handleContainerStatus).Methods("GET")
+	node.HandleFunc("/qemu/{vmid:[0-9]+}/status/{action}", s.handleContainerAction).Methods("POST")
+	node.HandleFunc("/qemu/{vmid:[0-9]+}/config", s.handleGetConfig).Methods("GET")
+	node.HandleFunc("/qemu/{vmid:[0-9]+}/config", s.handleUpdateConfig).Methods("PUT")
+	node.HandleFunc("/qemu/{vmid:[0-9]+}/clone", s.handleClone).Methods("POST")
+
+	// Storage
+	node.HandleFunc("/vzdump", s.handleVzdump).Methods("POST")
+	node.HandleFunc("/storage", s.handleListStorage).Methods("GET")
+	node.HandleFunc("/storage/{storage}/upload", s.handleUpload).Methods("POST")
+	node.HandleFunc("/storage/{storage}/content", s.handleListContent).Methods("GET")
+	node.HandleFunc("/storage/{storage}/content", s.handleAllocVolume).Methods("POST")
+	node.HandleFunc("/storage/{storage}/content/{volid}", s.handleGetVolume).Methods("GET")
+	node.HandleFunc("/storage/{storage}/content/{volid}/snapshots", s.handleListSnapshots).Methods("GET")
+	node.HandleFunc("/storage/{storage}/content/{volid}/snapshot", s.handleCreateSnapshot).Methods("POST")
+	node.HandleFunc("/storage/{storage}/content/{volid}/snapshot/{snap}", s.handleDeleteSnapshot).Methods("DELETE")
+	// Archive volids contain slashes, so this must come after the snapshot routes
+	node.HandleFunc("/storage/{storage}/content/{volid:.+}", s.handleDeleteVolume).Methods("DELETE")
+	node.HandleFunc("/storage/{storage}/content/{volid}/snapshot/{snap}/rollback", s.handleRollbackSnapshot).Methods("POST")
+	node.HandleFunc("/storage/{storage}/content/{volid}/snapshot/{snap}/clone", s.handleCloneSnapshot).Methods("POST")
+
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotImplemented, fmt.Sprintf("Method '%s %s' not implemented", r.Method, r.URL.Path))
+	})
+
+	return r
+}
+
+// injectFaults counts requests and fails them while FailRequests is in effect
+func (s *Server) injectFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests++
+		fail := s.failNext > 0
+		status, message := s.failStatus, s.failMessage
+		if fail {
+			s.failNext--
+		}
+		s.mu.Unlock()
+
+		if fail {
+			writeError(w, status, message)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate rejects requests without the expected API token
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	expected := fmt.Sprintf("PVEAPIToken=%s=%s", DefaultTokenID, DefaultTokenSecret)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != expected {
+			writeError(w, http.StatusUnauthorized, "authentication failure")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkNode rejects requests for nodes that are not part of the cluster
+func (s *Server) checkNode(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		known := s.hasNode(mux.Vars(r)["node"])
+		s.mu.Unlock()
+		if !known {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("hostname lookup '%s' failed - failed to get address info", mux.Vars(r)["node"]))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeData writes a successful {"data": ...} response
+func writeData(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+// writeError writes an error response the way Proxmox does
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": nil, "message": message})
+}
+
+// writeParamError writes a parameter verification failure the way Proxmox does
+func writeParamError(w http.ResponseWriter, errors map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": nil, "message": "Parameter verification failed.", "errors": errors})
+}
+
+// parseSize parses a Proxmox size string such as "8G" or "512M" into bytes
+func parseSize(size string) (int64, error) {
+	multiplier := int64(1024 * 1024 * 1024)
+	switch {
+	case strings.HasSuffix(size, "T"):
+		multiplier = 1024 * 1024 * 1024 * 1024
+		size = strings.TrimSuffix(size, "T")
+	case strings.HasSuffix(size, "G"):
+		size = strings.TrimSuffix(size, "G")
+	case strings.HasSuffix(size, "M"):
+		multiplier = 1024 * 1024
+		size = strings.TrimSuffix(size, "M")
+	case strings.HasSuffix(size, "K"):
+		multiplier = 1024
+		size = strings.TrimSuffix(size, "K")
+	}
+
+	n, err := strconv.ParseFloat(size, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s'", size)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// lookupContainer finds a container on the node named in the request path,
+// writing the error Proxmox returns when it lives elsewhere or does not exist;
+// callers must hold s.mu
+func (s *Server) lookupContainer(w http.ResponseWriter, r *http.Request, vmid int) (*container, bool) {
+	node := mux.Vars(r)["node"]
+	kind := guestKind(r)
+	c, ok := s.containers[vmid]
+	if !ok || c.node != node || c.kind != kind {
+		dir := "lxc"
+		if kind == "qemu" {
+			dir = "qemu-server"
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Configuration file 'nodes/%s/%s/%d.conf' does not exist", node, dir, vmid))
+		return nil, false
+	}
+	return c, true
+}
+
+// guestKind returns "qemu" for VM endpoints and "lxc" for container endpoints
+func guestKind(r *http.Request) string {
+	if strings.Contains(r.URL.Path, "/qemu") {
+		return "qemu"
+	}
+	return "lxc"
+}
+
+// label returns the prefix Proxmox uses for the guest in messages and task types
+func (c *container) label() (name, taskPrefix string) {
+	if c.kind == "qemu" {
+		return "VM", "qm"
+	}
+	return "CT", "vz"
+}
+
+func vmidVar(r *http.Request) int {
+	vmid, _ := strconv.Atoi(mux.Vars(r)["vmid"])
+	return vmid
+}
+
+func (s *Server) handleTaskStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[mux.Vars(r)["upid"]]
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("unable to parse worker upid '%s'", mux.Vars(r)["upid"]))
+		return
+	}
+	writeData(w, map[string]interface{}{
+		"upid":       t.upid,
+		"node":       t.node,
+		"type":       t.taskType,
+		"id":         t.id,
+		"user":       DefaultTokenID,
+		"status":     "stopped",
+		"exitstatus": t.exitStatus,
+		"starttime":  t.startTime,
+	})
+}
+
+func (s *Server) handleTaskLog(w http.ResponseWriter, r *http.Request) {
+	start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[mux.Vars(r)["upid"]]
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("unable to parse worker upid '%s'", mux.Vars(r)["upid"]))
+		return
+	}
+
+	lines := []map[string]interface{}{}
+	for i := start; i < len(t.log) && len(lines) < limit; i++ {
+		lines = append(lines, map[string]interface{}{"n": i + 1, "t": t.log[i]})
+	}
+	writeData(w, lines)
+}
+
+func (s *Server) handleClusterResources(w http.ResponseWriter, r *http.Request) {
+	resourceType := r.URL.Query().Get("type")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := []map[string]interface{}{}
+	if resourceType == "" || resourceType == "node" {
+		for _, node := range s.nodes {
+			list = append(list, map[string]interface{}{
+				"id":     "node/" + node,
+				"type":   "node",
+				"node":   node,
+				"status": "online",
+				"maxcpu": 8,
+				"maxmem": int64(32 * 1024 * 1024 * 1024),
+			})
+		}
+	}
+	if resourceType == "" || resourceType == "vm" {
+		vmids := make([]int, 0, len(s.containers))
+		for vmid := range s.containers {
+			vmids = append(vmids, vmid)
+		}
+		sort.Ints(vmids)
+		for _, vmid := range vmids {
+			c := s.containers[vmid]
+			res := containerStatus(c)
+			res["id"] = fmt.Sprintf("lxc/%d", vmid)
+			res["node"] = c.node
+			list = append(list, res)
+		}
+	}
+	if resourceType == "" || resourceType == "storage" {
+		for _, node := range s.nodes {
+			for _, name := range s.storageNames() {
+				st := s.storages[name]
+				list = append(list, map[string]interface{}{
+					"id":      fmt.Sprintf("storage/%s/%s", node, name),
+					"type":    "storage",
+					"node":    node,
+					"storage": name,
+					"status":  "available",
+					"maxdisk": st.total,
+				})
+			}
+		}
+	}
+	writeData(w, list)
+}
+
+func (s *Server) handleNextID(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := 100
+	for {
+		if _, exists := s.containers[id]; !exists {
+			break
+		}
+		id++
+	}
+	writeData(w, strconv.Itoa(id))
+}
+
+func (s *Server) handleListContainers(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node := mux.Vars(r)["node"]
+	kind := guestKind(r)
+	vmids := make([]int, 0, len(s.containers))
+	for vmid, c := range s.containers {
+		if c.node == node && c.kind == kind {
+			vmids = append(vmids, vmid)
+		}
+	}
+	sort.Ints(vmids)
+
+	list := make([]map[string]interface{}, 0, len(vmids))
+	for _, vmid := range vmids {
+		list = append(list, containerStatus(s.containers[vmid]))
+	}
+	writeData(w, list)
+}
+
+// containerStatus renders a container the way the list and status endpoints do
+func containerStatus(c *container) map[string]interface{} {
+	status := map[string]interface{}{
+		"vmid":    c.ct.VMID,
+		"name":    c.ct.Name,
+		"status":  c.ct.Status,
+		"cpu":     c.ct.CPU,
+		"mem":     c.ct.Mem,
+		"maxmem":  c.ct.MaxMem,
+		"disk":    c.ct.Disk,
+		"maxdisk": c.ct.MaxDisk,
+		"uptime":  c.ct.Uptime,
+		"type":    c.kind,
+	}
+	if c.kind == "qemu" {
+		status["cpus"], _ = strconv.Atoi(c.config["cores"])
+	}
+	if c.config["template"] == "1" {
+		status["template"] = 1
+	}
+	return status
+}
+
+func (s *Server) handleCreateContainer(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	vmid, err := strconv.Atoi(r.PostForm.Get("vmid"))
+	if err != nil || vmid < 100 {
+		writeParamError(w, map[string]string{"vmid": "invalid format"})
+		return
+	}
+	if r.PostForm.Get("ostemplate") == "" {
+		writeParamError(w, map[string]string{"ostemplate": "property is missing and it is not optional"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.PostForm.Get("restore") == "1" {
+		s.restoreArchive(w, r, "lxc", vmid, r.PostForm.Get("ostemplate"))
+		return
+	}
+
+	if existing, exists := s.containers[vmid]; exists {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("CT %d already exists on node '%s'", vmid, existing.node))
+		return
+	}
+
+	config := make(map[string]string)
+	for key := range r.PostForm {
+		switch key {
+		case "vmid", "ostemplate", "password", "ssh-public-keys":
+			continue
+		}
+		config[key] = r.PostForm.Get(key)
+	}
+
+	ct := proxmox.Container{
+		VMID:   vmid,
+		Name:   r.PostForm.Get("hostname"),
+		Status: "stopped",
+	}
+	if mem, err := strconv.ParseInt(config["memory"], 10, 64); err == nil {
+		ct.MaxMem = mem * 1024 * 1024
+	}
+
+	// Allocate rootfs in the form "storage:sizeGB"
+	if rootfs := config["rootfs"]; rootfs != "" {
+		parts := strings.SplitN(strings.Split(rootfs, ",")[0], ":", 2)
+		st, ok := s.storages[parts[0]]
+		if !ok {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("storage '%s' does not exist", parts[0]))
+			return
+		}
+		sizeGB := int64(8)
+		if len(parts) == 2 {
+			if n, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+				sizeGB = n
+			}
+		}
+		volid := fmt.Sprintf("%s:vm-%d-disk-0", st.name, vmid)
+		st.volumes[volid] = &volume{
+			volid:   volid,
+			format:  "raw",
+			content: "images",
+			size:    sizeGB * 1024 * 1024 * 1024,
+			vmid:    vmid,
+		}
+		config["rootfs"] = fmt.Sprintf("%s,size=%dG", volid, sizeGB)
+		ct.MaxDisk = sizeGB * 1024 * 1024 * 1024
+	}
+
+	s.containers[vmid] = &container{ct: ct, kind: "lxc", node: mux.Vars(r)["node"], config: config}
+	writeData(w, s.newUPID(mux.Vars(r)["node"], "vzcreate", strconv.Itoa(vmid)))
+}
+
+func (s *Server) handleDeleteContainer(w http.ResponseWriter, r *http.Request) {
+	vmid := vmidVar(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.lookupContainer(w, r, vmid)
+	if !ok {
+		return
+	}
+	label, prefix := c.label()
+	if c.ct.Status == "running" {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("%s %d is running - destroy failed", label, vmid))
+		return
+	}
+
+	// Owned volumes are destroyed together with the container
+	for _, st := range s.storages {
+		for volid, vol := range st.volumes {
+			if vol.vmid == vmid {
+				delete(st.volumes, volid)
+			}
+		}
+	}
+	delete(s.containers, vmid)
+	writeData(w, s.newUPID(mux.Vars(r)["node"], prefix+"destroy", strconv.Itoa(vmid)))
+}
+
+func (s *Server) handleContainerStatus(w http.ResponseWriter, r *http.Request) {
+	vmid := vmidVar(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.lookupContainer(w, r, vmid)
+	if !ok {
+		return
+	}
+	writeData(w, containerStatus(c))
+}
+
+func (s *Server) handleContainerAction(w http.ResponseWriter, r *http.Request) {
+	vmid := vmidVar(r)
+	action := mux.Vars(r)["action"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.lookupContainer(w, r, vmid)
+	if !ok {
+		return
+	}
+
+	label, prefix := c.label()
+	switch action {
+	case "start":
+		if c.ct.Status == "running" {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("%s %d already running", label, vmid))
+			return
+		}
+		if c.config["template"] == "1" {
+			writeError(w, http.StatusInternalServerError, "you can't start a vm if it's a template")
+			return
+		}
+		c.applyPending()
+		c.ct.Status = "running"
+		c.ct.Uptime = 1
+		writeData(w, s.newUPID(mux.Vars(r)["node"], prefix+"start", strconv.Itoa(vmid)))
+	case "stop", "shutdown":
+		c.ct.Status = "stopped"
+		c.ct.Uptime = 0
+		writeData(w, s.newUPID(mux.Vars(r)["node"], prefix+action, strconv.Itoa(vmid)))
+	case "reboot":
+		if c.ct.Status != "running" {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("%s %d not running", label, vmid))
+			return
+		}
+		c.applyPending()
+		writeData(w, s.newUPID(mux.Vars(r)["node"], prefix+"reboot", strconv.Itoa(vmid)))
+	default:
+		writeError(w, http.StatusNotImplemented, fmt.Sprintf("unsupported action '%s'", action))
+	}
+}
+
+func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	vmid := vmidVar(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.lookupContainer(w, r, vmid)
+	if !ok {
+		return
+	}
+	writeData(w, c.config)
+}
+
+func (s *Server) handleUpdateConfig(w http.ResponseWriter, r *http.Request) {
+	vmid := vmidVar(r)
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.lookupContainer(w, r, vmid)
+	if !ok {
+		return
+	}
+
+	running := c.ct.Status == "running"
+	for key := range r.PostForm {
+		if key == "delete" {
+			for _, k := range strings.Split(r.PostForm.Get(key), ",") {
+				k = strings.TrimSpace(k)
+				if running && !hotplugKeys.MatchString(k) {
+					c.setPending(k, "", true)
+					continue
+				}
+				delete(c.config, k)
+			}
+			continue
+		}
+		if running && !hotplugKeys.MatchString(key) {
+			c.setPending(key, r.PostForm.Get(key), false)
+			continue
+		}
+		c.config[key] = r.PostForm.Get(key)
+	}
+	c.syncName()
+	writeData(w, nil)
+}
+
+// setPending records a change to apply on the next restart
+func (c *container) setPending(key, value string, remove bool) {
+	if c.pending == nil {
+		c.pending = make(map[string]string)
+		c.pendingDelete = make(map[string]bool)
+	}
+	if remove {
+		delete(c.pending, key)
+		c.pendingDelete[key] = true
+		return
+	}
+	delete(c.pendingDelete, key)
+	c.pending[key] = value
+}
+
+// applyPending moves the pending changes into the config, as a restart does
+func (c *container) applyPending() {
+	for key, value := range c.pending {
+		c.config[key] = value
+	}
+	for key := range c.pendingDelete {
+		delete(c.config, key)
+	}
+	c.pending = nil
+	c.pendingDelete = nil
+	c.syncName()
+}
+
+// syncName keeps the listed name in line with the hostname (or VM name) in the config
+func (c *container) syncName() {
+	if hostname, ok := c.config["hostname"]; ok && c.kind == "lxc" {
+		c.ct.Name = hostname
+	}
+	if name, ok := c.config["name"]; ok && c.kind == "qemu" {
+		c.ct.Name = name
+	}
+}
+
+func (s *Server) handleGetPending(w http.ResponseWriter, r *http.Request) {
+	vmid := vmidVar(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.lookupContainer(w, r, vmid)
+	if !ok {
+		return
+	}
+
+	keys := make(map[string]bool)
+	for key := range c.config {
+		keys[key] = true
+	}
+	for key := range c.pending {
+		keys[key] = true
+	}
+	for key := range c.pendingDelete {
+		keys[key] = true
+	}
+
+	entries := make([]map[string]interface{}, 0, len(keys))
+	for key := range keys {
+		entry := map[string]interface{}{"key": key}
+		if value, ok := c.config[key]; ok {
+			entry["value"] = value
+		}
+		if value, ok := c.pending[key]; ok {
+			entry["pending"] = value
+		}
+		if c.pendingDelete[key] {
+			entry["delete"] = 1
+		}
+		entries = append(entries, entry)
+	}
+	writeData(w, entries)
+}
+
+func (s *Server) handleResizeDisk(w http.ResponseWriter, r *http.Request) {
+	vmid := vmidVar(r)
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	disk := r.PostForm.Get("disk")
+	size := r.PostForm.Get("size")
+	if disk == "" {
+		writeParamError(w, map[string]string{"disk": "property is missing and it is not optional"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.lookupContainer(w, r, vmid)
+	if !ok {
+		return
+	}
+
+	value, ok := c.config[disk]
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("unable to parse config '%s'", disk))
+		return
+	}
+	volid := strings.Split(value, ",")[0]
+	vol := s.findVolume(volid)
+	if vol == nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("no such volume '%s'", volid))
+		return
+	}
+
+	grow := strings.HasPrefix(size, "+")
+	newSize, err := parseSize(strings.TrimPrefix(size, "+"))
+	if err != nil {
+		writeParamError(w, map[string]string{"size": "value does not match the regex pattern"})
+		return
+	}
+	if grow {
+		newSize += vol.size
+	}
+	if newSize < vol.size {
+		writeError(w, http.StatusInternalServerError, "unable to shrink disk size")
+		return
+	}
+
+	vol.size = newSize
+	c.config[disk] = withDiskSize(value, newSize)
+	if disk == "rootfs" {
+		c.ct.MaxDisk = newSize
+	}
+	writeData(w, s.newUPID(mux.Vars(r)["node"], "resize", strconv.Itoa(vmid)))
+}
+
+// withDiskSize replaces the size option of a disk config value, keeping the
+// volid and the other options in place
+func withDiskSize(value string, size int64) string {
+	parts := strings.Split(value, ",")
+	out := parts[:1]
+	for _, opt := range parts[1:] {
+		if !strings.HasPrefix(opt, "size=") {
+			out = append(out, opt)
+		}
+	}
+	out = append(out, fmt.Sprintf("size=%dG", size/(1024*1024*1024)))
+	return strings.Join(out, ",")
+}
+
+func (s *Server) handleMoveVolume(w http.ResponseWriter, r *http.Request) {
+	vmid := vmidVar(r)
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	disk := r.PostForm.Get("volume")
+	target := r.PostForm.Get("storage")
+	if disk == "" {
+		writeParamError(w, map[string]string{"volume": "property is missing and it is not optional"})
+		return
+	}
+	if target == "" {
+		writeParamError(w, map[string]string{"storage": "property is missing and it is not optional"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.lookupContainer(w, r, vmid)
+	if !ok {
+		return
+	}
+	value, ok := c.config[disk]
+	if !ok || (disk != "rootfs" && !strings.HasPrefix(disk, "mp")) {
+		writeParamError(w, map[string]string{"volume": fmt.Sprintf("value '%s' does not have a value in the enumeration", disk)})
+		return
+	}
+	dest, ok := s.storages[target]
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("storage '%s' does not exist", target))
+		return
+	}
+	if c.ct.Status == "running" {
+		writeError(w, http.StatusInternalServerError, "cannot move volumes of a running container")
+		return
+	}
+	volid := strings.Split(value, ",")[0]
+	vol := s.findVolume(volid)
+	if vol == nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("no such volume '%s'", volid))
+		return
+	}
+	source := strings.SplitN(volid, ":", 2)[0]
+	if source == target {
+		writeError(w, http.StatusInternalServerError, "you can't move to the same storage with same format")
+		return
+	}
+
+	upid := s.newUPID(mux.Vars(r)["node"], "move_volume", strconv.Itoa(vmid))
+	if _, fails := s.taskFails["move_volume"]; fails {
+		writeData(w, upid)
+		return
+	}
+
+	index := 0
+	for {
+		if _, exists := dest.volumes[fmt.Sprintf("%s:vm-%d-disk-%d", dest.name, vmid, index)]; !exists {
+			break
+		}
+		index++
+	}
+	newVolID := fmt.Sprintf("%s:vm-%d-disk-%d", dest.name, vmid, index)
+	dest.volumes[newVolID] = &volume{
+		volid:   newVolID,
+		format:  vol.format,
+		content: vol.content,
+		size:    vol.size,
+		vmid:    vmid,
+	}
+	c.config[disk] = newVolID + strings.TrimPrefix(value, volid)
+
+	if r.PostForm.Get("delete") == "1" {
+		delete(s.storages[source].volumes, volid)
+	} else {
+		unused := 0
+		for {
+			if _, exists := c.config[fmt.Sprintf("unused%d", unused)]; !exists {
+				break
+			}
+			unused++
+		}
+		c.config[fmt.Sprintf("unused%d", unused)] = volid
+	}
+	writeData(w, upid)
+}
+
+func (s *Server) handleClone(w http.ResponseWriter, r *http.Request) {
+	vmid := vmidVar(r)
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	newID, err := strconv.Atoi(r.PostForm.Get("newid"))
+	if err != nil || newID < 100 {
+		writeParamError(w, map[string]string{"newid": "invalid format"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src, ok := s.lookupContainer(w, r, vmid)
+	if !ok {
+		return
+	}
+	label, prefix := src.label()
+	if existing, exists := s.containers[newID]; exists {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("unable to create %s %d: config file already exists on node '%s'", label, newID, existing.node))
+		return
+	}
+	full := r.PostForm.Get("full") == "1"
+	if !full && src.config["template"] != "1" {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Linked clone feature is not supported for running/non-template %ss", label))
+		return
+	}
+	target := mux.Vars(r)["node"]
+	if t := r.PostForm.Get("target"); t != "" {
+		if !s.hasNode(t) {
+			writeParamError(w, map[string]string{"target": fmt.Sprintf("no such cluster node '%s'", t)})
+			return
+		}
+		target = t
+	}
+
+	config := make(map[string]string, len(src.config))
+	for k, v := range src.config {
+		if k != "template" {
+			config[k] = v
+		}
+	}
+	nameKey := "name"
+	if src.kind == "lxc" {
+		nameKey = "hostname"
+	}
+	if name := r.PostForm.Get(nameKey); name != "" {
+		config[nameKey] = name
+	}
+
+	// Every disk gets a copy owned by the new guest, the rootfs first
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if (keys[i] == "rootfs") != (keys[j] == "rootfs") {
+			return keys[i] == "rootfs"
+		}
+		return keys[i] < keys[j]
+	})
+	diskIndex := 0
+	for _, key := range keys {
+		value := config[key]
+		if !strings.Contains(value, ":") || strings.Contains(value, "media=cdrom") {
+			continue
+		}
+		volid := strings.Split(value, ",")[0]
+		parts := strings.SplitN(volid, ":", 2)
+		st, ok := s.storages[parts[0]]
+		if !ok {
+			continue
+		}
+		srcVol, ok := st.volumes[volid]
+		if !ok {
+			continue
+		}
+		if storageName := r.PostForm.Get("storage"); full && storageName != "" {
+			if target, ok := s.storages[storageName]; ok {
+				st = target
+			}
+		}
+		newVolID := fmt.Sprintf("%s:vm-%d-disk-%d", st.name, newID, diskIndex)
+		diskIndex++
+		st.volumes[newVolID] = &volume{
+			volid:   newVolID,
+			format:  srcVol.format,
+			content: "images",
+			size:    srcVol.size,
+			vmid:    newID,
+		}
+		config[key] = newVolID + strings.TrimPrefix(value, volid)
+	}
+
+	ct := src.ct
+	ct.VMID = newID
+	ct.Status = "stopped"
+	ct.Uptime = 0
+	clone := &container{ct: ct, kind: src.kind, node: target, config: config}
+	clone.syncName()
+	s.containers[newID] = clone
+	writeData(w, s.newUPID(mux.Vars(r)["node"], prefix+"clone", strconv.Itoa(vmid)))
+}
+
+func (s *Server) handleConvertToTemplate(w http.ResponseWriter, r *http.Request) {
+	vmid := vmidVar(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.lookupContainer(w, r, vmid)
+	if !ok {
+		return
+	}
+	label, _ := c.label()
+	if c.ct.Status == "running" {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("%s %d is running - unable to convert to template", label, vmid))
+		return
+	}
+	if c.config["template"] == "1" {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("%s %d is already a template", label, vmid))
+		return
+	}
+	c.config["template"] = "1"
+	writeData(w, nil)
+}
+
+func (s *Server) handleMigrate(w http.ResponseWriter, r *http.Request) {
+	vmid := vmidVar(r)
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	target := r.PostForm.Get("target")
+	if target == "" {
+		writeParamError(w, map[string]string{"target": "property is missing and it is not optional"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.hasNode(target) {
+		writeParamError(w, map[string]string{"target": fmt.Sprintf("no such cluster node '%s'", target)})
+		return
+	}
+	c, ok := s.lookupContainer(w, r, vmid)
+	if !ok {
+		return
+	}
+	label, prefix := c.label()
+	if target == c.node {
+		writeError(w, http.StatusInternalServerError, "target is local node.")
+		return
+	}
+	if c.ct.Status == "running" && r.PostForm.Get("restart") != "1" {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("%s %d is running - use restart migration", label, vmid))
+		return
+	}
+
+	// Map the disks to the target storage, either one storage or "source:target" pairs
+	mapping := make(map[string]string)
+	if targetStorage := r.PostForm.Get("target-storage"); targetStorage != "" {
+		for _, pair := range strings.Split(targetStorage, ",") {
+			parts := strings.SplitN(pair, ":", 2)
+			if len(parts) == 1 {
+				mapping["*"] = parts[0]
+			} else {
+				mapping[parts[0]] = parts[1]
+			}
+			dest := parts[len(parts)-1]
+			if _, ok := s.storages[dest]; !ok {
+				writeError(w, http.StatusInternalServerError, fmt.Sprintf("storage '%s' does not exist", dest))
+				return
+			}
+		}
+	}
+
+	upid := s.newUPID(mux.Vars(r)["node"], prefix+"migrate", strconv.Itoa(vmid))
+	if _, fails := s.taskFails[prefix+"migrate"]; fails {
+		writeData(w, upid)
+		return
+	}
+
+	for key, value := range c.config {
+		volid := strings.Split(value, ",")[0]
+		vol := s.findVolume(volid)
+		if vol == nil || vol.vmid != vmid {
+			continue
+		}
+		parts := strings.SplitN(volid, ":", 2)
+		dest, ok := mapping[parts[0]]
+		if !ok {
+			dest, ok = mapping["*"]
+		}
+		if !ok || dest == parts[0] {
+			continue
+		}
+		delete(s.storages[parts[0]].volumes, volid)
+		newVolID := dest + ":" + parts[1]
+		vol.volid = newVolID
+		s.storages[dest].volumes[newVolID] = vol
+		c.config[key] = newVolID + strings.TrimPrefix(value, volid)
+	}
+	c.node = target
+	writeData(w, upid)
+}
+
+func (s *Server) handleListGuestSnapshots(w http.ResponseWriter, r *http.Request) {
+	vmid := vmidVar(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.lookupContainer(w, r, vmid)
+	if !ok {
+		return
+	}
+
+	snaps := make([]*guestSnapshot, 0, len(c.snapshots))
+	for _, snap := range c.snapshots {
+		snaps = append(snaps, snap)
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].seq < snaps[j].seq })
+
+	list := make([]map[string]interface{}, 0, len(snaps)+1)
+	for _, snap := range snaps {
+		entry := map[string]interface{}{
+			"name":        snap.name,
+			"description": snap.description,
+			"snaptime":    snap.snaptime,
+		}
+		if snap.parent != "" {
+			entry["parent"] = snap.parent
+		}
+		list = append(list, entry)
+	}
+	current := map[string]interface{}{"name": "current", "description": "You are here!"}
+	if c.parentSnap != "" {
+		current["parent"] = c.parentSnap
+	}
+	if c.ct.Status == "running" {
+		current["running"] = 1
+	}
+	writeData(w, append(list, current))
+}
+
+func (s *Server) handleCreateGuestSnapshot(w http.ResponseWriter, r *http.Request) {
+	vmid := vmidVar(r)
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := r.PostForm.Get("snapname")
+	if name == "" {
+		writeParamError(w, map[string]string{"snapname": "property is missing and it is not optional"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.lookupContainer(w, r, vmid)
+	if !ok {
+		return
+	}
+	if _, exists := c.snapshots[name]; exists || name == "current" {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("snapshot name '%s' already used", name))
+		return
+	}
+
+	// Every disk must live on a storage with snapshot support
+	for key, value := range c.config {
+		if !guestDiskKey(key) {
+			continue
+		}
+		storageName := strings.SplitN(strings.Split(value, ",")[0], ":", 2)[0]
+		if st, ok := s.storages[storageName]; ok && st.typ == "dir" {
+			writeError(w, http.StatusInternalServerError, "snapshot feature is not available")
+			return
+		}
+	}
+
+	config := make(map[string]string, len(c.config))
+	for k, v := range c.config {
+		config[k] = v
+	}
+	if c.snapshots == nil {
+		c.snapshots = make(map[string]*guestSnapshot)
+	}
+	c.snapSeq++
+	c.snapshots[name] = &guestSnapshot{
+		name:        name,
+		description: r.PostForm.Get("description"),
+		snaptime:    time.Now().Unix(),
+		parent:      c.parentSnap,
+		seq:         c.snapSeq,
+		config:      config,
+	}
+	c.parentSnap = name
+
+	_, prefix := c.label()
+	writeData(w, s.newUPID(mux.Vars(r)["node"], prefix+"snapshot", strconv.Itoa(vmid)))
+}
+
+func (s *Server) handleRollbackGuestSnapshot(w http.ResponseWriter, r *http.Request) {
+	vmid := vmidVar(r)
+	name := mux.Vars(r)["snap"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.lookupContainer(w, r, vmid)
+	if !ok {
+		return
+	}
+	snap, ok := c.snapshots[name]
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("snapshot '%s' does not exist", name))
+		return
+	}
+
+	// Rolling back stops the guest and restores the config of the snapshot
+	c.config = make(map[string]string, len(snap.config))
+	for k, v := range snap.config {
+		c.config[k] = v
+	}
+	c.pending = nil
+	c.pendingDelete = nil
+	c.syncName()
+	c.ct.Status = "stopped"
+	c.ct.Uptime = 0
+	c.parentSnap = name
+
+	_, prefix := c.label()
+	writeData(w, s.newUPID(mux.Vars(r)["node"], prefix+"rollback", strconv.Itoa(vmid)))
+}
+
+func (s *Server) handleDeleteGuestSnapshot(w http.ResponseWriter, r *http.Request) {
+	vmid := vmidVar(r)
+	name := mux.Vars(r)["snap"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.lookupContainer(w, r, vmid)
+	if !ok {
+		return
+	}
+	snap, ok := c.snapshots[name]
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("snapshot '%s' does not exist", name))
+		return
+	}
+
+	// Snapshots taken on top of the deleted one move to its parent
+	for _, other := range c.snapshots {
+		if other.parent == name {
+			other.parent = snap.parent
+		}
+	}
+	if c.parentSnap == name {
+		c.parentSnap = snap.parent
+	}
+	delete(c.snapshots, name)
+
+	_, prefix := c.label()
+	writeData(w, s.newUPID(mux.Vars(r)["node"], prefix+"delsnapshot", strconv.Itoa(vmid)))
+}
+
+// guestDiskKey reports whether a config key holds a disk of a container or VM
+func guestDiskKey(key string) bool {
+	if key == "rootfs" {
+		return true
+	}
+	for _, prefix := range []string{"mp", "scsi", "virtio", "sata", "ide"} {
+		if n := strings.TrimPrefix(key, prefix); n != key {
+			if _, err := strconv.Atoi(n); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Server) handleTermProxy(w http.ResponseWriter, r *http.Request) {
+	vmid := vmidVar(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lookupContainer(w, r, vmid); !ok {
+		return
+	}
+	writeData(w, map[string]interface{}{
+		"user":   DefaultTokenID,
+		"ticket": "PVEVNC:FAKE",
+		"port":   "5900",
+		"upid":   s.newUPID(mux.Vars(r)["node"], "vncproxy", strconv.Itoa(vmid)),
+	})
+}
+
+func (s *Server) handleVzdump(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	vmid, err := strconv.Atoi(r.PostForm.Get("vmid"))
+	if err != nil {
+		writeParamError(w, map[string]string{"vmid": "invalid format"})
+		return
+	}
+	storageName := r.PostForm.Get("storage")
+	if storageName == "" {
+		storageName = "local"
+	}
+	node := mux.Vars(r)["node"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.containers[vmid]
+	if !ok || c.node != node {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("unable to find VMID '%d'", vmid))
+		return
+	}
+	st, ok := s.storages[storageName]
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("storage '%s' does not exist", storageName))
+		return
+	}
+	if !containsCSV(st.content, "backup") {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("storage '%s' does not support backups", storageName))
+		return
+	}
+
+	upid := s.newUPID(node, "vzdump", strconv.Itoa(vmid))
+	if _, fails := s.taskFails["vzdump"]; fails {
+		writeData(w, upid)
+		return
+	}
+
+	saved := &archive{kind: c.kind, config: make(map[string]string, len(c.config)), disks: make(map[string]int64)}
+	var total int64
+	for key, value := range c.config {
+		saved.config[key] = value
+		if !guestDiskKey(key) || strings.Contains(value, "media=cdrom") {
+			continue
+		}
+		if vol := s.findVolume(strings.Split(value, ",")[0]); vol != nil {
+			saved.disks[key] = vol.size
+			total += vol.size
+		}
+	}
+
+	format := "tar"
+	if c.kind == "qemu" {
+		format = "vma"
+	}
+	if compress := r.PostForm.Get("compress"); compress != "" && compress != "0" {
+		format += "." + map[string]string{"zstd": "zst", "gzip": "gz", "lzo": "lzo"}[compress]
+	}
+
+	// Archive names only have second resolution, bump the time on a collision
+	ctime := time.Now().Unix()
+	var volid string
+	for {
+		volid = fmt.Sprintf("%s:backup/vzdump-%s-%d-%s.%s", st.name, c.kind, vmid, time.Unix(ctime, 0).UTC().Format("2006_01_02-15_04_05"), format)
+		if _, exists := st.volumes[volid]; !exists {
+			break
+		}
+		ctime++
+	}
+
+	notes := strings.NewReplacer("{{guestname}}", c.ct.Name, "{{vmid}}", strconv.Itoa(vmid), "{{node}}", node).
+		Replace(r.PostForm.Get("notes-template"))
+	st.volumes[volid] = &volume{
+		volid:   volid,
+		format:  format,
+		content: "backup",
+		size:    total / 4, // Compressed archives are much smaller than the disks
+		vmid:    vmid,
+		ctime:   ctime,
+		notes:   notes,
+		archive: saved,
+	}
+	writeData(w, upid)
+}
+
+// handleCreateVM only supports restoring VMs from vzdump archives
+func (s *Server) handleCreateVM(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	vmid, err := strconv.Atoi(r.PostForm.Get("vmid"))
+	if err != nil || vmid < 100 {
+		writeParamError(w, map[string]string{"vmid": "invalid format"})
+		return
+	}
+	if r.PostForm.Get("archive") == "" {
+		writeError(w, http.StatusNotImplemented, "creating VMs without an archive is not implemented")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.restoreArchive(w, r, "qemu", vmid, r.PostForm.Get("archive"))
+}
+
+// restoreArchive recreates a guest and its disks from a vzdump archive,
+// replacing an existing stopped guest when force=1; callers must hold s.mu
+func (s *Server) restoreArchive(w http.ResponseWriter, r *http.Request, kind string, vmid int, volid string) {
+	vol := s.findVolume(volid)
+	if vol == nil || vol.archive == nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("no such volume '%s'", volid))
+		return
+	}
+	if vol.archive.kind != kind {
+		writeParamError(w, map[string]string{"archive": fmt.Sprintf("'%s' is not a %s backup", volid, kind)})
+		return
+	}
+
+	target := &container{ct: proxmox.Container{VMID: vmid, Status: "stopped"}, kind: kind, node: mux.Vars(r)["node"]}
+	label, prefix := target.label()
+	if existing, exists := s.containers[vmid]; exists {
+		if r.PostForm.Get("force") != "1" || existing.kind != kind {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("%s %d already exists on node '%s'", label, vmid, existing.node))
+			return
+		}
+		if existing.ct.Status == "running" {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("%s %d is running - unable to restore", label, vmid))
+			return
+		}
+	}
+
+	var override *storage
+	if name := r.PostForm.Get("storage"); name != "" {
+		st, ok := s.storages[name]
+		if !ok {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("storage '%s' does not exist", name))
+			return
+		}
+		override = st
+	}
+
+	// The guest being overwritten loses its disks
+	for _, st := range s.storages {
+		for id, v := range st.volumes {
+			if v.vmid == vmid && v.archive == nil {
+				delete(st.volumes, id)
+			}
+		}
+	}
+
+	config := make(map[string]string, len(vol.archive.config))
+	for key, value := range vol.archive.config {
+		config[key] = value
+	}
+	keys := make([]string, 0, len(vol.archive.disks))
+	for key := range vol.archive.disks {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if (keys[i] == "rootfs") != (keys[j] == "rootfs") {
+			return keys[i] == "rootfs"
+		}
+		return keys[i] < keys[j]
+	})
+	for index, key := range keys {
+		value := config[key]
+		oldVolID := strings.Split(value, ",")[0]
+		st := override
+		if st == nil {
+			st = s.storages[strings.SplitN(oldVolID, ":", 2)[0]]
+		}
+		if st == nil {
+			st = s.storages["local-lvm"]
+		}
+		newVolID := fmt.Sprintf("%s:vm-%d-disk-%d", st.name, vmid, index)
+		size := vol.archive.disks[key]
+		st.volumes[newVolID] = &volume{volid: newVolID, format: "raw", content: "images", size: size, vmid: vmid}
+		config[key] = newVolID + strings.TrimPrefix(value, oldVolID)
+		if key == "rootfs" {
+			target.ct.MaxDisk = size
+		}
+	}
+
+	target.config = config
+	if mem, err := strconv.ParseInt(config["memory"], 10, 64); err == nil {
+		target.ct.MaxMem = mem * 1024 * 1024
+	}
+	target.syncName()
+	s.containers[vmid] = target
+	writeData(w, s.newUPID(mux.Vars(r)["node"], prefix+"restore", strconv.Itoa(vmid)))
+}
+
+func (s *Server) handleListStorage(w http.ResponseWriter, r *http.Request) {
+	contentFilter := r.URL.Query().Get("content")
+	storageFilter := r.URL.Query().Get("storage")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := []map[string]interface{}{}
+	for _, name := range s.storageNames() {
+		st := s.storages[name]
+		if storageFilter != "" && st.name != storageFilter {
+			continue
+		}
+		if contentFilter != "" && !containsCSV(st.content, contentFilter) {
+			continue
+		}
+
+		var used int64
+		for _, vol := range st.volumes {
+			used += vol.size
+		}
+		list = append(list, map[string]interface{}{
+			"storage":       st.name,
+			"type":          st.typ,
+			"content":       st.content,
+			"active":        1,
+			"enabled":       1,
+			"shared":        0,
+			"total":         st.total,
+			"used":          used,
+			"avail":         st.total - used,
+			"used_fraction": float64(used) / float64(st.total),
+		})
+	}
+	writeData(w, list)
+}
+
+// containsCSV reports whether a comma-separated list contains value
+func containsCSV(list, value string) bool {
+	for _, item := range strings.Split(list, ",") {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) handleListContent(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.storages[mux.Vars(r)["storage"]]
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("storage '%s' does not exist", mux.Vars(r)["storage"]))
+		return
+	}
+	contentFilter := r.URL.Query().Get("content")
+	vmidFilter, _ := strconv.Atoi(r.URL.Query().Get("vmid"))
+
+	volids := make([]string, 0, len(st.volumes))
+	for volid, vol := range st.volumes {
+		if contentFilter != "" && vol.content != contentFilter {
+			continue
+		}
+		if vmidFilter != 0 && vol.vmid != vmidFilter {
+			continue
+		}
+		volids = append(volids, volid)
+	}
+	sort.Strings(volids)
+
+	list := make([]map[string]interface{}, 0, len(volids))
+	for _, volid := range volids {
+		vol := st.volumes[volid]
+		item := map[string]interface{}{
+			"volid":   vol.volid,
+			"size":    vol.size,
+			"format":  vol.format,
+			"content": vol.content,
+		}
+		if vol.used != nil {
+			item["used"] = *vol.used
+		}
+		if vol.vmid != 0 {
+			item["vmid"] = vol.vmid
+		}
+		if vol.ctime != 0 {
+			item["ctime"] = vol.ctime
+		}
+		if vol.archive != nil {
+			item["subtype"] = vol.archive.kind
+			if vol.notes != "" {
+				item["notes"] = vol.notes
+			}
+		}
+		list = append(list, item)
+	}
+	writeData(w, list)
+}
+
+func (s *Server) handleAllocVolume(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filename := r.PostForm.Get("filename")
+	if filename == "" {
+		writeParamError(w, map[string]string{"filename": "property is missing and it is not optional"})
+		return
+	}
+	size, err := parseSize(r.PostForm.Get("size"))
+	if err != nil {
+		writeParamError(w, map[string]string{"size": err.Error()})
+		return
+	}
+	vmid, _ := strconv.Atoi(r.PostForm.Get("vmid"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.storages[mux.Vars(r)["storage"]]
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("storage '%s' does not exist", mux.Vars(r)["storage"]))
+		return
+	}
+
+	volid := fmt.Sprintf("%s:%s", st.name, filename)
+	if _, exists := st.volumes[volid]; exists {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("volume '%s' already exists", volid))
+		return
+	}
+	st.volumes[volid] = &volume{
+		volid:   volid,
+		format:  "raw",
+		content: "images",
+		size:    size,
+		vmid:    vmid,
+		ctime:   time.Now().Unix(),
+	}
+	writeData(w, volid)
+}
+
+func (s *Server) handleGetVolume(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vol := s.findVolume(mux.Vars(r)["volid"])
+	if vol == nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("no such volume '%s'", mux.Vars(r)["volid"]))
+		return
+	}
+	data := map[string]interface{}{
+		"volid":  vol.volid,
+		"size":   vol.size,
+		"format": vol.format,
+		"path":   "/dev/fake/" + strings.ReplaceAll(vol.volid, ":", "/"),
+	}
+	if vol.used != nil {
+		data["used"] = *vol.used
+	}
+	writeData(w, data)
+}
+
+func (s *Server) handleDeleteVolume(w http.ResponseWriter, r *http.Request) {
+	volid := mux.Vars(r)["volid"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.storages[mux.Vars(r)["storage"]]
+	if !ok || st.volumes[volid] == nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("no such volume '%s'", volid))
+		return
+	}
+	delete(st.volumes, volid)
+	writeData(w, s.newUPID(mux.Vars(r)["node"], "imgdel", volid))
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	file, header, err := r.FormFile("filename")
+	if err != nil {
+		writeParamError(w, map[string]string{"filename": "property is missing and it is not optional"})
+		return
+	}
+	defer file.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.storages[mux.Vars(r)["storage"]]
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("storage '%s' does not exist", mux.Vars(r)["storage"]))
+		return
+	}
+
+	volid := fmt.Sprintf("%s:%s/%s", st.name, r.FormValue("content"), header.Filename)
+	st.volumes[volid] = &volume{
+		volid:   volid,
+		format:  "tgz",
+		content: r.FormValue("content"),
+		size:    header.Size,
+	}
+	writeData(w, s.newUPID(mux.Vars(r)["node"], "imgcopy", ""))
+}
+
+func (s *Server) handleListSnapshots(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vol := s.findVolume(mux.Vars(r)["volid"])
+	if vol == nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("no such volume '%s'", mux.Vars(r)["volid"]))
+		return
+	}
+
+	list := make([]map[string]interface{}, 0, len(vol.snapshots))
+	for _, snap := range vol.snapshots {
+		list = append(list, map[string]interface{}{
+			"name":        snap.name,
+			"description": snap.description,
+			"ctime":       snap.ctime,
+		})
+	}
+	writeData(w, list)
+}
+
+func (s *Server) handleCreateSnapshot(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vol := s.findVolume(mux.Vars(r)["volid"])
+	if vol == nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("no such volume '%s'", mux.Vars(r)["volid"]))
+		return
+	}
+
+	name := r.PostForm.Get("snapname")
+	for _, snap := range vol.snapshots {
+		if snap.name == name {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("snapshot name '%s' already used", name))
+			return
+		}
+	}
+	vol.snapshots = append(vol.snapshots, snapshot{
+		name:        name,
+		description: r.PostForm.Get("description"),
+		ctime:       time.Now().Unix(),
+	})
+	writeData(w, s.newUPID(mux.Vars(r)["node"], "vzsnapshot", name))
+}
+
+func (s *Server) handleRollbackSnapshot(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vol := s.findVolume(mux.Vars(r)["volid"])
+	if vol == nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("no such volume '%s'", mux.Vars(r)["volid"]))
+		return
+	}
+
+	name := mux.Vars(r)["snap"]
+	for i, snap := range vol.snapshots {
+		if snap.name == name {
+			// Rolling back discards every newer snapshot
+			vol.snapshots = vol.snapshots[:i+1]
+			writeData(w, s.newUPID(mux.Vars(r)["node"], "vzrollback", name))
+			return
+		}
+	}
+	writeError(w, http.StatusInternalServerError, fmt.Sprintf("snapshot '%s' does not exist", name))
+}
+
+func (s *Server) handleDeleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vol := s.findVolume(mux.Vars(r)["volid"])
+	if vol == nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("no such volume '%s'", mux.Vars(r)["volid"]))
+		return
+	}
+
+	name := mux.Vars(r)["snap"]
+	for i, snap := range vol.snapshots {
+		if snap.name == name {
+			vol.snapshots = append(vol.snapshots[:i], vol.snapshots[i+1:]...)
+			writeData(w, s.newUPID(mux.Vars(r)["node"], "vzdelsnapshot", name))
+			return
+		}
+	}
+	writeError(w, http.StatusInternalServerError, fmt.Sprintf("snapshot '%s' does not exist", name))
+}
+
+func (s *Server) handleCloneSnapshot(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vol := s.findVolume(mux.Vars(r)["volid"])
+	if vol == nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("no such volume '%s'", mux.Vars(r)["volid"]))
+		return
+	}
+
+	name := mux.Vars(r)["snap"]
+	found := false
+	for _, snap := range vol.snapshots {
+		if snap.name == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("snapshot '%s' does not exist", name))
+		return
+	}
+
+	st := s.storages[mux.Vars(r)["storage"]]
+	if st == nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("storage '%s' does not exist", mux.Vars(r)["storage"]))
+		return
+	}
+	volid := fmt.Sprintf("%s:%s", st.name, r.PostForm.Get("target"))
+	st.volumes[volid] = &volume{
+		volid:   volid,
+		format:  vol.format,
+		content: vol.content,
+		size:    vol.size,
+	}
+	writeData(w, volid)
+}
+
+func (s *Server) handleApplySDN(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, zone := range s.zones {
+		zone.Pending = false
+		s.zones[id] = zone
+	}
+	writeData(w, s.newUPID(mux.Vars(r)["node"], "reloadnetworkall", ""))
+}
+
+func (s *Server) handleListZones(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]string, 0, len(s.zones))
+	for id := range s.zones {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	list := make([]proxmox.SDNZone, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, s.zones[id])
+	}
+	writeData(w, list)
+}
+
+func (s *Server) handleCreateZone(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	zoneID := r.PostForm.Get("zone")
+	if zoneID == "" || r.PostForm.Get("type") == "" {
+		writeParamError(w, map[string]string{"zone": "property is missing and it is not optional", "type": "property is missing and it is not optional"})
+		return
+	}
+	if len(zoneID) > 8 {
+		writeParamError(w, map[string]string{"zone": "zone ID too long"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.zones[zoneID]; exists {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("zone ID '%s' already defined", zoneID))
+		return
+	}
+	s.zones[zoneID] = proxmox.SDNZone{
+		Zone:    zoneID,
+		Type:    r.PostForm.Get("type"),
+		Nodes:   r.PostForm.Get("nodes"),
+		Pending: true,
+	}
+	writeData(w, nil)
+}
+
+func (s *Server) handleDeleteZone(w http.ResponseWriter, r *http.Request) {
+	zoneID := mux.Vars(r)["zone"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.zones[zoneID]; !exists {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("sdn '%s' does not exist", zoneID))
+		return
+	}
+	for id, vn := range s.vnets {
+		if vn.zone == zoneID {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("cannot delete zone '%s', vnet '%s' still uses it", zoneID, id))
+			return
+		}
+	}
+	delete(s.zones, zoneID)
+	writeData(w, nil)
+}
+
+func (s *Server) handleCreateVNet(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	vnetID := r.PostForm.Get("vnet")
+	zoneID := r.PostForm.Get("zone")
+	tag, _ := strconv.Atoi(r.PostForm.Get("tag"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.zones[zoneID]; !exists {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("zone '%s' does not exist", zoneID))
+		return
+	}
+	if _, exists := s.vnets[vnetID]; exists {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("vnet ID '%s' already defined", vnetID))
+		return
+	}
+	s.vnets[vnetID] = &vnet{
+		zone:    zoneID,
+		tag:     tag,
+		subnets: make(map[string]map[string]interface{}),
+	}
+	writeData(w, nil)
+}
+
+func (s *Server) handleDeleteVNet(w http.ResponseWriter, r *http.Request) {
+	vnetID := mux.Vars(r)["vnet"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vn, exists := s.vnets[vnetID]
+	if !exists {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("sdn '%s' does not exist", vnetID))
+		return
+	}
+	if len(vn.subnets) > 0 {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("cannot delete vnet '%s', subnets still exist", vnetID))
+		return
+	}
+	delete(s.vnets, vnetID)
+	writeData(w, nil)
+}
+
+func (s *Server) handleListSubnets(w http.ResponseWriter, r *http.Request) {
+	vnetID := mux.Vars(r)["vnet"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vn, exists := s.vnets[vnetID]
+	if !exists {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("sdn '%s' does not exist", vnetID))
+		return
+	}
+
+	ids := make([]string, 0, len(vn.subnets))
+	for id := range vn.subnets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	list := make([]map[string]interface{}, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, vn.subnets[id])
+	}
+	writeData(w, list)
+}
+
+func (s *Server) handleCreateSubnet(w http.ResponseWriter, r *http.Request) {
+	vnetID := mux.Vars(r)["vnet"]
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cidr := r.PostForm.Get("subnet")
+	if cidr == "" {
+		writeParamError(w, map[string]string{"subnet": "property is missing and it is not optional"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vn, exists := s.vnets[vnetID]
+	if !exists {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("sdn '%s' does not exist", vnetID))
+		return
+	}
+
+	id := fmt.Sprintf("%s-%s", vnetID, strings.ReplaceAll(cidr, "/", "-"))
+	subnet := map[string]interface{}{
+		"subnet": id,
+		"cidr":   cidr,
+		"vnet":   vnetID,
+		"type":   "subnet",
+		"zone":   vn.zone,
+	}
+	if gw := r.PostForm.Get("gateway"); gw != "" {
+		subnet["gateway"] = gw
+	}
+	if r.PostForm.Get("snat") == "1" {
+		subnet["snat"] = 1
+	}
+	if dhcp := r.PostForm.Get("dhcp-range"); dhcp != "" {
+		subnet["dhcp-range"] = []string{dhcp}
+	}
+	vn.subnets[id] = subnet
+	writeData(w, nil)
+}
+
+func (s *Server) handleDeleteSubnet(w http.ResponseWriter, r *http.Request) {
+	vnetID := mux.Vars(r)["vnet"]
+	subnetID := mux.Vars(r)["subnet"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vn, exists := s.vnets[vnetID]
+	if !exists || vn.subnets[subnetID] == nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("sdn subnet '%s' does not exist", subnetID))
+		return
+	}
+	delete(vn.subnets, subnetID)
+	writeData(w, nil)
+}
@@ -0,0 +1,184 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting Proxmox while the circuit breaker is tripped
+var ErrCircuitOpen = errors.New("proxmox API unavailable (circuit breaker open)")
+
+// RetryPolicy controls how transient Proxmox failures are retried
+type RetryPolicy struct {
+	MaxRetries int           // Retries after the first attempt; 0 disables retries
+	BaseDelay  time.Duration // Backoff before the first retry, doubled on each attempt
+	MaxDelay   time.Duration // Upper bound for a single backoff
+}
+
+// DefaultRetryPolicy is used by clients created with NewClient
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	BaseDelay:  250 * time.Millisecond,
+	MaxDelay:   5 * time.Second,
+}
+
+// backoff returns a jittered delay before retry number attempt (starting at 1)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// Full jitter spreads retries from concurrent requests apart
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// isRetryableRequest reports whether a request can safely be sent again.
+// GETs and PUTs (config updates) are idempotent; POSTs and DELETEs create or
+// destroy resources and are only retried for known-safe endpoints.
+func isRetryableRequest(method, path string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT":
+		return true
+	case "POST":
+		return strings.HasSuffix(path, "/termproxy")
+	}
+	return false
+}
+
+// Circuit breaker states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// CircuitBreaker stops calls to Proxmox after repeated transient failures so
+// callers fail fast (and fall back to the cache) instead of waiting out timeouts.
+// After the cooldown a single probe request is let through; its outcome
+// closes the circuit again or restarts the cooldown.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker creates a breaker that trips after threshold consecutive
+// failures and stays open for cooldown. A threshold of 0 disables it.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a request may be sent to Proxmox
+func (b *CircuitBreaker) Allow() bool {
+	if b == nil || b.threshold <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if time.Since(b.openedAt) < b.cooldown || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// RecordSuccess closes the circuit
+func (b *CircuitBreaker) RecordSuccess() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures >= b.threshold && b.threshold > 0 {
+		logCircuit("closed")
+	}
+	b.failures = 0
+	b.probing = false
+}
+
+// RecordFailure counts a transient failure and trips the circuit once the threshold is reached
+func (b *CircuitBreaker) RecordFailure() {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.failures >= b.threshold {
+		if !b.probing && b.failures == b.threshold {
+			logCircuit("opened")
+		}
+		b.openedAt = time.Now()
+	}
+	b.probing = false
+}
+
+// abandon releases a half-open probe whose outcome is unknown (e.g. the caller went away)
+func (b *CircuitBreaker) abandon() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// State returns the current breaker state
+func (b *CircuitBreaker) State() string {
+	if b == nil || b.threshold <= 0 {
+		return CircuitClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.failures < b.threshold:
+		return CircuitClosed
+	case b.probing || time.Since(b.openedAt) >= b.cooldown:
+		return CircuitHalfOpen
+	default:
+		return CircuitOpen
+	}
+}
+
+func logCircuit(state string) {
+	fmt.Printf("[WARNING] Proxmox API circuit breaker %s\n", state)
+}
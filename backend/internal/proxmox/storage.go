@@ -0,0 +1,86 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// snapshotStorageTypes are the storage types whose volumes can be snapshotted
+// through the storage API. LVM-thin and file-based storages (dir, NFS, CIFS)
+// only support snapshots of whole guests.
+var snapshotStorageTypes = map[string]bool{
+	"zfspool": true,
+	"zfs":     true,
+	"rbd":     true,
+	"btrfs":   true,
+}
+
+// hasContent reports whether a storage's comma-separated content list
+// includes any of the given content types
+func hasContent(list string, contents ...string) bool {
+	for _, item := range strings.Split(list, ",") {
+		for _, content := range contents {
+			if strings.TrimSpace(item) == content {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// usable reports whether a storage is enabled and active. Storages that do not
+// report the flags are assumed usable.
+func (s Storage) usable() bool {
+	return (s.Enabled == nil || *s.Enabled) && (s.Active == nil || *s.Active)
+}
+
+// discoverStorages returns the names of the usable storages on node (or the
+// default node) that hold any of the given content types, in the order
+// Proxmox lists them
+func (c *Client) discoverStorages(ctx context.Context, node string, contents ...string) ([]string, error) {
+	storages, err := c.GetStorage(ctx, &GetStorageRequest{Node: node})
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover storage: %w", err)
+	}
+
+	var names []string
+	for _, storage := range storages {
+		if storage.usable() && hasContent(storage.Content, contents...) {
+			names = append(names, storage.Storage)
+		}
+	}
+	return names, nil
+}
+
+// FindStorage returns the first usable storage on node (or the default node)
+// that holds the given content type, e.g. "rootdir" for container disks or
+// "backup" for vzdump archives
+func (c *Client) FindStorage(ctx context.Context, node string, content string) (string, error) {
+	names, err := c.discoverStorages(ctx, node, content)
+	if err != nil {
+		return "", err
+	}
+	if len(names) == 0 {
+		if node == "" {
+			node = c.node
+		}
+		return "", fmt.Errorf("no storage on node %s supports %s content", node, content)
+	}
+	return names[0], nil
+}
+
+// checkVolumeSnapshots returns ErrSnapshotsUnsupported if storage is of a type
+// that cannot snapshot single volumes. If the type cannot be looked up the
+// request is left for Proxmox to accept or reject.
+func (c *Client) checkVolumeSnapshots(ctx context.Context, node string, storage string) error {
+	storages, err := c.GetStorage(ctx, &GetStorageRequest{Node: node, Storage: storage})
+	if err != nil || len(storages) == 0 {
+		fmt.Printf("[WARNING] Could not look up the type of storage '%s': %v\n", storage, err)
+		return nil
+	}
+	if typ := storages[0].Type; !snapshotStorageTypes[typ] {
+		return fmt.Errorf("%w: storage '%s' is of type '%s'. Use ZFS, Ceph RBD or another snapshot-capable storage backend", ErrSnapshotsUnsupported, storage, typ)
+	}
+	return nil
+}
@@ -0,0 +1,369 @@
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Task status values reported by Proxmox
+const (
+	TaskRunning = "running"
+	TaskStopped = "stopped"
+)
+
+// UPID is a parsed Proxmox task identifier
+// Format: UPID:{node}:{pid}:{pstart}:{starttime}:{type}:{id}:{user}:
+type UPID struct {
+	Node      string
+	Type      string
+	ID        string
+	User      string
+	StartTime int64
+}
+
+// ParseUPID parses a Proxmox UPID string
+func ParseUPID(upid string) (*UPID, error) {
+	parts := strings.Split(upid, ":")
+	if len(parts) < 8 || parts[0] != "UPID" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid UPID: %s", upid)
+	}
+
+	startTime, err := strconv.ParseInt(parts[4], 16, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UPID start time: %s", upid)
+	}
+
+	return &UPID{
+		Node:      parts[1],
+		Type:      parts[5],
+		ID:        parts[6],
+		User:      parts[7],
+		StartTime: startTime,
+	}, nil
+}
+
+// VMID returns the guest a task is for, or 0 if it is not for a guest
+func (u *UPID) VMID() int {
+	vmid, err := strconv.Atoi(u.ID)
+	if err != nil || vmid <= 0 {
+		return 0
+	}
+	return vmid
+}
+
+// TaskStatus represents the status of a Proxmox task
+type TaskStatus struct {
+	UPID       string `json:"upid"`
+	Node       string `json:"node"`
+	Type       string `json:"type"`
+	ID         string `json:"id,omitempty"`
+	User       string `json:"user,omitempty"`
+	Status     string `json:"status"`               // running or stopped
+	ExitStatus string `json:"exitstatus,omitempty"` // "OK" on success, error message otherwise
+	StartTime  int64  `json:"starttime"`
+}
+
+// Finished reports whether the task has stopped running
+func (t *TaskStatus) Finished() bool {
+	return t.Status == TaskStopped
+}
+
+// Succeeded reports whether the task finished without error
+func (t *TaskStatus) Succeeded() bool {
+	return t.Finished() && (t.ExitStatus == "OK" || strings.HasPrefix(t.ExitStatus, "WARNINGS"))
+}
+
+// TaskLogLine is a single line of a Proxmox task log
+type TaskLogLine struct {
+	N int    `json:"n"`
+	T string `json:"t"`
+}
+
+// parseUPIDResponse extracts the UPID returned by an asynchronous Proxmox call
+func parseUPIDResponse(respBody []byte) (string, error) {
+	var response struct {
+		Data *string `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return "", fmt.Errorf("failed to parse task response: %w", err)
+	}
+	if response.Data == nil {
+		return "", nil
+	}
+	return *response.Data, nil
+}
+
+// GetTaskStatus retrieves the status of a task
+func (c *Client) GetTaskStatus(ctx context.Context, upid string) (*TaskStatus, error) {
+	parsed, err := ParseUPID(upid)
+	if err != nil {
+		return nil, err
+	}
+
+	path := fmt.Sprintf("/nodes/%s/tasks/%s/status", parsed.Node, url.PathEscape(upid))
+	respBody, err := c.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task status: %w", err)
+	}
+
+	var response struct {
+		Data TaskStatus `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse task status response: %w", err)
+	}
+
+	status := &response.Data
+	if status.UPID == "" {
+		status.UPID = upid
+	}
+	if status.Node == "" {
+		status.Node = parsed.Node
+	}
+	return status, nil
+}
+
+// GetTaskLog retrieves up to limit lines of a task log starting at line start
+func (c *Client) GetTaskLog(ctx context.Context, upid string, start, limit int) ([]TaskLogLine, error) {
+	parsed, err := ParseUPID(upid)
+	if err != nil {
+		return nil, err
+	}
+
+	path := fmt.Sprintf("/nodes/%s/tasks/%s/log?start=%d&limit=%d", parsed.Node, url.PathEscape(upid), start, limit)
+	respBody, err := c.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task log: %w", err)
+	}
+
+	var response struct {
+		Data []TaskLogLine `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse task log response: %w", err)
+	}
+
+	return response.Data, nil
+}
+
+// Task is a Proxmox task tracked by ProxiCloud
+type Task struct {
+	TaskStatus
+	Description string   `json:"description,omitempty"`
+	Log         []string `json:"log,omitempty"`
+	UpdatedAt   int64    `json:"updated_at"`
+
+	done chan struct{} // Closed when the tracker stops polling the task
+}
+
+// TaskTracker polls Proxmox tasks started by ProxiCloud until they finish
+type TaskTracker struct {
+	client       ProxmoxAPI
+	pollInterval time.Duration
+	maxTaskAge   time.Duration
+	logLimit     int
+
+	mu    sync.RWMutex
+	tasks map[string]*Task
+}
+
+// NewTaskTracker creates a new task tracker
+func NewTaskTracker(client ProxmoxAPI) *TaskTracker {
+	return &TaskTracker{
+		client:       client,
+		pollInterval: 2 * time.Second,
+		maxTaskAge:   24 * time.Hour,
+		logLimit:     500,
+		tasks:        make(map[string]*Task),
+	}
+}
+
+// SetPollInterval sets how often running tasks are polled
+func (t *TaskTracker) SetPollInterval(interval time.Duration) {
+	t.pollInterval = interval
+}
+
+// Track starts polling a task in the background until it finishes
+func (t *TaskTracker) Track(upid string, description string) *Task {
+	parsed, err := ParseUPID(upid)
+	if err != nil {
+		log.Printf("[WARNING] Not tracking task with invalid UPID %q: %v", upid, err)
+		return nil
+	}
+
+	task := &Task{
+		TaskStatus: TaskStatus{
+			UPID:      upid,
+			Node:      parsed.Node,
+			Type:      parsed.Type,
+			ID:        parsed.ID,
+			User:      parsed.User,
+			Status:    TaskRunning,
+			StartTime: parsed.StartTime,
+		},
+		Description: description,
+		UpdatedAt:   time.Now().Unix(),
+		done:        make(chan struct{}),
+	}
+
+	t.mu.Lock()
+	t.pruneLocked()
+	if existing, ok := t.tasks[upid]; ok {
+		t.mu.Unlock()
+		return existing.copy()
+	}
+	t.tasks[upid] = task
+	snapshot := task.copy()
+	t.mu.Unlock()
+
+	go func() {
+		defer close(task.done)
+		ctx, cancel := context.WithTimeout(context.Background(), t.maxTaskAge)
+		defer cancel()
+		if err := t.poll(ctx, upid); err != nil {
+			log.Printf("[WARNING] Stopped tracking task %s: %v", upid, err)
+		}
+	}()
+
+	return snapshot
+}
+
+// Get returns the current state of a task. Tasks not started through the
+// tracker are fetched from Proxmox directly.
+func (t *TaskTracker) Get(ctx context.Context, upid string) (*Task, error) {
+	t.mu.RLock()
+	task, ok := t.tasks[upid]
+	if ok {
+		snapshot := task.copy()
+		t.mu.RUnlock()
+		return snapshot, nil
+	}
+	t.mu.RUnlock()
+
+	return t.refresh(ctx, upid)
+}
+
+// Wait blocks until the task finishes or ctx is done. It waits for the
+// polling Track started, tracking the task first if needed, rather than
+// polling Proxmox itself.
+func (t *TaskTracker) Wait(ctx context.Context, upid string) (*Task, error) {
+	t.mu.RLock()
+	task, ok := t.tasks[upid]
+	t.mu.RUnlock()
+	if !ok {
+		if t.Track(upid, "") == nil {
+			return nil, fmt.Errorf("invalid UPID: %s", upid)
+		}
+		t.mu.RLock()
+		task = t.tasks[upid]
+		t.mu.RUnlock()
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-task.done:
+	}
+
+	t.mu.RLock()
+	snapshot := task.copy()
+	t.mu.RUnlock()
+	if !snapshot.Finished() {
+		return nil, fmt.Errorf("stopped tracking task %s before it finished", upid)
+	}
+	return snapshot, nil
+}
+
+// poll refreshes a tracked task until it finishes or ctx is done. It gives up
+// on Proxmox errors that retrying will not fix, e.g. an unknown task.
+func (t *TaskTracker) poll(ctx context.Context, upid string) error {
+	ticker := time.NewTicker(t.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		task, err := t.refresh(ctx, upid)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && !apiErr.IsTransient() {
+				return err
+			}
+			log.Printf("[WARNING] Failed to poll task %s: %v", upid, err)
+		} else if task.Finished() {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh fetches the task status (and log once finished) from Proxmox
+func (t *TaskTracker) refresh(ctx context.Context, upid string) (*Task, error) {
+	status, err := t.client.GetTaskStatus(ctx, upid)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	task, ok := t.tasks[upid]
+	if !ok {
+		task = &Task{}
+	}
+	task.TaskStatus = *status
+	task.UpdatedAt = time.Now().Unix()
+	t.mu.Unlock()
+
+	if status.Finished() {
+		lines, err := t.client.GetTaskLog(ctx, upid, 0, t.logLimit)
+		if err != nil {
+			log.Printf("[WARNING] Failed to fetch log for task %s: %v", upid, err)
+		} else {
+			logText := make([]string, len(lines))
+			for i, line := range lines {
+				logText[i] = line.T
+			}
+			t.mu.Lock()
+			task.Log = logText
+			t.mu.Unlock()
+		}
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return task.copy(), nil
+}
+
+// pruneLocked drops tasks not updated for maxTaskAge, finished or not, since
+// polling has stopped by then; callers must hold t.mu
+func (t *TaskTracker) pruneLocked() {
+	cutoff := time.Now().Add(-t.maxTaskAge).Unix()
+	for upid, task := range t.tasks {
+		if task.UpdatedAt < cutoff {
+			delete(t.tasks, upid)
+		}
+	}
+}
+
+// copy returns a snapshot of the task safe to hand to callers
+func (task *Task) copy() *Task {
+	c := *task
+	c.done = nil
+	if task.Log != nil {
+		c.Log = append([]string(nil), task.Log...)
+	}
+	return &c
+}
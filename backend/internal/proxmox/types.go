@@ -14,7 +14,7 @@ type Container struct {
 	Disk      int64   `json:"disk"`
 	MaxDisk   int64   `json:"maxdisk"`
 	Uptime    int64   `json:"uptime"`
-	Template  string  `json:"template,omitempty"`
+	Template  bool    `json:"template,omitempty"` // True for container templates
 	OS        string  `json:"os,omitempty"`
 	ProjectID string  `json:"project_id,omitempty"` // Associated project ID
 	IPAddress string  `json:"ip_address,omitempty"` // IP address (extracted from network config)
@@ -27,12 +27,15 @@ type CreateContainerRequest struct {
 	Cores        int    `json:"cores"`
 	Memory       int    `json:"memory"`
 	Disk         int    `json:"disk"`
+	Storage      string `json:"storage,omitempty"`       // Optional: storage for the rootfs (default: first storage on the node that holds container volumes)
+	StorageClass string `json:"storage_class,omitempty"` // Optional: storage class for the rootfs, resolved to a storage by the API
 	OSTemplate   string `json:"ostemplate"`
 	Password     string `json:"password,omitempty"`
 	SSHKeys      string `json:"ssh_keys,omitempty"`
 	StartOnBoot  bool   `json:"start_on_boot,omitempty"`
 	Unprivileged bool   `json:"unprivileged,omitempty"`
 	ProjectID    string `json:"project_id,omitempty"` // Optional: assign to project
+	Node         string `json:"node,omitempty"`       // Optional: node to create on (default: configured node)
 	// Network configuration
 	IPAddress  string `json:"ip_address,omitempty"` // IP address with CIDR notation (e.g., "192.168.1.100/24")
 	Gateway    string `json:"gateway,omitempty"`    // Gateway IP address (e.g., "192.168.1.1")
@@ -40,6 +43,99 @@ type CreateContainerRequest struct {
 	VNetID     string `json:"-"`                    // Internal: VNet ID to use (set by handler from project)
 }
 
+// CloneContainerRequest holds parameters for cloning a container
+type CloneContainerRequest struct {
+	NewID     int    `json:"newid,omitempty"`      // Optional: VMID of the clone (default: next free ID)
+	Hostname  string `json:"hostname,omitempty"`   // Hostname of the clone (default: the source's)
+	Full      bool   `json:"full,omitempty"`       // Full clone; linked clones are only possible from templates
+	Storage   string `json:"storage,omitempty"`    // Target storage for a full clone
+	Node      string `json:"node,omitempty"`       // Target node (default: the source container's node)
+	ProjectID string `json:"project_id,omitempty"` // Optional: assign the clone to a project
+}
+
+// MigrateContainerRequest holds parameters for moving a container to another node
+type MigrateContainerRequest struct {
+	Target        string `json:"target"`                   // Target node
+	Restart       bool   `json:"restart,omitempty"`        // Restart migration: required to move a running container
+	Timeout       int    `json:"timeout,omitempty"`        // Seconds to wait for the container to shut down in restart mode
+	TargetStorage string `json:"target_storage,omitempty"` // Storage on the target, or a "source:target,..." mapping
+}
+
+// UpdateContainerRequest holds the container settings to change; nil fields are left as they are
+type UpdateContainerRequest struct {
+	Cores      *int    `json:"cores,omitempty"`
+	Memory     *int    `json:"memory,omitempty"` // Memory in MB
+	Swap       *int    `json:"swap,omitempty"`   // Swap in MB
+	Hostname   *string `json:"hostname,omitempty"`
+	Nameserver *string `json:"nameserver,omitempty"` // DNS nameserver, empty to use the host settings
+	OnBoot     *bool   `json:"onboot,omitempty"`     // Start on host boot
+	Disk       *int    `json:"disk,omitempty"`       // New rootfs size in GB, can only grow
+}
+
+// PendingChange is a container config key together with its value in the
+// running config and the value that takes effect on the next restart
+type PendingChange struct {
+	Key     string      `json:"key"`
+	Value   interface{} `json:"value,omitempty"`   // Current value
+	Pending interface{} `json:"pending,omitempty"` // Value applied on restart
+	Delete  bool        `json:"delete,omitempty"`  // Key is removed on restart
+}
+
+// VM represents a QEMU virtual machine
+type VM struct {
+	VMID      int     `json:"vmid"`
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Node      string  `json:"node"`
+	CPU       float64 `json:"cpu"`
+	CPUs      int     `json:"cpus"`
+	Mem       int64   `json:"mem"`
+	MaxMem    int64   `json:"maxmem"`
+	Disk      int64   `json:"disk"`
+	MaxDisk   int64   `json:"maxdisk"`
+	Uptime    int64   `json:"uptime"`
+	Template  bool    `json:"template,omitempty"`   // True for VM templates
+	ProjectID string  `json:"project_id,omitempty"` // Associated project ID
+	IPAddress string  `json:"ip_address,omitempty"` // IP address (from the cloud-init ipconfig0)
+}
+
+// CreateVMRequest holds parameters for creating a VM from a VM template
+type CreateVMRequest struct {
+	VMID      *int   `json:"vmid,omitempty"` // Optional: user can specify VMID
+	Name      string `json:"name"`
+	Template  int    `json:"template"`             // VMID of the template to clone
+	Full      bool   `json:"full,omitempty"`       // Full clone instead of a linked clone
+	Storage   string `json:"storage,omitempty"`    // Target storage for a full clone
+	Node      string `json:"node,omitempty"`       // Optional: node to create on (default: the template's node)
+	ProjectID string `json:"project_id,omitempty"` // Optional: assign to project
+	Start     bool   `json:"start,omitempty"`      // Start the VM once it is configured
+	VMConfigRequest
+}
+
+// VMConfigRequest holds the VM hardware and cloud-init settings that can be changed
+type VMConfigRequest struct {
+	Cores      int    `json:"cores,omitempty"`
+	Memory     int    `json:"memory,omitempty"`     // Memory in MB
+	CIUser     string `json:"ciuser,omitempty"`     // cloud-init default user
+	CIPassword string `json:"cipassword,omitempty"` // cloud-init password
+	SSHKeys    string `json:"sshkeys,omitempty"`    // cloud-init SSH public keys, one per line
+	IPConfig0  string `json:"ipconfig0,omitempty"`  // cloud-init network, e.g. "ip=dhcp" or "ip=10.0.0.5/24,gw=10.0.0.1"
+	Nameserver string `json:"nameserver,omitempty"` // cloud-init DNS server
+	OnBoot     *bool  `json:"onboot,omitempty"`     // Start on host boot
+	Bridge     string `json:"-"`                    // Internal: bridge or VNet for net0 (set by handler from project)
+}
+
+// CloneVMRequest holds parameters for cloning a VM
+type CloneVMRequest struct {
+	NewID   int    `json:"newid"`
+	Name    string `json:"name,omitempty"`
+	Full    bool   `json:"full,omitempty"`    // Full clone instead of a linked clone (templates only)
+	Storage string `json:"storage,omitempty"` // Target storage for a full clone
+	Node    string `json:"node,omitempty"`    // Target node (default: the source VM's node)
+
+	ProjectID string `json:"project_id,omitempty"` // Optional: assign the clone to a project
+}
+
 // Template represents a container template
 type Template struct {
 	VolID   string `json:"volid"`
@@ -52,8 +148,10 @@ type Template struct {
 type Volume struct {
 	VolID      string `json:"volid"`
 	Name       string `json:"name"`
-	Size       int64  `json:"size"` // Size in GB
-	Used       int64  `json:"used"` // Used space in GB
+	Size       int64  `json:"size"`       // Size in GB, rounded down
+	Used       int64  `json:"used"`       // Used space in GB, rounded down
+	SizeBytes  int64  `json:"size_bytes"` // Size in bytes
+	UsedBytes  int64  `json:"used_bytes"` // Used space in bytes
 	Node       string `json:"node"`
 	Storage    string `json:"storage"`               // Storage pool (e.g., local-lvm, local-zfs)
 	Type       string `json:"type"`                  // ssd or hdd
@@ -62,21 +160,126 @@ type Volume struct {
 	AttachedTo *int   `json:"attached_to,omitempty"` // VMID if attached
 	MountPoint string `json:"mountpoint,omitempty"`  // Mount point if attached (mp0-mp9)
 	CreatedAt  int64  `json:"created_at,omitempty"`  // Unix timestamp
+
+	// Metadata kept by the volume registry
+	ProjectID   string            `json:"project_id,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
 }
 
 // CreateVolumeRequest holds parameters for creating a new volume
 type CreateVolumeRequest struct {
-	Name    string `json:"name"`
-	Size    int    `json:"size"`           // Size in GB
-	Storage string `json:"storage"`        // Storage pool (default: local-lvm)
-	Type    string `json:"type"`           // ssd or hdd (default: ssd)
-	Node    string `json:"node,omitempty"` // Optional: specific node
+	Name      string `json:"name"`
+	Size      int    `json:"size"`                 // Size in GB
+	Storage   string `json:"storage"`              // Storage pool (default: from the storage class, else the first storage on the node that holds disk images)
+	Type      string `json:"type"`                 // Storage class, e.g. ssd or hdd (default: the default class, else ssd)
+	Node      string `json:"node,omitempty"`       // Optional: specific node
+	ProjectID string `json:"project_id,omitempty"` // Optional: project the volume is for, checked against the class's allowed projects
+
+	Description string            `json:"description,omitempty"` // Optional: kept by the volume registry
+	Labels      map[string]string `json:"labels,omitempty"`      // Optional: kept by the volume registry
+}
+
+// UpdateVolumeRequest holds the volume metadata to change; nil fields are
+// left as they are and labels are replaced as a whole
+type UpdateVolumeRequest struct {
+	Name        *string           `json:"name,omitempty"`
+	Description *string           `json:"description,omitempty"`
+	ProjectID   *string           `json:"project_id,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// OrphanedVolume is a volume left behind by a guest that no longer exists
+type OrphanedVolume struct {
+	VolID     string   `json:"volid"`
+	Node      string   `json:"node"`
+	Storage   string   `json:"storage"`
+	Size      int64    `json:"size"`                 // Size in GB
+	VMID      int      `json:"vmid"`                 // The guest the volume belonged to
+	CreatedAt int64    `json:"created_at,omitempty"` // Unix timestamp, if the storage reports it
+	Age       int64    `json:"age,omitempty"`        // Seconds since CreatedAt
+	Snapshots []string `json:"snapshots,omitempty"`  // Snapshots deleted with the volume
+}
+
+// OrphanCleanupRequest selects orphaned volumes to delete. Without Confirm
+// nothing is deleted and the response shows what would be; with Confirm the
+// volumes must be named in VolIDs.
+type OrphanCleanupRequest struct {
+	VolIDs  []string `json:"volids,omitempty"`       // Orphans to delete (default: all, required with Confirm)
+	MinAge  int      `json:"min_age_days,omitempty"` // Only delete orphans at least this many days old
+	Confirm bool     `json:"confirm"`
+}
+
+// OrphanCleanupResult is the outcome of cleaning up one orphaned volume
+type OrphanCleanupResult struct {
+	OrphanedVolume
+	Status string `json:"status"` // "would_delete", "deleted", "skipped" or "failed"
+	Reason string `json:"reason,omitempty"`
+	TaskID string `json:"task_id,omitempty"`
+}
+
+// OrphanCleanupResponse summarizes an orphan cleanup
+type OrphanCleanupResponse struct {
+	DryRun    bool                  `json:"dry_run"`
+	Volumes   []OrphanCleanupResult `json:"volumes"`
+	Reclaimed int64                 `json:"reclaimed"` // GB freed, or that would be freed by a dry run
+}
+
+// StorageClass is an admin-defined class of storage, such as "ssd" or "hdd",
+// that volumes and container disks are created in
+type StorageClass struct {
+	Name        string   `json:"name"`
+	Storage     string   `json:"storage"` // Proxmox storage pool backing the class
+	Description string   `json:"description,omitempty"`
+	Default     bool     `json:"default,omitempty"`      // Used when a request names no class
+	DefaultSize int      `json:"default_size,omitempty"` // Size in GB when a request gives none
+	MaxSize     int      `json:"max_size,omitempty"`     // Largest volume or disk in GB (0 for no limit)
+	Snapshots   bool     `json:"snapshots"`              // Whether volumes in the class may be snapshotted
+	Projects    []string `json:"projects,omitempty"`     // Projects allowed to use the class (empty for all)
+}
+
+// AllowsProject reports whether a project may use the class. Classes without
+// a project list are open to all requests, including those without a project.
+func (c StorageClass) AllowsProject(projectID string) bool {
+	if len(c.Projects) == 0 {
+		return true
+	}
+	for _, id := range c.Projects {
+		if id == projectID {
+			return true
+		}
+	}
+	return false
+}
+
+// StorageClassStatus is a storage class with the capacity of its pool
+type StorageClassStatus struct {
+	StorageClass
+	Available bool   `json:"available"`       // Whether the pool was found, enabled and active
+	Total     *int64 `json:"total,omitempty"` // Total pool space in bytes
+	Used      *int64 `json:"used,omitempty"`  // Used pool space in bytes
+	Avail     *int64 `json:"avail,omitempty"` // Available pool space in bytes
 }
 
 // AttachVolumeRequest holds parameters for attaching a volume to a container
 type AttachVolumeRequest struct {
 	VMID       int    `json:"vmid"`
-	MountPoint string `json:"mountpoint,omitempty"` // Optional: mp0-mp9 (auto-detect if not provided)
+	MountPoint string `json:"mountpoint,omitempty"` // Optional: mp0-mp255 (first free one if not provided)
+	Path       string `json:"path,omitempty"`       // Path inside the container (default: /mnt/<volume name>)
+	Backup     *bool  `json:"backup,omitempty"`     // Include the volume in backups (Proxmox default: no)
+	ReadOnly   bool   `json:"ro,omitempty"`         // Mount read-only
+	Quota      bool   `json:"quota,omitempty"`      // Enable user quotas
+}
+
+// ResizeVolumeRequest holds parameters for growing a volume
+type ResizeVolumeRequest struct {
+	Size int `json:"size"` // New size in GB; volumes can only grow
+}
+
+// MoveVolumeRequest holds parameters for moving a volume to another storage
+type MoveVolumeRequest struct {
+	Storage    string `json:"storage"`               // Target storage pool
+	KeepSource bool   `json:"keep_source,omitempty"` // Keep the original volume as an unused disk
 }
 
 // DetachVolumeRequest holds parameters for detaching a volume
@@ -88,11 +291,13 @@ type DetachVolumeRequest struct {
 // Snapshot represents a volume snapshot
 type Snapshot struct {
 	Name        string `json:"name"`
-	VolID       string `json:"volid"`
+	VolID       string `json:"volid,omitempty"` // Set for volume snapshots
+	VMID        int    `json:"vmid,omitempty"`  // Set for container snapshots
 	Description string `json:"description,omitempty"`
 	CreatedAt   int64  `json:"created_at"`
-	Size        int64  `json:"size"`             // Snapshot size in GB
-	Parent      string `json:"parent,omitempty"` // Parent snapshot name
+	Size        int64  `json:"size"`              // Snapshot size in GB
+	Parent      string `json:"parent,omitempty"`  // Parent snapshot name
+	Current     bool   `json:"current,omitempty"` // The container's current state is based on this snapshot
 }
 
 // CreateSnapshotRequest holds parameters for creating a volume snapshot
@@ -113,6 +318,39 @@ type CloneSnapshotRequest struct {
 	Storage      string `json:"storage,omitempty"` // Optional: different storage pool
 }
 
+// Backup represents a vzdump archive in a storage's backup content
+type Backup struct {
+	VolID     string `json:"volid"`
+	Storage   string `json:"storage"`
+	Node      string `json:"node"` // Node the archive was listed through
+	VMID      int    `json:"vmid"`
+	Type      string `json:"type"`   // lxc or qemu
+	Format    string `json:"format"` // e.g. tar.zst, vma.zst
+	Size      int64  `json:"size"`   // Size in bytes
+	Notes     string `json:"notes,omitempty"`
+	Protected bool   `json:"protected,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// CreateBackupRequest holds parameters for backing up a guest with vzdump
+type CreateBackupRequest struct {
+	VMID     int    `json:"vmid"`
+	Storage  string `json:"storage,omitempty"`  // Storage with backup content (default: the project's backup storage)
+	Mode     string `json:"mode,omitempty"`     // snapshot, suspend or stop (default: snapshot)
+	Compress string `json:"compress,omitempty"` // zstd, lzo, gzip or 0 (default: zstd)
+	Notes    string `json:"notes,omitempty"`
+}
+
+// RestoreBackupRequest holds parameters for restoring a backup archive into a guest
+type RestoreBackupRequest struct {
+	VolID     string `json:"volid"`
+	VMID      *int   `json:"vmid,omitempty"`       // Target VMID (auto-assigned if not provided)
+	Storage   string `json:"storage,omitempty"`    // Storage for the restored disks (default: the original storages)
+	Node      string `json:"node,omitempty"`       // Node to restore on (default: the guest's node, or the configured node)
+	Force     bool   `json:"force,omitempty"`      // Overwrite the existing guest with this VMID
+	ProjectID string `json:"project_id,omitempty"` // Project to assign the restored guest to
+}
+
 // ProjectNetwork represents network configuration for a project
 type ProjectNetwork struct {
 	Subnet          string `json:"subnet,omitempty"`            // CIDR notation (e.g., "192.168.1.0/24")
@@ -126,15 +364,17 @@ type ProjectNetwork struct {
 
 // Project represents a logical grouping of containers
 type Project struct {
-	ID               string          `json:"id"`
-	Name             string          `json:"name"`
-	Description      string          `json:"description,omitempty"`
-	Tags             []string        `json:"tags,omitempty"`
-	Network          *ProjectNetwork `json:"network,omitempty"`
-	ContainerIDStart *int            `json:"container_id_start,omitempty"` // Start of container ID range (e.g., 200)
-	ContainerIDEnd   *int            `json:"container_id_end,omitempty"`   // End of container ID range (e.g., 299)
-	CreatedAt        int64           `json:"created_at"`
-	UpdatedAt        int64           `json:"updated_at"`
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	Description      string            `json:"description,omitempty"`
+	Tags             []string          `json:"tags,omitempty"`
+	Network          *ProjectNetwork   `json:"network,omitempty"`
+	ContainerIDStart *int              `json:"container_id_start,omitempty"` // Start of container ID range (e.g., 200)
+	ContainerIDEnd   *int              `json:"container_id_end,omitempty"`   // End of container ID range (e.g., 299)
+	BackupStorage    string            `json:"backup_storage,omitempty"`     // Default storage for backups of the project's guests
+	Members          map[string]string `json:"members,omitempty"`            // Role of each member, keyed by username
+	CreatedAt        int64             `json:"created_at"`
+	UpdatedAt        int64             `json:"updated_at"`
 }
 
 // CreateProjectRequest holds parameters for creating a new project
@@ -145,6 +385,7 @@ type CreateProjectRequest struct {
 	Network          *ProjectNetwork `json:"network,omitempty"`
 	ContainerIDStart *int            `json:"container_id_start,omitempty"` // Start of container ID range (e.g., 200)
 	ContainerIDEnd   *int            `json:"container_id_end,omitempty"`   // End of container ID range (e.g., 299)
+	BackupStorage    string          `json:"backup_storage,omitempty"`     // Default storage for backups of the project's guests
 }
 
 // UpdateProjectRequest holds parameters for updating a project
@@ -155,6 +396,13 @@ type UpdateProjectRequest struct {
 	Network          *ProjectNetwork `json:"network,omitempty"`
 	ContainerIDStart *int            `json:"container_id_start,omitempty"` // Start of container ID range
 	ContainerIDEnd   *int            `json:"container_id_end,omitempty"`   // End of container ID range
+	BackupStorage    *string         `json:"backup_storage,omitempty"`     // Default backup storage ("" clears it)
+}
+
+// ProjectMember is a user's role in a project
+type ProjectMember struct {
+	Username string `json:"username"`
+	Role     string `json:"role"` // admin, operator or viewer
 }
 
 // AssignProjectRequest holds parameters for assigning a container to a project
@@ -249,6 +497,7 @@ func parseBoolOrIntValue(v interface{}) *bool {
 
 // GetStorageRequest holds optional parameters for querying storage
 type GetStorageRequest struct {
+	Node    string `json:"node,omitempty"`    // Node to query (default: configured node)
 	Content string `json:"content,omitempty"` // Only list stores which support this content type
 	Enabled *bool  `json:"enabled,omitempty"` // Only list stores which are enabled
 	Format  *bool  `json:"format,omitempty"`  // Include information about formats
@@ -0,0 +1,121 @@
+package proxmox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ContainerExecutor runs commands inside containers. The Proxmox API cannot
+// do this, so it is used as a fallback to measure mount point usage the
+// storage does not report.
+type ContainerExecutor interface {
+	Exec(ctx context.Context, node string, vmid int, command ...string) ([]byte, error)
+}
+
+// PctExecutor runs commands with `pct exec`, either on the machine the
+// backend runs on or on the container's node over SSH
+type PctExecutor struct {
+	SSHUser string // Run over SSH as this user when set, otherwise run locally
+	SSHKey  string // Optional identity file for SSH
+}
+
+// Exec runs command inside the container and returns its standard output
+func (e *PctExecutor) Exec(ctx context.Context, node string, vmid int, command ...string) ([]byte, error) {
+	args := append([]string{"pct", "exec", strconv.Itoa(vmid), "--"}, command...)
+	if e.SSHUser != "" {
+		// ssh joins its arguments into one command line for the remote shell,
+		// so each word is quoted to reach pct unchanged
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			quoted[i] = shellQuote(arg)
+		}
+		ssh := []string{"ssh", "-o", "BatchMode=yes"}
+		if e.SSHKey != "" {
+			ssh = append(ssh, "-i", e.SSHKey)
+		}
+		args = append(ssh, fmt.Sprintf("%s@%s", e.SSHUser, node), "--", strings.Join(quoted, " "))
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %q in container %d: %w: %s", strings.Join(command, " "), vmid, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// shellQuote quotes a word for a POSIX shell
+func shellQuote(word string) string {
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// SetContainerExecutor sets the backend used to measure mount point usage
+// inside running containers; nil disables the fallback
+func (c *Client) SetContainerExecutor(executor ContainerExecutor) {
+	c.executor = executor
+}
+
+// mountPath returns the path inside the container a mount point config value
+// mounts its volume at, e.g. "/srv/data" for "local-lvm:vm-100-disk-1,mp=/srv/data"
+func mountPath(value string) string {
+	for _, option := range strings.Split(value, ",")[1:] {
+		if path, ok := strings.CutPrefix(option, "mp="); ok {
+			return path
+		}
+	}
+	return ""
+}
+
+// parseDFUsed returns the used bytes from the output of `df -P -k <path>`
+func parseDFUsed(out []byte) (int64, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for line := 0; scanner.Scan(); line++ {
+		// The first line is the header
+		if line == 0 {
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		used, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("unexpected df output %q: %w", scanner.Text(), err)
+		}
+		return used * 1024, nil
+	}
+	return 0, fmt.Errorf("unexpected df output %q", string(out))
+}
+
+// probeMountUsage measures the usage of a container mount point by running
+// df inside the container. It returns 0 if no executor is set, the config
+// value is not a mount point or the probe fails.
+func (c *Client) probeMountUsage(ctx context.Context, node string, vmid int, mountPoint string, value string) int64 {
+	if c.executor == nil || !mountPointPattern.MatchString(mountPoint) {
+		return 0
+	}
+	path := mountPath(value)
+	if path == "" {
+		return 0
+	}
+
+	out, err := c.executor.Exec(ctx, node, vmid, "df", "-P", "-k", path)
+	if err != nil {
+		fmt.Printf("[DEBUG] Failed to probe usage of %s in container %d: %v\n", mountPoint, vmid, err)
+		return 0
+	}
+	used, err := parseDFUsed(out)
+	if err != nil {
+		fmt.Printf("[DEBUG] Failed to probe usage of %s in container %d: %v\n", mountPoint, vmid, err)
+		return 0
+	}
+	fmt.Printf("[DEBUG] Container %d %s (%s) usage from df: %d bytes\n", vmid, mountPoint, path, used)
+	return used
+}
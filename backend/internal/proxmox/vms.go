@@ -0,0 +1,249 @@
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// GetVMs retrieves all QEMU virtual machines in the cluster, including their IP addresses
+func (c *Client) GetVMs(ctx context.Context) ([]VM, error) {
+	inventory, err := c.buildInventory(ctx, false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("[INFO] GetVMs: found %d VMs\n", len(inventory.VMs))
+	return inventory.VMs, nil
+}
+
+// getNodeVMs retrieves the QEMU VMs on a single node
+func (c *Client) getNodeVMs(ctx context.Context, node string) ([]VM, error) {
+	path := fmt.Sprintf("/nodes/%s/qemu", node)
+	fmt.Printf("[DEBUG] getNodeVMs: requesting path=%s\n", path)
+
+	respBody, err := c.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Data []vmStatus `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse VMs response: %w", err)
+	}
+
+	vms := make([]VM, 0, len(response.Data))
+	for _, status := range response.Data {
+		vm := status.vm(node)
+		c.rememberGuestNode(vm.VMID, node)
+		vms = append(vms, vm)
+	}
+
+	fmt.Printf("[INFO] getNodeVMs: parsed %d VMs from node '%s'\n", len(vms), node)
+	return vms, nil
+}
+
+// vmStatus is a VM as returned by the qemu list and status endpoints, which
+// report the template flag as 0/1
+type vmStatus struct {
+	VMID     int     `json:"vmid"`
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	CPU      float64 `json:"cpu"`
+	CPUs     int     `json:"cpus"`
+	Mem      int64   `json:"mem"`
+	MaxMem   int64   `json:"maxmem"`
+	Disk     int64   `json:"disk"`
+	MaxDisk  int64   `json:"maxdisk"`
+	Uptime   int64   `json:"uptime"`
+	Template int     `json:"template"`
+}
+
+func (s vmStatus) vm(node string) VM {
+	return VM{
+		VMID:     s.VMID,
+		Name:     s.Name,
+		Status:   s.Status,
+		Node:     node,
+		CPU:      s.CPU,
+		CPUs:     s.CPUs,
+		Mem:      s.Mem,
+		MaxMem:   s.MaxMem,
+		Disk:     s.Disk,
+		MaxDisk:  s.MaxDisk,
+		Uptime:   s.Uptime,
+		Template: s.Template == 1,
+	}
+}
+
+// GetVM retrieves a specific QEMU VM
+func (c *Client) GetVM(ctx context.Context, vmid int) (*VM, error) {
+	node := c.nodeFor(ctx, vmid)
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/status/current", node, vmid)
+	respBody, err := c.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Data vmStatus `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse VM response: %w", err)
+	}
+	vm := response.Data.vm(node)
+
+	// The config holds the cloud-init network settings and the template flag
+	if config, err := c.getGuestConfig(ctx, "qemu", node, vmid); err == nil {
+		if ipconfig, ok := config["ipconfig0"].(string); ok {
+			vm.IPAddress = extractIPFromNetConfig(ipconfig)
+		}
+		if template, ok := config["template"].(float64); ok && template == 1 {
+			vm.Template = true
+		}
+	}
+
+	return &vm, nil
+}
+
+// CreateVM clones the VM template req.Template into a new VM and returns the
+// UPID of the clone task. The VM is locked until the task finishes, so
+// cloud-init and hardware settings must be applied with UpdateVMConfig afterwards.
+func (c *Client) CreateVM(ctx context.Context, vmid int, req CreateVMRequest) (string, error) {
+	if req.Template <= 0 {
+		return "", fmt.Errorf("a template VMID is required to create a VM")
+	}
+	return c.CloneVM(ctx, req.Template, CloneVMRequest{
+		NewID:   vmid,
+		Name:    req.Name,
+		Full:    req.Full,
+		Storage: req.Storage,
+		Node:    req.Node,
+	})
+}
+
+// CloneVM clones a VM or VM template and returns the UPID of the clone task
+func (c *Client) CloneVM(ctx context.Context, vmid int, req CloneVMRequest) (string, error) {
+	node := c.nodeFor(ctx, vmid)
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/clone", node, vmid)
+
+	params := map[string]interface{}{
+		"newid": req.NewID,
+	}
+	if req.Name != "" {
+		params["name"] = req.Name
+	}
+	if req.Full {
+		params["full"] = 1
+		if req.Storage != "" {
+			params["storage"] = req.Storage
+		}
+	}
+	target := node
+	if req.Node != "" && req.Node != node {
+		params["target"] = req.Node
+		target = req.Node
+	}
+
+	fmt.Printf("[DEBUG] CloneVM: requesting path=%s (vmid=%d -> newid=%d on %s)\n", path, vmid, req.NewID, target)
+	respBody, err := c.doRequest(ctx, "POST", path, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to clone VM: %w", err)
+	}
+
+	c.rememberGuestNode(req.NewID, target)
+	return parseUPIDResponse(respBody)
+}
+
+// UpdateVMConfig applies hardware and cloud-init settings to a VM
+func (c *Client) UpdateVMConfig(ctx context.Context, vmid int, req VMConfigRequest) error {
+	params := vmConfigParams(req)
+	if len(params) == 0 {
+		return nil
+	}
+
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/config", c.nodeFor(ctx, vmid), vmid)
+	fmt.Printf("[DEBUG] UpdateVMConfig: requesting path=%s\n", path)
+	if _, err := c.doRequest(ctx, "PUT", path, params); err != nil {
+		return fmt.Errorf("failed to update VM config: %w", err)
+	}
+	return nil
+}
+
+// vmConfigParams converts a VMConfigRequest into Proxmox config parameters
+func vmConfigParams(req VMConfigRequest) map[string]interface{} {
+	params := make(map[string]interface{})
+	if req.Cores > 0 {
+		params["cores"] = req.Cores
+	}
+	if req.Memory > 0 {
+		params["memory"] = req.Memory
+	}
+	if req.CIUser != "" {
+		params["ciuser"] = req.CIUser
+	}
+	if req.CIPassword != "" {
+		params["cipassword"] = req.CIPassword
+	}
+	if req.SSHKeys != "" {
+		// Proxmox expects the keys URL-encoded a second time, with %20 for spaces
+		params["sshkeys"] = strings.ReplaceAll(url.QueryEscape(req.SSHKeys), "+", "%20")
+	}
+	if req.IPConfig0 != "" {
+		params["ipconfig0"] = req.IPConfig0
+	}
+	if req.Nameserver != "" {
+		params["nameserver"] = req.Nameserver
+	}
+	if req.Bridge != "" {
+		params["net0"] = fmt.Sprintf("virtio,bridge=%s", req.Bridge)
+	}
+	if req.OnBoot != nil {
+		if *req.OnBoot {
+			params["onboot"] = 1
+		} else {
+			params["onboot"] = 0
+		}
+	}
+	return params
+}
+
+// StartVM starts a VM and returns the UPID of the Proxmox task
+func (c *Client) StartVM(ctx context.Context, vmid int) (string, error) {
+	return c.vmAction(ctx, vmid, "start")
+}
+
+// StopVM stops a VM immediately and returns the UPID of the Proxmox task
+func (c *Client) StopVM(ctx context.Context, vmid int) (string, error) {
+	return c.vmAction(ctx, vmid, "stop")
+}
+
+// RebootVM reboots a VM and returns the UPID of the Proxmox task
+func (c *Client) RebootVM(ctx context.Context, vmid int) (string, error) {
+	return c.vmAction(ctx, vmid, "reboot")
+}
+
+// vmAction posts a power action to a VM
+func (c *Client) vmAction(ctx context.Context, vmid int, action string) (string, error) {
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/status/%s", c.nodeFor(ctx, vmid), vmid, action)
+	respBody, err := c.doRequest(ctx, "POST", path, nil)
+	if err != nil {
+		return "", err
+	}
+	return parseUPIDResponse(respBody)
+}
+
+// DeleteVM deletes a VM together with its disks and returns the UPID of the Proxmox task
+func (c *Client) DeleteVM(ctx context.Context, vmid int) (string, error) {
+	path := fmt.Sprintf("/nodes/%s/qemu/%d?purge=1&destroy-unreferenced-disks=1", c.nodeFor(ctx, vmid), vmid)
+	respBody, err := c.doRequest(ctx, "DELETE", path, nil)
+	if err != nil {
+		return "", err
+	}
+	c.forgetGuestNode(vmid)
+	return parseUPIDResponse(respBody)
+}
@@ -0,0 +1,608 @@
+package scheduler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policy actions
+const (
+	ActionSnapshot = "snapshot"
+	ActionBackup   = "backup"
+)
+
+// Policy target types
+const (
+	TargetContainer = "container"
+	TargetVolume    = "volume"
+	TargetProject   = "project"
+)
+
+// Run and run item status values
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed" // At least one item failed
+	RunSkipped   = "skipped"
+)
+
+// Run triggers
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// maxRunsPerPolicy is the number of runs kept in the history of each policy
+const maxRunsPerPolicy = 50
+
+var (
+	// ErrPolicyNotFound is returned for an unknown policy ID
+	ErrPolicyNotFound = errors.New("policy not found")
+	// ErrInvalidPolicy is wrapped by every policy validation error
+	ErrInvalidPolicy = errors.New("invalid policy")
+	// ErrPolicyRunning is returned when a policy is run while a run of it is still going
+	ErrPolicyRunning = errors.New("policy is already running")
+)
+
+// Target is what a policy snapshots or backs up
+type Target struct {
+	Type      string `json:"type"`                 // container, volume or project
+	VMID      int    `json:"vmid,omitempty"`       // Set for container targets
+	VolID     string `json:"volid,omitempty"`      // Set for volume targets
+	ProjectID string `json:"project_id,omitempty"` // Set for project targets: every guest in the project
+}
+
+// Retention decides which of the items created by a policy are kept. The
+// rules are combined; an item kept by any rule is not pruned. A retention
+// with every rule at zero keeps everything.
+type Retention struct {
+	KeepLast   int `json:"keep_last,omitempty"`   // Keep the newest N items
+	KeepDaily  int `json:"keep_daily,omitempty"`  // Keep the newest item of each of the last N days with items
+	KeepWeekly int `json:"keep_weekly,omitempty"` // Keep the newest item of each of the last N weeks with items
+}
+
+// Policy is a scheduled snapshot or backup of a target with a retention rule
+type Policy struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Schedule  string    `json:"schedule"` // Cron expression, in server local time
+	Action    string    `json:"action"`   // snapshot or backup
+	Target    Target    `json:"target"`
+	Retention Retention `json:"retention"`
+	Storage   string    `json:"storage,omitempty"` // Backup storage (default: the project's backup storage)
+	Mode      string    `json:"mode,omitempty"`    // Backup mode: snapshot, suspend or stop
+	Enabled   bool      `json:"enabled"`
+	LastRunAt int64     `json:"last_run_at,omitempty"`
+	NextRunAt int64     `json:"next_run_at,omitempty"`
+	CreatedAt int64     `json:"created_at"`
+	UpdatedAt int64     `json:"updated_at"`
+}
+
+// CreatePolicyRequest holds parameters for creating a policy
+type CreatePolicyRequest struct {
+	Name      string    `json:"name"`
+	Schedule  string    `json:"schedule"`
+	Action    string    `json:"action"`
+	Target    Target    `json:"target"`
+	Retention Retention `json:"retention"`
+	Storage   string    `json:"storage,omitempty"`
+	Mode      string    `json:"mode,omitempty"`
+	Enabled   *bool     `json:"enabled,omitempty"` // Default: true
+}
+
+// UpdatePolicyRequest holds parameters for updating a policy
+type UpdatePolicyRequest struct {
+	Name      string     `json:"name,omitempty"`
+	Schedule  string     `json:"schedule,omitempty"`
+	Action    string     `json:"action,omitempty"`
+	Target    *Target    `json:"target,omitempty"`
+	Retention *Retention `json:"retention,omitempty"`
+	Storage   *string    `json:"storage,omitempty"`
+	Mode      *string    `json:"mode,omitempty"`
+	Enabled   *bool      `json:"enabled,omitempty"`
+}
+
+// PolicyRun is one run of a policy and its outcome
+type PolicyRun struct {
+	ID         string    `json:"id"`
+	PolicyID   string    `json:"policy_id"`
+	Trigger    string    `json:"trigger"` // schedule or manual
+	Status     string    `json:"status"`  // running, succeeded or failed
+	StartedAt  int64     `json:"started_at"`
+	FinishedAt int64     `json:"finished_at,omitempty"`
+	Items      []RunItem `json:"items"`
+	Error      string    `json:"error,omitempty"` // Set when the run could not start on its targets
+}
+
+// RunItem is the outcome of a policy run for one guest or volume
+type RunItem struct {
+	Target  string   `json:"target"`            // e.g. "ct 100", "vm 101" or a volid
+	Status  string   `json:"status"`            // running, succeeded, failed or skipped
+	Created string   `json:"created,omitempty"` // Name of the snapshot or backup that was made
+	TaskID  string   `json:"task_id,omitempty"`
+	Pruned  []string `json:"pruned,omitempty"` // Snapshots or backups removed by the retention rule
+	Error   string   `json:"error,omitempty"`
+}
+
+// validBackupModes are the vzdump modes a backup policy accepts
+var validBackupModes = map[string]bool{"snapshot": true, "suspend": true, "stop": true}
+
+// validate checks a policy's fields and returns an error wrapping ErrInvalidPolicy
+func (p *Policy) validate() error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", ErrInvalidPolicy, fmt.Sprintf(format, args...))
+	}
+
+	if p.Name == "" {
+		return invalid("name is required")
+	}
+	if _, err := ParseSchedule(p.Schedule); err != nil {
+		return invalid("%v", err)
+	}
+	if p.Action != ActionSnapshot && p.Action != ActionBackup {
+		return invalid("action must be snapshot or backup")
+	}
+
+	switch p.Target.Type {
+	case TargetContainer:
+		if p.Target.VMID <= 0 {
+			return invalid("target vmid is required")
+		}
+	case TargetVolume:
+		if !strings.Contains(p.Target.VolID, ":") {
+			return invalid("target volid is required")
+		}
+		if p.Action == ActionBackup {
+			return invalid("volumes cannot be backed up on their own, back up their container instead")
+		}
+	case TargetProject:
+		if p.Target.ProjectID == "" {
+			return invalid("target project_id is required")
+		}
+	default:
+		return invalid("target type must be container, volume or project")
+	}
+
+	if p.Retention.KeepLast < 0 || p.Retention.KeepDaily < 0 || p.Retention.KeepWeekly < 0 {
+		return invalid("retention counts cannot be negative")
+	}
+	if p.Mode != "" && !validBackupModes[p.Mode] {
+		return invalid("mode must be snapshot, suspend or stop")
+	}
+	return nil
+}
+
+// IsZero reports whether the retention keeps everything
+func (r Retention) IsZero() bool {
+	return r.KeepLast == 0 && r.KeepDaily == 0 && r.KeepWeekly == 0
+}
+
+// retained is a snapshot or backup a retention rule is applied to
+type retained struct {
+	name    string
+	created int64
+}
+
+// prune returns the names of the items the retention rule does not keep,
+// oldest first. Days and weeks are counted in server local time.
+func (r Retention) prune(items []retained) []string {
+	if r.IsZero() {
+		return nil
+	}
+
+	sorted := append([]retained(nil), items...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].created > sorted[j].created })
+
+	keep := make(map[string]bool)
+	for i := 0; i < r.KeepLast && i < len(sorted); i++ {
+		keep[sorted[i].name] = true
+	}
+	keepNewestPer := func(n int, bucket func(time.Time) string) {
+		seen := make(map[string]bool)
+		for _, item := range sorted {
+			if len(seen) == n {
+				return
+			}
+			key := bucket(time.Unix(item.created, 0))
+			if !seen[key] {
+				seen[key] = true
+				keep[item.name] = true
+			}
+		}
+	}
+	keepNewestPer(r.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") })
+	keepNewestPer(r.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%02d", year, week)
+	})
+
+	var pruned []string
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if !keep[sorted[i].name] {
+			pruned = append(pruned, sorted[i].name)
+		}
+	}
+	return pruned
+}
+
+// generateID creates a random ID for policies and runs
+func generateID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random ID: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// PolicyStore manages policy and run history persistence
+type PolicyStore struct {
+	filePath string
+	mu       sync.RWMutex
+	policies map[string]*Policy
+	runs     map[string][]*PolicyRun // Maps policy ID to its runs, oldest first
+}
+
+// NewPolicyStore creates a new policy store
+func NewPolicyStore(dataPath string) (*PolicyStore, error) {
+	if dataPath == "" {
+		dataPath = "/var/lib/proxicloud/policies.json"
+	}
+
+	// Ensure directory exists
+	dir := filepath.Dir(dataPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	store := &PolicyStore{
+		filePath: dataPath,
+		policies: make(map[string]*Policy),
+		runs:     make(map[string][]*PolicyRun),
+	}
+
+	// Load existing data
+	if err := store.load(); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to load policies: %v", err)
+		}
+		// File doesn't exist yet, that's OK
+	}
+
+	return store, nil
+}
+
+// load reads policies and runs from disk
+func (ps *PolicyStore) load() error {
+	data, err := os.ReadFile(ps.filePath)
+	if err != nil {
+		return err
+	}
+
+	var stored struct {
+		Policies map[string]*Policy      `json:"policies"`
+		Runs     map[string][]*PolicyRun `json:"runs"`
+	}
+
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("failed to unmarshal policies: %v", err)
+	}
+
+	if stored.Policies != nil {
+		ps.policies = stored.Policies
+	}
+	if stored.Runs != nil {
+		ps.runs = stored.Runs
+	}
+
+	return nil
+}
+
+// save writes policies and runs to disk; callers must hold ps.mu
+func (ps *PolicyStore) save() error {
+	stored := struct {
+		Policies map[string]*Policy      `json:"policies"`
+		Runs     map[string][]*PolicyRun `json:"runs"`
+	}{
+		Policies: ps.policies,
+		Runs:     ps.runs,
+	}
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal policies: %v", err)
+	}
+
+	// Write atomically via temp file
+	tmpFile := ps.filePath + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write temp file: %v", err)
+	}
+
+	if err := os.Rename(tmpFile, ps.filePath); err != nil {
+		_ = os.Remove(tmpFile)
+		return fmt.Errorf("failed to rename temp file: %v", err)
+	}
+
+	return nil
+}
+
+// CreatePolicy validates and stores a new policy and schedules its first run
+func (ps *PolicyStore) CreatePolicy(req CreatePolicyRequest) (*Policy, error) {
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	policy := &Policy{
+		ID:        id,
+		Name:      req.Name,
+		Schedule:  req.Schedule,
+		Action:    req.Action,
+		Target:    req.Target,
+		Retention: req.Retention,
+		Storage:   req.Storage,
+		Mode:      req.Mode,
+		Enabled:   req.Enabled == nil || *req.Enabled,
+		CreatedAt: now.Unix(),
+		UpdatedAt: now.Unix(),
+	}
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	policy.scheduleNext(now)
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for _, p := range ps.policies {
+		if p.Name == req.Name {
+			return nil, fmt.Errorf("%w: policy with name '%s' already exists", ErrInvalidPolicy, req.Name)
+		}
+	}
+
+	ps.policies[policy.ID] = policy
+	if err := ps.save(); err != nil {
+		return nil, err
+	}
+
+	c := *policy
+	return &c, nil
+}
+
+// GetPolicy retrieves a policy by ID
+func (ps *PolicyStore) GetPolicy(id string) (*Policy, error) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	policy, exists := ps.policies[id]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrPolicyNotFound, id)
+	}
+
+	c := *policy
+	return &c, nil
+}
+
+// ListPolicies returns all policies sorted by name
+func (ps *PolicyStore) ListPolicies() []*Policy {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	policies := make([]*Policy, 0, len(ps.policies))
+	for _, p := range ps.policies {
+		c := *p
+		policies = append(policies, &c)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+
+	return policies
+}
+
+// UpdatePolicy updates a policy. Changing the schedule or enabling the policy
+// reschedules its next run.
+func (ps *PolicyStore) UpdatePolicy(id string, req UpdatePolicyRequest) (*Policy, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	existing, exists := ps.policies[id]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrPolicyNotFound, id)
+	}
+
+	// Validate a copy so a rejected update leaves the policy untouched
+	policy := *existing
+	if req.Name != "" {
+		for pid, p := range ps.policies {
+			if pid != id && p.Name == req.Name {
+				return nil, fmt.Errorf("%w: policy with name '%s' already exists", ErrInvalidPolicy, req.Name)
+			}
+		}
+		policy.Name = req.Name
+	}
+	if req.Schedule != "" {
+		policy.Schedule = req.Schedule
+	}
+	if req.Action != "" {
+		policy.Action = req.Action
+	}
+	if req.Target != nil {
+		policy.Target = *req.Target
+	}
+	if req.Retention != nil {
+		policy.Retention = *req.Retention
+	}
+	if req.Storage != nil {
+		policy.Storage = *req.Storage
+	}
+	if req.Mode != nil {
+		policy.Mode = *req.Mode
+	}
+	if req.Enabled != nil {
+		policy.Enabled = *req.Enabled
+	}
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if policy.Schedule != existing.Schedule || policy.Enabled != existing.Enabled {
+		policy.scheduleNext(now)
+	}
+	policy.UpdatedAt = now.Unix()
+
+	ps.policies[id] = &policy
+	if err := ps.save(); err != nil {
+		ps.policies[id] = existing
+		return nil, err
+	}
+
+	c := policy
+	return &c, nil
+}
+
+// DeletePolicy deletes a policy and its run history
+func (ps *PolicyStore) DeletePolicy(id string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if _, exists := ps.policies[id]; !exists {
+		return fmt.Errorf("%w: %s", ErrPolicyNotFound, id)
+	}
+
+	delete(ps.policies, id)
+	delete(ps.runs, id)
+
+	return ps.save()
+}
+
+// ListRuns returns the run history of a policy, newest first
+func (ps *PolicyStore) ListRuns(policyID string) ([]*PolicyRun, error) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	if _, exists := ps.policies[policyID]; !exists {
+		return nil, fmt.Errorf("%w: %s", ErrPolicyNotFound, policyID)
+	}
+
+	runs := ps.runs[policyID]
+	list := make([]*PolicyRun, 0, len(runs))
+	for i := len(runs) - 1; i >= 0; i-- {
+		list = append(list, runs[i].copy())
+	}
+	return list, nil
+}
+
+// GetRun returns a run of a policy
+func (ps *PolicyStore) GetRun(policyID, runID string) (*PolicyRun, error) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	for _, run := range ps.runs[policyID] {
+		if run.ID == runID {
+			return run.copy(), nil
+		}
+	}
+	return nil, fmt.Errorf("run not found: %s", runID)
+}
+
+// saveRun records a run (replacing an earlier record of it) and trims the
+// history of its policy. Runs of deleted policies are dropped.
+func (ps *PolicyStore) saveRun(run *PolicyRun) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if _, exists := ps.policies[run.PolicyID]; !exists {
+		return nil
+	}
+
+	runs := ps.runs[run.PolicyID]
+	replaced := false
+	for i, r := range runs {
+		if r.ID == run.ID {
+			runs[i] = run.copy()
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		runs = append(runs, run.copy())
+	}
+	if len(runs) > maxRunsPerPolicy {
+		runs = runs[len(runs)-maxRunsPerPolicy:]
+	}
+	ps.runs[run.PolicyID] = runs
+
+	return ps.save()
+}
+
+// markRun records that a policy started a run at now and schedules its next one
+func (ps *PolicyStore) markRun(id string, now time.Time, reschedule bool) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	policy, exists := ps.policies[id]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrPolicyNotFound, id)
+	}
+	policy.LastRunAt = now.Unix()
+	if reschedule {
+		policy.scheduleNext(now)
+	}
+
+	return ps.save()
+}
+
+// rescheduleMissed moves the next run of every policy that is overdue at now
+// to its next occurrence after now
+func (ps *PolicyStore) rescheduleMissed(now time.Time) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	changed := false
+	for _, policy := range ps.policies {
+		if policy.Enabled && policy.NextRunAt <= now.Unix() {
+			policy.scheduleNext(now)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return ps.save()
+}
+
+// scheduleNext sets the time of the policy's next run after now
+func (p *Policy) scheduleNext(now time.Time) {
+	p.NextRunAt = 0
+	if !p.Enabled {
+		return
+	}
+	schedule, err := ParseSchedule(p.Schedule)
+	if err != nil {
+		return
+	}
+	if next := schedule.Next(now); !next.IsZero() {
+		p.NextRunAt = next.Unix()
+	}
+}
+
+// copy returns a snapshot of the run safe to hand to callers
+func (r *PolicyRun) copy() *PolicyRun {
+	c := *r
+	c.Items = make([]RunItem, len(r.Items))
+	for i, item := range r.Items {
+		c.Items[i] = item
+		c.Items[i].Pruned = append([]string(nil), item.Pruned...)
+	}
+	return &c
+}
@@ -0,0 +1,458 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/proxmox"
+)
+
+// Scheduler runs snapshot and backup policies when they are due and prunes
+// the items they created according to their retention rule
+type Scheduler struct {
+	client   proxmox.ProxmoxAPI
+	store    *PolicyStore
+	projects *proxmox.ProjectStore
+	tasks    *proxmox.TaskTracker
+	interval time.Duration
+	timeout  time.Duration
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	mu     sync.Mutex
+	active map[string]string        // Maps policy ID to its running run
+	done   map[string]chan struct{} // Closed when a run finishes, keyed by run ID
+}
+
+// NewScheduler creates a policy scheduler. projects may be nil, in which case
+// project targets fail to run.
+func NewScheduler(client proxmox.ProxmoxAPI, store *PolicyStore, projects *proxmox.ProjectStore) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Scheduler{
+		client:   client,
+		store:    store,
+		projects: projects,
+		tasks:    proxmox.NewTaskTracker(client),
+		interval: 30 * time.Second,
+		timeout:  12 * time.Hour,
+		ctx:      ctx,
+		cancel:   cancel,
+		active:   make(map[string]string),
+		done:     make(map[string]chan struct{}),
+	}
+}
+
+// Store returns the store holding the scheduler's policies and run history
+func (s *Scheduler) Store() *PolicyStore {
+	return s.store
+}
+
+// SetPollInterval sets how often the tasks started by policy runs are polled
+func (s *Scheduler) SetPollInterval(interval time.Duration) {
+	s.tasks.SetPollInterval(interval)
+}
+
+// Start begins running due policies in the background. Runs missed while the
+// server was down are not caught up; those policies next run on schedule.
+func (s *Scheduler) Start() {
+	log.Printf("Starting policy scheduler (interval: %v)", s.interval)
+
+	if err := s.store.rescheduleMissed(time.Now()); err != nil {
+		log.Printf("Failed to reschedule missed policy runs: %v", err)
+	}
+
+	ticker := time.NewTicker(s.interval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				s.runDue(time.Now())
+			case <-s.ctx.Done():
+				ticker.Stop()
+				log.Println("Policy scheduler stopped")
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the scheduler and cancels running policy runs
+func (s *Scheduler) Stop() {
+	log.Println("Stopping policy scheduler...")
+	s.cancel()
+}
+
+// RunNow starts a run of a policy outside its schedule and returns the
+// initial run record. Disabled policies can be run this way.
+func (s *Scheduler) RunNow(policyID string) (*PolicyRun, error) {
+	policy, err := s.store.GetPolicy(policyID)
+	if err != nil {
+		return nil, err
+	}
+	return s.start(policy, TriggerManual, time.Now())
+}
+
+// WaitRun blocks until a run finishes or ctx is done and returns the run
+func (s *Scheduler) WaitRun(ctx context.Context, policyID, runID string) (*PolicyRun, error) {
+	s.mu.Lock()
+	done, ok := s.done[runID]
+	s.mu.Unlock()
+
+	if ok {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return s.store.GetRun(policyID, runID)
+}
+
+// runDue starts every enabled policy whose next run is due
+func (s *Scheduler) runDue(now time.Time) {
+	for _, policy := range s.store.ListPolicies() {
+		if !policy.Enabled || policy.NextRunAt == 0 || policy.NextRunAt > now.Unix() {
+			continue
+		}
+		if _, err := s.start(policy, TriggerSchedule, now); err != nil {
+			log.Printf("[WARNING] Policy %s (%s) not run: %v", policy.Name, policy.ID, err)
+			// Skip this occurrence rather than retrying it every tick
+			if errors.Is(err, ErrPolicyRunning) {
+				if err := s.store.markRun(policy.ID, now, true); err != nil {
+					log.Printf("[WARNING] Failed to reschedule policy %s: %v", policy.ID, err)
+				}
+			}
+		}
+	}
+}
+
+// start records a new run of a policy and executes it in the background
+func (s *Scheduler) start(policy *Policy, trigger string, now time.Time) (*PolicyRun, error) {
+	runID, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	run := &PolicyRun{
+		ID:        runID,
+		PolicyID:  policy.ID,
+		Trigger:   trigger,
+		Status:    RunRunning,
+		StartedAt: now.Unix(),
+		Items:     make([]RunItem, 0),
+	}
+
+	s.mu.Lock()
+	if _, running := s.active[policy.ID]; running {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrPolicyRunning, policy.Name)
+	}
+	done := make(chan struct{})
+	s.active[policy.ID] = runID
+	s.done[runID] = done
+	s.mu.Unlock()
+
+	if err := s.store.markRun(policy.ID, now, trigger == TriggerSchedule); err != nil {
+		log.Printf("[WARNING] Failed to record run of policy %s: %v", policy.ID, err)
+	}
+	if err := s.store.saveRun(run); err != nil {
+		log.Printf("[WARNING] Failed to save run of policy %s: %v", policy.ID, err)
+	}
+	snapshot := run.copy()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.active, policy.ID)
+			delete(s.done, runID)
+			s.mu.Unlock()
+			close(done)
+		}()
+		s.execute(policy, run, now)
+	}()
+
+	return snapshot, nil
+}
+
+// execute runs a policy on each of its targets in turn and records the outcome
+func (s *Scheduler) execute(policy *Policy, run *PolicyRun, now time.Time) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.timeout)
+	defer cancel()
+
+	log.Printf("[INFO] Running %s policy %s (%s)", policy.Action, policy.Name, run.Trigger)
+
+	// Every run of a policy tags what it creates so pruning only touches the policy's own items
+	tag := "auto-" + policy.ID[:8]
+
+	record := func() {
+		if err := s.store.saveRun(run); err != nil {
+			log.Printf("[WARNING] Failed to save run of policy %s: %v", policy.ID, err)
+		}
+	}
+
+	if policy.Target.Type == TargetVolume {
+		run.Items = append(run.Items, RunItem{Target: policy.Target.VolID, Status: RunRunning})
+		record()
+		s.snapshotVolume(ctx, policy, tag, now, &run.Items[0])
+	} else {
+		guests, err := s.guests(ctx, policy.Target)
+		if err != nil {
+			run.Error = err.Error()
+		}
+		for _, guest := range guests {
+			run.Items = append(run.Items, RunItem{Target: guest.label(), Status: RunRunning})
+		}
+		record()
+
+		for i, guest := range guests {
+			item := &run.Items[i]
+			switch {
+			case guest.missing:
+				item.Status = RunSkipped
+				item.Error = "guest no longer exists"
+			case policy.Action == ActionBackup:
+				s.backupGuest(ctx, policy, tag, guest.vmid, item)
+			case guest.kind == "qemu":
+				item.Status = RunSkipped
+				item.Error = "snapshot policies only cover containers"
+			default:
+				s.snapshotContainer(ctx, policy, tag, now, guest.vmid, item)
+			}
+			record()
+		}
+	}
+
+	run.Status = RunSucceeded
+	if run.Error != "" {
+		run.Status = RunFailed
+	}
+	for _, item := range run.Items {
+		if item.Status == RunFailed {
+			run.Status = RunFailed
+		}
+	}
+	run.FinishedAt = time.Now().Unix()
+	record()
+
+	log.Printf("[INFO] Policy %s run %s", policy.Name, run.Status)
+}
+
+// guest is a container or VM covered by a policy
+type guest struct {
+	vmid    int
+	kind    string // lxc or qemu
+	missing bool
+}
+
+// label names the guest in run items
+func (g guest) label() string {
+	if g.kind == "qemu" {
+		return fmt.Sprintf("vm %d", g.vmid)
+	}
+	return fmt.Sprintf("ct %d", g.vmid)
+}
+
+// guests resolves a container or project target to its guests
+func (s *Scheduler) guests(ctx context.Context, target Target) ([]guest, error) {
+	if target.Type == TargetContainer {
+		return []guest{{vmid: target.VMID, kind: "lxc"}}, nil
+	}
+
+	if s.projects == nil {
+		return nil, fmt.Errorf("project store not available")
+	}
+	if _, err := s.projects.GetProject(target.ProjectID); err != nil {
+		return nil, err
+	}
+	vmids := s.projects.GetProjectContainers(target.ProjectID)
+	sort.Ints(vmids)
+
+	inventory, err := s.client.GetInventory(ctx, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list guests: %w", err)
+	}
+	kinds := make(map[int]string)
+	for _, ct := range inventory.Containers {
+		kinds[ct.VMID] = "lxc"
+	}
+	for _, vm := range inventory.VMs {
+		kinds[vm.VMID] = "qemu"
+	}
+
+	guests := make([]guest, 0, len(vmids))
+	for _, vmid := range vmids {
+		kind, ok := kinds[vmid]
+		guests = append(guests, guest{vmid: vmid, kind: kind, missing: !ok})
+	}
+	return guests, nil
+}
+
+// snapshotContainer takes a policy snapshot of a container and prunes old ones
+func (s *Scheduler) snapshotContainer(ctx context.Context, policy *Policy, tag string, now time.Time, vmid int, item *RunItem) {
+	name := tag + "-" + now.Format("20060102-150405")
+	upid, err := s.client.CreateContainerSnapshot(ctx, vmid, proxmox.CreateSnapshotRequest{
+		Name:        name,
+		Description: fmt.Sprintf("Created by policy %s", policy.Name),
+	})
+	if err == nil {
+		item.TaskID = upid
+		err = s.waitTask(ctx, upid, fmt.Sprintf("policy %s: snapshot container %d", policy.Name, vmid))
+	}
+	if err != nil {
+		item.fail(err)
+		return
+	}
+	item.Created = name
+
+	snapshots, err := s.client.GetContainerSnapshots(ctx, vmid)
+	if err != nil {
+		item.fail(fmt.Errorf("failed to list snapshots for pruning: %w", err))
+		return
+	}
+	for _, snap := range policy.Retention.prune(ownSnapshots(snapshots, tag)) {
+		upid, err := s.client.DeleteContainerSnapshot(ctx, vmid, snap)
+		if err == nil {
+			err = s.waitTask(ctx, upid, fmt.Sprintf("policy %s: prune snapshot %s of container %d", policy.Name, snap, vmid))
+		}
+		if err != nil {
+			item.fail(fmt.Errorf("failed to prune snapshot %s: %w", snap, err))
+			return
+		}
+		item.Pruned = append(item.Pruned, snap)
+	}
+	item.Status = RunSucceeded
+}
+
+// snapshotVolume takes a policy snapshot of a volume and prunes old ones
+func (s *Scheduler) snapshotVolume(ctx context.Context, policy *Policy, tag string, now time.Time, item *RunItem) {
+	volid := policy.Target.VolID
+	name := tag + "-" + now.Format("20060102-150405")
+	if _, err := s.client.CreateSnapshot(ctx, volid, proxmox.CreateSnapshotRequest{
+		Name:        name,
+		Description: fmt.Sprintf("Created by policy %s", policy.Name),
+	}); err != nil {
+		item.fail(err)
+		return
+	}
+	item.Created = name
+
+	snapshots, err := s.client.GetSnapshots(ctx, volid)
+	if err != nil {
+		item.fail(fmt.Errorf("failed to list snapshots for pruning: %w", err))
+		return
+	}
+	for _, snap := range policy.Retention.prune(ownSnapshots(snapshots, tag)) {
+		upid, err := s.client.DeleteSnapshot(ctx, volid, snap)
+		if err == nil {
+			err = s.waitTask(ctx, upid, fmt.Sprintf("policy %s: prune snapshot %s of %s", policy.Name, snap, volid))
+		}
+		if err != nil {
+			item.fail(fmt.Errorf("failed to prune snapshot %s: %w", snap, err))
+			return
+		}
+		item.Pruned = append(item.Pruned, snap)
+	}
+	item.Status = RunSucceeded
+}
+
+// backupGuest backs up a guest with vzdump and prunes the policy's old backups of it
+func (s *Scheduler) backupGuest(ctx context.Context, policy *Policy, tag string, vmid int, item *RunItem) {
+	storage := policy.Storage
+	if storage == "" && s.projects != nil {
+		storage = s.projects.GetContainerBackupStorage(vmid)
+	}
+	if storage == "" {
+		var err error
+		if storage, err = s.client.FindStorage(ctx, "", "backup"); err != nil {
+			item.fail(err)
+			return
+		}
+	}
+
+	// Backup archive names are chosen by Proxmox, so the tag goes in the notes
+	marker := "[" + tag + "]"
+	upid, err := s.client.CreateBackup(ctx, proxmox.CreateBackupRequest{
+		VMID:    vmid,
+		Storage: storage,
+		Mode:    policy.Mode,
+		Notes:   policy.Name + " " + marker,
+	})
+	if err == nil {
+		item.TaskID = upid
+		err = s.waitTask(ctx, upid, fmt.Sprintf("policy %s: back up guest %d to %s", policy.Name, vmid, storage))
+	}
+	if err != nil {
+		item.fail(err)
+		return
+	}
+
+	backups, err := s.client.GetBackups(ctx, storage, vmid)
+	if err != nil {
+		item.fail(fmt.Errorf("failed to list backups for pruning: %w", err))
+		return
+	}
+	own := make([]retained, 0, len(backups))
+	nodes := make(map[string]string, len(backups))
+	for _, backup := range backups {
+		if strings.Contains(backup.Notes, marker) {
+			own = append(own, retained{name: backup.VolID, created: backup.CreatedAt})
+			nodes[backup.VolID] = backup.Node
+		}
+	}
+	// Backups are listed newest first
+	if len(own) > 0 {
+		item.Created = own[0].name
+	}
+
+	for _, volid := range policy.Retention.prune(own) {
+		upid, err := s.client.DeleteBackup(ctx, nodes[volid], volid)
+		if err == nil {
+			err = s.waitTask(ctx, upid, fmt.Sprintf("policy %s: prune backup %s", policy.Name, volid))
+		}
+		if err != nil {
+			item.fail(fmt.Errorf("failed to prune backup %s: %w", volid, err))
+			return
+		}
+		item.Pruned = append(item.Pruned, volid)
+	}
+	item.Status = RunSucceeded
+}
+
+// waitTask follows a Proxmox task until it finishes and returns its failure, if any
+func (s *Scheduler) waitTask(ctx context.Context, upid string, description string) error {
+	if upid == "" {
+		return nil
+	}
+	s.tasks.Track(upid, description)
+	task, err := s.tasks.Wait(ctx, upid)
+	if err != nil {
+		return fmt.Errorf("stopped waiting for task %s: %v", upid, err)
+	}
+	if !task.Succeeded() {
+		return fmt.Errorf("task %s failed: %s", upid, task.ExitStatus)
+	}
+	return nil
+}
+
+// ownSnapshots returns the snapshots created by the policy with the given tag
+func ownSnapshots(snapshots []proxmox.Snapshot, tag string) []retained {
+	own := make([]retained, 0, len(snapshots))
+	for _, snap := range snapshots {
+		if strings.HasPrefix(snap.Name, tag+"-") {
+			own = append(own, retained{name: snap.Name, created: snap.CreatedAt})
+		}
+	}
+	return own
+}
+
+// fail marks a run item as failed
+func (item *RunItem) fail(err error) {
+	item.Status = RunFailed
+	item.Error = err.Error()
+}
@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	from := time.Date(2024, time.January, 15, 10, 30, 0, 0, time.UTC) // A Monday
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, time.January, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2024, time.January, 16, 2, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.January, 15, 11, 0, 0, 0, time.UTC)},
+		{"0 3 * * sun", time.Date(2024, time.January, 21, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * 7", time.Date(2024, time.January, 21, 3, 0, 0, 0, time.UTC)},
+		{"30 1 1,15 feb-mar *", time.Date(2024, time.February, 1, 1, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: either may match
+		{"0 12 20 * mon-fri", time.Date(2024, time.January, 15, 12, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		schedule, err := ParseSchedule(tt.expr)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q) error = %v", tt.expr, err)
+			continue
+		}
+		if got := schedule.Next(from); !got.Equal(tt.want) {
+			t.Errorf("ParseSchedule(%q).Next() = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "@often"} {
+		if _, err := ParseSchedule(expr); err == nil {
+			t.Errorf("ParseSchedule(%q) error = nil, want error", expr)
+		}
+	}
+}
+
+func TestRetentionPrune(t *testing.T) {
+	// Two snapshots a day at 01:00 and 13:00 for 21 days, starting on a Monday
+	start := time.Date(2024, time.January, 1, 1, 0, 0, 0, time.Local)
+	var items []retained
+	for i := 0; i < 42; i++ {
+		created := start.Add(time.Duration(i) * 12 * time.Hour)
+		items = append(items, retained{name: created.Format("01-02T15"), created: created.Unix()})
+	}
+
+	kept := func(r Retention) []string {
+		pruned := make(map[string]bool)
+		for _, name := range r.prune(items) {
+			pruned[name] = true
+		}
+		var names []string
+		for i := len(items) - 1; i >= 0; i-- {
+			if !pruned[items[i].name] {
+				names = append(names, items[i].name)
+			}
+		}
+		return names
+	}
+
+	tests := []struct {
+		name      string
+		retention Retention
+		want      []string
+	}{
+		{"keep last", Retention{KeepLast: 3}, []string{"01-21T13", "01-21T01", "01-20T13"}},
+		{"keep daily", Retention{KeepDaily: 2}, []string{"01-21T13", "01-20T13"}},
+		{"keep weekly", Retention{KeepWeekly: 2}, []string{"01-21T13", "01-14T13"}},
+		{"combined", Retention{KeepLast: 2, KeepDaily: 3, KeepWeekly: 3}, []string{"01-21T13", "01-21T01", "01-20T13", "01-19T13", "01-14T13", "01-07T13"}},
+	}
+	for _, tt := range tests {
+		if got := kept(tt.retention); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: kept %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if pruned := (Retention{}).prune(items); len(pruned) != 0 {
+		t.Errorf("empty retention pruned %v, want nothing", pruned)
+	}
+}
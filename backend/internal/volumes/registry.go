@@ -0,0 +1,491 @@
+package volumes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MasonD-007/proxicloud/backend/internal/proxmox"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// ErrNotFound is returned for volumes the registry has no record of
+var ErrNotFound = errors.New("volume not found in registry")
+
+// Event kinds recorded in a volume's history
+const (
+	EventCreated    = "created"
+	EventDiscovered = "discovered"
+	EventUpdated    = "updated"
+	EventAttached   = "attached"
+	EventDetached   = "detached"
+	EventResized    = "resized"
+	EventMoved      = "moved"
+	EventMissing    = "missing"
+	EventReappeared = "reappeared"
+	EventDeleted    = "deleted"
+)
+
+// Registry keeps the volume metadata Proxmox does not store, such as names,
+// storage classes, projects, labels and history, keyed by volid
+type Registry struct {
+	db *sql.DB
+	mu sync.Mutex // Serialises writes so reconciles do not interleave
+}
+
+// Event is an entry in a volume's history
+type Event struct {
+	VolID     string `json:"volid"`
+	Kind      string `json:"event"`
+	Detail    string `json:"detail,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// record is the registry row of a volume: its metadata and the state Proxmox
+// last reported, which is compared against to detect changes
+type record struct {
+	volid        string
+	name         string
+	typ          string
+	projectID    string
+	description  string
+	labels       map[string]string
+	createdAt    int64
+	missingSince int64
+
+	size       int64
+	attachedTo int
+	mountPoint string
+}
+
+// NewRegistry opens the registry database, creating it if needed
+func NewRegistry(dbPath string) (*Registry, error) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open volume registry database: %w", err)
+	}
+	// A single connection keeps readers from hitting SQLITE_BUSY during reconciles
+	db.SetMaxOpenConns(1)
+
+	registry := &Registry{db: db}
+	if err := registry.initialize(); err != nil {
+		if closeErr := db.Close(); closeErr != nil {
+			log.Printf("Failed to close database after initialization error: %v", closeErr)
+		}
+		return nil, err
+	}
+
+	return registry, nil
+}
+
+// initialize creates the registry tables if they don't exist
+func (r *Registry) initialize() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS volumes (
+		volid TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL DEFAULT '',
+		project_id TEXT NOT NULL DEFAULT '',
+		description TEXT NOT NULL DEFAULT '',
+		labels TEXT NOT NULL DEFAULT '{}',
+		created_at INTEGER NOT NULL,
+		missing_since INTEGER NOT NULL DEFAULT 0,
+		size INTEGER NOT NULL DEFAULT 0,
+		attached_to INTEGER NOT NULL DEFAULT 0,
+		mountpoint TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS volume_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		volid TEXT NOT NULL,
+		event TEXT NOT NULL,
+		detail TEXT NOT NULL DEFAULT '',
+		timestamp INTEGER NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_volume_events_volid ON volume_events(volid);
+	`
+
+	if _, err := r.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to create volume registry tables: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the registry database
+func (r *Registry) Close() error {
+	return r.db.Close()
+}
+
+// queryer is the part of *sql.DB and *sql.Tx the registry reads and writes through
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+const recordColumns = "volid, name, type, project_id, description, labels, created_at, missing_since, size, attached_to, mountpoint"
+
+// scanRecord reads a row selected with recordColumns
+func scanRecord(scan func(dest ...interface{}) error) (*record, error) {
+	var rec record
+	var labels string
+	if err := scan(&rec.volid, &rec.name, &rec.typ, &rec.projectID, &rec.description, &labels,
+		&rec.createdAt, &rec.missingSince, &rec.size, &rec.attachedTo, &rec.mountPoint); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(labels), &rec.labels); err != nil {
+		return nil, fmt.Errorf("failed to parse labels of volume %s: %w", rec.volid, err)
+	}
+	return &rec, nil
+}
+
+func getRecord(q queryer, volid string) (*record, error) {
+	rec, err := scanRecord(q.QueryRow("SELECT "+recordColumns+" FROM volumes WHERE volid = ?", volid).Scan)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return rec, err
+}
+
+func putRecord(q queryer, rec *record) error {
+	labels, err := json.Marshal(rec.labels)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(
+		"INSERT OR REPLACE INTO volumes ("+recordColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		rec.volid, rec.name, rec.typ, rec.projectID, rec.description, string(labels),
+		rec.createdAt, rec.missingSince, rec.size, rec.attachedTo, rec.mountPoint,
+	)
+	return err
+}
+
+func addEvent(q queryer, volid, kind, detail string, now int64) error {
+	_, err := q.Exec(
+		"INSERT INTO volume_events (volid, event, detail, timestamp) VALUES (?, ?, ?, ?)",
+		volid, kind, detail, now,
+	)
+	return err
+}
+
+// apply copies a record's metadata onto a volume reported by Proxmox
+func (rec *record) apply(volume *proxmox.Volume) {
+	if rec.name != "" {
+		volume.Name = rec.name
+	}
+	if rec.typ != "" {
+		volume.Type = rec.typ
+	}
+	volume.ProjectID = rec.projectID
+	volume.Description = rec.description
+	volume.Labels = rec.labels
+	volume.CreatedAt = rec.createdAt
+}
+
+// newRecord builds the record of a volume seen for the first time
+func newRecord(volume *proxmox.Volume, now int64) *record {
+	rec := &record{
+		volid:       volume.VolID,
+		name:        volume.Name,
+		typ:         volume.Type,
+		projectID:   volume.ProjectID,
+		description: volume.Description,
+		labels:      volume.Labels,
+		createdAt:   volume.CreatedAt,
+		size:        volume.Size,
+	}
+	if rec.createdAt == 0 {
+		rec.createdAt = now
+	}
+	if volume.AttachedTo != nil {
+		rec.attachedTo = *volume.AttachedTo
+		rec.mountPoint = volume.MountPoint
+	}
+	return rec
+}
+
+// Register records a volume created through the API, with the name, type,
+// project, description and labels it was created with
+func (r *Registry) Register(volume proxmox.Volume) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().Unix()
+	if err := putRecord(r.db, newRecord(&volume, now)); err != nil {
+		return fmt.Errorf("failed to register volume %s: %w", volume.VolID, err)
+	}
+	return addEvent(r.db, volume.VolID, EventCreated, fmt.Sprintf("%dGB on %s", volume.Size, volume.Storage), now)
+}
+
+// Merge reconciles a single volume reported by Proxmox with its record,
+// recording changes since it was last seen, and fills in its metadata
+func (r *Registry) Merge(volume *proxmox.Volume) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("Failed to rollback transaction: %v", err)
+		}
+	}()
+
+	if err := observe(tx, volume, time.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Reconcile merges the full list of volumes reported by Proxmox with the
+// registry. Volumes that moved to another storage keep their metadata and
+// history, and records of volumes Proxmox no longer reports are marked missing.
+func (r *Registry) Reconcile(volumes []proxmox.Volume) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("Failed to rollback transaction: %v", err)
+		}
+	}()
+
+	records, err := listRecords(tx)
+	if err != nil {
+		return err
+	}
+	reported := make(map[string]bool, len(volumes))
+	for _, volume := range volumes {
+		reported[volume.VolID] = true
+	}
+
+	// A disk moved to another storage shows up under a new volid at the same
+	// mount point of the same guest as a record that disappeared
+	gone := make(map[string]*record)
+	known := make(map[string]bool, len(records))
+	for _, rec := range records {
+		known[rec.volid] = true
+		if !reported[rec.volid] && rec.missingSince == 0 && rec.attachedTo != 0 {
+			gone[fmt.Sprintf("%d/%s", rec.attachedTo, rec.mountPoint)] = rec
+		}
+	}
+
+	now := time.Now().Unix()
+	for i := range volumes {
+		volume := &volumes[i]
+		if !known[volume.VolID] && volume.AttachedTo != nil {
+			key := fmt.Sprintf("%d/%s", *volume.AttachedTo, volume.MountPoint)
+			if rec, ok := gone[key]; ok {
+				if err := rename(tx, rec.volid, volume.VolID, now); err != nil {
+					return err
+				}
+				delete(gone, key)
+				reported[rec.volid] = true
+			}
+		}
+		if err := observe(tx, volume, now); err != nil {
+			return err
+		}
+	}
+
+	for _, rec := range records {
+		if reported[rec.volid] || rec.missingSince != 0 {
+			continue
+		}
+		rec.missingSince = now
+		if err := putRecord(tx, rec); err != nil {
+			return err
+		}
+		if err := addEvent(tx, rec.volid, EventMissing, "no longer reported by Proxmox", now); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// observe compares a volume with its record, records what changed and fills
+// in the volume's metadata. Volumes without a record are added as discovered.
+func observe(tx *sql.Tx, volume *proxmox.Volume, now int64) error {
+	rec, err := getRecord(tx, volume.VolID)
+	if errors.Is(err, ErrNotFound) {
+		rec = newRecord(volume, now)
+		if err := putRecord(tx, rec); err != nil {
+			return err
+		}
+		rec.apply(volume)
+		return addEvent(tx, volume.VolID, EventDiscovered, fmt.Sprintf("%dGB on %s", volume.Size, volume.Storage), now)
+	}
+	if err != nil {
+		return err
+	}
+
+	var events [][2]string
+	if rec.missingSince != 0 {
+		events = append(events, [2]string{EventReappeared, ""})
+		rec.missingSince = 0
+	}
+	if volume.Size != rec.size {
+		if rec.size != 0 {
+			events = append(events, [2]string{EventResized, fmt.Sprintf("%dGB to %dGB", rec.size, volume.Size)})
+		}
+		rec.size = volume.Size
+	}
+	attachedTo, mountPoint := 0, ""
+	if volume.AttachedTo != nil {
+		attachedTo, mountPoint = *volume.AttachedTo, volume.MountPoint
+	}
+	if attachedTo != rec.attachedTo || mountPoint != rec.mountPoint {
+		if rec.attachedTo != 0 {
+			events = append(events, [2]string{EventDetached, fmt.Sprintf("from %d (%s)", rec.attachedTo, rec.mountPoint)})
+		}
+		if attachedTo != 0 {
+			events = append(events, [2]string{EventAttached, fmt.Sprintf("to %d as %s", attachedTo, mountPoint)})
+		}
+		rec.attachedTo, rec.mountPoint = attachedTo, mountPoint
+	}
+
+	if len(events) > 0 {
+		if err := putRecord(tx, rec); err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := addEvent(tx, rec.volid, event[0], event[1], now); err != nil {
+				return err
+			}
+		}
+	}
+	rec.apply(volume)
+	return nil
+}
+
+// rename moves a record and its history to a new volid
+func rename(tx *sql.Tx, oldVolID, newVolID string, now int64) error {
+	if _, err := tx.Exec("UPDATE volumes SET volid = ? WHERE volid = ?", newVolID, oldVolID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE volume_events SET volid = ? WHERE volid = ?", newVolID, oldVolID); err != nil {
+		return err
+	}
+	return addEvent(tx, newVolID, EventMoved, "from "+oldVolID, now)
+}
+
+func listRecords(q queryer) ([]*record, error) {
+	rows, err := q.Query("SELECT " + recordColumns + " FROM volumes ORDER BY volid")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Failed to close rows: %v", closeErr)
+		}
+	}()
+
+	var records []*record
+	for rows.Next() {
+		rec, err := scanRecord(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// Update changes the user-editable metadata of a volume
+func (r *Registry) Update(volid string, req proxmox.UpdateVolumeRequest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec, err := getRecord(r.db, volid)
+	if err != nil {
+		return err
+	}
+
+	var changed []string
+	if req.Name != nil && *req.Name != rec.name {
+		rec.name = *req.Name
+		changed = append(changed, "name")
+	}
+	if req.Description != nil && *req.Description != rec.description {
+		rec.description = *req.Description
+		changed = append(changed, "description")
+	}
+	if req.ProjectID != nil && *req.ProjectID != rec.projectID {
+		rec.projectID = *req.ProjectID
+		changed = append(changed, "project")
+	}
+	if req.Labels != nil {
+		rec.labels = req.Labels
+		changed = append(changed, "labels")
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	if err := putRecord(r.db, rec); err != nil {
+		return fmt.Errorf("failed to update volume %s: %w", volid, err)
+	}
+	return addEvent(r.db, volid, EventUpdated, strings.Join(changed, ", "), time.Now().Unix())
+}
+
+// Project returns the project a volume was assigned to, or "" if it has no
+// record or no project
+func (r *Registry) Project(volid string) (string, error) {
+	var projectID string
+	err := r.db.QueryRow("SELECT project_id FROM volumes WHERE volid = ?", volid).Scan(&projectID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return projectID, err
+}
+
+// Delete removes the record of a deleted volume. Its history is kept.
+func (r *Registry) Delete(volid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.db.Exec("DELETE FROM volumes WHERE volid = ?", volid); err != nil {
+		return fmt.Errorf("failed to delete volume %s: %w", volid, err)
+	}
+	return addEvent(r.db, volid, EventDeleted, "", time.Now().Unix())
+}
+
+// History returns the events of a volume, newest first
+func (r *Registry) History(volid string) ([]Event, error) {
+	rows, err := r.db.Query(
+		"SELECT volid, event, detail, timestamp FROM volume_events WHERE volid = ? ORDER BY id DESC",
+		volid,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Failed to close rows: %v", closeErr)
+		}
+	}()
+
+	events := make([]Event, 0)
+	for rows.Next() {
+		var event Event
+		if err := rows.Scan(&event.VolID, &event.Kind, &event.Detail, &event.Timestamp); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
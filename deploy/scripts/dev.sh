@@ -20,6 +20,8 @@ FRONTEND_DIR="${PROJECT_ROOT}/frontend"
 CONFIG_FILE="${CONFIG_FILE:-${PROJECT_ROOT}/config.test.yaml}"
 CACHE_PATH="${CACHE_PATH:-/tmp/proxicloud-dev/cache.db}"
 ANALYTICS_PATH="${ANALYTICS_PATH:-/tmp/proxicloud-dev/analytics.db}"
+AUTH_PATH="${AUTH_PATH:-/tmp/proxicloud-dev/auth.db}"
+AUDIT_PATH="${AUDIT_PATH:-/tmp/proxicloud-dev/audit.db}"
 BACKEND_PORT="${BACKEND_PORT:-8080}"
 FRONTEND_PORT="${FRONTEND_PORT:-3000}"
 
@@ -142,6 +144,8 @@ setup_environment() {
     # Create temp directories for dev data
     mkdir -p "$(dirname "$CACHE_PATH")"
     mkdir -p "$(dirname "$ANALYTICS_PATH")"
+    mkdir -p "$(dirname "$AUTH_PATH")"
+    mkdir -p "$(dirname "$AUDIT_PATH")"
     
     # Check for config file
     if [ ! -f "$CONFIG_FILE" ]; then
@@ -158,6 +162,8 @@ setup_environment() {
     print_status "Using config: $CONFIG_FILE"
     print_status "Cache path: $CACHE_PATH"
     print_status "Analytics path: $ANALYTICS_PATH"
+    print_status "Auth path: $AUTH_PATH"
+    print_status "Audit path: $AUDIT_PATH"
 }
 
 # Install backend dependencies
@@ -214,11 +220,15 @@ start_backend() {
     export CONFIG_PATH="$CONFIG_FILE"
     export CACHE_PATH="$CACHE_PATH"
     export ANALYTICS_PATH="$ANALYTICS_PATH"
+    export AUTH_PATH="$AUTH_PATH"
+    export AUDIT_PATH="$AUDIT_PATH"
     export CGO_ENABLED=1
     
     print_debug "CONFIG_PATH=$CONFIG_PATH"
     print_debug "CACHE_PATH=$CACHE_PATH"
     print_debug "ANALYTICS_PATH=$ANALYTICS_PATH"
+    print_debug "AUTH_PATH=$AUTH_PATH"
+    print_debug "AUDIT_PATH=$AUDIT_PATH"
     print_debug "CGO_ENABLED=$CGO_ENABLED"
     
     # Run backend with go run (shows all errors and allows live reload)
@@ -294,6 +304,8 @@ show_summary() {
     echo "  • Config:    $CONFIG_FILE"
     echo "  • Cache:     $CACHE_PATH"
     echo "  • Analytics: $ANALYTICS_PATH"
+    echo "  • Auth:      $AUTH_PATH"
+    echo "  • Audit:     $AUDIT_PATH"
     echo ""
     echo -e "${YELLOW}Press Ctrl+C to stop all services${NC}"
     echo ""
@@ -356,6 +368,8 @@ case "${1:-}" in
         echo "  CONFIG_FILE      Path to config file (default: ./config.test.yaml)"
         echo "  CACHE_PATH       Path to cache database (default: /tmp/proxicloud-dev/cache.db)"
         echo "  ANALYTICS_PATH   Path to analytics database (default: /tmp/proxicloud-dev/analytics.db)"
+        echo "  AUTH_PATH        Path to users and sessions database (default: /tmp/proxicloud-dev/auth.db)"
+        echo "  AUDIT_PATH       Path to audit log database (default: /tmp/proxicloud-dev/audit.db)"
         echo "  BACKEND_PORT     Backend port (default: 8080)"
         echo "  FRONTEND_PORT    Frontend port (default: 3000)"
         echo ""
@@ -353,7 +353,7 @@ export default function ContainerDetailPage() {
           {container.template && (
             <div>
               <dt className="text-sm font-medium text-text-muted">Template</dt>
-              <dd className="text-text-primary mt-1">{container.template}</dd>
+              <dd className="text-text-primary mt-1">Yes</dd>
             </div>
           )}
           {container.os && (
@@ -0,0 +1,132 @@
+'use client';
+
+import { useState, useEffect, Suspense } from 'react';
+import { useRouter, useSearchParams } from 'next/navigation';
+import { Lock } from 'lucide-react';
+import Card from '@/components/ui/Card';
+import Button from '@/components/ui/Button';
+import Input from '@/components/ui/Input';
+import { login, getAuthProviders, ssoLoginURL, completeSSOLogin, APIError, AuthProviders } from '@/lib/api';
+
+function LoginForm() {
+  const router = useRouter();
+  const searchParams = useSearchParams();
+  const [username, setUsername] = useState('');
+  const [password, setPassword] = useState('');
+  const [loading, setLoading] = useState(false);
+  const [error, setError] = useState<string | null>(null);
+  const [providers, setProviders] = useState<AuthProviders | null>(null);
+
+  function nextPath(next: string | null): string {
+    return next && next.startsWith('/') && !next.startsWith('//') && next !== '/login' ? next : '/';
+  }
+
+  useEffect(() => {
+    // Single sign-on returns here with a one-time code in the fragment
+    const result = new URLSearchParams(window.location.hash.slice(1));
+    if (result.get('code')) {
+      window.history.replaceState(null, '', window.location.pathname + window.location.search);
+      completeSSOLogin(result.get('code')!)
+        .then(() => router.replace(nextPath(result.get('next'))))
+        .catch((err) => {
+          setError(err instanceof Error ? err.message : 'Failed to log in');
+          getAuthProviders()
+            .then(setProviders)
+            .catch(() => setProviders({ password: true }));
+        });
+      return;
+    }
+    if (result.get('error')) {
+      setError(result.get('error'));
+      window.history.replaceState(null, '', window.location.pathname + window.location.search);
+    }
+
+    getAuthProviders()
+      .then(setProviders)
+      .catch(() => setProviders({ password: true }));
+  }, [router]);
+
+  async function handleSubmit(e: React.FormEvent) {
+    e.preventDefault();
+    setLoading(true);
+    setError(null);
+
+    try {
+      await login(username, password);
+      router.push(nextPath(searchParams.get('next')));
+    } catch (err) {
+      if (err instanceof APIError && err.status === 401) {
+        setError('Invalid username or password');
+      } else {
+        setError(err instanceof Error ? err.message : 'Failed to log in');
+      }
+    } finally {
+      setLoading(false);
+    }
+  }
+
+  return (
+    <div className="flex items-center justify-center h-full">
+      <Card className="w-full max-w-sm p-6">
+        <div className="flex items-center gap-3 mb-6">
+          <Lock className="w-6 h-6 text-primary" />
+          <h1 className="text-xl font-bold text-text-primary">Log in to ProxiCloud</h1>
+        </div>
+
+        {providers?.oidc && (
+          <div className="flex flex-col gap-4 mb-4">
+            <Button
+              type="button"
+              onClick={() => {
+                window.location.href = ssoLoginURL(providers.oidc!.login_url, nextPath(searchParams.get('next')));
+              }}
+            >
+              Log in with {providers.oidc.name}
+            </Button>
+            {providers.password && <div className="text-center text-sm text-text-secondary">or</div>}
+          </div>
+        )}
+
+        {providers && !providers.password && error && <div className="text-sm text-error">{error}</div>}
+
+        {providers?.password !== false && (
+          <form onSubmit={handleSubmit} className="flex flex-col gap-4">
+            <Input
+              label="Username"
+              value={username}
+              onChange={(e) => setUsername(e.target.value)}
+              autoComplete="username"
+              required
+            />
+            <Input
+              label="Password"
+              type="password"
+              value={password}
+              onChange={(e) => setPassword(e.target.value)}
+              autoComplete="current-password"
+              required
+            />
+
+            {error && <div className="text-sm text-error">{error}</div>}
+
+            <Button type="submit" disabled={loading}>
+              {loading ? 'Logging in...' : 'Log in'}
+            </Button>
+          </form>
+        )}
+      </Card>
+    </div>
+  );
+}
+
+export default function LoginPage() {
+  return (
+    <Suspense fallback={
+      <div className="flex items-center justify-center h-full">
+        <div className="text-text-secondary">Loading...</div>
+      </div>
+    }>
+      <LoginForm />
+    </Suspense>
+  );
+}
@@ -211,7 +211,7 @@ export default function VolumeDetailPage() {
     );
   }
 
-  const usagePercent = volume.size > 0 ? ((volume.used / volume.size) * 100).toFixed(1) : '0';
+  const usagePercent = volume.size_bytes > 0 ? ((volume.used_bytes / volume.size_bytes) * 100).toFixed(1) : '0';
   const availableContainers = containers.filter(c => c.status === 'running');
 
   return (
@@ -287,9 +287,9 @@ export default function VolumeDetailPage() {
           <div className="space-y-2">
             <div className="text-text-muted text-sm">Used Space</div>
             <div className="text-2xl font-bold text-text-primary">
-              {volume.used > 0 ? `${volume.used} GB` : '0 GB'}
+              {formatBytes(volume.used_bytes)}
             </div>
-            {volume.used > 0 ? (
+            {volume.used_bytes > 0 ? (
               <div className="flex items-center gap-2">
                 <div className="flex-1 bg-surface-elevated rounded-full h-2">
                   <div
@@ -432,9 +432,9 @@ export default function VolumesPage() {
                   </td>
                   <td className="px-6 py-4 whitespace-nowrap text-sm text-text-secondary">
                     {volume.size} GB
-                    {volume.used > 0 && (
+                    {volume.used_bytes > 0 && (
                       <span className="text-xs text-text-muted ml-1">
-                        ({formatBytes(volume.used * 1024 * 1024 * 1024)} used)
+                        ({formatBytes(volume.used_bytes)} used)
                       </span>
                     )}
                   </td>
@@ -1,8 +1,27 @@
 'use client';
 
-import { Server, Bell } from 'lucide-react';
+import { useEffect, useState } from 'react';
+import { usePathname } from 'next/navigation';
+import { Server, Bell, LogOut } from 'lucide-react';
+import { getCurrentUser, logout, type AuthUser } from '@/lib/api';
 
 export default function TopBar() {
+  const pathname = usePathname();
+  const [user, setUser] = useState<AuthUser | null>(null);
+
+  useEffect(() => {
+    if (pathname === '/login') {
+      setUser(null);
+      return;
+    }
+    getCurrentUser().then(setUser).catch(() => setUser(null));
+  }, [pathname]);
+
+  async function handleLogout() {
+    await logout().catch(() => undefined);
+    window.location.href = '/login';
+  }
+
   return (
     <header className="h-16 bg-surface border-b border-border flex items-center justify-between px-6 sticky top-0 z-10">
       <div className="flex items-center gap-3">
@@ -15,15 +34,24 @@ export default function TopBar() {
           <Bell className="w-5 h-5 text-text-secondary" />
         </button>
         
-        <div className="flex items-center gap-3 pl-4 border-l border-border">
-          <div className="text-right">
-            <div className="text-sm font-medium text-text-primary">Admin</div>
-            <div className="text-xs text-text-muted">Connected</div>
-          </div>
-          <div className="w-8 h-8 rounded-full bg-primary flex items-center justify-center text-white text-sm font-medium">
-            A
+        {user && (
+          <div className="flex items-center gap-3 pl-4 border-l border-border">
+            <div className="text-right">
+              <div className="text-sm font-medium text-text-primary">{user.username}</div>
+              <div className="text-xs text-text-muted">{user.admin ? 'Admin' : 'User'}</div>
+            </div>
+            <div className="w-8 h-8 rounded-full bg-primary flex items-center justify-center text-white text-sm font-medium">
+              {user.username.charAt(0).toUpperCase()}
+            </div>
+            <button
+              onClick={handleLogout}
+              className="p-2 hover:bg-surface-elevated rounded-lg transition-colors"
+              title="Log out"
+            >
+              <LogOut className="w-5 h-5 text-text-secondary" />
+            </button>
           </div>
-        </div>
+        )}
       </div>
     </header>
   );
@@ -1,4 +1,4 @@
-import { Container, CreateContainerRequest, DashboardStats, MetricsData, MetricsSummary, Template, Volume, CreateVolumeRequest, AttachVolumeRequest, DetachVolumeRequest, Snapshot, CreateSnapshotRequest, RestoreSnapshotRequest, CloneSnapshotRequest, Project, CreateProjectRequest, UpdateProjectRequest, AssignProjectRequest, ProjectContainersResponse, Storage, GetStorageRequest, TermProxyResponse } from './types';
+import { Container, CreateContainerRequest, DashboardStats, MetricsData, MetricsSummary, Template, Volume, CreateVolumeRequest, AttachVolumeRequest, DetachVolumeRequest, Snapshot, CreateSnapshotRequest, RestoreSnapshotRequest, CloneSnapshotRequest, Project, CreateProjectRequest, UpdateProjectRequest, AssignProjectRequest, ProjectContainersResponse, Storage, GetStorageRequest, TermProxyResponse, ProjectRole } from './types';
 
 // Support runtime API URL configuration
 // In standalone mode, this will be available at window location
@@ -38,6 +38,36 @@ export function isCached(): boolean {
   return isUsingCache;
 }
 
+// Session token from login, sent as a bearer token on every request
+const TOKEN_KEY = 'proxicloud_token';
+
+export function getAuthToken(): string | null {
+  if (typeof window === 'undefined') return null;
+  return window.localStorage.getItem(TOKEN_KEY);
+}
+
+function setAuthToken(token: string | null) {
+  if (typeof window === 'undefined') return;
+  if (token) {
+    window.localStorage.setItem(TOKEN_KEY, token);
+  } else {
+    window.localStorage.removeItem(TOKEN_KEY);
+  }
+}
+
+function authHeaders(): Record<string, string> {
+  const token = getAuthToken();
+  return token ? { Authorization: `Bearer ${token}` } : {};
+}
+
+// Send the user to the login page when their session is missing or expired
+function redirectToLogin() {
+  setAuthToken(null);
+  if (typeof window !== 'undefined' && window.location.pathname !== '/login') {
+    window.location.href = `/login?next=${encodeURIComponent(window.location.pathname)}`;
+  }
+}
+
 // Retry configuration
 interface RetryOptions {
   maxRetries?: number;
@@ -94,14 +124,19 @@ async function fetchAPI<T>(
   for (let attempt = 0; attempt <= maxRetries; attempt++) {
     try {
       const response = await fetch(`${API_URL}${endpoint}`, {
+        signal: AbortSignal.timeout(60000), // 60 second timeout for slow networks
+        ...options,
         headers: {
           'Content-Type': 'application/json',
+          ...authHeaders(),
           ...options?.headers,
         },
-        signal: AbortSignal.timeout(60000), // 60 second timeout for slow networks
-        ...options,
       });
 
+      if (response.status === 401 && endpoint !== '/auth/login') {
+        redirectToLogin();
+      }
+
       // Check if response is from cache
       const cacheStatus = response.headers.get('X-Cache-Status');
       notifyCacheStatus(cacheStatus === 'HIT');
@@ -174,6 +209,121 @@ async function fetchAPI<T>(
   throw lastError || new APIError('Request failed after all retry attempts', 0, 'Max Retries Exceeded', endpoint, false);
 }
 
+// Authentication
+export interface AuthUser {
+  username: string;
+  admin: boolean;
+  external?: boolean; // Signs in through single sign-on
+  created_at: number;
+  projects?: Record<string, ProjectRole>; // Role in each project, keyed by project ID
+}
+
+export async function login(username: string, password: string): Promise<AuthUser> {
+  const result = await fetchAPI<{ token: string; expires_at: number; user: AuthUser }>(
+    '/auth/login',
+    { method: 'POST', body: JSON.stringify({ username, password }) },
+    { maxRetries: 0 }
+  );
+  setAuthToken(result.token);
+  return result.user;
+}
+
+export interface AuthProviders {
+  password: boolean;
+  oidc?: { name: string; login_url: string };
+}
+
+export async function getAuthProviders(): Promise<AuthProviders> {
+  return fetchAPI('/auth/providers', {}, { maxRetries: 0 });
+}
+
+// URL that starts a single sign-on login, returning to next afterwards
+export function ssoLoginURL(loginURL: string, next: string): string {
+  return `${API_URL.replace(/\/api$/, '')}${loginURL}?next=${encodeURIComponent(next)}`;
+}
+
+// Exchange the one-time code the single sign-on callback passed to the login
+// page for the session token
+export async function completeSSOLogin(code: string): Promise<AuthUser> {
+  const result = await fetchAPI<{ token: string; expires_at: number; user: AuthUser }>(
+    '/auth/oidc/exchange',
+    { method: 'POST', body: JSON.stringify({ code }) },
+    { maxRetries: 0 }
+  );
+  setAuthToken(result.token);
+  return result.user;
+}
+
+export async function logout(): Promise<void> {
+  try {
+    await fetchAPI('/auth/logout', { method: 'POST' }, { maxRetries: 0 });
+  } finally {
+    setAuthToken(null);
+  }
+}
+
+export async function getCurrentUser(): Promise<AuthUser> {
+  return fetchAPI('/auth/me');
+}
+
+// Audit log (admins only)
+export interface AuditEntry {
+  id: number;
+  time: number;
+  actor?: string;
+  source_ip: string;
+  method: string;
+  path: string;
+  action: string; // Method and route, e.g. "DELETE /containers/{vmid}"
+  vmid?: number;
+  volid?: string;
+  project?: string;
+  params?: Record<string, unknown>; // Secrets are redacted
+  status: number;
+  result: 'success' | 'denied' | 'failed';
+  error?: string;
+  upids?: string[];
+  duration_ms: number;
+}
+
+export interface AuditFilter {
+  actor?: string;
+  method?: string;
+  action?: string;
+  vmid?: number;
+  volid?: string;
+  project?: string;
+  result?: 'success' | 'denied' | 'failed';
+  since?: string;
+  until?: string;
+  limit?: number;
+  offset?: number;
+}
+
+function auditQuery(filter: AuditFilter): URLSearchParams {
+  const params = new URLSearchParams();
+  Object.entries(filter).forEach(([key, value]) => {
+    if (value !== undefined && value !== '') params.set(key, String(value));
+  });
+  return params;
+}
+
+export async function getAuditLog(filter: AuditFilter = {}): Promise<AuditEntry[]> {
+  const query = auditQuery(filter).toString();
+  return fetchAPI(`/audit${query ? `?${query}` : ''}`);
+}
+
+// Download the matching audit entries as JSON lines
+export async function exportAuditLog(filter: AuditFilter = {}): Promise<Blob> {
+  const params = auditQuery(filter);
+  params.set('format', 'jsonl');
+  const response = await fetch(`${API_URL}/audit?${params}`, { headers: authHeaders() });
+  if (!response.ok) {
+    throw new APIError(`Failed to export audit log: ${response.statusText}`, response.status, response.statusText, '/audit', false);
+  }
+  return response.blob();
+}
+
 // Health check
 export async function healthCheck(): Promise<{ status: string }> {
   return fetchAPI('/health');
@@ -279,6 +429,7 @@ export async function uploadTemplate(file: File, storage: string = 'local', onPr
 
     // Send request
     xhr.open('POST', `${API_URL}/templates/upload`);
+    Object.entries(authHeaders()).forEach(([name, value]) => xhr.setRequestHeader(name, value));
     xhr.send(formData);
   });
 }
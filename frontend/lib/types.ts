@@ -9,7 +9,7 @@ export interface Container {
   disk: number;
   maxdisk: number;
   uptime: number;
-  template?: string;
+  template?: boolean;
   os?: string;
   project_id?: string;
   ip_address?: string;
@@ -80,6 +80,8 @@ export interface Volume {
   name: string;
   size: number; // Size in GB
   used: number; // Used space in GB
+  size_bytes: number;
+  used_bytes: number;
   node: string;
   storage: string;
   type: string; // ssd or hdd
@@ -142,6 +144,8 @@ export interface ProjectNetwork {
   auto_created_zone?: boolean;  // Whether the zone was auto-created
 }
 
+export type ProjectRole = 'admin' | 'operator' | 'viewer';
+
 export interface Project {
   id: string;
   name: string;
@@ -151,6 +155,7 @@ export interface Project {
   container_id_start?: number;  // Start of container ID range
   container_id_end?: number;    // End of container ID range
   container_count: number;
+  members?: Record<string, ProjectRole>; // Role of each member, keyed by username
   created_at: number; // Unix timestamp
   updated_at: number; // Unix timestamp
 }